@@ -0,0 +1,13 @@
+-- No-call overlay for allele paths.
+--
+-- When the graph is built with no-calls filled in (create_tile_graph -nocall-fill),
+-- each AllelePathItem goes through the filled in sequence.  The positions that were
+-- no-calls for the allele are recorded here, per AllelePathItem.
+--
+CREATE TABLE AllelePathItemNoCall (alleleID INTEGER NOT NULL,
+	pathItemIndex INTEGER NOT NULL,
+	start INTEGER NOT NULL, -- 0 based, relative to the start of the path item's sequence
+	length INTEGER NOT NULL,
+	PRIMARY KEY(alleleID, pathItemIndex, start),
+	FOREIGN KEY(alleleID, pathItemIndex) REFERENCES AllelePathItem(alleleID, pathItemIndex));
+--
@@ -12,14 +12,27 @@ callset_fn="callset_2c5.csv"
 variantset_callset_fn="variantset-callset-join_2c5.csv"
 gj_vs_join="graphjoin-variantset-join_2c5.csv"
 
+# The ReferenceSet is only written by the first create_tile_graph run
+# (the others find it in the database), so it comes from the combined
+# files for every locus.
+#
+reference_fn="reference_2c5.csv"
+reference_accession_fn="reference-accession_2c5.csv"
+referenceset_fn="referenceset_2c5.csv"
+referenceset_accession_fn="referenceset-accession_2c5.csv"
+reference_referenceset_fn="reference-referenceset-join_2c5.csv"
+gj_rs_join="graphjoin-referenceset-join_2c5.csv"
+
 allelecall_fn="allelecall_2c5.csv"
 allele_fn="allele_2c5.csv"
 allelepath_fn="allelepath_2c5.csv"
+allelepath_nocall_fn="allelepath-nocall_2c5.csv"
 
 rm -f $db_fn
 
 echo "creating $db_fn"
 cat graphSQL_v023.sql | sqlite3 $db_fn
+cat allelepathitem_nocall.sql | sqlite3 $db_fn
 
 echo "import FASTA from $fasta_db_fn"
 echo -e '.separator ","\n.import '$fasta_db_fn' FASTA' | sqlite3 $db_fn
@@ -30,6 +43,24 @@ echo -e '.separator ","\n.import '$seq_fn' Sequence' | sqlite3 $db_fn
 echo "import GraphJoin from $graphjoin_fn"
 echo -e '.separator ","\n.import '$graphjoin_fn' GraphJoin' | sqlite3 $db_fn
 
+echo "import ReferenceSet from $referenceset_fn"
+echo -e '.separator ","\n.import '$referenceset_fn' ReferenceSet' | sqlite3 $db_fn
+
+echo "import ReferenceSetAccession from $referenceset_accession_fn"
+echo -e '.separator ","\n.import '$referenceset_accession_fn' ReferenceSetAccession' | sqlite3 $db_fn
+
+echo "import Reference from $reference_fn"
+echo -e '.separator ","\n.import '$reference_fn' Reference' | sqlite3 $db_fn
+
+echo "import ReferenceAccession from $reference_accession_fn"
+echo -e '.separator ","\n.import '$reference_accession_fn' ReferenceAccession' | sqlite3 $db_fn
+
+echo "import Reference_ReferenceSet_Join from $reference_referenceset_fn"
+echo -e '.separator ","\n.import '$reference_referenceset_fn' Reference_ReferenceSet_Join' | sqlite3 $db_fn
+
+echo "import GraphJoin_ReferenceSet_Join from $gj_rs_join"
+echo -e '.separator ","\n.import '$gj_rs_join' GraphJoin_ReferenceSet_Join' | sqlite3 $db_fn
+
 echo "import VariantSet from $variantset_fn"
 echo -e '.separator ","\n.import '$variantset_fn' VariantSet' | sqlite3 $db_fn
 
@@ -50,3 +81,6 @@ echo -e '.separator ","\n.import '$allelecall_fn' AlleleCall' | sqlite3 $db_fn
 
 echo "import AllelePathItem from $allelepath_fn"
 echo -e '.separator ","\n.import '$allelepath_fn' AllelePathItem' | sqlite3 $db_fn
+
+echo "import AllelePathItemNoCall from $allelepath_nocall_fn"
+echo -e '.separator ","\n.import '$allelepath_nocall_fn' AllelePathItemNoCall' | sqlite3 $db_fn
@@ -12,14 +12,27 @@ callset_fn="callset.csv"
 variantset_callset_fn="variantset-callset-join.csv"
 gj_vs_join="graphjoin-variantset-join.csv"
 
+# The ReferenceSet is only written by the first create_tile_graph run
+# (the others find it in the database), so it comes from the combined
+# files for every locus.
+#
+reference_fn="reference.csv"
+reference_accession_fn="reference-accession.csv"
+referenceset_fn="referenceset.csv"
+referenceset_accession_fn="referenceset-accession.csv"
+reference_referenceset_fn="reference-referenceset-join.csv"
+gj_rs_join="graphjoin-referenceset-join.csv"
+
 allelecall_fn="allelecall.csv"
 allele_fn="allele.csv"
 allelepath_fn="allelepath.csv"
+allelepath_nocall_fn="allelepath-nocall.csv"
 
 rm -f $db_fn
 
 echo "creating $db_fn"
 cat graphSQL_v023.sql | sqlite3 $db_fn
+cat allelepathitem_nocall.sql | sqlite3 $db_fn
 
 echo "import FASTA from $fasta_db_fn"
 echo -e '.separator ","\n.import '$fasta_db_fn' FASTA' | sqlite3 $db_fn
@@ -30,6 +43,24 @@ echo -e '.separator ","\n.import '$seq_fn' Sequence' | sqlite3 $db_fn
 echo "import GraphJoin from $graphjoin_fn"
 echo -e '.separator ","\n.import '$graphjoin_fn' GraphJoin' | sqlite3 $db_fn
 
+echo "import ReferenceSet from $referenceset_fn"
+echo -e '.separator ","\n.import '$referenceset_fn' ReferenceSet' | sqlite3 $db_fn
+
+echo "import ReferenceSetAccession from $referenceset_accession_fn"
+echo -e '.separator ","\n.import '$referenceset_accession_fn' ReferenceSetAccession' | sqlite3 $db_fn
+
+echo "import Reference from $reference_fn"
+echo -e '.separator ","\n.import '$reference_fn' Reference' | sqlite3 $db_fn
+
+echo "import ReferenceAccession from $reference_accession_fn"
+echo -e '.separator ","\n.import '$reference_accession_fn' ReferenceAccession' | sqlite3 $db_fn
+
+echo "import Reference_ReferenceSet_Join from $reference_referenceset_fn"
+echo -e '.separator ","\n.import '$reference_referenceset_fn' Reference_ReferenceSet_Join' | sqlite3 $db_fn
+
+echo "import GraphJoin_ReferenceSet_Join from $gj_rs_join"
+echo -e '.separator ","\n.import '$gj_rs_join' GraphJoin_ReferenceSet_Join' | sqlite3 $db_fn
+
 echo "import VariantSet from $variantset_fn"
 echo -e '.separator ","\n.import '$variantset_fn' VariantSet' | sqlite3 $db_fn
 
@@ -50,3 +81,6 @@ echo -e '.separator ","\n.import '$allelecall_fn' AlleleCall' | sqlite3 $db_fn
 
 echo "import AllelePathItem from $allelepath_fn"
 echo -e '.separator ","\n.import '$allelepath_fn' AllelePathItem' | sqlite3 $db_fn
+
+echo "import AllelePathItemNoCall from $allelepath_nocall_fn"
+echo -e '.separator ","\n.import '$allelepath_nocall_fn' AllelePathItemNoCall' | sqlite3 $db_fn
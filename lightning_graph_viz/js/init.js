@@ -74,6 +74,76 @@ function loci_select(loci_name) {
   g_loci_name = loci_name;
 }
 
+// Tile paths from tilegraph_server (/tiles/paths) when the
+// viewer is served by it, null when the databases are loaded
+// into the browser instead.
+//
+var g_tile_paths = null;
+
+// Names shown in the loci menu for known tile paths, any
+// other path is shown by its hex value.
+//
+var g_path_name = { "2c5" : "BRCA1", "247" : "BRCA2" };
+
+function path_name(path) {
+  if (path in g_path_name) { return g_path_name[path]; }
+  return path;
+}
+
+function fill_sample_list() {
+  $("#dropdown-sample-list").empty();
+  $("#dropdown-sample-list").append('<li role="presentation"><a role="menuitem" tabindex="-1" href="#" onclick="sample_deselect();"; >None</a></li>');
+  for (var sample_name in g_model.call_set) {
+    $("#dropdown-sample-list").append('<li role="presentation">' +
+        '<a role="menuitem" tabindex="-1" href="#" onclick="sample_select(' + "'" + sample_name + "'" + ');"  >' +
+        sample_name + '</a></li>');
+  }
+
+  // This needs to happen after the dropdown list has been created
+  //
+  $("#dropdown-sample-list li a").click(function() {
+    $("#sample_main_text").text( $(this).text() );
+    $("#sample_main_text").val( $(this).text() );
+  });
+}
+
+// Fetch the graph of the locus from tilegraph_server as it
+// comes into view.
+//
+function tile_api_select(path) {
+  if (!(path in g_tile_paths)) {
+    console.log("ERROR: no tile path", path);
+    return;
+  }
+
+  g_loci_name = path_name(path);
+  g_controller.display_text = g_loci_name;
+
+  var tp = g_tile_paths[path];
+  g_model.init_api(path, tp.minStep, tp.maxStep, fill_sample_list);
+
+  $("#loci_main_text").text(g_loci_name);
+  $("#loci_main_text").val(g_loci_name);
+  $("#sample_main_text").text("None");
+  $("#sample_main_text").val("None");
+}
+
+// Replace the loci menu with the tile paths tilegraph_server
+// has, in the order it lists them.
+//
+function fill_loci_list(paths) {
+  $("#dropdown-loci-list").empty();
+  for (var i=0; i<paths.length; i++) {
+    $("#dropdown-loci-list").append('<li role="presentation">' +
+        '<a role="menuitem" tabindex="-1" href="#" data-path="' + paths[i].path + '" >' +
+        path_name(paths[i].path) + '</a></li>');
+  }
+
+  $("#dropdown-loci-list li a").click(function() {
+    tile_api_select($(this).attr("data-path"));
+  });
+}
+
 $(document).ready( function() {
 
   //Load database
@@ -157,6 +227,36 @@ $(document).ready( function() {
   });
 
 
+  // Served by tilegraph_server, fetch only what is on screen
+  // from its /tiles endpoints.  Otherwise load the whole
+  // databases and FASTA files into the browser.
+  //
+  $.getJSON("tiles/paths")
+    .done(function(resp) {
+      g_tile_paths = {};
+      for (var i=0; i<resp.paths.length; i++) {
+        g_tile_paths[resp.paths[i].path] = resp.paths[i];
+      }
+      fill_loci_list(resp.paths);
+
+      if (resp.paths.length == 0) {
+        console.log("ERROR: no tile paths in the database");
+        $("#loci_main_text").text("None");
+        $("#loci_main_text").val("None");
+        return;
+      }
+      tile_api_select(resp.paths[0].path);
+    })
+    .fail(load_sqlite_files);
+
+});
+
+
+// Download the SQLite databases and FASTA files of both loci
+// to query them in the browser with sql.js.
+//
+function load_sqlite_files() {
+
   // Grab BRCA2 database
   //
   var xhr = new XMLHttpRequest();
@@ -254,10 +354,7 @@ $(document).ready( function() {
   }
   fa_req2.send();
 
-
-
-});
-
+}
 
 // more magic
 //
@@ -55,6 +55,18 @@ function lightningGraph() {
 
   this.path = "none";
   this.shift_step = 0;
+
+  // When served by tilegraph_server the graph is fetched from
+  // its /tiles endpoints a block of steps at a time, as they
+  // come into view, instead of from the whole database.
+  //
+  this.api = false;
+  this.api_min_step = 0;
+  this.api_max_step = 0;
+  this.block_size = 64;
+  this.block = {};
+  this.seq_seen = {};
+  this.join_seen = {};
 }
 
 
@@ -66,6 +78,14 @@ lightningGraph.prototype.load_sample_path = function(sample_name) {
   }
   var callid = this.call_set[sample_name].id;
 
+  if (this.api) {
+    this.sample_allele[sample_name] = {};
+    for (var b in this.block) {
+      if (this.block[b] == "loaded") { this.load_block(parseInt(b), sample_name, true); }
+    }
+    return "ok";
+  }
+
   var cmd = "select alleleID from AlleleCall where callSetID = " + callid;
   var z = g_db.exec(cmd);
   if (z.length==0) { console.log("ERROR: did not get any alleleIDs for callSetID", callid); return; }
@@ -124,6 +144,129 @@ lightningGraph.prototype.init_allele_path = function() {
 
 }
 
+// Use the /tiles endpoints of tilegraph_server for tile
+// path, whose steps run from min_step to max_step.  done is
+// called once the CallSets are loaded.
+//
+lightningGraph.prototype.init_api = function(path, min_step, max_step, done) {
+  this.reset();
+
+  this.api = true;
+  this.path = path;
+  this.shift_step = min_step;
+  this.api_min_step = min_step;
+  this.api_max_step = max_step;
+
+  var model = this;
+  var load_callsets = function(page_token) {
+    var req = { "pageSize" : 1000 };
+    if (page_token !== null) { req.pageToken = page_token; }
+
+    $.ajax({ "url" : "callsets/search", "type" : "POST", "data" : JSON.stringify(req),
+             "contentType" : "application/json", "dataType" : "json" })
+      .done(function(resp) {
+        for (var i=0; i<resp.callSets.length; i++) {
+          var cs = resp.callSets[i];
+          model.call_set_id[ cs.id ] = cs.name;
+          model.call_set[ cs.name ] = { "id" : cs.id };
+        }
+        if (resp.nextPageToken !== null) { load_callsets(resp.nextPageToken); return; }
+        if (typeof(done) !== "undefined") { done(); }
+      })
+      .fail(function() { console.log("ERROR: could not load CallSets"); });
+  };
+  load_callsets(null);
+
+  g_painter.dirty_flag = true;
+}
+
+// Fetch the blocks covering steps lo to hi that haven't
+// been fetched yet.
+//
+lightningGraph.prototype.ensure_steps = function(lo, hi) {
+  if (lo < this.api_min_step) { lo = this.api_min_step; }
+  if (hi > this.api_max_step) { hi = this.api_max_step; }
+
+  var b0 = Math.floor((lo - this.api_min_step) / this.block_size);
+  var b1 = Math.floor((hi - this.api_min_step) / this.block_size);
+  for (var b=b0; b<=b1; b++) {
+    if (b in this.block) { continue; }
+    var sample = (this.highlight_sample_flag ? this.highlight_sample_name : null);
+    this.load_block(b, sample, false);
+  }
+}
+
+// Fetch block b, starting a step early so the joins into it
+// come back too, with the path of sample if not null.  With
+// paths_only only the sample path is fetched, for a sample
+// highlighted after the block was loaded.
+//
+lightningGraph.prototype.load_block = function(b, sample, paths_only) {
+  var start = this.api_min_step + b*this.block_size;
+  var end = start + this.block_size - 1;
+  if (start > this.api_min_step) { start--; }
+  if (end > this.api_max_step) { end = this.api_max_step; }
+
+  var url = "tiles/range?path=" + this.path + "&start=" + start.toString(16) + "&end=" + end.toString(16);
+  if (paths_only) { url += "&paths_only=1"; }
+  else            { url += "&bases=1"; this.block[b] = "loading"; }
+  if (sample !== null) { url += "&callset=" + encodeURIComponent(sample); }
+
+  var model = this;
+  var path = this.path;
+  $.getJSON(url)
+    .done(function(resp) {
+      if (!model.api || (model.path != path)) { return; }
+
+      if (!paths_only) {
+        var seqs = [];
+        for (var i=0; i<resp.sequences.length; i++) {
+          var seq = resp.sequences[i];
+          g_sequence[seq.name] = model.fasta_text(seq.name, seq.bases);
+          seqs.push([ seq.id, seq.name, seq.md5checksum, seq.length ]);
+        }
+        model.add_sequences(seqs);
+        model.chooseCoords(start, end);
+
+        var joins = [];
+        for (var i=0; i<resp.joins.length; i++) {
+          var gj = resp.joins[i];
+          joins.push([ gj.id, gj.side1SequenceId, gj.side1Position, gj.side1StrandIsForward,
+                              gj.side2SequenceId, gj.side2Position, gj.side2StrandIsForward ]);
+        }
+        model.add_graphjoins(joins);
+
+        model.block[b] = "loaded";
+      }
+
+      for (var sample_name in resp.paths) {
+        if (!(sample_name in model.sample_allele)) { model.sample_allele[sample_name] = {}; }
+        for (var allele_name in resp.paths[sample_name]) {
+          var a = model.sample_allele[sample_name];
+          if (!(allele_name in a)) { a[allele_name] = []; }
+          a[allele_name] = a[allele_name].concat(resp.paths[sample_name][allele_name]);
+        }
+      }
+
+      g_painter.dirty_flag = true;
+    })
+    .fail(function() {
+      console.log("ERROR: could not load steps", start, "to", end);
+      if (!paths_only) { delete model.block[b]; }
+    });
+}
+
+// The FASTA record of a sequence, as it is drawn (a header
+// line then 50 bases a line).
+//
+lightningGraph.prototype.fasta_text = function(name, bases) {
+  var lines = [ ">" + name ];
+  for (var i=0; i<bases.length; i+=50) {
+    lines.push(bases.slice(i, i+50));
+  }
+  return lines.join("\n");
+}
+
 lightningGraph.prototype.init_graphjoin = function() {
   var z = g_db.exec("select ID, side1SequenceID, side1Position, side1StrandIsForward," +
                                "side2SequenceID, side2Position, side2StrandIsForward from GraphJoin");
@@ -132,7 +275,14 @@ lightningGraph.prototype.init_graphjoin = function() {
     return;
   }
 
-  var vals = z[0].values;
+  this.add_graphjoins(z[0].values);
+}
+
+// vals are GraphJoin rows:
+// ID, side1SequenceID, side1Position, side1StrandIsForward,
+// side2SequenceID, side2Position, side2StrandIsForward
+//
+lightningGraph.prototype.add_graphjoins = function(vals) {
   for (var ind=0; ind<vals.length; ind++) {
     var seq0_id = vals[ind][1];
     var seq1_id = vals[ind][4];
@@ -159,6 +309,9 @@ lightningGraph.prototype.init_graphjoin = function() {
       continue;
     }
 
+    if (vals[ind][0] in this.join_seen) { continue; }
+    this.join_seen[vals[ind][0]] = true;
+
     var seq0_name = p0[8];
     var seq1_name = p1[8];
 
@@ -204,7 +357,7 @@ lightningGraph.prototype.init_graphjoin = function() {
 
 }
 
-lightningGraph.prototype.init = function() {
+lightningGraph.prototype.reset = function() {
 
   this.min_step = -1;
   this.max_step = -1;
@@ -225,6 +378,16 @@ lightningGraph.prototype.init = function() {
   this.call_set = {};
   this.call_set_id = {};
 
+  this.api = false;
+  this.block = {};
+  this.seq_seen = {};
+  this.join_seen = {};
+}
+
+lightningGraph.prototype.init = function() {
+
+  this.reset();
+
   var z = g_db.exec("select ID, sequenceRecordName, md5checksum, length from Sequence");
 
   if ((z.length!=1) || (!("values" in z[0]))) {
@@ -232,30 +395,36 @@ lightningGraph.prototype.init = function() {
     return;
   }
 
-  var min_path = -1;
-  var max_path = -1;
-  var min_step = -1;
-  var max_step = -1;
+  this.add_sequences(z[0].values);
+
+  this.chooseCoords();
+
+  this.graphjoin_line = [];
+  this.init_graphjoin();
+  this.init_allele_path();
+
+}
+
+// vals are Sequence rows:
+// ID, sequenceRecordName, md5checksum, length
+//
+lightningGraph.prototype.add_sequences = function(vals) {
+
+  var min_step = this.min_step;
+  var max_step = this.max_step;
 
-  var vals = z[0].values;
   for (var ind=0; ind<vals.length; ind++) {
     var id   = vals[ind][0];
     var name = vals[ind][1];
 
     var name_part = name.split(".");
-    var path = parseInt(name_part[1], 16);
     var step = parseInt(name_part[2], 16);
 
-    if (min_path<0) {
-      min_path = path;
-      max_path = path;
+    if (min_step<0) {
       min_step = step;
       max_step = step;
     }
 
-    if (min_path>path) min_path = path;
-    if (max_path<path) max_path = path;
-
     if (min_step>step) min_step = step;
     if (max_step<step) max_step = step;
   }
@@ -271,6 +440,9 @@ lightningGraph.prototype.init = function() {
     var m5    = vals[ind][2];
     var len   = vals[ind][3];
 
+    if (id in this.seq_seen) { continue; }
+    this.seq_seen[id] = true;
+
     var name_part = name.split(".");
     var path = parseInt(name_part[1], 16);
     var step = parseInt(name_part[2], 16);
@@ -323,15 +495,22 @@ lightningGraph.prototype.init = function() {
     })
   }
 
-  this.chooseCoords();
-
-  this.graphjoin_line = [];
-  this.init_graphjoin();
-  this.init_allele_path();
+}
 
+// Width of a step on the canvas.
+//
+lightningGraph.prototype.step_width = function() {
+  var fold_size = 50;
+  var edge_col_width = 30;
+  var tag_shift = this.font_size*24 + this.font_size*2 + 2*this.line_width + edge_col_width*this.font_size;
+  return fold_size*this.font_size + 2*this.line_width + 2*this.font_size + tag_shift + edge_col_width*this.font_size;
 }
 
-lightningGraph.prototype.chooseCoords = function() {
+// Lay out steps from_step to to_step (all of them if not
+// given).  Steps are laid out on their own so blocks fetched
+// from the server can be added as they come in.
+//
+lightningGraph.prototype.chooseCoords = function(from_step, to_step) {
   //var shift_step = 0;
 
   // for path 2c5
@@ -359,7 +538,10 @@ lightningGraph.prototype.chooseCoords = function() {
   //var path = "2c5";
   var path = this.path;
 
-  for (var step=this.min_step; step<=this.max_step; step++) {
+  if (typeof(from_step) === "undefined") { from_step = this.min_step; }
+  if (typeof(to_step) === "undefined") { to_step = this.max_step; }
+
+  for (var step=from_step; step<=to_step; step++) {
     if (!(step in this.component)) { continue; }
 
     x = (step - shift_step)*shift_x;
@@ -568,6 +750,11 @@ lightningGraph.prototype.draw = function() {
   var ul = g_painter.devToWorld(0, 0);
   var lr = g_painter.devToWorld(w, h);
 
+  if (this.api) {
+    var sw = this.step_width();
+    this.ensure_steps(Math.floor(ul.x/sw) + this.shift_step - 1, Math.floor(lr.x/sw) + this.shift_step + 1);
+  }
+
   for (var id in this.component_pos) {
     var v = this.component_pos[id];
 
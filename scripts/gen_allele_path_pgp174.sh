@@ -8,38 +8,45 @@ b1b="data/GI528476558.fj.gz"
 
 idir="tiles"
 
-opt="-i GRCh38_2c5,<( fjfilter -i <(zcat $b38chr17) -s 2c5.00.3cd -e 2c5.00.52c )"
-opt=" $opt -i GI262359905_rc,<( fjfilter -i <(zcat $b1a) -s 2c5.00.3cd -e 2c5.00.52c )"
-opt=" $opt -i GI528476558,<( fjfilter -i <(zcat $b1b) -s 2c5.00.3cd -e 2c5.00.52c )"
+# Allele, CallSet and VariantSet IDs are allocated after the ones
+# already in the database written by gen_pgp174.sh.
+#
+db="out-data/pgp174.sqlite3"
+
+opt="-i GRCh38_2c5,$b38chr17"
+opt=" $opt -i GI262359905_rc,$b1a"
+opt=" $opt -i GI528476558,$b1b"
 for d in `ls $idir/*/2c5.fj.gz`
 do
   nam=`dirname $d`
   nam=`basename $nam .fj`
-  opt="$opt -i ${nam}_2c5,<( fjfilter -i <(zcat $d) -s 2c5.00.3cd -e 2c5.00.52c )"
+  opt="$opt -i ${nam}_2c5,$d"
 done
 
-starts=" -start-allele-id 0 -start-callset-id 0 -start-variantset-id 0"
+# GRCh38 and the GI assemblies are haploid, the PGP samples diploid.
+#
+starts=" -db $db -csv -ploidy GRCh38_2c5=1 -ploidy GI262359905_rc=1 -ploidy GI528476558=1"
 
-cmd=" ./src/fj2allele $opt -progress -sequence out-data/pgp174_2c5.seq -allele out-data/pgp174_2c5.allele -allele-path out-data/pgp174_2c5.allelepath  -allele-call out-data/pgp174_2c5.allelecall -callset out-data/pgp174_2c5.callset -variantset out-data/pgp174_2c5.variantset -variantset-callset-join out-data/pgp174_2c5.variantset-callset-join -variantset-name brca1 $starts"
+cmd=" ./src/fj2allele -s 2c5.00.3cd -e 2c5.00.52c $opt -progress -sequence out-data/pgp174_2c5.seq -tile-map out-data/pgp174_2c5.tilemap -gfa out-data/pgp174_2c5_paths.gfa -allele out-data/pgp174_2c5.allele -allele-path out-data/pgp174_2c5.allelepath -allele-path-nocall out-data/pgp174_2c5.allelepath-nocall  -allele-call out-data/pgp174_2c5.allelecall -callset out-data/pgp174_2c5.callset -variantset out-data/pgp174_2c5.variantset -variantset-callset-join out-data/pgp174_2c5.variantset-callset-join -graphjoin-variantset out-data/pgp174_2c5.gj_vs -variantset-name brca1 $starts"
 echo ">>>> $cmd"
 bash -c " $cmd "
 
 b2a="data/GI388428999.fj.gz"
 b2b="data/GI528476586.fj.gz"
 
-opt="-i GRCh38_247,<( fjfilter -i <(zcat $b38chr13) -s 247.00.abb -e 2c5.00.c20 )"
-opt=" $opt -i GI388428999,<( fjfilter -i <(zcat $b2a) -s 247.00.abb -e 247.00.c20 )"
-opt=" $opt -i GI528476586,<( fjfilter -i <(zcat $b2b) -s 247.00.abb -e 247.00.c20 )"
+opt="-i GRCh38_247,$b38chr13"
+opt=" $opt -i GI388428999,$b2a"
+opt=" $opt -i GI528476586,$b2b"
 for d in `ls $idir/*/247.fj.gz`
 do
   nam=`dirname $d`
   nam=`basename $nam .fj`
-  opt="$opt -i ${nam}_247,<( fjfilter -i <(zcat $d) -s 247.00.abb -e 247.00.c20 )"
+  opt="$opt -i ${nam}_247,$d"
 done
 
-starts=" -start-allele-id 100000 -start-callset-id 1000000 -start-variantset-id 1"
+starts=" -db $db -csv -ploidy GRCh38_247=1 -ploidy GI388428999=1 -ploidy GI528476586=1"
 
-cmd=" ./src/fj2allele $opt -progress -sequence out-data/pgp174_247.seq -allele out-data/pgp174_247.allele -allele-path out-data/pgp174_247.allelepath  -allele-call out-data/pgp174_247.allelecall -callset out-data/pgp174_247.callset -variantset out-data/pgp174_247.variantset -variantset-callset-join out-data/pgp174_247.variantset-callset-join -variantset-name brca2 $starts "
+cmd=" ./src/fj2allele -s 247.00.abb -e 247.00.c20 $opt -progress -sequence out-data/pgp174_247.seq -tile-map out-data/pgp174_247.tilemap -gfa out-data/pgp174_247_paths.gfa -allele out-data/pgp174_247.allele -allele-path out-data/pgp174_247.allelepath -allele-path-nocall out-data/pgp174_247.allelepath-nocall  -allele-call out-data/pgp174_247.allelecall -callset out-data/pgp174_247.callset -variantset out-data/pgp174_247.variantset -variantset-callset-join out-data/pgp174_247.variantset-callset-join -graphjoin-variantset out-data/pgp174_247.gj_vs -variantset-name brca2 $starts "
 echo ">>>> $cmd"
 bash -c " $cmd "
 
@@ -47,7 +54,14 @@ bash -c " $cmd "
 cat out-data/pgp174_247.seq out-data/pgp174_2c5.seq > out-data/pgp174.seq
 cat out-data/pgp174_247.allele out-data/pgp174_2c5.allele > out-data/pgp174.allele
 cat out-data/pgp174_247.allelepath out-data/pgp174_2c5.allelepath > out-data/pgp174.allelepath
+cat out-data/pgp174_247.allelepath-nocall out-data/pgp174_2c5.allelepath-nocall > out-data/pgp174.allelepath-nocall
 cat out-data/pgp174_247.allelecall out-data/pgp174_2c5.allelecall > out-data/pgp174.allelecall
 cat out-data/pgp174_247.callset out-data/pgp174_2c5.callset > out-data/pgp174.callset
 cat out-data/pgp174_247.variantset out-data/pgp174_2c5.variantset > out-data/pgp174.variantset
 cat out-data/pgp174_247.variantset-callset-join out-data/pgp174_2c5.variantset-callset-join > out-data/pgp174.variantset-callset-join
+cat out-data/pgp174_247.gj_vs out-data/pgp174_2c5.gj_vs > out-data/pgp174.gj_vs
+
+# GFA 1.0 with the sample paths, one file per locus
+#
+cat out-data/pgp174_2c5_graph.gfa out-data/pgp174_2c5_paths.gfa > out-data/pgp174_2c5.gfa
+cat out-data/pgp174_247_graph.gfa out-data/pgp174_247_paths.gfa > out-data/pgp174_247.gfa
@@ -2,6 +2,16 @@
 
 mkdir -p out-data
 
+# Both loci go into the same database so Sequence, GraphJoin, FASTA
+# and VariantSet IDs are allocated after the ones already there.
+# The CSV files are written too.
+#
+# The GRCh38 FastJ of each locus is the Reference, in the grch38
+# ReferenceSet the VariantSets point to.
+#
+db="out-data/pgp174.sqlite3"
+rm -f $db
+
 b38chr17="data/b38_brca1_2c5.fj.gz"
 b38chr13="data/b38_brca2_247.fj.gz"
 
@@ -10,32 +20,34 @@ b1b="data/GI528476558.fj.gz"
 
 #idir="/scratch/brca/tiles/pgp174"
 idir="tiles"
-opt="-i <( fjfilter -i <(zcat $b38chr17) -s 2c5.00.3cd -e 2c5.00.52c )"
-opt=" $opt -i <( fjfilter -i <(zcat $b1a) -s 2c5.00.3cd -e 2c5.00.52c )"
-opt=" $opt -i <( fjfilter -i <(zcat $b1b) -s 2c5.00.3cd -e 2c5.00.52c )"
+opt="-i $b38chr17"
+opt=" $opt -i $b1a"
+opt=" $opt -i $b1b"
 for d in `ls $idir/*/2c5.fj.gz`
 do
-  opt="$opt -i <( fjfilter -i <(zcat $d) -s 2c5.00.3cd -e 2c5.00.52c )"
+  opt="$opt -i $d"
 done
 
-starts=" -start-sequence-id 0 -start-graphjoin-id 0 -fasta-id 1 -variantset-id 0"
-cmd="./src/create_tile_graph --progress $opt -fasta-csv out-data/pgp174_2c5_fasta.csv -fasta out-data/pgp174_2c5.fa -sequence out-data/pgp174_2c5.seq -graphjoin out-data/pgp174_2c5.gj -graphjoin-variantset out-data/pgp174_2c5.gj_vs $starts"
+ref="-reference $b38chr17 -reference-accession 'gi|568815581' -reference-csv out-data/pgp174_2c5.reference -reference-accession-csv out-data/pgp174_2c5.reference-accession -referenceset-csv out-data/pgp174_2c5.referenceset -referenceset-accession-csv out-data/pgp174_2c5.referenceset-accession -reference-referenceset-csv out-data/pgp174_2c5.reference-referenceset -graphjoin-referenceset out-data/pgp174_2c5.gj_rs"
+starts=" -db $db -csv -variantset-name brca1 $ref"
+cmd="./src/create_tile_graph --progress -s 2c5.00.3cd -e 2c5.00.52c $opt -fasta-csv out-data/pgp174_2c5_fasta.csv -fasta out-data/pgp174_2c5.fa -sequence out-data/pgp174_2c5.seq -graphjoin out-data/pgp174_2c5.gj -tile-map out-data/pgp174_2c5.tilemap -gfa out-data/pgp174_2c5_graph.gfa $starts"
 echo ">>>> $cmd"
 bash -c " $cmd "
 
 b2a="data/GI388428999.fj.gz"
 b2b="data/GI528476586.fj.gz"
 
-opt="-i <( fjfilter -i <(zcat $b38chr13) -s 247.00.abb -e 247.00.c20 )"
-opt=" $opt -i <( fjfilter -i <(zcat $b2a) -s 247.00.abb -e 247.00.c20 )"
-opt=" $opt -i <( fjfilter -i <(zcat $b2b) -s 247.00.abb -e 247.00.c20 )"
+opt="-i $b38chr13"
+opt=" $opt -i $b2a"
+opt=" $opt -i $b2b"
 for d in `ls $idir/*/247.fj.gz`
 do
-  opt="$opt -i <( fjfilter -i <(zcat $d) -s 247.00.abb -e 247.00.c20 )"
+  opt="$opt -i $d"
 done
 
-starts=" -start-sequence-id 1000000 -start-graphjoin-id 1000000 -fasta-id 2 -variantset-id 1"
-cmd="./src/create_tile_graph --progress $opt -fasta-csv out-data/pgp174_247_fasta.csv -fasta out-data/pgp174_247.fa -sequence out-data/pgp174_247.seq -graphjoin out-data/pgp174_247.gj -graphjoin-variantset out-data/pgp174_247.gj_vs $starts"
+ref="-reference $b38chr13 -reference-accession 'gi|568815585' -reference-csv out-data/pgp174_247.reference -reference-accession-csv out-data/pgp174_247.reference-accession -referenceset-csv out-data/pgp174_247.referenceset -referenceset-accession-csv out-data/pgp174_247.referenceset-accession -reference-referenceset-csv out-data/pgp174_247.reference-referenceset -graphjoin-referenceset out-data/pgp174_247.gj_rs"
+starts=" -db $db -csv -variantset-name brca2 $ref"
+cmd="./src/create_tile_graph --progress -s 247.00.abb -e 247.00.c20 $opt -fasta-csv out-data/pgp174_247_fasta.csv -fasta out-data/pgp174_247.fa -sequence out-data/pgp174_247.seq -graphjoin out-data/pgp174_247.gj -tile-map out-data/pgp174_247.tilemap -gfa out-data/pgp174_247_graph.gfa $starts"
 echo ">>>> $cmd"
 bash -c " $cmd "
 
@@ -43,4 +55,9 @@ cat out-data/pgp174_247_fasta.csv out-data/pgp174_2c5_fasta.csv > out-data/pgp17
 cat out-data/pgp174_247.seq out-data/pgp174_2c5.seq > out-data/pgp174.seq
 cat out-data/pgp174_247.fa out-data/pgp174_2c5.fa > out-data/pgp174.fa
 cat out-data/pgp174_247.gj out-data/pgp174_2c5.gj > out-data/pgp174.gj
-cat out-data/pgp174_247.gj_vs out-data/pgp174_2c5.gj_vs > out-data/pgp174.gj_vs
+cat out-data/pgp174_247.gj_rs out-data/pgp174_2c5.gj_rs > out-data/pgp174.gj_rs
+cat out-data/pgp174_247.reference out-data/pgp174_2c5.reference > out-data/pgp174.reference
+cat out-data/pgp174_247.reference-accession out-data/pgp174_2c5.reference-accession > out-data/pgp174.reference-accession
+cat out-data/pgp174_247.referenceset out-data/pgp174_2c5.referenceset > out-data/pgp174.referenceset
+cat out-data/pgp174_247.referenceset-accession out-data/pgp174_2c5.referenceset-accession > out-data/pgp174.referenceset-accession
+cat out-data/pgp174_247.reference-referenceset out-data/pgp174_2c5.reference-referenceset > out-data/pgp174.reference-referenceset
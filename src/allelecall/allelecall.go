@@ -0,0 +1,140 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package allelecall works out the Alleles of a sample, and
+// the ploidy each is called with, from the haplotypes in its
+// FastJ (the .000 and .001 tiles).
+//
+// Alleles are named:
+//
+//   [sample]:[variant]            phased haplotype
+//   [sample]:[variant]:unphased   haplotype of an unphased sample
+//   [sample]:0+1                  both haplotypes, if identical and collapsed
+//
+// A haplotype with no tiles isn't an allele, so a diploid
+// sample with only .000 tiles gets a single Allele called
+// with ploidy 1.  Ploidy is the number of copies of the
+// Allele in the sample.
+//
+package allelecall
+
+import "fmt"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+// A haplotype of a sample.  Key is the caller's key for it,
+// Tiles the path.step and md5sum of each of its tiles, in
+// order (see TileSig).
+//
+type Haplotype struct {
+  Key string
+  Variant int
+  Tiles []string
+}
+
+// An Allele of a sample.  Keys are the haplotypes it stands
+// for, the first one giving its path.
+//
+type Call struct {
+  Name string
+  Keys []string
+  Ploidy int
+}
+
+// How a sample is called.  Ploidy is the number of
+// haplotypes it has (1 or 2), Phased is false if any of its
+// tiles are unphased and Collapse merges identical
+// haplotypes into one Allele.
+//
+type Sample struct {
+  Name string
+  Ploidy int
+  Phased bool
+  Collapse bool
+}
+
+// Signature of a tile for comparing haplotypes.
+//
+func TileSig(tile *fastj.Tile) string {
+  return fmt.Sprintf("%s:%s", tile.TileID.Pos(), tile.Md5Sum)
+}
+
+// True if the tile has a "Phase (UNPHASED)" note, as
+// written by vcf2fj.
+//
+func IsUnphased(tile *fastj.Tile) bool {
+  for _,note := range tile.Notes {
+    if strings.HasPrefix(note, "Phase (UNPHASED)") { return true }
+  }
+  return false
+}
+
+// Parse the NAME=PLOIDY ploidy settings.
+//
+func ParsePloidy(specs []string) (map[string]int, error) {
+  ploidy := make(map[string]int)
+  for _,spec := range specs {
+    eq := strings.LastIndex(spec, "=")
+    if eq<1 { return nil, fmt.Errorf("invalid ploidy '%s' (expected NAME=PLOIDY)", spec) }
+
+    p,e := strconv.Atoi(spec[eq+1:])
+    if e!=nil || p<1 || p>2 {
+      return nil, fmt.Errorf("invalid ploidy '%s' (must be 1 or 2)", spec)
+    }
+    ploidy[spec[:eq]] = p
+  }
+  return ploidy, nil
+}
+
+// The Alleles of sample s from its haplotypes, in variant
+// order.  Haplotypes without tiles are left out.
+//
+func Calls(s Sample, haps []Haplotype) ([]Call, error) {
+  called := make([]Haplotype, 0, len(haps))
+  for _,h := range haps {
+    if len(h.Tiles)==0 { continue }
+    if h.Variant >= s.Ploidy {
+      return nil, fmt.Errorf("sample %s has ploidy %d but has .%03x tiles", s.Name, s.Ploidy, h.Variant)
+    }
+    called = append(called, h)
+  }
+
+  if s.Collapse && len(called)==2 && same_tiles(called[0].Tiles, called[1].Tiles) {
+    return []Call{{ Name:fmt.Sprintf("%s:%d+%d", s.Name, called[0].Variant, called[1].Variant),
+      Keys:[]string{called[0].Key, called[1].Key}, Ploidy:2 }}, nil
+  }
+
+  calls := make([]Call, 0, len(called))
+  for _,h := range called {
+    name := fmt.Sprintf("%s:%d", s.Name, h.Variant)
+    if !s.Phased && len(called)>1 { name += ":unphased" }
+    calls = append(calls, Call{ Name:name, Keys:[]string{h.Key}, Ploidy:1 })
+  }
+  return calls, nil
+}
+
+func same_tiles(a, b []string) bool {
+  if len(a)!=len(b) { return false }
+  for i:=0; i<len(a); i++ {
+    if a[i]!=b[i] { return false }
+  }
+  return true
+}
@@ -0,0 +1,147 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package allelecall
+
+import "testing"
+import "reflect"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+func hap(variant int, tiles ...string) Haplotype {
+  return Haplotype{ Key: []string{ "h0", "h1" }[variant], Variant: variant, Tiles: tiles }
+}
+
+func TestCalls(t *testing.T) {
+  tests := []struct {
+    name string
+    s Sample
+    haps []Haplotype
+    want []Call
+  }{
+    { "phased",
+      Sample{ "S", 2, true, false },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "c") },
+      []Call{ { "S:0", []string{ "h0" }, 1 }, { "S:1", []string{ "h1" }, 1 } } },
+
+    { "unphased",
+      Sample{ "S", 2, false, false },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "c") },
+      []Call{ { "S:0:unphased", []string{ "h0" }, 1 }, { "S:1:unphased", []string{ "h1" }, 1 } } },
+
+    { "haploid",
+      Sample{ "S", 1, true, false },
+      []Haplotype{ hap(0, "a", "b") },
+      []Call{ { "S:0", []string{ "h0" }, 1 } } },
+
+    { "haploid with an empty .001",
+      Sample{ "S", 1, true, true },
+      []Haplotype{ hap(0, "a", "b"), hap(1) },
+      []Call{ { "S:0", []string{ "h0" }, 1 } } },
+
+    { "missing .001",
+      Sample{ "S", 2, true, false },
+      []Haplotype{ hap(0, "a", "b"), hap(1) },
+      []Call{ { "S:0", []string{ "h0" }, 1 } } },
+
+    // A single haplotype has nothing to be unphased with.
+    //
+    { "missing .001 unphased",
+      Sample{ "S", 2, false, true },
+      []Haplotype{ hap(0, "a", "b"), hap(1) },
+      []Call{ { "S:0", []string{ "h0" }, 1 } } },
+
+    { "missing .000",
+      Sample{ "S", 2, true, false },
+      []Haplotype{ hap(0), hap(1, "a", "b") },
+      []Call{ { "S:1", []string{ "h1" }, 1 } } },
+
+    { "collapse",
+      Sample{ "S", 2, true, true },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "b") },
+      []Call{ { "S:0+1", []string{ "h0", "h1" }, 2 } } },
+
+    { "collapse unphased",
+      Sample{ "S", 2, false, true },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "b") },
+      []Call{ { "S:0+1", []string{ "h0", "h1" }, 2 } } },
+
+    { "collapse different",
+      Sample{ "S", 2, true, true },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "b", "c") },
+      []Call{ { "S:0", []string{ "h0" }, 1 }, { "S:1", []string{ "h1" }, 1 } } },
+
+    { "identical without collapse",
+      Sample{ "S", 2, true, false },
+      []Haplotype{ hap(0, "a", "b"), hap(1, "a", "b") },
+      []Call{ { "S:0", []string{ "h0" }, 1 }, { "S:1", []string{ "h1" }, 1 } } },
+
+    { "no tiles",
+      Sample{ "S", 2, true, true },
+      []Haplotype{ hap(0), hap(1) },
+      []Call{} },
+  }
+
+  for _,x := range tests {
+    got,e := Calls(x.s, x.haps)
+    if e!=nil { t.Errorf("%s: %v", x.name, e) ; continue }
+    if !reflect.DeepEqual(got, x.want) {
+      t.Errorf("%s: got %+v, expected %+v", x.name, got, x.want)
+    }
+  }
+}
+
+// A haploid sample with .001 tiles is an error.
+//
+func TestCallsPloidyError(t *testing.T) {
+  _,e := Calls(Sample{ "S", 1, true, false }, []Haplotype{ hap(0, "a"), hap(1, "a") })
+  if e==nil { t.Errorf("ploidy 1 with .001 tiles: expected an error") }
+}
+
+func TestParsePloidy(t *testing.T) {
+  got,e := ParsePloidy([]string{ "S1=1", "S2=2", "a=b=1" })
+  if e!=nil { t.Fatal(e) }
+  want := map[string]int{ "S1":1, "S2":2, "a=b":1 }
+  if !reflect.DeepEqual(got, want) {
+    t.Errorf("got %v, expected %v", got, want)
+  }
+
+  for _,spec := range []string{ "S1", "=1", "S1=", "S1=0", "S1=3", "S1=x" } {
+    if _,e := ParsePloidy([]string{ spec }) ; e==nil {
+      t.Errorf("ParsePloidy(%s): expected an error", spec)
+    }
+  }
+}
+
+func TestIsUnphased(t *testing.T) {
+  tests := []struct {
+    notes []string
+    want bool
+  }{
+    { nil, false },
+    { []string{ "tagVariant start 3 a/g" }, false },
+    { []string{ "tagVariant start 3 a/g", "Phase (UNPHASED) 12 c/t" }, true },
+  }
+  for _,x := range tests {
+    if got := IsUnphased(&fastj.Tile{ Notes: x.notes }) ; got!=x.want {
+      t.Errorf("%v: got %v, expected %v", x.notes, got, x.want)
+    }
+  }
+}
@@ -24,6 +24,7 @@
 //  - out.fa
 //  - out.sequence
 //  - out.graphjoin
+//  - out.tilemap
 //
 // The above are the default names.  They can be overidden.
 //
@@ -31,16 +32,79 @@
 //  out.fa        is the FASTA file of sequences.
 //  out.sequence  is a comma separated list of Sequence rows Sequence value order
 //  out.graphjoin is a comma separated list of GraphJoin rows in GraphJoin value orde
+//  out.tilemap   maps each path.step and whole tile md5sum to its body Sequence record name (for fj2allele)
+//
+// Sequences are written (and given IDs) in path.step order, tags before
+// bodies, bodies by rank.  GraphJoins follow the tiles in path.step then
+// rank order.  The same inputs always give byte-identical outputs.
 //
 // example usage (a.fj and b.fj are input FastJ files):
 //
 // ./create_tile_graph -i a.fj -i b.fj -fa out.fa -seq out.seq -graphjoin out.graphjoin
 //
+// With -nocall-fill (ref or consensus) no-calls are filled in before the
+// nodes are built so tiles that only differ by no-calls share a node.
+// The -nocall-map output records the filled tiles so fj2allele can route
+// each allele through the filled nodes and keep the no-calls as an overlay:
+//
+// ./create_tile_graph -i a.fj -i b.fj -nocall-fill ref -nocall-ref ref.fj -nocall-map out.nocallmap ...
+//
+// With -db the FASTA, Sequence and GraphJoin rows
+// are inserted straight into a SQLite database (the schema is created if
+// needed) and the CSV files are only written if -csv is also given:
+//
+// ./create_tile_graph -i a.fj -i b.fj -fasta out.fa -db tilegraph.sqlite3
+//
+// IDs that aren't given on the command line then follow the largest
+// ones already in the database, so each tile path can be built into
+// the same database in turn:
+//
+// ./create_tile_graph -s 2c5.00.3cd -e 2c5.00.52c -variantset-name brca1 -db tilegraph.sqlite3 ...
+// ./create_tile_graph -s 247.00.abb -e 247.00.c20 -variantset-name brca2 -db tilegraph.sqlite3 ...
+//
+// With -gfa the graph is also written as GFA 1.0.  Append the P-lines
+// from fj2allele -gfa to get the sample paths:
+//
+// ./create_tile_graph -i a.fj -i b.fj -gfa graph.gfa ...
+// ./fj2allele -i a.fj -i b.fj -gfa paths.gfa ...
+// cat graph.gfa paths.gfa > brca.gfa
+//
+// With -reference, one of the inputs (by name) is the reference, e.g.
+// the GRCh38 FastJ.  Its .000 tiles spell the sequence of a Reference,
+// written as a FASTA record and Sequence row of its own, named:
+//
+//   [md5sum].[path].[step].s+[steps]
+//
+// The Reference is put in the ReferenceSet of -assembly (added if it
+// isn't in the database yet), which the -variantset-name VariantSet
+// points to.  The GraphJoins the reference tiles go through, the
+// reference path, are written to GraphJoin_ReferenceSet_Join, so the
+// other joins are the alternate ones.  The joins are linked to a
+// VariantSet in GraphJoin_VariantSet_Join by fj2allele and
+// tile_graph_add, from the paths of the VariantSet's samples, so a
+// join the reference alone goes through isn't linked to it:
+//
+// ./create_tile_graph -i GRCh38_2c5,b38_brca1_2c5.fj.gz -i ... -reference GRCh38_2c5 \
+//   -reference-accession 'gi|568815581' -assembly grch38 -assembly-accession GCA_000001405.15 ...
+//
+// Ranks are normally worked out from scratch, by frequency, so adding
+// a sample can change the rank (and so the body Sequence record name)
+// of existing tiles.  With -tile-lib the ranks are kept in a tile
+// library file that is read, if it exists, and written back.  Tiles
+// already in the library keep their rank, new tiles are ranked after
+// them by frequency and frequencies are updated to this run's counts.
+// Tiles in the library but not in this run's input are kept as they
+// are.  -build names the build new tiles were first seen in:
+//
+// ./create_tile_graph -i a.fj -i b.fj -tile-lib brca.tilelib -build pgp174 ...
+// ./create_tile_graph -i a.fj -i b.fj -i c.fj -tile-lib brca.tilelib -build pgp175 ...
+//
 
 
 package main
 
 import "os"
+import "io"
 import "fmt"
 import "log"
 import "strings"
@@ -48,16 +112,20 @@ import "runtime"
 import "runtime/pprof"
 
 import "bufio"
-import "strconv"
 
 import "sort"
 
 import "crypto/md5"
 
 import "github.com/abeconnelly/autoio"
-import "github.com/abeconnelly/sloppyjson"
 import "github.com/codegangsta/cli"
 
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjidx"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/gfa"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/tilelib"
+
 var VERSION_STR string = "0.1.0"
 var gVerboseFlag bool
 
@@ -67,8 +135,8 @@ var gProfileFile string = "create_tile_graph.pprof"
 var gMemProfileFlag bool
 var gMemProfileFile string = "create_tile_graph.mprof"
 
-var g_path_md5sum_freq map[string]map[string]int
-var g_path_md5sum map[string][]string
+var g_path_md5sum_freq map[fastj.TilePos]map[string]int
+var g_path_md5sum map[fastj.TilePos][]string
 
 var g_show_progress bool
 
@@ -77,32 +145,53 @@ var g_START_SEQUENCEID int
 var g_START_GRAPHJOINID int
 var g_VARIANTSETID int
 
+// With -db and -variantset-name, the VariantSet row is
+// inserted too unless it's already in the database.
+//
+var g_VARIANTSET_NAME string
+var g_new_variantset bool
+
 type TileInfo struct {
   Md5Sum string
-  PathStep string
+  PathStep fastj.TilePos
   SeedLen int
   Freq int
   Rank int
+
+  // True if the reference input (-reference) has the tile.
+  //
+  IsRef bool
 }
 
 // Key is [path].[step]
 // Second key is md5sum of full tile sequence.
 //
-var g_tile_lib map[string]map[string]TileInfo
+var g_tile_lib map[fastj.TilePos]map[string]TileInfo
 
 // Map md5sum of whole tile sequence (including tags)
 // to whole tile sequence.
 //
 var g_md5sum_seq map[string]string
 
-// Map Sequence ID for tag to tag sequence
+// A tag or tile body Sequence.  Pos is the position of
+// the tile the tag is the prefix tag for, or the position
+// of the tile the body belongs to.
 //
-var g_id_tag map[string]string
+type SequenceInfo struct {
+  Name string
+  Pos fastj.TilePos
+  IsBody bool
+  Rank int
+  Seq string
+}
+
+// Every tag and body Sequence in output order.
+//
+var g_sequence_order []SequenceInfo
 
-// Map Sequnce ID for tile body to tile body sequence
-// Key is [MD5SUM].[path].[step]+[seed-tile-length]
+// Tiles of the library in path.step then rank order.
 //
-var g_id_body map[string]string
+var g_tile_order []TileInfo
 
 
 // Map either a tag Sequence ID to tile body Sequence ID
@@ -110,6 +199,79 @@ var g_id_body map[string]string
 //
 var g_graphjoin map[string]string
 
+// No-call fill mode, one of "", "ref" or "consensus".
+//
+var g_nocall_fill string
+
+// Reference tiles used to fill no-calls in 'ref' mode.
+//
+var g_nocall_ref map[fastj.TilePos][]*fastj.Tile
+
+// Key is [path].[step]
+// Second key is the md5sum of the original whole tile
+// sequence, value is the md5sum of the filled in tile.
+//
+var g_nocall_fill_map map[fastj.TilePos]map[string]string
+
+// Tile ID range of the inputs to use, -s and -e (inclusive,
+// see fjfilter).  FastJ inputs with an index (see fjindex)
+// are read from the start of the range.
+//
+var g_range_beg fastj.TileID
+var g_range_end fastj.TileID
+
+// Name of the reference input (-reference) and its .000
+// tiles, which spell the Reference sequence.
+//
+var g_reference_input string
+var g_reference_tiles []*fastj.Tile
+
+// A Reference spelled by the reference input.  RecordName
+// is its FASTA record (sequenceRecordName), Name the name of
+// the Reference row.
+//
+type ReferenceInfo struct {
+  RecordName string
+  Name string
+  Assembly string
+  Seq string
+}
+
+// The References spelled by the reference input, one
+// for each stretch of tiles without a gap, in step order.
+//
+var g_reference []ReferenceInfo
+
+var g_START_REFERENCEID int
+var g_REFERENCESETID int
+var g_START_REFERENCE_ACCESSIONID int
+var g_START_REFERENCESET_ACCESSIONID int
+
+// With -db, true if the ReferenceSet of the assembly is
+// already there, in which case it (and its accessions)
+// aren't added again.
+//
+var g_referenceset_in_db bool
+
+var g_reference_rows []graphdb.Reference
+var g_reference_accession_rows []graphdb.ReferenceAccession
+var g_referenceset_rows []graphdb.ReferenceSet
+var g_referenceset_accession_rows []graphdb.ReferenceSetAccession
+var g_reference_referenceset_rows []graphdb.ReferenceReferenceSetJoin
+
+// Persistent tile library (-tile-lib), see the tilelib
+// package.
+//
+var g_persist_lib tilelib.Library
+
+// Build name recorded for tiles added to the library.
+//
+var g_build string
+
+// Whether the CSV files start with a header line.
+//
+var g_csv_header bool
+
 
 
 
@@ -124,17 +286,26 @@ func md5sum_str(seq string) string {
 
 
 func init() {
-  g_path_md5sum_freq = make(map[string]map[string]int)
-  g_path_md5sum = make(map[string][]string)
+  g_path_md5sum_freq = make(map[fastj.TilePos]map[string]int)
+  g_path_md5sum = make(map[fastj.TilePos][]string)
 
   g_md5sum_seq  = make(map[string]string)
-  g_id_tag      = make(map[string]string)
-  g_id_body     = make(map[string]string)
-  g_tile_lib    = make(map[string]map[string]TileInfo)
+  g_tile_lib    = make(map[fastj.TilePos]map[string]TileInfo)
+
+  g_nocall_ref      = make(map[fastj.TilePos][]*fastj.Tile)
+  g_nocall_fill_map = make(map[fastj.TilePos]map[string]string)
+
+  g_persist_lib = tilelib.New()
+  g_bridged = make(map[fastj.TilePos]map[string]bool)
 
   g_FASTAID = 1
   g_START_SEQUENCEID = 1
   g_START_GRAPHJOINID = 1
+
+  g_START_REFERENCEID = 1
+  g_REFERENCESETID = -1
+  g_START_REFERENCE_ACCESSIONID = 1
+  g_START_REFERENCESET_ACCESSIONID = 1
 }
 
 func dump_raw(h autoio.AutoioHandle) {
@@ -145,14 +316,44 @@ func dump_raw(h autoio.AutoioHandle) {
   }
 }
 
-func create_tag_id(tile_path, tag_seq string) string {
-  var no_call_bitvec uint
-  for i:=0; i<24; i++ {
-    if tag_seq[23-i] == 'n' || tag_seq[23-i] == 'N' { no_call_bitvec |= (1<<uint(i)); }
+// Add a single tile to the tile library, g_tile_lib.
+// The whole tile sequence is added to g_md5sum_seq.
+//
+func add_tile(tile *fastj.Tile, is_ref bool) error {
+  tile_path := tile.TileID.Pos()
+
+  if _,ok := g_path_md5sum_freq[tile_path] ; !ok {
+    g_path_md5sum_freq[tile_path] = make(map[string]int)
+  }
+  g_path_md5sum_freq[tile_path][tile.Md5Sum]++
+
+  pfx := "0:"
+  if tile.TileID.Variant == 1 { pfx = "1:" }
+  g_path_md5sum[tile_path] = append(g_path_md5sum[tile_path], pfx + tile.Md5Sum)
+
+  if len(tile.Seq)==0 { return nil }
+
+  if e:=tile.CheckMd5() ; e!=nil { return e }
+
+  if _,ok := g_md5sum_seq[tile.Md5Sum] ; !ok {
+    g_md5sum_seq[tile.Md5Sum] = tile.Seq
   }
-  return fmt.Sprintf("%s.%s.t%06x", md5sum_str(tag_seq), tile_path, no_call_bitvec)
-}
 
+  if _,ok := g_tile_lib[tile_path] ; !ok {
+    g_tile_lib[tile_path] = make(map[string]TileInfo)
+  }
+
+  if _,ok := g_tile_lib[tile_path][tile.Md5Sum] ; !ok {
+    g_tile_lib[tile_path][tile.Md5Sum] = TileInfo{ tile.Md5Sum, tile_path, tile.SeedTileLength, 1, -1, is_ref }
+  } else {
+    z := g_tile_lib[tile_path][tile.Md5Sum]
+    z.Freq++
+    z.IsRef = z.IsRef || is_ref
+    g_tile_lib[tile_path][tile.Md5Sum] = z
+  }
+
+  return nil
+}
 
 // Open a stream and read the FastJ file.
 // Populate g_tile_lib.  This will group
@@ -160,235 +361,424 @@ func create_tag_id(tile_path, tag_seq string) string {
 // each grouping there will be a tile per md5sum
 // with the appropriate TileInfo field.
 //
+// A tile whose next tile (of the same variant) starts on its
+// suffix tag further on than its seedTileLength says has its
+// seed tile length corrected, see fastj.BridgedSeedTileLength.
+//
 func import_fastj(name, fn string) error {
-  var prev_md5sum string
-  var prev_tile_path string
-  var prev_tileid string ; _ = prev_tileid
-  var prev_seedlen int
-
-  var prev_path_i int64
-  var prev_step_i int64
+  fj,e := fjidx.OpenRange(fn, g_range_beg, g_range_end)
+  if e!=nil { return e }
+  defer fj.Close()
 
-  curseq := make([]string, 0, 10)
+  prev := make(map[int]*fastj.Tile)
 
-  h,e := autoio.OpenReadScannerSimple(fn)
-  if e!=nil { return e }
-  defer h.Close()
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { return e }
 
-  for h.ReadScan() {
-    l := h.ReadText()
-    if len(l) == 0 { continue }
+    if p,ok := prev[tile.TileID.Variant] ; ok && len(tile.Seq)>0 {
+      if seedlen := fastj.BridgedSeedTileLength(p, tile) ; seedlen>0 {
+        bridge_tile(name, p, tile, seedlen)
+      }
+    }
+    if len(tile.Seq)>0 { prev[tile.TileID.Variant] = tile }
 
-    if l[0] == '>' {
-      sj,e := sloppyjson.Loads(l[1:])
-      if e!=nil { return e }
+    is_ref := name==g_reference_input && tile.TileID.Variant==0
+    if e:=add_tile(tile, is_ref) ; e!=nil {
+      return fmt.Errorf("%s (line %d): %v", fn, fj.LineNo(), e)
+    }
 
-      md5sum := sj.O["md5sum"].S
-      tileid := sj.O["tileID"].S
-      seedlen := int(sj.O["seedTileLength"].P)
+    if is_ref && len(tile.Seq)>0 {
+      g_reference_tiles = append(g_reference_tiles, tile)
+    }
+  }
 
-      tile_parts := strings.SplitN(tileid, ".", 4)
-      tile_path := fmt.Sprintf("%s.%s", tile_parts[0], tile_parts[2])
+  return nil
 
-      path_i,e := strconv.ParseInt(tile_parts[0], 16, 64)
-      if e!=nil { return e }
-      step_i,e := strconv.ParseInt(tile_parts[2], 16, 64)
-      if e!=nil { return e }
+}
 
-      if _,ok := g_path_md5sum_freq[tile_path] ; !ok {
-        g_path_md5sum_freq[tile_path] = make(map[string]int)
-      }
-      g_path_md5sum_freq[tile_path][md5sum]++
+// Positions and md5sums of the tiles whose seed tile
+// length has been corrected, so it's only reported once.
+//
+var g_bridged map[fastj.TilePos]map[string]bool
 
-      pfx := "0:"
-      if tile_parts[3] == "001" { pfx = "1:" }
-      g_path_md5sum[tile_path] = append(g_path_md5sum[tile_path], pfx + md5sum)
+// Correct the seed tile length of tile, already in the tile
+// library, to seedlen so its body joins the suffix tag at
+// the position of next.
+//
+func bridge_tile(name string, tile, next *fastj.Tile, seedlen int) {
+  pos := tile.TileID.Pos()
+  if g_bridged[pos]==nil { g_bridged[pos] = make(map[string]bool) }
+  if !g_bridged[pos][tile.Md5Sum] {
+    fmt.Fprintf(os.Stderr, "warning: %s: %s (seedTileLength %d) is followed by %s, taking its seedTileLength to be %d\n",
+      name, tile.TileID, tile.SeedTileLength, next.TileID, seedlen)
+    g_bridged[pos][tile.Md5Sum] = true
+  }
 
-      if len(curseq)>0 {
+  tile.SeedTileLength = seedlen
+  if ti,ok := g_tile_lib[pos][tile.Md5Sum] ; ok {
+    ti.SeedLen = seedlen
+    g_tile_lib[pos][tile.Md5Sum] = ti
+  }
+}
 
-        tile_seq := strings.Join(curseq, "")
-        pfx_tag := tile_seq[0:24]
-        sfx_tag := tile_seq[len(tile_seq)-24:]
+type TileStepOrder []*fastj.Tile
+func (t TileStepOrder) Len() int { return len(t) }
+func (t TileStepOrder) Swap(i,j int) { t[i],t[j] = t[j],t[i] }
+func (t TileStepOrder) Less(i,j int) bool { return t[i].TileID.Pos().Less(t[j].TileID.Pos()) }
+
+// Spell the Reference sequences from the reference input's
+// tiles (whose seedTileLengths import_fastj has corrected).
+// A tile that doesn't start where the one before it ends
+// starts a new Reference.  Each Reference is named after the
+// locus of its first tile ([chrom]:[start]-[end], 1-based
+// inclusive) if it has one.
+//
+func build_reference(assembly string) error {
+  tiles := g_reference_tiles
+  if len(tiles)==0 {
+    return fmt.Errorf("no tiles in reference input %s", g_reference_input)
+  }
+  sort.Sort(TileStepOrder(tiles))
 
-        md5_tile_seq := md5sum_str(tile_seq)
-        if md5_tile_seq != prev_md5sum {
-          log.Fatal(fmt.Sprintf("previous md5sum %s (%s) != current md5sum %s (%s)\n", prev_md5sum, tileid, md5_tile_seq, prev_tileid))
-        }
+  g_reference = g_reference[:0]
 
-        if _,ok := g_md5sum_seq[md5_tile_seq] ; !ok {
-          g_md5sum_seq[md5_tile_seq] = tile_seq
-        }
+  beg := 0
+  for i:=1; i<=len(tiles); i++ {
+    if i<len(tiles) {
+      prev := tiles[i-1]
+      tile := tiles[i]
+      if prev.SuffixPos().Cmp(tile.TileID.Pos())==0 { continue }
 
-        pfx_tag_id := create_tag_id(prev_tile_path, pfx_tag)
-        if _,ok := g_id_tag[pfx_tag_id] ; !ok {
-          g_id_tag[pfx_tag_id] = pfx_tag
-        }
+      fmt.Fprintf(os.Stderr, "warning: reference input %s has a gap between %s and %s, starting a new Reference\n",
+        g_reference_input, prev.TileID, tile.TileID)
+    }
 
-        //sfx_tag_id := create_tag_id(prev_tile_path, sfx_tag)
-        prev_sfx_tile_path := fmt.Sprintf("%03x.%04x", prev_path_i, prev_step_i+int64(prev_seedlen))
-        sfx_tag_id := create_tag_id(prev_sfx_tile_path, sfx_tag)
+    g_reference = append(g_reference, reference_segment(tiles[beg:i], assembly))
+    beg = i
+  }
 
-        if _,ok := g_id_tag[sfx_tag_id] ; !ok {
-          g_id_tag[sfx_tag_id] = sfx_tag
-        }
+  return nil
+}
 
-        if _,ok := g_tile_lib[prev_tile_path] ; !ok {
-          g_tile_lib[prev_tile_path] = make(map[string]TileInfo)
-        }
+// One Reference spelled by tiles that follow on from
+// each other.
+//
+func reference_segment(tiles []*fastj.Tile, assembly string) ReferenceInfo {
+  parts := make([]string, 0, len(tiles))
+  for i,tile := range tiles {
+    if i==0 {
+      parts = append(parts, tile.Seq)
+      continue
+    }
+    parts = append(parts, tile.Seq[fastj.TAGLEN:])
+  }
+  seq := strings.Join(parts, "")
+  m5 := md5sum_str(seq)
+
+  first := tiles[0]
+  last := tiles[len(tiles)-1]
+  steps := last.TileID.Step + last.SeedTileLength - first.TileID.Step
+
+  ref := ReferenceInfo{}
+  ref.RecordName = fastj.ReferenceSequenceName(m5, first.TileID.Pos(), steps)
+  ref.Name = g_reference_input
+  ref.Assembly = assembly
+  ref.Seq = seq
+
+  if len(first.Locus)>0 {
+    if r,e := first.Locus[0].Range() ; e==nil {
+      ref.Name = fmt.Sprintf("%s:%d-%d", r.Chrom, r.Start+1, r.Start+len(seq))
+      if len(ref.Assembly)==0 { ref.Assembly = r.Prefix }
+    }
+  }
+  if len(ref.Assembly)==0 { ref.Assembly = g_reference_input }
 
-        if _,ok := g_tile_lib[prev_tile_path][prev_md5sum] ; !ok {
-          g_tile_lib[prev_tile_path][prev_md5sum] = TileInfo{ prev_md5sum, prev_tile_path, prev_seedlen, 1, -1 }
-        } else {
-          z := g_tile_lib[prev_tile_path][prev_md5sum]
-          z.Freq++
-          g_tile_lib[prev_tile_path][prev_md5sum] = z
-        }
+  return ref
+}
 
-      } else {
-      }
+// Reference, ReferenceAccession, ReferenceSet (unless it's
+// in the database already), ReferenceSetAccession and
+// Reference_ReferenceSet_Join rows for the Reference.  The
+// Sequence rows have to be built first.
+//
+func build_reference_rows(update_time string, taxon_id int, ref_accessions, set_accessions []string) {
+  g_reference_rows = make([]graphdb.Reference, 0, len(g_reference))
+  g_reference_accession_rows = make([]graphdb.ReferenceAccession, 0, len(g_reference)*len(ref_accessions))
+  g_reference_referenceset_rows = make([]graphdb.ReferenceReferenceSetJoin, 0, len(g_reference))
+
+  acc_id := g_START_REFERENCE_ACCESSIONID
+  for i,ref := range g_reference {
+    ref_id := g_START_REFERENCEID+i
+
+    g_reference_rows = append(g_reference_rows, graphdb.Reference{
+      Id:ref_id, Name:ref.Name, UpdateTime:update_time,
+      SequenceId:g_sequence_id[ref.RecordName], Start:0, Length:len(ref.Seq),
+      Md5Checksum:md5sum_str(ref.Seq), IsDerived:false, NcbiTaxonId:taxon_id, IsPrimary:true })
+
+    for _,acc := range ref_accessions {
+      g_reference_accession_rows = append(g_reference_accession_rows,
+        graphdb.ReferenceAccession{Id:acc_id, ReferenceId:ref_id, AccessionId:acc})
+      acc_id++
+    }
 
-      curseq = curseq[0:0]
+    g_reference_referenceset_rows = append(g_reference_referenceset_rows,
+      graphdb.ReferenceReferenceSetJoin{ReferenceId:ref_id, ReferenceSetId:g_REFERENCESETID})
+  }
 
-      prev_md5sum     = md5sum
-      prev_tile_path  = tile_path
-      prev_tileid     = tileid
-      prev_seedlen    = seedlen
-      prev_path_i     = path_i
-      prev_step_i     = step_i
+  ref := g_reference[0]
 
-      continue
+  g_referenceset_rows = make([]graphdb.ReferenceSet, 0, 1)
+  g_referenceset_accession_rows = make([]graphdb.ReferenceSetAccession, 0, len(set_accessions))
+  if !g_referenceset_in_db {
+    g_referenceset_rows = append(g_referenceset_rows,
+      graphdb.ReferenceSet{Id:g_REFERENCESETID, NcbiTaxonId:taxon_id, Description:ref.Assembly, AssemblyId:ref.Assembly, IsDerived:false})
 
+    for i,acc := range set_accessions {
+      g_referenceset_accession_rows = append(g_referenceset_accession_rows,
+        graphdb.ReferenceSetAccession{Id:g_START_REFERENCESET_ACCESSIONID+i, ReferenceSetId:g_REFERENCESETID, AccessionId:acc})
     }
+  }
+}
+
+// Sequences are ordered by path.step, tags before bodies, then
+// bodies by rank and tags by record name.  A tag is placed at the
+// position of the tile it is the prefix tag for.
+//
+type SequenceOrder []SequenceInfo
+func (s SequenceOrder) Len() int { return len(s) }
+func (s SequenceOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s SequenceOrder) Less(i,j int) bool {
+  if c := s[i].Pos.Cmp(s[j].Pos) ; c!=0 { return c<0 }
+  if s[i].IsBody != s[j].IsBody { return !s[i].IsBody }
+  if s[i].Rank != s[j].Rank { return s[i].Rank < s[j].Rank }
+  return s[i].Name < s[j].Name
+}
 
-    curseq = append(curseq, l)
+// Populate g_sequence_order with the prefix and suffix tags
+// and the body of every tile in the tile library.  The tiles
+// must be ranked first.
+//
+func build_sequence_order() {
+  seen := make(map[string]bool)
+  g_sequence_order = make([]SequenceInfo, 0, 1024)
 
+  add := func(si SequenceInfo) {
+    if seen[si.Name] { return }
+    seen[si.Name] = true
+    g_sequence_order = append(g_sequence_order, si)
   }
 
-  if len(curseq)>0 {
-    tile_seq := strings.Join(curseq, "")
+  for _,ti := range g_tile_order {
+    tile_seq := g_md5sum_seq[ti.Md5Sum]
+
+    if len(tile_seq)<48 {
+      log.Fatal(fmt.Sprintf(">>>> path_step:%s, md5sum:%s ???? %s\n", ti.PathStep, ti.Md5Sum, tile_seq))
+    }
+
     pfx_tag := tile_seq[0:24]
     sfx_tag := tile_seq[len(tile_seq)-24:]
+    body := tile_seq[24:len(tile_seq)-24]
 
-    md5_tile_seq := md5sum_str(tile_seq)
-    if md5_tile_seq != prev_md5sum {
-      log.Fatal(fmt.Sprintf("previous md5sum %s != current md5sum %s (%s)\n", prev_md5sum, md5_tile_seq, prev_tileid))
-    }
+    add(SequenceInfo{fastj.TagSequenceName(ti.PathStep, pfx_tag), ti.PathStep, false, 0, pfx_tag})
 
-    if _,ok := g_md5sum_seq[md5_tile_seq] ; !ok {
-      g_md5sum_seq[md5_tile_seq] = tile_seq
-    }
+    sfx_pos := ti.PathStep.Next(ti.SeedLen)
+    add(SequenceInfo{fastj.TagSequenceName(sfx_pos, sfx_tag), sfx_pos, false, 0, sfx_tag})
 
-    pfx_tag_id := create_tag_id(prev_tile_path, pfx_tag)
-    if _,ok := g_id_tag[pfx_tag_id] ; !ok {
-      g_id_tag[pfx_tag_id] = pfx_tag
-    }
+    add(SequenceInfo{body_sequence_name(ti), ti.PathStep, true, ti.Rank, body})
+  }
 
-    //sfx_tag_id := create_tag_id(prev_tile_path, sfx_tag)
-    prev_sfx_tile_path := fmt.Sprintf("%03x.%04x", prev_path_i, prev_step_i+int64(prev_seedlen))
-    sfx_tag_id := create_tag_id(prev_sfx_tile_path, sfx_tag)
+  sort.Sort(SequenceOrder(g_sequence_order))
+}
 
-    if _,ok := g_id_tag[sfx_tag_id] ; !ok {
-      g_id_tag[sfx_tag_id] = sfx_tag
-    }
+// Sequence record name of the body of the tile ti.
+//
+func body_sequence_name(ti TileInfo) string {
+  tile_seq := g_md5sum_seq[ti.Md5Sum]
+  body_md5 := md5sum_str(tile_seq[24:len(tile_seq)-24])
+  return fastj.BodySequenceName(body_md5, ti.PathStep, ti.Rank, ti.SeedLen)
+}
 
-    if _,ok := g_tile_lib[prev_tile_path] ; !ok {
-      g_tile_lib[prev_tile_path] = make(map[string]TileInfo)
-    }
+func has_nocall(seq string) bool {
+  return strings.IndexAny(seq, "nN") >= 0
+}
 
-    if _,ok := g_tile_lib[prev_tile_path][prev_md5sum] ; !ok {
-      g_tile_lib[prev_tile_path][prev_md5sum] = TileInfo{ prev_md5sum, prev_tile_path, prev_seedlen, 1, -1 }
-    } else {
-      z := g_tile_lib[prev_tile_path][prev_md5sum]
-      z.Freq++
-      g_tile_lib[prev_tile_path][prev_md5sum] = z
-    }
+// Load the reference FastJ used to fill no-calls.
+//
+func import_nocall_ref(fn string) error {
+  fj,e := fjidx.OpenRange(fn, g_range_beg, g_range_end)
+  if e!=nil { return e }
+  defer fj.Close()
+
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { return e }
+    if len(tile.Seq)==0 { continue }
 
+    path_step := tile.TileID.Pos()
+    g_nocall_ref[path_step] = append(g_nocall_ref[path_step], tile)
   }
 
-  _ = name
   return nil
-
 }
 
-func emit_fasta_sql_csv(ofp *bufio.Writer, fasta_ofn string) {
-  l := fmt.Sprintf("%d,%s\n", g_FASTAID, fasta_ofn)
-  ofp.Write([]byte(l))
-}
+// Fill the no-calls in tile_seq.
+//
+// In 'ref' mode the bases are taken from a reference tile at the
+// same path.step with the same length and seed tile length.  Otherwise,
+// or if there is no such reference tile, each no-call is filled with the
+// most frequent base at that position from the called tiles in the
+// library at the same path.step with the same length and seed tile length.
+//
+// No-calls that can't be filled are left in place.
+//
+func fill_nocall(path_step fastj.TilePos, seedlen int, tile_seq string) string {
+  filled := []byte(tile_seq)
+
+  if g_nocall_fill == "ref" {
+    for _,ref_tile := range g_nocall_ref[path_step] {
+      if len(ref_tile.Seq)!=len(tile_seq) || ref_tile.SeedTileLength!=seedlen { continue }
+      for i:=0; i<len(filled); i++ {
+        if filled[i]=='n' || filled[i]=='N' { filled[i] = ref_tile.Seq[i] }
+      }
+      break
+    }
+  }
 
-func emit_fasta(ofp *bufio.Writer) {
-  fold := 50
+  for i:=0; i<len(filled); i++ {
+    if filled[i]!='n' && filled[i]!='N' { continue }
 
-  seen := make(map[string]bool)
+    base_freq := make(map[byte]int)
+    for m,ti := range g_tile_lib[path_step] {
+      seq := g_md5sum_seq[m]
+      if len(seq)!=len(tile_seq) || ti.SeedLen!=seedlen { continue }
+      if seq[i]=='n' || seq[i]=='N' { continue }
+      base_freq[seq[i]] += ti.Freq
+    }
+
+    var best byte
+    for _,b := range []byte("acgtACGT") {
+      if base_freq[b] > base_freq[best] { best = b }
+    }
+    if best!=0 { filled[i] = best }
+  }
+
+  return string(filled)
+}
 
+// Replace every tile in the library that has no-calls
+// with its filled in version.  Tiles that fill in to the
+// same sequence are merged, adding their frequencies.
+// The original to filled md5sum mapping is kept in
+// g_nocall_fill_map.
+//
+func fill_nocall_tile_lib() {
   for path_step := range g_tile_lib {
-    for m := range g_tile_lib[path_step] {
-      tile_seq := g_md5sum_seq[m]
+    filled_lib := make(map[string]TileInfo)
 
-      pfx_tag := tile_seq[0:24]
-      sfx_tag := tile_seq[len(tile_seq)-24:]
+    for m,ti := range g_tile_lib[path_step] {
+      seq := g_md5sum_seq[m]
 
-      pfx_tag_id := create_tag_id(path_step, pfx_tag)
+      if has_nocall(seq) {
+        filled_seq := fill_nocall(path_step, ti.SeedLen, seq)
+        filled_m5 := md5sum_str(filled_seq)
 
-      //sfx_tag_id := create_tag_id(path_step, sfx_tag)
-      seedlen := g_tile_lib[path_step][m].SeedLen
-      path_step_parts := strings.Split(path_step, ".")
-      path_i,e := strconv.ParseInt(path_step_parts[0], 16, 64)
-      if e!=nil { log.Fatal(e) }
-      step_i,e := strconv.ParseInt(path_step_parts[1], 16, 64)
-      if e!=nil { log.Fatal(e) }
-      sfx_path_step := fmt.Sprintf("%03x.%04x", path_i, step_i+int64(seedlen))
-      sfx_tag_id := create_tag_id(sfx_path_step, sfx_tag)
-
-      body_md5 := md5sum_str(tile_seq[24:len(tile_seq)-24])
-      body_id := fmt.Sprintf("%s.%s.r%x+%0x",
-        body_md5,
-        path_step,
-        g_tile_lib[path_step][m].Rank,
-        g_tile_lib[path_step][m].SeedLen)
-
-
-      if !seen[pfx_tag_id] {
-        seen[pfx_tag_id] = true
-        l := fmt.Sprintf(">%s\n", pfx_tag_id)
-        ofp.Write([]byte(l))
-        ofp.Write([]byte(pfx_tag))
-        ofp.Write([]byte("\n\n"))
+        if _,ok := g_nocall_fill_map[path_step] ; !ok {
+          g_nocall_fill_map[path_step] = make(map[string]string)
+        }
+        g_nocall_fill_map[path_step][m] = filled_m5
+
+        if _,ok := g_md5sum_seq[filled_m5] ; !ok {
+          g_md5sum_seq[filled_m5] = filled_seq
+        }
+
+        ti.Md5Sum = filled_m5
       }
 
-      if !seen[sfx_tag_id] {
-        seen[sfx_tag_id] = true
-        l := fmt.Sprintf(">%s\n", sfx_tag_id)
-        ofp.Write([]byte(l))
-        ofp.Write([]byte(sfx_tag))
-        ofp.Write([]byte("\n\n"))
+      if z,ok := filled_lib[ti.Md5Sum] ; ok {
+        z.Freq += ti.Freq
+        z.IsRef = z.IsRef || ti.IsRef
+        filled_lib[ti.Md5Sum] = z
+      } else {
+        filled_lib[ti.Md5Sum] = ti
       }
+    }
 
-      if !seen[body_id] {
-        seen[body_id] = true
-        l := fmt.Sprintf(">%s\n", body_id)
-        ofp.Write([]byte(l))
+    g_tile_lib[path_step] = filled_lib
+  }
+}
 
-        body := tile_seq[24:len(tile_seq)-24]
-        p:=0
-        for p=0; p<(len(body)-fold); p+=fold {
+// Map of original whole tile md5sum to the filled in
+// whole tile and its sequence, for use by fj2allele.
+//
+// Each line is:
+//
+//   path.step,md5sum,filled_md5sum,filled_sequence
+//
+func emit_nocall_map(ofp *bufio.Writer) {
+  path_steps := make([]fastj.TilePos, 0, len(g_nocall_fill_map))
+  for path_step := range g_nocall_fill_map {
+    path_steps = append(path_steps, path_step)
+  }
+  sort.Sort(fastj.TilePosOrder(path_steps))
 
-          /*
-          //DEBUG
-          fmt.Printf(">>> p %d, len(body) %d, fold %d (%d)\n", p, len(body), fold, len(body)-fold)
+  for _,path_step := range path_steps {
+    m5s := make([]string, 0, len(g_nocall_fill_map[path_step]))
+    for m := range g_nocall_fill_map[path_step] {
+      m5s = append(m5s, m)
+    }
+    sort.Strings(m5s)
 
-          l:=fmt.Sprintf(">>>> p %d s%d:e%d (%d-%d=%d)\n", p, fold*p, fold*(p+1), len(body), fold, len(body)-fold)
-          ofp.Write([]byte(l))
-          ofp.Flush()
-          */
+    for _,m := range m5s {
+      filled_m5 := g_nocall_fill_map[path_step][m]
+      l := fmt.Sprintf("%s,%s,%s,%s\n", path_step, m, filled_m5, g_md5sum_seq[filled_m5])
+      ofp.Write([]byte(l))
+    }
+  }
+}
 
-          ofp.Write([]byte(body[p:p+fold]))
-          ofp.Write([]byte("\n"))
-        }
-        ofp.Write([]byte(body[p:]))
-        ofp.Write([]byte("\n\n"))
-      }
+// Map of whole tile md5sum to the body Sequence record
+// name the tile goes through, for use by fj2allele.
+//
+// Each line is:
+//
+//   path.step,md5sum,body_sequence_record_name
+//
+func emit_tile_map(ofp *bufio.Writer) {
+  for _,ti := range g_tile_order {
+    l := fmt.Sprintf("%s,%s,%s\n", ti.PathStep, ti.Md5Sum, body_sequence_name(ti))
+    ofp.Write([]byte(l))
+  }
+}
+
+func emit_fasta_sql_csv(ofp *bufio.Writer, fasta_ofn string) {
+  emit_row(ofp, "FASTA", graphdb.Row{ "ID":g_FASTAID, "fastaURI":fasta_ofn })
+}
 
+func emit_fasta(ofp *bufio.Writer) {
+  fold := fastj.FOLD
+
+  for _,si := range g_sequence_order {
+    l := fmt.Sprintf(">%s\n", si.Name)
+    ofp.Write([]byte(l))
+
+    if !si.IsBody {
+      ofp.Write([]byte(si.Seq))
+      ofp.Write([]byte("\n\n"))
+      continue
     }
+
+    if len(si.Seq)==0 { ofp.Write([]byte("\n")) }
+    fastj.WriteFold(ofp, si.Seq, fold)
+    ofp.Write([]byte("\n"))
+  }
+
+  for _,ref := range g_reference {
+    ofp.Write([]byte(fmt.Sprintf(">%s\n", ref.RecordName)))
+    fastj.WriteFold(ofp, ref.Seq, fold)
+    ofp.Write([]byte("\n"))
   }
 
 }
@@ -397,7 +787,18 @@ func emit_fasta(ofp *bufio.Writer) {
 //
 var g_sequence_id map[string]int
 
-func emit_sequences(ofp *bufio.Writer) {
+// Sequence and GraphJoin rows, built once and then
+// written out as CSV and/or into the database.
+//
+var g_sequence_rows []graphdb.Sequence
+var g_graphjoin_rows []graphdb.GraphJoin
+
+// GraphJoins the reference input's tiles go through, in
+// ID order.
+//
+var g_graphjoin_referenceset_rows []graphdb.GraphJoinReferenceSetJoin
+
+func build_sequences() {
   //seq_id  := 1
   //fa_id   := 1
 
@@ -405,144 +806,260 @@ func emit_sequences(ofp *bufio.Writer) {
   fa_id   := g_FASTAID
 
   g_sequence_id = make(map[string]int)
+  g_sequence_rows = make([]graphdb.Sequence, 0, len(g_sequence_order))
 
-  for id_tag := range g_id_tag {
-    m5 := md5sum_str(g_id_tag[id_tag])
+  for _,si := range g_sequence_order {
+    g_sequence_rows = append(g_sequence_rows, graphdb.Sequence{Id:seq_id, FastaId:fa_id, SequenceRecordName:si.Name, Md5Checksum:md5sum_str(si.Seq), Length:len(si.Seq)})
+    g_sequence_id[si.Name] = seq_id
+    seq_id++
+  }
+
+  // The Reference sequences come last.
+  //
+  for _,ref := range g_reference {
+    g_sequence_rows = append(g_sequence_rows, graphdb.Sequence{Id:seq_id, FastaId:fa_id, SequenceRecordName:ref.RecordName, Md5Checksum:md5sum_str(ref.Seq), Length:len(ref.Seq)})
+    g_sequence_id[ref.RecordName] = seq_id
+    seq_id++
+  }
 
-    l := fmt.Sprintf("%d,%d,%s,%s,%d\n", seq_id, fa_id, id_tag, m5, 24)
+}
 
-    if _,ok := g_sequence_id[id_tag] ; !ok {
-      ofp.Write( []byte(l) )
-      g_sequence_id[id_tag] = seq_id
-      seq_id++
-    }
+func emit_sequences(ofp *bufio.Writer) {
+  for i:=0; i<len(g_sequence_rows); i++ {
+    s := g_sequence_rows[i]
+    emit_row(ofp, "Sequence", graphdb.Row{ "ID":s.Id, "fastaID":s.FastaId,
+      "sequenceRecordName":s.SequenceRecordName, "md5checksum":s.Md5Checksum, "length":s.Length })
   }
+}
 
-  for path_step := range g_tile_lib {
-    for m := range g_tile_lib[path_step] {
-      tile_seq := g_md5sum_seq[m]
+func new_graphjoin(id, seq1, pos1 int, fwd1 bool, seq2, pos2 int, fwd2 bool) graphdb.GraphJoin {
+  return graphdb.GraphJoin{
+    Id:id,
+    Side1SequenceId:seq1, Side1Position:pos1, Side1StrandIsForward:fwd1,
+    Side2SequenceId:seq2, Side2Position:pos2, Side2StrandIsForward:fwd2 }
+}
+
+func build_graphjoins() {
+
+  g_graphjoin_rows = make([]graphdb.GraphJoin,0,1024)
+
+  //gj_id := 1
+  gj_id := g_START_GRAPHJOINID
+
+  seen_hash := make(map[string]int)
+  ref_join := make(map[int]bool)
+
+  for _,ti := range g_tile_order {
+    path_step := ti.PathStep
+    tile_seq := g_md5sum_seq[ti.Md5Sum]
+
+    pfx_tag := tile_seq[0:24]
+    sfx_tag := tile_seq[len(tile_seq)-24:]
+
+    pfx_tag_id := fastj.TagSequenceName(path_step, pfx_tag)
+    sfx_tag_id := fastj.TagSequenceName(path_step.Next(ti.SeedLen), sfx_tag)
+
+    body_id := body_sequence_name(ti)
 
-      if len(tile_seq)<48 {
-        log.Fatal(fmt.Sprintf(">>>> path_step:%s, md5sum:%s ???? %s\n", path_step, m, tile_seq))
-      }
 
-      body_md5 := md5sum_str(tile_seq[24:len(tile_seq)-24])
+    pfx_seq_id := g_sequence_id[pfx_tag_id]
+    sfx_seq_id := g_sequence_id[sfx_tag_id]
+    body_seq_id := g_sequence_id[body_id]
+
+    key := fmt.Sprintf("%x:%x", pfx_seq_id, body_seq_id)
+    if _,seen := seen_hash[key]; !seen {
+      if pfx_tag_id < body_id {
+        g_graphjoin_rows = append(g_graphjoin_rows, new_graphjoin(gj_id, pfx_seq_id, 23, false, body_seq_id, 0, true))
+      } else {
+        g_graphjoin_rows = append(g_graphjoin_rows, new_graphjoin(gj_id, body_seq_id, 0, true, pfx_seq_id, 23, false))
+      }
 
-      body_id := fmt.Sprintf("%s.%s.r%x+%0x",
-        body_md5,
-        path_step,
-        g_tile_lib[path_step][m].Rank,
-        g_tile_lib[path_step][m].SeedLen)
-      l := fmt.Sprintf("%d,%d,%s,%s,%d\n", seq_id, fa_id, body_id, body_md5, len(tile_seq)-48)
+      seen_hash[key]=gj_id
+      gj_id++
+    }
+    if ti.IsRef { ref_join[seen_hash[key]] = true }
 
-      if _,ok := g_sequence_id[body_id] ; !ok {
-        ofp.Write([]byte(l))
-        g_sequence_id[body_id]=seq_id
-        seq_id++
+    key = fmt.Sprintf("%x:%x", sfx_seq_id, body_seq_id)
+    if _,seen := seen_hash[key]; !seen {
+      if body_id < sfx_tag_id {
+        g_graphjoin_rows = append(g_graphjoin_rows, new_graphjoin(gj_id, body_seq_id, len(tile_seq)-49, false, sfx_seq_id, 0, true))
+      } else {
+        g_graphjoin_rows = append(g_graphjoin_rows, new_graphjoin(gj_id, sfx_seq_id, 0, true, body_seq_id, len(tile_seq)-49, false))
       }
 
+      seen_hash[key]=gj_id
+      gj_id++
     }
+    if ti.IsRef { ref_join[seen_hash[key]] = true }
+
   }
 
-}
+  g_graphjoin_referenceset_rows = make([]graphdb.GraphJoinReferenceSetJoin, 0, len(ref_join))
+  if len(g_reference)==0 { return }
+  for _,gj := range g_graphjoin_rows {
+    if !ref_join[gj.Id] { continue }
+    g_graphjoin_referenceset_rows = append(g_graphjoin_referenceset_rows, graphdb.GraphJoinReferenceSetJoin{GraphJoinId:gj.Id, ReferenceSetId:g_REFERENCESETID})
+  }
 
-var g_graphjoin_id_list []int
+}
 
 func emit_graphjoin(ofp *bufio.Writer) {
+  for i:=0; i<len(g_graphjoin_rows); i++ {
+    gj := g_graphjoin_rows[i]
+    emit_row(ofp, "GraphJoin", graphdb.Row{ "ID":gj.Id,
+      "side1SequenceID":gj.Side1SequenceId, "side1Position":gj.Side1Position, "side1StrandIsForward":gj.Side1StrandIsForward,
+      "side2SequenceID":gj.Side2SequenceId, "side2Position":gj.Side2Position, "side2StrandIsForward":gj.Side2StrandIsForward })
+  }
+}
 
-  g_graphjoin_id_list = make([]int,0,1024)
+func emit_graphjoin_referenceset(ofp *bufio.Writer) {
+  for _,j := range g_graphjoin_referenceset_rows {
+    emit_row(ofp, "GraphJoin_ReferenceSet_Join", graphdb.Row{ "graphJoinID":j.GraphJoinId, "referenceSetID":j.ReferenceSetId })
+  }
+}
 
-  //gj_id := 1
-  gj_id := g_START_GRAPHJOINID
+// Reference rows (sourceDivergence left empty).
+//
+func emit_reference(ofp *bufio.Writer) {
+  for _,r := range g_reference_rows {
+    emit_row(ofp, "Reference", graphdb.Row{ "ID":r.Id, "name":r.Name, "updateTime":r.UpdateTime,
+      "sequenceID":r.SequenceId, "start":r.Start, "length":r.Length, "md5checksum":r.Md5Checksum,
+      "isDerived":r.IsDerived, "ncbiTaxonID":r.NcbiTaxonId, "isPrimary":r.IsPrimary })
+  }
+}
 
-  seen_hash := make(map[string]bool)
+func emit_reference_accession(ofp *bufio.Writer) {
+  for _,a := range g_reference_accession_rows {
+    emit_row(ofp, "ReferenceAccession", graphdb.Row{ "ID":a.Id, "referenceID":a.ReferenceId, "accessionID":a.AccessionId })
+  }
+}
 
-  for path_step := range g_tile_lib {
-    for m := range g_tile_lib[path_step] {
-      tile_seq := g_md5sum_seq[m]
+func emit_referenceset(ofp *bufio.Writer) {
+  for _,r := range g_referenceset_rows {
+    emit_row(ofp, "ReferenceSet", graphdb.Row{ "ID":r.Id, "ncbiTaxonID":r.NcbiTaxonId,
+      "description":r.Description, "assemblyID":r.AssemblyId, "isDerived":r.IsDerived })
+  }
+}
 
-      pfx_tag := tile_seq[0:24]
-      sfx_tag := tile_seq[len(tile_seq)-24:]
+func emit_referenceset_accession(ofp *bufio.Writer) {
+  for _,a := range g_referenceset_accession_rows {
+    emit_row(ofp, "ReferenceSetAccession", graphdb.Row{ "ID":a.Id, "referenceSetID":a.ReferenceSetId, "accessionID":a.AccessionId })
+  }
+}
 
-      pfx_tag_id := create_tag_id(path_step, pfx_tag)
+func emit_reference_referenceset(ofp *bufio.Writer) {
+  for _,j := range g_reference_referenceset_rows {
+    emit_row(ofp, "Reference_ReferenceSet_Join", graphdb.Row{ "referenceID":j.ReferenceId, "referenceSetID":j.ReferenceSetId })
+  }
+}
 
-      //sfx_tag_id := create_tag_id(path_step, sfx_tag)
-      seedlen := int64(g_tile_lib[path_step][m].SeedLen)
-      path_step_parts := strings.Split(path_step, ".")
-      path_i,e := strconv.ParseInt(path_step_parts[0], 16, 64)
-      if e!=nil { log.Fatal(e) }
-      step_i,e := strconv.ParseInt(path_step_parts[1], 16, 64)
-      if e!=nil { log.Fatal(e) }
-      sfx_path_step := fmt.Sprintf("%03x.%04x", path_i, step_i+seedlen)
-      sfx_tag_id := create_tag_id(sfx_path_step, sfx_tag)
-
-
-      body_md5 := md5sum_str(tile_seq[24:len(tile_seq)-24])
-      body_id := fmt.Sprintf("%s.%s.r%x+%0x",
-        body_md5,
-        path_step,
-        g_tile_lib[path_step][m].Rank,
-        g_tile_lib[path_step][m].SeedLen)
-
-
-      pfx_seq_id := g_sequence_id[pfx_tag_id]
-      sfx_seq_id := g_sequence_id[sfx_tag_id]
-      body_seq_id := g_sequence_id[body_id]
-
-      key := fmt.Sprintf("%x:%x", pfx_seq_id, body_seq_id)
-      if _,seen := seen_hash[key]; !seen {
-        if pfx_tag_id < body_id {
-          //l:=fmt.Sprintf("%d,%d,%d,'FALSE',%d,%d,'TRUE'\n", gj_id, pfx_seq_id, 23, body_seq_id, len(tile_seq)-49)
-          l:=fmt.Sprintf("%d,%d,%d,'FALSE',%d,%d,'TRUE'\n", gj_id, pfx_seq_id, 23, body_seq_id, 0)
-          ofp.Write([]byte(l))
-        } else {
-          //l:=fmt.Sprintf("%d,%d,%d,'TRUE',%d,%d,'FALSE'\n", gj_id, body_seq_id, len(tile_seq)-49, pfx_seq_id, 23)
-          l:=fmt.Sprintf("%d,%d,%d,'TRUE',%d,%d,'FALSE'\n", gj_id, body_seq_id, 0, pfx_seq_id, 23)
-          ofp.Write([]byte(l))
-        }
+// Write the graph as GFA 1.0: an S-line for every tag and body
+// Sequence and an L-line for every GraphJoin.  The allele paths
+// are written by fj2allele -gfa and can be appended.
+//
+func emit_gfa(ofp *bufio.Writer) {
+  id_name := make(map[int]string)
+  for _,seq := range g_sequence_rows {
+    id_name[seq.Id] = seq.SequenceRecordName
+  }
 
-        g_graphjoin_id_list = append(g_graphjoin_id_list, gj_id)
+  gfa.WriteHeader(ofp)
 
-        gj_id++
-        seen_hash[key]=true
-      }
+  for _,si := range g_sequence_order {
+    gfa.WriteSegment(ofp, si.Name, si.Seq)
+  }
 
-      key = fmt.Sprintf("%x:%x", sfx_seq_id, body_seq_id)
-      if _,seen := seen_hash[key]; !seen {
-        if body_id < sfx_tag_id {
-          //l:=fmt.Sprintf("%d,%d,%d,'TRUE',%d,%d,'FALSE'\n", gj_id, body_seq_id, len(tile_seq)-49, sfx_seq_id, 23)
-          l:=fmt.Sprintf("%d,%d,%d,'FALSE',%d,%d,'TRUE'\n", gj_id, body_seq_id, len(tile_seq)-49, sfx_seq_id, 0)
-          ofp.Write([]byte(l))
-        } else {
-          //l:=fmt.Sprintf("%d,%d,%d,'FALSE',%d,%d,'TRUE'\n", gj_id, sfx_seq_id, 23, body_seq_id, len(tile_seq)-49)
-          l:=fmt.Sprintf("%d,%d,%d,'TRUE',%d,%d,'FALSE'\n", gj_id, sfx_seq_id, 0, body_seq_id, len(tile_seq)-49)
-          ofp.Write([]byte(l))
-        }
+  for _,gj := range g_graphjoin_rows {
+    gfa.WriteLink(ofp,
+      id_name[gj.Side1SequenceId], gj.Side1StrandIsForward,
+      id_name[gj.Side2SequenceId], gj.Side2StrandIsForward)
+  }
+}
 
-        g_graphjoin_id_list = append(g_graphjoin_id_list, gj_id)
+// Write the FASTA, Sequence and GraphJoin rows, with the VariantSet
+// and the Reference rows, into the SQLite database db_fn inside a
+// single transaction.
+//
+func write_db(db_fn, fasta_ofn string) error {
+  db,e := graphdb.Open(db_fn)
+  if e!=nil { return e }
+  defer db.Close()
 
-        gj_id++
-        seen_hash[key]=true
-      }
+  tx,e := db.Begin()
+  if e!=nil { return e }
 
-    }
+  if len(g_reference)>0 {
+    if e = graphdb.InsertReferenceSet(tx, g_referenceset_rows) ; e!=nil { tx.Rollback() ; return e }
+    if e = graphdb.InsertReferenceSetAccession(tx, g_referenceset_accession_rows) ; e!=nil { tx.Rollback() ; return e }
+    if e = graphdb.InsertReference(tx, g_reference_rows) ; e!=nil { tx.Rollback() ; return e }
+    if e = graphdb.InsertReferenceAccession(tx, g_reference_accession_rows) ; e!=nil { tx.Rollback() ; return e }
+    if e = graphdb.InsertReferenceReferenceSetJoin(tx, g_reference_referenceset_rows) ; e!=nil { tx.Rollback() ; return e }
+  }
+  if g_new_variantset {
+    vs := []graphdb.VariantSet{{Id:g_VARIANTSETID, ReferenceSetId:g_REFERENCESETID, Name:g_VARIANTSET_NAME}}
+    if e = graphdb.InsertVariantSet(tx, vs) ; e!=nil { tx.Rollback() ; return e }
   }
+  if e = graphdb.InsertFASTA(tx, []graphdb.FASTA{{Id:g_FASTAID, FastaURI:fasta_ofn}}) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertSequence(tx, g_sequence_rows) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertGraphJoin(tx, g_graphjoin_rows) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertGraphJoinReferenceSetJoin(tx, g_graphjoin_referenceset_rows) ; e!=nil { tx.Rollback() ; return e }
 
+  return tx.Commit()
 }
 
-func emit_graphjoin_variantset(ofp *bufio.Writer) {
-  for i:=0; i<len(g_graphjoin_id_list); i++ {
-    l := fmt.Sprintf("%d,%d\n", g_graphjoin_id_list[i], g_VARIANTSETID)
-    ofp.Write([]byte(l))
+// With -db, the IDs not given on the command line follow the
+// largest ones already in the database, so several runs (one
+// per tile path, say) can go into the same database without
+// picking ID ranges by hand.  The VariantSet is looked up by
+// -variantset-name and added if it isn't there, and likewise
+// the ReferenceSet by its assembly.
+//
+func auto_ids(c *cli.Context, db_fn string) error {
+  db,e := graphdb.Open(db_fn)
+  if e!=nil { return e }
+  defer db.Close()
+
+  next_id := func(flag, table string, id *int) error {
+    if c.IsSet(flag) { return nil }
+    max_id,e := graphdb.MaxId(db, table)
+    if e!=nil { return e }
+    *id = max_id+1
+    return nil
   }
-}
 
-var path_step_order []string
+  if e:=next_id("start-sequence-id", "Sequence", &g_START_SEQUENCEID) ; e!=nil { return e }
+  if e:=next_id("start-graphjoin-id", "GraphJoin", &g_START_GRAPHJOINID) ; e!=nil { return e }
+  if e:=next_id("fasta-id", "FASTA", &g_FASTAID) ; e!=nil { return e }
 
-type LexOrder []string
-func (s LexOrder) Len() int { return len(s) }
-func (s LexOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
-func (s LexOrder) Less(i,j int) bool { return s[i] < s[j] }
+  if len(g_reference)>0 {
+    if e:=next_id("start-reference-id", "Reference", &g_START_REFERENCEID) ; e!=nil { return e }
+    if e:=next_id("-", "ReferenceAccession", &g_START_REFERENCE_ACCESSIONID) ; e!=nil { return e }
+
+    rs,ok,e := graphdb.ReferenceSetByAssembly(db, g_reference[0].Assembly)
+    if e!=nil { return e }
+    if ok {
+      g_REFERENCESETID = rs.Id
+      g_referenceset_in_db = true
+    } else {
+      if e:=next_id("referenceset-id", "ReferenceSet", &g_REFERENCESETID) ; e!=nil { return e }
+      if e:=next_id("-", "ReferenceSetAccession", &g_START_REFERENCESET_ACCESSIONID) ; e!=nil { return e }
+    }
+  }
+
+  if len(g_VARIANTSET_NAME)==0 { return nil }
+
+  vs,ok,e := graphdb.VariantSetByName(db, g_VARIANTSET_NAME)
+  if e!=nil { return e }
+  if ok {
+    g_VARIANTSETID = vs.Id
+    return nil
+  }
+
+  g_new_variantset = true
+  return next_id("variantset-id", "VariantSet", &g_VARIANTSETID)
+}
+
+var path_step_order []fastj.TilePos
 
 type TileFreqOrder []TileInfo
 func (t TileFreqOrder) Len() int { return len(t) }
@@ -553,11 +1070,27 @@ func (t TileFreqOrder) Less(i,j int) bool {
   return t[i].Md5Sum < t[j].Md5Sum
 }
 
+type TileRankOrder []TileInfo
+func (t TileRankOrder) Len() int { return len(t) }
+func (t TileRankOrder) Swap(i,j int) { t[i],t[j] = t[j],t[i] }
+func (t TileRankOrder) Less(i,j int) bool { return t[i].Rank < t[j].Rank }
+
+// Rank the tiles of each path.step.  Tiles in the persistent
+// library keep their rank, the rest are ranked by frequency
+// (then md5sum) after the highest rank in the library.  The
+// library is updated with the new ranks and frequencies.
+//
 func rank_tile_lib() {
-  path_step_order = make([]string, 0, len(g_tile_lib))
+  path_step_order = make([]fastj.TilePos, 0, len(g_tile_lib))
   for path_step := range g_tile_lib {
     path_step_order = append(path_step_order, path_step)
 
+    if _,ok := g_persist_lib[path_step] ; !ok {
+      g_persist_lib[path_step] = make(map[string]tilelib.Tile)
+    }
+    lib := g_persist_lib[path_step]
+    next_rank := g_persist_lib.NextRank(path_step)
+
     freq_order := make([]TileInfo, 0, len(g_tile_lib[path_step]))
     for m5 := range g_tile_lib[path_step] {
       freq_order = append(freq_order, g_tile_lib[path_step][m5])
@@ -567,22 +1100,106 @@ func rank_tile_lib() {
 
     for i:=0; i<len(freq_order); i++ {
       z := g_tile_lib[ freq_order[i].PathStep ][ freq_order[i].Md5Sum ]
-      z.Rank = i
+
+      if lt,ok := lib[z.Md5Sum] ; ok {
+        z.Rank = lt.Rank
+        lt.Freq = z.Freq
+        lib[z.Md5Sum] = lt
+      } else {
+        z.Rank = next_rank
+        next_rank++
+        lib[z.Md5Sum] = tilelib.Tile{ Md5Sum:z.Md5Sum, PathStep:z.PathStep, SeedLen:z.SeedLen, Freq:z.Freq, Rank:z.Rank, Build:g_build }
+      }
+
       g_tile_lib[ freq_order[i].PathStep ][ freq_order[i].Md5Sum ] = z
     }
 
   }
 
-  sort.Sort(LexOrder(path_step_order))
+  sort.Sort(fastj.TilePosOrder(path_step_order))
+
+  // Flatten the library into path.step then rank order.
+  //
+  g_tile_order = make([]TileInfo, 0, len(path_step_order))
+  for _,path_step := range path_step_order {
+    start := len(g_tile_order)
+    for m5 := range g_tile_lib[path_step] {
+      g_tile_order = append(g_tile_order, g_tile_lib[path_step][m5])
+    }
+    sort.Sort(TileRankOrder(g_tile_order[start:]))
+  }
 
 }
 
+func create_csv(fn string, emit func(*bufio.Writer)) {
+  out,err := autoio.CreateWriter( fn )
+  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
+  emit(out.Writer)
+  out.Flush()
+  out.Close()
+}
+
+// CSV file of a table, with a header
+// line naming the columns if -csv-header is set.
+//
+func create_table_csv(fn, table string, emit func(*bufio.Writer)) {
+  create_csv(fn, func(ofp *bufio.Writer) {
+    if g_csv_header {
+      h,e := graphdb.CSVHeader(table)
+      if e!=nil { log.Fatal(e) }
+      ofp.WriteString(h)
+    }
+    emit(ofp)
+  })
+}
+
+func emit_row(ofp *bufio.Writer, table string, vals graphdb.Row) {
+  l,e := graphdb.CSVRow(table, vals)
+  if e!=nil { log.Fatal(e) }
+  ofp.WriteString(l)
+}
+
+// Write the FASTA, Sequence and GraphJoin CSV files for
+// import with sqlite3 .import.
+//
+func emit_csv(fasta_ofn, fasta_csv_ofn, sequence_ofn, graphjoin_ofn string) {
+  create_table_csv(fasta_csv_ofn, "FASTA", func(ofp *bufio.Writer) { emit_fasta_sql_csv(ofp, fasta_ofn) })
+  create_table_csv(sequence_ofn, "Sequence", emit_sequences)
+  create_table_csv(graphjoin_ofn, "GraphJoin", emit_graphjoin)
+}
+
+// Write the Reference, ReferenceAccession, ReferenceSet,
+// ReferenceSetAccession, Reference_ReferenceSet_Join and
+// GraphJoin_ReferenceSet_Join CSV files, only when there
+// is a -reference.
+//
+func emit_reference_csv(c *cli.Context) {
+  create_table_csv(c.String("reference-csv"), "Reference", emit_reference)
+  create_table_csv(c.String("reference-accession-csv"), "ReferenceAccession", emit_reference_accession)
+  create_table_csv(c.String("referenceset-csv"), "ReferenceSet", emit_referenceset)
+  create_table_csv(c.String("referenceset-accession-csv"), "ReferenceSetAccession", emit_referenceset_accession)
+  create_table_csv(c.String("reference-referenceset-csv"), "Reference_ReferenceSet_Join", emit_reference_referenceset)
+  create_table_csv(c.String("graphjoin-referenceset"), "GraphJoin_ReferenceSet_Join", emit_graphjoin_referenceset)
+}
+
 func _main( c *cli.Context ) {
 
   g_START_SEQUENCEID = c.Int("start-sequence-id")
   g_START_GRAPHJOINID = c.Int("start-graphjoin-id")
   g_FASTAID = c.Int("fasta-id")
   g_VARIANTSETID = c.Int("variantset-id")
+  g_VARIANTSET_NAME = c.String("variantset-name")
+
+  g_nocall_fill = c.String("nocall-fill")
+  if g_nocall_fill=="none" { g_nocall_fill = "" }
+  if g_nocall_fill!="" && g_nocall_fill!="ref" && g_nocall_fill!="consensus" {
+    fmt.Fprintf(os.Stderr, "invalid -nocall-fill '%s' (must be none, ref or consensus)\n", g_nocall_fill)
+    os.Exit(1)
+  }
+  if g_nocall_fill=="ref" && len(c.String("nocall-ref"))==0 {
+    fmt.Fprintf(os.Stderr, "-nocall-fill ref requires -nocall-ref\n")
+    os.Exit(1)
+  }
 
   if c.Bool( "pprof" ) {
     gProfileFlag = true
@@ -614,32 +1231,37 @@ func _main( c *cli.Context ) {
 
 
   fasta_ofn     := c.String("fasta")
-  sequence_ofn  := c.String("sequence")
-  graphjoin_ofn := c.String("graphjoin")
   fasta_csv_ofn := c.String("fasta-csv")
-  graphjoin_variantset_ofn := c.String("graphjoin-variantset")
+  tile_map_ofn  := c.String("tile-map")
 
-  fasta_out,err := autoio.CreateWriter( fasta_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
-  defer func() { fasta_out.Flush() ; fasta_out.Close() }()
+  db_fn := c.String("db")
+  write_csv := len(db_fn)==0 || c.Bool("csv")
 
-  fasta_csv_out,err := autoio.CreateWriter( fasta_csv_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
-  defer func() { fasta_csv_out.Flush() ; fasta_csv_out.Close() }()
+  g_reference_input = c.String("reference")
 
-  seq_out,err := autoio.CreateWriter( sequence_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
-  defer func() { seq_out.Flush() ; seq_out.Close() }()
+  g_csv_header = c.Bool("csv-header")
 
-  gj_out,err := autoio.CreateWriter( graphjoin_ofn )
+  fasta_out,err := autoio.CreateWriter( fasta_ofn )
   if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
-  defer func() { gj_out.Flush() ; gj_out.Close() }()
+  defer func() { fasta_out.Flush() ; fasta_out.Close() }()
 
-  vs_gj_out,err := autoio.CreateWriter( graphjoin_variantset_ofn )
+  tile_map_out,err := autoio.CreateWriter( tile_map_ofn )
   if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
-  defer func() { vs_gj_out.Flush() ; vs_gj_out.Close() }()
+  defer func() { tile_map_out.Flush() ; tile_map_out.Close() }()
 
 
+  g_range_beg,g_range_end = fjidx.AllTiles(),fjidx.AllTiles()
+  if len(c.String("start"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("start"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid start: %v\n", e) ; os.Exit(1) }
+    g_range_beg = z
+  }
+  if len(c.String("end"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("end"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid end: %v\n", e) ; os.Exit(1) }
+    g_range_end = z
+  }
+
   // Process input FastJ files
 
   ifns := c.StringSlice("input")
@@ -661,32 +1283,110 @@ func _main( c *cli.Context ) {
     if e!=nil { log.Fatal(e) }
   }
 
+  if len(g_reference_input)>0 {
+    if e:=build_reference(c.String("assembly")) ; e!=nil { log.Fatal(e) }
+    g_START_REFERENCEID = c.Int("start-reference-id")
+    g_REFERENCESETID = c.Int("referenceset-id")
+  }
+
+  // IDs not given are picked after the largest in the
+  // database (needs the Reference, if any).
+  //
+  if len(db_fn)>0 {
+    e := auto_ids(c, db_fn)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  }
+
+  // Fill in no-calls so that tiles that only differ
+  // by no-calls collapse to a single node.
+  //
+  if g_nocall_fill!="" {
+
+    if g_nocall_fill=="ref" {
+      e := import_nocall_ref(c.String("nocall-ref"))
+      if e!=nil { log.Fatal(e) }
+    }
+
+    fill_nocall_tile_lib()
+
+    nocall_map_ofn := c.String("nocall-map")
+    if len(nocall_map_ofn)>0 {
+      nocall_map_out,err := autoio.CreateWriter( nocall_map_ofn )
+      if err!=nil { fmt.Fprintf(os.Stderr, "%v", err) ; os.Exit(1) }
+      emit_nocall_map(nocall_map_out.Writer)
+      nocall_map_out.Flush()
+      nocall_map_out.Close()
+    }
+  }
 
   // Once the library has been created, rank
-  // the resulting tiles.
+  // the resulting tiles, keeping the ranks of the
+  // persistent tile library if there is one.
   //
+  tile_lib_fn := c.String("tile-lib")
+  g_build = c.String("build")
+  if strings.Contains(g_build, ",") {
+    fmt.Fprintf(os.Stderr, "invalid -build '%s' (can't contain ',')\n", g_build)
+    os.Exit(1)
+  }
+
+  if len(tile_lib_fn)>0 {
+    lib,e := tilelib.Load(tile_lib_fn)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", tile_lib_fn, e)) }
+    g_persist_lib = lib
+  }
+
   rank_tile_lib()
 
+  if len(tile_lib_fn)>0 {
+    create_csv(tile_lib_fn, func(ofp *bufio.Writer) { g_persist_lib.Write(ofp) })
+  }
+
+  // Everything below is written in path.step then
+  // rank order so identical inputs give identical
+  // outputs.
+  //
+  build_sequence_order()
+
 
   // Output a big FASTA file with all of our
   // sequence information.
   //
   emit_fasta(fasta_out.Writer)
 
-  // And our FASTA.csv file
+  // Build the SQL Sequence and GraphJoin rows.
   //
-  emit_fasta_sql_csv(fasta_csv_out.Writer, fasta_ofn)
+  build_sequences()
+  build_graphjoins()
+
+  if len(g_reference)>0 {
+    // updateTime is left empty unless given, so the same
+    // inputs always give the same output.
+    //
+    build_reference_rows(c.String("reference-date"), c.Int("ncbi-taxon-id"), c.StringSlice("reference-accession"), c.StringSlice("assembly-accession"))
+  }
+
+  if len(db_fn)>0 {
+    e := write_db(db_fn, fasta_ofn)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  }
 
-  // Now output the SQL Sequences.
+  // The CSV files are always written when there is no -db,
+  // otherwise only if asked for with -csv.
   //
-  emit_sequences(seq_out.Writer)
+  if len(c.String("gfa"))>0 {
+    create_csv(c.String("gfa"), emit_gfa)
+  }
 
+  if write_csv {
+    emit_csv(fasta_ofn, fasta_csv_ofn, c.String("sequence"), c.String("graphjoin"))
+    if len(g_reference)>0 { emit_reference_csv(c) }
+  }
 
-  // Now emit the SQL GraphJoin
+  // And the tile map so fj2allele can find the
+  // body each tile goes through.
   //
-  emit_graphjoin(gj_out.Writer)
-
-  emit_graphjoin_variantset(vs_gj_out.Writer)
+  emit_tile_map(tile_map_out.Writer)
 
 }
 
@@ -732,9 +1432,71 @@ func main() {
     },
 
     cli.StringFlag{
-      Name: "graphjoin-variantset",
-      Value: "out.graphjoin-variantset",
-      Usage: "GraphJoin_VariantSet_Join OUTPUT",
+      Name: "graphjoin-referenceset",
+      Value: "out.graphjoin-referenceset",
+      Usage: "GraphJoin_ReferenceSet_Join OUTPUT (with -reference)",
+    },
+
+    cli.StringFlag{
+      Name: "db",
+      Usage: "SQLite database OUTPUT (created with the graphSQL_v023 schema if needed)",
+    },
+
+    cli.BoolFlag{
+      Name: "csv",
+      Usage: "Also write the CSV OUTPUTs when -db is given",
+    },
+
+    cli.BoolFlag{
+      Name: "csv-header",
+      Usage: "Start each CSV OUTPUT with a line of column names",
+    },
+
+    cli.StringFlag{
+      Name: "gfa",
+      Usage: "GFA 1.0 OUTPUT of the graph (S- and L-lines)",
+    },
+
+    cli.StringFlag{
+      Name: "nocall-fill",
+      Value: "none",
+      Usage: "Fill in no-calls before building the graph (none, ref or consensus)",
+    },
+
+    cli.StringFlag{
+      Name: "nocall-ref",
+      Usage: "Reference FastJ used to fill no-calls with -nocall-fill ref",
+    },
+
+    cli.StringFlag{
+      Name: "nocall-map",
+      Usage: "No-call fill map OUTPUT (path.step,md5sum,filled_md5sum,filled_sequence) for fj2allele",
+    },
+
+    cli.StringFlag{
+      Name: "tile-map",
+      Value: "out.tilemap",
+      Usage: "Tile map OUTPUT (path.step,md5sum,body_sequence_record_name) for fj2allele",
+    },
+
+    cli.StringFlag{
+      Name: "tile-lib",
+      Usage: "Tile library (path.step,md5sum,seedlen,freq,rank,build) to keep ranks stable across runs, read if it exists and written back",
+    },
+
+    cli.StringFlag{
+      Name: "build",
+      Usage: "Build name recorded in the tile library for tiles first seen in this run",
+    },
+
+    cli.StringFlag{
+      Name: "start, s",
+      Usage: "Only use input tiles from START (path.step, path.ver.step or path.ver.step.variant, inclusive)",
+    },
+
+    cli.StringFlag{
+      Name: "end, e",
+      Usage: "Only use input tiles up to END (inclusive)",
     },
 
     cli.IntFlag{
@@ -746,25 +1508,105 @@ func main() {
     cli.IntFlag{
       Name: "fasta-id",
       Value: 1,
-      Usage: "ID of FASTA SQL row",
+      Usage: "ID of FASTA SQL row (with -db, defaults to after the largest in the database)",
     },
 
     cli.IntFlag{
       Name: "start-sequence-id",
       Value: 1,
-      Usage: "Start ID of Sequence SQL row",
+      Usage: "Start ID of Sequence SQL row (with -db, defaults to after the largest in the database)",
     },
 
     cli.IntFlag{
       Name: "start-graphjoin-id",
       Value: 1,
-      Usage: "Start ID of GraphJoin SQL row",
+      Usage: "Start ID of GraphJoin SQL row (with -db, defaults to after the largest in the database)",
     },
 
     cli.IntFlag{
       Name: "variantset-id",
       Value: 0,
-      Usage: "ID of VariantSet SQL row",
+      Usage: "ID of VariantSet SQL row (with -db and -variantset-name, the ID of that VariantSet or after the largest)",
+    },
+
+    cli.StringFlag{
+      Name: "variantset-name",
+      Usage: "With -db, name of the VariantSet, added to the database if it isn't there",
+    },
+
+    cli.StringFlag{
+      Name: "reference",
+      Usage: "Name of the input (NAME,FILE) whose tiles spell the Reference, e.g. the GRCh38 FastJ",
+    },
+
+    cli.StringSliceFlag{
+      Name: "reference-accession",
+      Value: &cli.StringSlice{},
+      Usage: "Accession of the Reference, e.g. 'gi|568815581' (can be specified more than once)",
+    },
+
+    cli.StringFlag{
+      Name: "assembly",
+      Usage: "Assembly ID of the ReferenceSet (defaults to the build in the reference tiles' locus, e.g. grch38)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "assembly-accession",
+      Value: &cli.StringSlice{},
+      Usage: "Accession of the ReferenceSet (can be specified more than once)",
+    },
+
+    cli.IntFlag{
+      Name: "ncbi-taxon-id",
+      Value: 9606,
+      Usage: "NCBI taxon ID of the Reference and ReferenceSet",
+    },
+
+    cli.StringFlag{
+      Name: "reference-date",
+      Usage: "updateTime of the Reference, e.g. the release date of the assembly (default empty)",
+    },
+
+    cli.IntFlag{
+      Name: "start-reference-id",
+      Value: 1,
+      Usage: "ID of Reference SQL row (with -db, defaults to after the largest in the database)",
+    },
+
+    cli.IntFlag{
+      Name: "referenceset-id",
+      Value: 1,
+      Usage: "ID of ReferenceSet SQL row (with -db, the ReferenceSet of the assembly or after the largest)",
+    },
+
+    cli.StringFlag{
+      Name: "reference-csv",
+      Value: "out.reference",
+      Usage: "Reference OUTPUT",
+    },
+
+    cli.StringFlag{
+      Name: "reference-accession-csv",
+      Value: "out.reference-accession",
+      Usage: "ReferenceAccession OUTPUT",
+    },
+
+    cli.StringFlag{
+      Name: "referenceset-csv",
+      Value: "out.referenceset",
+      Usage: "ReferenceSet OUTPUT",
+    },
+
+    cli.StringFlag{
+      Name: "referenceset-accession-csv",
+      Value: "out.referenceset-accession",
+      Usage: "ReferenceSetAccession OUTPUT",
+    },
+
+    cli.StringFlag{
+      Name: "reference-referenceset-csv",
+      Value: "out.reference-referenceset",
+      Usage: "Reference_ReferenceSet_Join OUTPUT",
     },
 
     cli.BoolFlag{
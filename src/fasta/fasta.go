@@ -0,0 +1,245 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package fasta reads FASTA files: any number of records,
+// each a '>' header line followed by sequence lines of any
+// width.  Gzipped files and "-" for stdin are handled by
+// autoio.
+//
+// A file with no header line is read as a single record with
+// an empty name so plain one-sequence files can be used
+// wherever FASTA is expected.
+//
+// If an uncompressed file has a samtools style index next to
+// it (<file>.fai), records asked for by name are read directly
+// from their offset instead of scanning the whole file.
+//
+package fasta
+
+import "fmt"
+import "io"
+import "os"
+import "strconv"
+import "strings"
+
+import "github.com/abeconnelly/autoio"
+
+type Record struct {
+
+  // First word of the header line.
+  //
+  Name string
+
+  // Rest of the header line, if any.
+  //
+  Description string
+
+  // Sequence with the line breaks removed.
+  //
+  Seq string
+}
+
+// Call f on each record of a FASTA file in turn.  Only one
+// record is held in memory at a time.  Stops at the first
+// error f returns.
+//
+func Each(fn string, f func(*Record) error) error {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return e }
+  defer h.Close()
+
+  var cur *Record
+  parts := make([]string, 0, 1024)
+
+  finish := func() error {
+    if cur==nil { return nil }
+    cur.Seq = strings.Join(parts, "")
+    parts = parts[:0]
+    rec := cur
+    cur = nil
+    return f(rec)
+  }
+
+  line_no:=0
+  seen_header := false
+  for h.ReadScan() {
+    line_no++
+    l := strings.TrimRight(h.ReadText(), "\r")
+    if len(l)==0 { continue }
+
+    if l[0]=='>' {
+      if e:=finish() ; e!=nil { return e }
+
+      hdr := strings.TrimSpace(l[1:])
+      cur = &Record{ Name: hdr }
+      if sp := strings.IndexAny(hdr, " \t") ; sp>=0 {
+        cur.Name = hdr[:sp]
+        cur.Description = strings.TrimSpace(hdr[sp+1:])
+      }
+      seen_header = true
+      continue
+    }
+
+    if l[0]==';' { continue }
+
+    if cur==nil {
+      if seen_header { return fmt.Errorf("%s: sequence outside of a record (line %d)", fn, line_no) }
+      cur = &Record{}
+      seen_header = true
+    }
+    parts = append(parts, strings.TrimSpace(l))
+  }
+
+  return finish()
+}
+
+// Read all records of a FASTA file.
+//
+func Read(fn string) ([]Record, error) {
+  recs := make([]Record, 0, 1)
+  e := Each(fn, func(rec *Record) error {
+    recs = append(recs, *rec)
+    return nil
+  })
+  if e!=nil { return nil, e }
+  return recs, nil
+}
+
+// Read the record named name.  If name is empty the
+// first record is returned.  The index is used if there
+// is one.
+//
+func ReadRecord(fn, name string) (*Record, error) {
+  if len(name)>0 {
+    if idx,e := ReadIndex(fn) ; e==nil && idx!=nil {
+      for i:=0; i<len(idx); i++ {
+        if idx[i].Name==name { return ReadIndexed(fn, idx[i]) }
+      }
+      return nil, fmt.Errorf("%s: no FASTA record named '%s'", fn, name)
+    }
+  }
+
+  var found *Record
+  e := Each(fn, func(rec *Record) error {
+    if len(name)==0 || rec.Name==name {
+      found = rec
+      return io.EOF
+    }
+    return nil
+  })
+  if e!=nil && e!=io.EOF { return nil, e }
+  if found!=nil { return found, nil }
+
+  if len(name)==0 { return nil, fmt.Errorf("%s: no FASTA records", fn) }
+  return nil, fmt.Errorf("%s: no FASTA record named '%s'", fn, name)
+}
+
+// One line of a .fai index.
+//
+type IndexEntry struct {
+  Name string
+
+  // Number of bases in the record.
+  //
+  Length int64
+
+  // Byte offset of the first base.
+  //
+  Offset int64
+
+  // Bases per line and bytes per line (including the
+  // line ending).
+  //
+  LineBases int64
+  LineWidth int64
+}
+
+// Read the index for fn, <fn>.fai, in file order.  Returns nil
+// with no error if there is no index or fn is compressed, in
+// which case the offsets can't be used.
+//
+func ReadIndex(fn string) ([]IndexEntry, error) {
+  if fn=="-" { return nil, nil }
+  if _,e := os.Stat(fn + ".fai") ; e!=nil { return nil, nil }
+
+  f,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  magic := make([]byte, 2)
+  n,_ := io.ReadFull(f, magic)
+  f.Close()
+  if n==2 && magic[0]==0x1f && magic[1]==0x8b { return nil, nil }
+
+  h,e := autoio.OpenReadScannerSimple(fn + ".fai")
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  idx := make([]IndexEntry, 0, 1)
+  line_no:=0
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    fields := strings.Split(l, "\t")
+    if len(fields)<5 { return nil, fmt.Errorf("%s.fai: bad read on line %d", fn, line_no) }
+
+    ent := IndexEntry{ Name: fields[0] }
+    v := make([]int64, 4)
+    for i:=0; i<4; i++ {
+      v[i],e = strconv.ParseInt(fields[i+1], 10, 64)
+      if e!=nil { return nil, fmt.Errorf("%s.fai: bad read on line %d: %v", fn, line_no, e) }
+    }
+    ent.Length,ent.Offset,ent.LineBases,ent.LineWidth = v[0],v[1],v[2],v[3]
+    if ent.LineBases<=0 || ent.LineWidth<ent.LineBases {
+      return nil, fmt.Errorf("%s.fai: bad line lengths on line %d", fn, line_no)
+    }
+
+    idx = append(idx, ent)
+  }
+
+  return idx, nil
+}
+
+// Read a single record using its index entry.  The
+// description is not in the index so is left empty.
+//
+func ReadIndexed(fn string, ent IndexEntry) (*Record, error) {
+  f,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  defer f.Close()
+
+  nbyte := (ent.Length/ent.LineBases)*ent.LineWidth + ent.Length%ent.LineBases
+  buf := make([]byte, nbyte)
+
+  if _,e = f.Seek(ent.Offset, 0) ; e!=nil { return nil, e }
+  if _,e = io.ReadFull(f, buf) ; e!=nil && e!=io.ErrUnexpectedEOF {
+    return nil, fmt.Errorf("%s: reading '%s' from index: %v", fn, ent.Name, e)
+  }
+
+  seq := make([]byte, 0, ent.Length)
+  for i:=0; i<len(buf); i++ {
+    if buf[i]=='\n' || buf[i]=='\r' { continue }
+    seq = append(seq, buf[i])
+  }
+  if int64(len(seq))!=ent.Length {
+    return nil, fmt.Errorf("%s: record '%s' has %d bases, index says %d", fn, ent.Name, len(seq), ent.Length)
+  }
+
+  return &Record{ Name: ent.Name, Seq: string(seq) }, nil
+}
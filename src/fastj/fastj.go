@@ -0,0 +1,408 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package fastj reads and writes FastJ files.
+//
+// A FastJ file is a sequence of tiles.  Each tile is a header line,
+// a '>' followed by a JSON object, and then the tile sequence, usually
+// folded at 50 columns, followed by a blank line:
+//
+//  > { "tileID" : "2c5.00.03cc.000", "md5sum":"e5d1...", "locus":[{"build":"grch38 chr17 43023980 43024228"}], "n":249, ... }
+//  agcgtgcctagagtggccaaacctagctaagttttgatttttttttctag
+//  ...
+//
+// Tiles are streamed with a Reader and written with a Writer so
+// every tool handles the format the same way.
+//
+package fastj
+
+import "io"
+import "fmt"
+import "strings"
+import "strconv"
+import "bufio"
+
+import "crypto/md5"
+import "encoding/json"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/abeconnelly/sloppyjson"
+
+// Default column width sequence lines are folded at.
+//
+const FOLD = 50
+
+// Length of the tags that start and end each tile.
+//
+const TAGLEN = 24
+
+type Locus struct {
+  Build string
+}
+
+// A parsed locus build field:
+//
+//   [prefix ...] [chrom] [start] [end]
+//
+// e.g. 'grch38 chr17 43023980 43024228'.  Start and end are
+// 0-based and inclusive.
+//
+type LocusRange struct {
+  Prefix string
+  Chrom string
+  Start int
+  End int
+}
+
+// Parse the build field.  The last two words are the start and
+// end and the word before them is the chromosome (or whatever
+// sequence name the tile was made from).
+//
+func (l Locus) Range() (LocusRange, error) {
+  fields := strings.Fields(l.Build)
+  if len(fields)<3 { return LocusRange{}, fmt.Errorf("invalid locus '%s' (expected [prefix] chrom start end)", l.Build) }
+
+  n := len(fields)
+  s,e := strconv.Atoi(fields[n-2])
+  if e!=nil { return LocusRange{}, fmt.Errorf("invalid locus start in '%s'", l.Build) }
+  t,e := strconv.Atoi(fields[n-1])
+  if e!=nil { return LocusRange{}, fmt.Errorf("invalid locus end in '%s'", l.Build) }
+
+  return LocusRange{ Prefix: strings.Join(fields[:n-3], " "), Chrom: fields[n-3], Start: s, End: t }, nil
+}
+
+// A single FastJ tile, header fields and sequence.
+//
+type Tile struct {
+  TileID TileID
+  Md5Sum string
+  Locus []Locus
+  N int
+  SeedTileLength int
+
+  StartTile bool
+  EndTile bool
+
+  StartSeq string
+  EndSeq string
+
+  StartTag string
+  EndTag string
+
+  NocallCount int
+  Notes []string
+
+  Seq string
+}
+
+func Md5Sum(seq string) string {
+  return fmt.Sprintf("%x", md5.Sum([]byte(seq)))
+}
+
+// Check the md5sum in the header against the md5sum
+// of the tile sequence.
+//
+func (t *Tile) CheckMd5() error {
+  m5 := Md5Sum(t.Seq)
+  if m5 != t.Md5Sum {
+    return fmt.Errorf("md5sum mismatch for %s: header %s != sequence %s", t.TileID, t.Md5Sum, m5)
+  }
+  return nil
+}
+
+// Prefix tag, suffix tag and body of the tile sequence.
+// Returns empty strings if the sequence is too short
+// to hold both tags.
+//
+func (t *Tile) PrefixTag() string {
+  if len(t.Seq) < 2*TAGLEN { return "" }
+  return t.Seq[0:TAGLEN]
+}
+
+func (t *Tile) SuffixTag() string {
+  if len(t.Seq) < 2*TAGLEN { return "" }
+  return t.Seq[len(t.Seq)-TAGLEN:]
+}
+
+// Position of the tile this tile's suffix tag is
+// the prefix tag for.
+//
+func (t *Tile) SuffixPos() TilePos {
+  return t.TileID.Pos().Next(t.SeedTileLength)
+}
+
+// The seed tile length prev really has if next, the tile
+// after it in the same haplotype, doesn't start where prev
+// ends but does start on prev's suffix tag.  That happens
+// after an empty step, one whose two tags are at the same
+// place, which tilings can leave out.  Returns 0 if prev ends
+// where next starts or they don't share the tag.
+//
+func BridgedSeedTileLength(prev, next *Tile) int {
+  if prev.TileID.Path!=next.TileID.Path || next.TileID.Step<=prev.TileID.Step { return 0 }
+  if prev.SuffixPos().Cmp(next.TileID.Pos())==0 { return 0 }
+
+  tag := prev.SuffixTag()
+  if len(tag)==0 || tag!=next.PrefixTag() { return 0 }
+  return next.TileID.Step - prev.TileID.Step
+}
+
+func (t *Tile) Body() string {
+  if len(t.Seq) < 2*TAGLEN { return "" }
+  return t.Seq[TAGLEN:len(t.Seq)-TAGLEN]
+}
+
+func json_string(s string) string {
+  b,e := json.Marshal(s)
+  if e!=nil { return `""` }
+  return string(b)
+}
+
+// The header line for the tile, without the leading '>'.
+//
+func (t *Tile) HeaderString() string {
+  locus := make([]string, 0, len(t.Locus))
+  for i:=0; i<len(t.Locus); i++ {
+    locus = append(locus, fmt.Sprintf(`{"build":%s}`, json_string(t.Locus[i].Build)))
+  }
+
+  notes := make([]string, 0, len(t.Notes))
+  for i:=0; i<len(t.Notes); i++ {
+    notes = append(notes, json_string(t.Notes[i]))
+  }
+
+  return fmt.Sprintf(`{ "tileID" : %s, "md5sum":%s, "locus":[%s], "n":%d, "seedTileLength":%d, ` +
+    `"startTile":%t, "endTile":%t, "startSeq":%s, "endSeq":%s, ` +
+    `"startTag":%s, "endTag":%s, "nocallCount":%d, "notes":[%s] }`,
+    json_string(t.TileID.String()), json_string(t.Md5Sum), strings.Join(locus, ","), t.N, t.SeedTileLength,
+    t.StartTile, t.EndTile, json_string(t.StartSeq), json_string(t.EndSeq),
+    json_string(t.StartTag), json_string(t.EndTag), t.NocallCount, strings.Join(notes, ","))
+}
+
+// Write seq to w folded at fold columns.  Nothing
+// is written for an empty sequence.
+//
+func WriteFold(w io.Writer, seq string, fold int) error {
+  if len(seq)==0 { return nil }
+  if fold<=0 { fold = len(seq) }
+
+  p:=0
+  for ; p<(len(seq)-fold); p+=fold {
+    if _,e := io.WriteString(w, seq[p:p+fold]) ; e!=nil { return e }
+    if _,e := io.WriteString(w, "\n") ; e!=nil { return e }
+  }
+  if _,e := io.WriteString(w, seq[p:]) ; e!=nil { return e }
+  _,e := io.WriteString(w, "\n")
+  return e
+}
+
+type line_scanner interface {
+  ReadScan() bool
+  ReadText() string
+}
+
+type bufio_scanner struct {
+  s *bufio.Scanner
+}
+
+func (b *bufio_scanner) ReadScan() bool { return b.s.Scan() }
+func (b *bufio_scanner) ReadText() string { return b.s.Text() }
+
+// Reader streams tiles from a FastJ file.
+//
+type Reader struct {
+  sc line_scanner
+  h *autoio.AutoioHandle
+  c io.Closer
+
+  line_no int
+
+  hdr string
+  hdr_line_no int
+  tile_line_no int
+  seq []string
+}
+
+// Open a FastJ file for reading.  Gzipped files
+// and "-" for stdin are handled by autoio.
+//
+func Open(fn string) (*Reader, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  return &Reader{ sc: &h, h: &h, seq: make([]string, 0, 8) }, nil
+}
+
+func NewReader(r io.Reader) *Reader {
+  s := bufio.NewScanner(r)
+  s.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+  return &Reader{ sc: &bufio_scanner{s}, seq: make([]string, 0, 8) }
+}
+
+// Read tiles from r, part way into a file, e.g. after seeking
+// with an index.  line_no is the number of lines before the
+// start of r so LineNo stays right.  c, if not nil, is closed
+// by Close.
+//
+func NewReaderAt(r io.Reader, c io.Closer, line_no int) *Reader {
+  fj := NewReader(r)
+  fj.c = c
+  fj.line_no = line_no
+  return fj
+}
+
+func (r *Reader) Close() error {
+  if r.c != nil { return r.c.Close() }
+  if r.h == nil { return nil }
+  return r.h.Close()
+}
+
+// Line number of the header of the tile last returned by Read.
+//
+func (r *Reader) LineNo() int {
+  return r.tile_line_no
+}
+
+// Read the next tile.  Returns io.EOF when there
+// are no more tiles.
+//
+func (r *Reader) Read() (*Tile, error) {
+  for r.sc.ReadScan() {
+    r.line_no++
+    l := strings.TrimSpace(r.sc.ReadText())
+    if len(l)==0 { continue }
+
+    if l[0] == '>' {
+      prev_hdr := r.hdr
+      prev_line_no := r.hdr_line_no
+
+      if len(prev_hdr)==0 {
+        r.hdr = l
+        r.hdr_line_no = r.line_no
+        continue
+      }
+
+      tile,e := parse_tile(prev_hdr, prev_line_no, strings.Join(r.seq, ""))
+      r.tile_line_no = prev_line_no
+
+      r.hdr = l
+      r.hdr_line_no = r.line_no
+      r.seq = r.seq[0:0]
+
+      return tile, e
+    }
+
+    if len(r.hdr)==0 {
+      return nil, fmt.Errorf("line %d: sequence before FastJ header", r.line_no)
+    }
+
+    r.seq = append(r.seq, l)
+  }
+
+  if len(r.hdr)==0 { return nil, io.EOF }
+
+  tile,e := parse_tile(r.hdr, r.hdr_line_no, strings.Join(r.seq, ""))
+  r.tile_line_no = r.hdr_line_no
+
+  r.hdr = ""
+  r.seq = r.seq[0:0]
+
+  return tile, e
+}
+
+func sj_str(sj *sloppyjson.SloppyJSON, key string) string {
+  if v,ok := sj.O[key] ; ok && v!=nil { return v.S }
+  return ""
+}
+
+func sj_int(sj *sloppyjson.SloppyJSON, key string) int {
+  if v,ok := sj.O[key] ; ok && v!=nil { return int(v.P) }
+  return 0
+}
+
+func sj_bool(sj *sloppyjson.SloppyJSON, key string) bool {
+  if v,ok := sj.O[key] ; ok && v!=nil { return v.Y == "true" }
+  return false
+}
+
+// Parse a header line (including the leading '>')
+// into a Tile.  The sequence is left empty.
+//
+func ParseHeader(hdr string) (*Tile, error) {
+  if len(hdr)==0 || hdr[0]!='>' {
+    return nil, fmt.Errorf("FastJ header must start with '>'")
+  }
+
+  sj,e := sloppyjson.Loads(hdr[1:])
+  if e!=nil { return nil, e }
+
+  t := Tile{}
+  t.TileID,e        = ParseTileID(sj_str(sj, "tileID"))
+  if e!=nil { return nil, e }
+
+  t.Md5Sum          = sj_str(sj, "md5sum")
+  t.N               = sj_int(sj, "n")
+  t.SeedTileLength  = sj_int(sj, "seedTileLength")
+  t.StartTile       = sj_bool(sj, "startTile")
+  t.EndTile         = sj_bool(sj, "endTile")
+  t.StartSeq        = sj_str(sj, "startSeq")
+  t.EndSeq          = sj_str(sj, "endSeq")
+  t.StartTag        = sj_str(sj, "startTag")
+  t.EndTag          = sj_str(sj, "endTag")
+  t.NocallCount     = sj_int(sj, "nocallCount")
+
+  if v,ok := sj.O["locus"] ; ok && v!=nil {
+    for i:=0; i<len(v.L); i++ {
+      t.Locus = append(t.Locus, Locus{ sj_str(v.L[i], "build") })
+    }
+  }
+
+  if v,ok := sj.O["notes"] ; ok && v!=nil {
+    for i:=0; i<len(v.L); i++ {
+      t.Notes = append(t.Notes, v.L[i].S)
+    }
+  }
+
+  return &t, nil
+}
+
+func parse_tile(hdr string, line_no int, seq string) (*Tile, error) {
+  t,e := ParseHeader(hdr)
+  if e!=nil { return nil, fmt.Errorf("line %d: %v", line_no, e) }
+  t.Seq = seq
+  return t, nil
+}
+
+// Writer writes tiles in FastJ format.  The caller
+// is responsible for flushing the underlying writer.
+//
+type Writer struct {
+  w io.Writer
+  Fold int
+}
+
+func NewWriter(w io.Writer) *Writer {
+  return &Writer{ w: w, Fold: FOLD }
+}
+
+func (w *Writer) Write(t *Tile) error {
+  if _,e := io.WriteString(w.w, "> " + t.HeaderString() + "\n") ; e!=nil { return e }
+  if e := WriteFold(w.w, t.Seq, w.Fold) ; e!=nil { return e }
+  _,e := io.WriteString(w.w, "\n")
+  return e
+}
@@ -0,0 +1,145 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package fastj
+
+import "testing"
+import "strings"
+import "bytes"
+import "io"
+import "reflect"
+
+// Tile whose sequence is the 24mer tags pfx and sfx (each
+// one base repeated) around a short body.
+//
+func tagged_tile(path, step, seedlen int, pfx, sfx string) *Tile {
+  tag_a := strings.Repeat(pfx, TAGLEN)
+  tag_b := strings.Repeat(sfx, TAGLEN)
+  return &Tile{ TileID:TileID{ Path:path, Ver:0, Step:step, Variant:0 }, SeedTileLength:seedlen, Seq:tag_a + "acgt" + tag_b }
+}
+
+// A tile is only bridged if the next one starts further on
+// than its seedTileLength says and on its suffix tag.
+//
+func TestBridgedSeedTileLength(t *testing.T) {
+  tests := []struct {
+    name string
+    prev, next *Tile
+    want int
+  }{
+    { "adjacent", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c5, 0x11, 1, "c", "g"), 0 },
+    { "spanning", tagged_tile(0x2c5, 0x10, 2, "a", "c"), tagged_tile(0x2c5, 0x12, 1, "c", "g"), 0 },
+    { "empty step", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c5, 0x12, 1, "c", "g"), 2 },
+    { "two empty steps", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c5, 0x13, 1, "c", "g"), 3 },
+    { "gap", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c5, 0x12, 1, "g", "t"), 0 },
+    { "before", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c5, 0x0e, 1, "c", "g"), 0 },
+    { "other path", tagged_tile(0x2c5, 0x10, 1, "a", "c"), tagged_tile(0x2c6, 0x12, 1, "c", "g"), 0 },
+    { "no tags", &Tile{ TileID:TileID{ Path:0x2c5, Step:0x10 }, SeedTileLength:1, Seq:"acgt" }, tagged_tile(0x2c5, 0x12, 1, "c", "g"), 0 },
+  }
+
+  for _,x := range tests {
+    if got := BridgedSeedTileLength(x.prev, x.next) ; got!=x.want {
+      t.Errorf("%s: %s -> %s got %d, expected %d", x.name, x.prev.TileID, x.next.TileID, got, x.want)
+    }
+  }
+}
+
+// Header fields come back from ParseHeader as written by
+// HeaderString, including quotes in notes.
+//
+func TestHeaderRoundTrip(t *testing.T) {
+  tiles := []Tile{
+    { TileID:TileID{ 0x2c5, 0, 0x3cc, 0 }, Md5Sum:"e5d1a3c0d1e2f3a4b5c6d7e8f9a0b1c2",
+      Locus:[]Locus{ {"grch38 chr17 43023980 43024228"} }, N:249, SeedTileLength:1,
+      StartTag:"agcgtgcctagagtggccaaacct", EndTag:"tttgatttttttttctagcgtacc" },
+    { TileID:TileID{ 0x247, 0, 0xae5, 1 }, Md5Sum:"00000000000000000000000000000000",
+      N:412, SeedTileLength:2, StartTile:true, EndTile:true, StartSeq:"acgt", EndSeq:"tgca",
+      NocallCount:3, Notes:[]string{ "first", `say "hi"` } },
+  }
+
+  for i:=0; i<len(tiles); i++ {
+    hdr := tiles[i].HeaderString()
+    got,e := ParseHeader(">" + hdr)
+    if e!=nil { t.Errorf("%s: %v", hdr, e) ; continue }
+    if !reflect.DeepEqual(*got, tiles[i]) {
+      t.Errorf("ParseHeader(%s): got %+v, expected %+v", hdr, *got, tiles[i])
+    }
+  }
+
+  for _,hdr := range []string{ "", `{ "tileID" : "2c5.00.03cc.000" }`, `> { "tileID" : "2c5.00.03cc" }`, `> { "tileID" : ` } {
+    if _,e := ParseHeader(hdr) ; e==nil {
+      t.Errorf("ParseHeader(%q): expected an error", hdr)
+    }
+  }
+}
+
+// Tiles written by Writer, with sequences folded over
+// several lines, are read back by Reader unchanged.
+//
+func TestReaderWriterRoundTrip(t *testing.T) {
+  long := strings.Repeat("acgtn", 31)
+  tiles := []*Tile{
+    { TileID:TileID{ 0x2c5, 0, 0x3cc, 0 }, N:len(long), SeedTileLength:1, Seq:long },
+    { TileID:TileID{ 0x2c5, 0, 0x3cc, 1 }, N:FOLD, SeedTileLength:1, Seq:long[:FOLD] },
+    { TileID:TileID{ 0x2c5, 0, 0x3cd, 0 }, N:FOLD+1, SeedTileLength:2, Seq:long[:FOLD+1], Notes:[]string{ "spanning" } },
+  }
+  for i:=0; i<len(tiles); i++ { tiles[i].Md5Sum = Md5Sum(tiles[i].Seq) }
+
+  for _,fold := range []int{ FOLD, 7, 0 } {
+    var buf bytes.Buffer
+    w := NewWriter(&buf)
+    w.Fold = fold
+    for i:=0; i<len(tiles); i++ {
+      if e := w.Write(tiles[i]) ; e!=nil { t.Fatalf("fold %d: %v", fold, e) }
+    }
+
+    if fold>0 {
+      for _,l := range strings.Split(buf.String(), "\n") {
+        if len(l)>fold && l[0]!='>' { t.Errorf("fold %d: line %q is longer than the fold", fold, l) }
+      }
+    }
+
+    r := NewReader(&buf)
+    for i:=0; i<len(tiles); i++ {
+      got,e := r.Read()
+      if e!=nil { t.Fatalf("fold %d: tile %d: %v", fold, i, e) }
+      if !reflect.DeepEqual(got, tiles[i]) {
+        t.Errorf("fold %d: got %+v, expected %+v", fold, got, tiles[i])
+      }
+      if e := got.CheckMd5() ; e!=nil { t.Errorf("fold %d: %s: %v", fold, got.TileID, e) }
+    }
+    if _,e := r.Read() ; e!=io.EOF {
+      t.Errorf("fold %d: got %v after the last tile, expected EOF", fold, e)
+    }
+  }
+}
+
+func TestReaderLineNo(t *testing.T) {
+  fj := "> { \"tileID\" : \"2c5.00.03cc.000\" }\nacgt\nacgt\n\n> { \"tileID\" : \"2c5.00.03cd.000\" }\nacgt\n"
+
+  r := NewReader(strings.NewReader(fj))
+  for _,want := range []int{ 1, 5 } {
+    if _,e := r.Read() ; e!=nil { t.Fatal(e) }
+    if got := r.LineNo() ; got!=want { t.Errorf("LineNo: got %d, expected %d", got, want) }
+  }
+
+  r = NewReader(strings.NewReader("acgt\n> { \"tileID\" : \"2c5.00.03cc.000\" }\n"))
+  if _,e := r.Read() ; e==nil { t.Errorf("sequence before header: expected an error") }
+}
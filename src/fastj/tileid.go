@@ -0,0 +1,209 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package fastj
+
+import "fmt"
+import "strings"
+import "strconv"
+
+// Position of a tile, [path].[step], e.g. 2c5.03cc.
+//
+type TilePos struct {
+  Path int
+  Step int
+}
+
+// Full tile identifier, [path].[ver].[step].[variant],
+// e.g. 2c5.00.03cc.000.  All fields are hexadecimal.
+// In FastJ files the variant is the haplotype allele
+// (000 or 001).
+//
+type TileID struct {
+  Path int
+  Ver int
+  Step int
+  Variant int
+}
+
+func parse_hex(s, field, id string) (int, error) {
+  if len(s)==0 { return 0, fmt.Errorf("empty %s in tile ID '%s'", field, id) }
+  v,e := strconv.ParseInt(s, 16, 32)
+  if e!=nil || v<0 { return 0, fmt.Errorf("invalid %s '%s' in tile ID '%s'", field, s, id) }
+  return int(v), nil
+}
+
+// Parse a [path].[step] tile position.
+//
+func ParseTilePos(s string) (TilePos, error) {
+  parts := strings.Split(s, ".")
+  if len(parts)!=2 { return TilePos{}, fmt.Errorf("invalid tile position '%s' (expected path.step)", s) }
+
+  path,e := parse_hex(parts[0], "path", s)
+  if e!=nil { return TilePos{}, e }
+  step,e := parse_hex(parts[1], "step", s)
+  if e!=nil { return TilePos{}, e }
+
+  return TilePos{path, step}, nil
+}
+
+// Parse a full [path].[ver].[step].[variant] tile ID.
+//
+func ParseTileID(s string) (TileID, error) {
+  parts := strings.Split(s, ".")
+  if len(parts)!=4 { return TileID{}, fmt.Errorf("invalid tile ID '%s' (expected path.ver.step.variant)", s) }
+  return parse_tile_id_parts(s, parts)
+}
+
+// Parse a partial tile ID: [path].[step], [path].[ver].[step]
+// or [path].[ver].[step].[variant].  Fields that are not
+// given are set to -1.
+//
+func ParseTileIDPartial(s string) (TileID, error) {
+  parts := strings.Split(s, ".")
+
+  switch len(parts) {
+  case 2:
+    pos,e := ParseTilePos(s)
+    if e!=nil { return TileID{}, e }
+    return TileID{pos.Path, -1, pos.Step, -1}, nil
+  case 3:
+    t,e := parse_tile_id_parts(s, append(parts, "0"))
+    if e!=nil { return TileID{}, e }
+    t.Variant = -1
+    return t, nil
+  case 4:
+    return parse_tile_id_parts(s, parts)
+  }
+
+  return TileID{}, fmt.Errorf("invalid tile ID '%s' (expected path.step, path.ver.step or path.ver.step.variant)", s)
+}
+
+func parse_tile_id_parts(s string, parts []string) (TileID, error) {
+  var t TileID
+  var e error
+
+  if t.Path,e = parse_hex(parts[0], "path", s) ; e!=nil { return TileID{}, e }
+  if t.Ver,e = parse_hex(parts[1], "version", s) ; e!=nil { return TileID{}, e }
+  if t.Step,e = parse_hex(parts[2], "step", s) ; e!=nil { return TileID{}, e }
+  if t.Variant,e = parse_hex(parts[3], "variant", s) ; e!=nil { return TileID{}, e }
+
+  return t, nil
+}
+
+func (p TilePos) String() string {
+  return fmt.Sprintf("%03x.%04x", p.Path, p.Step)
+}
+
+func (t TileID) String() string {
+  return fmt.Sprintf("%03x.%02x.%04x.%03x", t.Path, t.Ver, t.Step, t.Variant)
+}
+
+func (t TileID) Pos() TilePos {
+  return TilePos{t.Path, t.Step}
+}
+
+// Position of the tile that follows a tile at p spanning
+// seedlen steps.  This is also the position the suffix tag
+// of the tile belongs to.
+//
+func (p TilePos) Next(seedlen int) TilePos {
+  return TilePos{p.Path, p.Step+seedlen}
+}
+
+// Compare by path then step.  Returns -1, 0 or 1.
+//
+func (p TilePos) Cmp(q TilePos) int {
+  if p.Path < q.Path { return -1 }
+  if p.Path > q.Path { return 1 }
+  if p.Step < q.Step { return -1 }
+  if p.Step > q.Step { return 1 }
+  return 0
+}
+
+func (p TilePos) Less(q TilePos) bool {
+  return p.Cmp(q) < 0
+}
+
+// Compare by path, version, step then variant.
+// Returns -1, 0 or 1.
+//
+func (t TileID) Cmp(u TileID) int {
+  if t.Path < u.Path { return -1 }
+  if t.Path > u.Path { return 1 }
+  if t.Ver < u.Ver { return -1 }
+  if t.Ver > u.Ver { return 1 }
+  if t.Step < u.Step { return -1 }
+  if t.Step > u.Step { return 1 }
+  if t.Variant < u.Variant { return -1 }
+  if t.Variant > u.Variant { return 1 }
+  return 0
+}
+
+type TilePosOrder []TilePos
+func (s TilePosOrder) Len() int { return len(s) }
+func (s TilePosOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s TilePosOrder) Less(i,j int) bool { return s[i].Less(s[j]) }
+
+// Sequence record name of a tag:
+//
+//   [md5sum].[path].[step].t[no-call-bitmask]
+//
+// where pos is the position of the tile the tag is
+// the prefix tag for.  See notes.md.
+//
+func TagSequenceName(pos TilePos, tag_seq string) string {
+  var no_call_bitvec uint
+  for i:=0; i<len(tag_seq) && i<TAGLEN; i++ {
+    c := tag_seq[len(tag_seq)-1-i]
+    if c == 'n' || c == 'N' { no_call_bitvec |= (1<<uint(i)); }
+  }
+  return fmt.Sprintf("%s.%s.t%06x", Md5Sum(tag_seq), pos, no_call_bitvec)
+}
+
+// Sequence record name of a tile body:
+//
+//   [md5sum].[path].[step].r[rank]+[seed-tile-length]
+//
+func BodySequenceName(body_md5 string, pos TilePos, rank, seedlen int) string {
+  return fmt.Sprintf("%s.%s.r%x+%0x", body_md5, pos, rank, seedlen)
+}
+
+// Seed tile length of a body sequence record name, ok is
+// false if it doesn't end in +[seed-tile-length].
+//
+func BodySeedTileLength(name string) (seedlen int, ok bool) {
+  p := strings.LastIndex(name, "+")
+  if p<0 { return 0, false }
+  x,e := strconv.ParseInt(name[p+1:], 16, 64)
+  if e!=nil { return 0, false }
+  return int(x), true
+}
+
+// Sequence record name of a reference spelled by consecutive
+// tiles starting at pos and spanning steps steps:
+//
+//   [md5sum].[path].[step].s+[steps]
+//
+// 's' isn't a hex digit and isn't the 't' of a tag or the 'r'
+// of a body, so no tag or body name can look like it.
+//
+func ReferenceSequenceName(seq_md5 string, pos TilePos, steps int) string {
+  return fmt.Sprintf("%s.%s.s+%x", seq_md5, pos, steps)
+}
@@ -0,0 +1,126 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package fastj
+
+import "testing"
+
+// Tile IDs and positions print back to the string
+// they were parsed from.
+//
+func TestTileIDRoundTrip(t *testing.T) {
+  ids := []string{ "2c5.00.03cc.000", "2c5.00.03cc.001", "000.00.0000.000", "247.01.0ae5.00f", "fff.ff.ffff.fff" }
+  for _,s := range ids {
+    id,e := ParseTileID(s)
+    if e!=nil { t.Errorf("%s: %v", s, e) ; continue }
+    if got := id.String() ; got!=s {
+      t.Errorf("ParseTileID(%s).String(): got %s, expected %s", s, got, s)
+    }
+  }
+
+  pos := []string{ "2c5.03cc", "000.0000", "247.0ae5" }
+  for _,s := range pos {
+    p,e := ParseTilePos(s)
+    if e!=nil { t.Errorf("%s: %v", s, e) ; continue }
+    if got := p.String() ; got!=s {
+      t.Errorf("ParseTilePos(%s).String(): got %s, expected %s", s, got, s)
+    }
+  }
+
+  id := TileID{ Path:0x2c5, Ver:0, Step:0x3cc, Variant:1 }
+  if got := id.Pos().String() ; got!="2c5.03cc" {
+    t.Errorf("%s Pos: got %s, expected %s", id, got, "2c5.03cc")
+  }
+}
+
+func TestTileIDMalformed(t *testing.T) {
+  ids := []string{
+    "",
+    "2c5",
+    "2c5.03cc",
+    "2c5.00.03cc",
+    "2c5.00.03cc.000.0",
+    "2c5..03cc.000",
+    "2c5.00.03cc.",
+    "2g5.00.03cc.000",
+    "2c5.00.-3cc.000",
+    "2c5.00.03cc.000 ",
+  }
+  for _,s := range ids {
+    if id,e := ParseTileID(s) ; e==nil {
+      t.Errorf("ParseTileID(%q): got %s, expected an error", s, id)
+    }
+  }
+
+  pos := []string{ "", "2c5", "2c5.", ".03cc", "2c5.00.03cc", "2c5.zz" }
+  for _,s := range pos {
+    if p,e := ParseTilePos(s) ; e==nil {
+      t.Errorf("ParseTilePos(%q): got %s, expected an error", s, p)
+    }
+  }
+}
+
+func TestTileIDPartial(t *testing.T) {
+  tests := []struct {
+    s string
+    want TileID
+  }{
+    { "2c5.03cc", TileID{ 0x2c5, -1, 0x3cc, -1 } },
+    { "2c5.00.03cc", TileID{ 0x2c5, 0, 0x3cc, -1 } },
+    { "2c5.00.03cc.001", TileID{ 0x2c5, 0, 0x3cc, 1 } },
+  }
+  for _,x := range tests {
+    got,e := ParseTileIDPartial(x.s)
+    if e!=nil { t.Errorf("%s: %v", x.s, e) ; continue }
+    if got!=x.want {
+      t.Errorf("ParseTileIDPartial(%s): got %+v, expected %+v", x.s, got, x.want)
+    }
+  }
+
+  for _,s := range []string{ "2c5", "2c5.00.03cc.000.0", "2c5.xx.03cc" } {
+    if _,e := ParseTileIDPartial(s) ; e==nil {
+      t.Errorf("ParseTileIDPartial(%q): expected an error", s)
+    }
+  }
+}
+
+// The suffix tag of a tile spanning seedTileLength steps
+// belongs to the tile that many steps on.
+//
+func TestSuffixPos(t *testing.T) {
+  tests := []struct {
+    id string
+    seedlen int
+    want string
+  }{
+    { "2c5.00.03cc.000", 1, "2c5.03cd" },
+    { "2c5.00.03cc.001", 2, "2c5.03ce" },
+    { "2c5.00.03cc.000", 3, "2c5.03cf" },
+    { "247.00.0aff.000", 2, "247.0b01" },
+  }
+  for _,x := range tests {
+    id,e := ParseTileID(x.id)
+    if e!=nil { t.Fatalf("%s: %v", x.id, e) }
+    tile := Tile{ TileID:id, SeedTileLength:x.seedlen }
+    if got := tile.SuffixPos().String() ; got!=x.want {
+      t.Errorf("%s seedTileLength %d: got %s, expected %s", x.id, x.seedlen, got, x.want)
+    }
+  }
+}
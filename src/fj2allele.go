@@ -23,6 +23,8 @@
 //  - out.allele
 //  - out.allelepath
 //  - out.callset
+//  - out.allelepath-nocall
+//  - out.graphjoin-variantset
 //
 // The above are the default names.  They can be overidden.
 //
@@ -30,10 +32,58 @@
 //  out.allele is a comma separated list of Allele rows
 //  out.allelepath is a comma separated list of AllelePathItem rows
 //  out.callset is a comma separated list of CallSet rows
+//  out.allelepath-nocall is a comma separated list of AllelePathItemNoCall rows
+//  out.graphjoin-variantset is a comma separated list of GraphJoin_VariantSet_Join rows
 //
 // example usage (a.fj and b.fj are input FastJ files):
 //
-// ./fj2allele -i a.fj -sequence in.seq -allele out.allele -allele-path out.allelepath -callset out.callset
+// ./fj2allele -i a.fj -sequence in.seq -tile-map in.tilemap -allele out.allele -allele-path out.allelepath -callset out.callset
+//
+// The GraphJoins the alleles go through, read from the -graphjoin CSV
+// file create_tile_graph wrote (or with -db, the GraphJoin table), are
+// linked to the VariantSet in GraphJoin_VariantSet_Join.  Only the joins
+// the samples' paths use are linked, so a join only the reference goes
+// through isn't, unless the reference is one of the inputs:
+//
+// ./fj2allele -i a.fj -sequence in.seq -graphjoin in.graphjoin -graphjoin-variantset out.graphjoin-variantset ...
+//
+// Tags are resolved by their full sequenceRecordName.  Bodies are resolved
+// through the -tile-map written by create_tile_graph, by the md5sum of the
+// whole tile, so each path goes through the exact nodes made for that tile.
+//
+// If the graph was built with create_tile_graph -nocall-fill, pass the
+// -nocall-map it wrote so paths go through the filled in nodes:
+//
+// ./fj2allele -i a.fj -sequence in.seq -nocall-map in.nocallmap -allele-path-nocall out.allelepath-nocall ...
+//
+// With -db the Sequence rows are read from the SQLite database written by
+// create_tile_graph -db and the allele rows are inserted into it.  The CSV
+// files are then only written if -csv is also given:
+//
+// ./fj2allele -i a.fj -tile-map in.tilemap -db tilegraph.sqlite3
+//
+// IDs that aren't given on the command line then follow the largest
+// ones already in the database, and the VariantSet named with
+// -variantset-name is used if it's already there (create_tile_graph
+// -variantset-name adds it), along with its ReferenceSet.  A VariantSet
+// fj2allele adds points to the ReferenceSet given with -referenceset-id
+// or, with -db, to the one of -assembly (added by create_tile_graph
+// -reference).  Otherwise it's the ReferenceSet the graph's reference
+// path is in, from the GraphJoin_ReferenceSet_Join table or, without
+// -db, the -graphjoin-referenceset CSV file create_tile_graph wrote.
+//
+// Each input is taken to be diploid unless given a ploidy of its own
+// with -ploidy, e.g. for the haploid GI assemblies and GRCh38.  A
+// sample's .000 and .001 tiles are its two haplotypes, each an Allele
+// called with ploidy 1.  With -collapse-homozygous identical haplotypes
+// are a single Allele called with ploidy 2.  Alleles of samples with
+// unphased tiles (noted "Phase (UNPHASED)" by vcf2fj) are named
+// [sample]:[variant]:unphased, see the allelecall package:
+//
+// ./fj2allele -i GRCh38_2c5,b38_brca1_2c5.fj.gz -i hu826751,hu826751.fj -ploidy GRCh38_2c5=1 -collapse-homozygous ...
+//
+// With -gfa each allele is written as a GFA 1.0 P-line, to be
+// appended to the create_tile_graph -gfa output.
 //
 // See:
 //  https://github.com/ga4gh/server/blob/graph/tests/data/graphs/graphSQL_v023.sql
@@ -43,6 +93,7 @@
 package main
 
 import "os"
+import "io"
 import "fmt"
 import "log"
 import "strings"
@@ -51,13 +102,20 @@ import "runtime/pprof"
 
 import "bufio"
 import "strconv"
+import "sort"
 
 import "crypto/md5"
+import "database/sql"
 
 import "github.com/abeconnelly/autoio"
-import "github.com/abeconnelly/sloppyjson"
 import "github.com/codegangsta/cli"
 
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjidx"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/gfa"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/allelecall"
+
 var VERSION_STR string = "0.1.0"
 var gVerboseFlag bool
 
@@ -67,10 +125,24 @@ var gProfileFile string = "fj2allele.pprof"
 var gMemProfileFlag bool
 var gMemProfileFile string = "fj2allele.mprof"
 
-// Map of md5sum of sequence to it's corresponding SequenceID, read
+// Map of sequenceRecordName to it's corresponding SequenceID, read
 // in from the Sequence file.
 //
-var g_md5_seqid_map map[string]int64
+var g_seqname_seqid_map map[string]int64
+
+// Body sequenceRecordNames with the rank removed, e.g.
+// [md5sum].[path].[step]+[seed-tile-length], mapped to the
+// full names.  Only used when there is no tile map to
+// resolve bodies with.
+//
+var g_body_seqname_map map[string][]string
+
+// Key is [path].[step]
+// Second key is the md5sum of the whole tile, value is the
+// body sequenceRecordName, as read from the create_tile_graph
+// -tile-map output.
+//
+var g_tile_body_seqname map[fastj.TilePos]map[string]string
 
 // Each map entry is the allele of the input sequence (presumably two
 // entries).  The value is an array of SequenceIDs.
@@ -102,7 +174,18 @@ type AllelePathItem struct {
   SequenceId int
   Start int
   Length int
-  StrandIsForward string
+  StrandIsForward bool
+}
+
+// No-call interval within the sequence of an
+// AllelePathItem.  Start is relative to the
+// start of the path item's sequence.
+//
+type AllelePathItemNoCall struct {
+  AlleleId int
+  PathItemIndex int
+  Start int
+  Length int
 }
 
 type AlleleCall struct {
@@ -122,6 +205,12 @@ var g_START_CALLSET_ID int
 var g_START_VARIANTSET_ID int
 
 var g_VARIANTSET_NAME string
+var g_REFERENCESET_ID int
+
+// With -db, true if the VariantSet is already in the
+// database (e.g. added by create_tile_graph).
+//
+var g_variantset_in_db bool
 
 // named sample as key
 //
@@ -137,6 +226,18 @@ var g_allele map[string]Allele
 //
 var g_allele_path_item map[string][]AllelePathItem
 
+// named sample colon allele as key
+// e.g. hu826751:1
+//
+var g_allele_path_item_nocall map[string][]AllelePathItemNoCall
+
+// Key is [path].[step]
+// Second key is the md5sum of the original whole tile
+// sequence, value is the filled in tile sequence, as
+// read from the create_tile_graph -nocall-map output.
+//
+var g_nocall_fill_map map[fastj.TilePos]map[string]string
+
 // named sample colon allele as key
 // e.g. hu826751:0
 //
@@ -144,6 +245,40 @@ var g_allele_call map[string]AlleleCall
 
 var g_variantset map[string]VariantSet
 
+// GraphJoin_VariantSet_Join rows of the joins the alleles go
+// through.  With -db, only the ones not in the database yet.
+//
+var g_graphjoin_variantset []graphdb.GraphJoinVariantSetJoin
+
+// Per input ploidy (-ploidy), -default-ploidy for the
+// rest.
+//
+var g_ploidy map[string]int
+var g_default_ploidy int
+var g_collapse_homozygous bool
+
+// Whether the CSV files start with a header line.
+//
+var g_csv_header bool
+
+// Haplotypes ([name]:[variant]) of each sample in order of
+// first appearance, with the signature of each of their tiles
+// (see allelecall.TileSig).
+//
+var g_sample_haplotypes map[string][]string
+var g_haplotype_tiles map[string][]string
+
+// Samples with unphased tiles.
+//
+var g_unphased map[string]bool
+
+// Tile ID range of the inputs to use, -s and -e (inclusive,
+// see fjfilter).  FastJ inputs with an index (see fjindex)
+// are read from the start of the range.
+//
+var g_range_beg fastj.TileID
+var g_range_end fastj.TileID
+
 func md5sum_str(seq string) string {
   ta := make([]string, 0, 32)
   s := md5.Sum([]byte(seq))
@@ -155,7 +290,9 @@ func md5sum_str(seq string) string {
 
 
 func init() {
-  g_md5_seqid_map = make(map[string]int64)
+  g_seqname_seqid_map = make(map[string]int64)
+  g_body_seqname_map = make(map[string][]string)
+  g_tile_body_seqname = make(map[fastj.TilePos]map[string]string)
   g_allele_sequenceid_path = make(map[string][]int64)
   g_callsetname_to_sampleid = make(map[string]string)
   g_callsetname_to_id = make(map[string]int64)
@@ -164,210 +301,252 @@ func init() {
   g_callset           = make(map[string]CallSet)
   g_allele            = make(map[string]Allele)
   g_allele_path_item  = make(map[string][]AllelePathItem)
+  g_allele_path_item_nocall = make(map[string][]AllelePathItemNoCall)
+  g_nocall_fill_map   = make(map[fastj.TilePos]map[string]string)
   g_allele_call       = make(map[string]AlleleCall)
+
+  g_ploidy            = make(map[string]int)
+  g_sample_haplotypes = make(map[string][]string)
+  g_haplotype_tiles   = make(map[string][]string)
+  g_unphased          = make(map[string]bool)
 }
 
-func create_tag_id(tile_path, tag_seq string) string {
-  var no_call_bitvec uint
-  for i:=0; i<24; i++ {
-    if tag_seq[23-i] == 'n' || tag_seq[23-i] == 'N' { no_call_bitvec |= (1<<uint(i)); }
+// Find the Sequence ID of the tag that is the prefix
+// tag of the tile at pos.
+//
+func tag_sequence_id(pos fastj.TilePos, tag string) (int64, error) {
+  tag_name := fastj.TagSequenceName(pos, tag)
+  seqid,ok := g_seqname_seqid_map[tag_name]
+  if !ok {
+    return 0, fmt.Errorf("could not find tag '%s' (%s) in Sequence map", tag, tag_name)
   }
-  return fmt.Sprintf("%s.%s.t%06x", md5sum_str(tag_seq), tile_path, no_call_bitvec)
+  return seqid, nil
 }
 
-// Open a stream and read the FastJ file.
-// Populate g_tile_lib.  This will group
-// tiles by path.step in g_tile_lib.  In
-// each grouping there will be a tile per md5sum
-// with the appropriate TileInfo field.
+// Find the Sequence ID of the body of tile and the seed tile
+// length it has in the graph.
 //
-func import_fastj(name, fn string) error {
-  var prev_md5sum string
-  var prev_tile_path string ; _ = prev_tile_path
-  var prev_tileid string ; _ = prev_tileid
-  var prev_seedlen int ; _ = prev_seedlen
-
-  var prev_path_i int64 ; _ = prev_path_i
-  var prev_step_i int64 ; _ = prev_step_i
-
-  var prev_tile_allele int64 ; _ = prev_tile_allele
-  var prev_allele_name_id string
-
-  curseq := make([]string, 0, 10)
-
-  h,e := autoio.OpenReadScannerSimple(fn)
-  if e!=nil { return e }
-
-  for h.ReadScan() {
-    l := h.ReadText()
-    if len(l) == 0 { continue }
-
-    if l[0] == '>' {
-      sj,e := sloppyjson.Loads(l[1:])
-      if e!=nil { return e }
-
-      md5sum := sj.O["md5sum"].S
-      tileid := sj.O["tileID"].S
-      seedlen := int(sj.O["seedTileLength"].P)
-
-      tile_parts := strings.SplitN(tileid, ".", 4)
-      tile_path := fmt.Sprintf("%s.%s", tile_parts[0], tile_parts[2])
-
-      path_i,e := strconv.ParseInt(tile_parts[0], 16, 64)
-      if e!=nil { return e }
-      step_i,e := strconv.ParseInt(tile_parts[2], 16, 64)
-      if e!=nil { return e }
-
-      tile_allele,e := strconv.ParseInt(tile_parts[3], 16, 64)
-      if e!=nil { return e }
-
-      allele_name_id := fmt.Sprintf("%s:%d", name, tile_allele)
-
-      // Initialize everything if we haven't seen it before
-      //
-      if _,ok := g_allele[allele_name_id] ; !ok {
+// The body is resolved through the tile map, using the md5sum
+// of the whole tile, so the path goes through the node of the
+// right rank.  Without a tile map the body is looked up by its
+// md5sum, path.step and seed tile length, which is ambiguous if
+// more than one rank shares the same body.
+//
+// The seed tile length is the one in the body name, which
+// create_tile_graph corrects for tiles followed by one that
+// starts further on (see fastj.BridgedSeedTileLength).
+//
+func body_sequence_id(tile *fastj.Tile) (int64, int, error) {
+  pos := tile.TileID.Pos()
 
-        callset_id := g_callset[name].Id
-        ploidy := 1
-        variant_set_id := g_START_VARIANTSET_ID
+  if len(g_tile_body_seqname)>0 {
+    body_name,ok := g_tile_body_seqname[pos][tile.Md5Sum]
+    if !ok {
+      return 0, 0, fmt.Errorf("could not find tile %s (%s) in tile map", tile.TileID, tile.Md5Sum)
+    }
 
-        g_allele[allele_name_id] = Allele{ g_ALLELE_ID, variant_set_id, allele_name_id, 0 }
-        g_allele_path_item[allele_name_id] = make([]AllelePathItem, 0, 1024)
-        g_allele_call[allele_name_id] = AlleleCall{ g_ALLELE_ID, callset_id, ploidy  }
-        g_ALLELE_ID++
-      }
+    seqid,ok := g_seqname_seqid_map[body_name]
+    if !ok {
+      return 0, 0, fmt.Errorf("could not find body %s in Sequence map", body_name)
+    }
+    seedlen,ok := fastj.BodySeedTileLength(body_name)
+    if !ok { seedlen = tile.SeedTileLength }
+    return seqid, seedlen, nil
+  }
 
-      if len(curseq)>0 {
+  key := fmt.Sprintf("%s.%s+%0x", md5sum_str(tile.Body()), pos, tile.SeedTileLength)
+  body_names := g_body_seqname_map[key]
 
-        tile_seq := strings.Join(curseq, "")
-        pfx_tag := tile_seq[0:24]
-        sfx_tag := tile_seq[len(tile_seq)-24:]
-        body_seq := tile_seq[24:len(tile_seq)-24]
+  if len(body_names)==0 {
+    return 0, 0, fmt.Errorf("could not find body (%s) in Sequence map", key)
+  }
+  if len(body_names)>1 {
+    return 0, 0, fmt.Errorf("ambiguous body for tile %s, candidates %s (use -tile-map)", tile.TileID, strings.Join(body_names, " "))
+  }
 
-        md5_tile_seq := md5sum_str(tile_seq)
-        if md5_tile_seq != prev_md5sum {
-          log.Fatal(fmt.Sprintf("previous md5sum %s (%s) != current md5sum %s (%s)\n", prev_md5sum, tileid, md5_tile_seq, prev_tileid))
-        }
+  return g_seqname_seqid_map[body_names[0]], tile.SeedTileLength, nil
+}
 
-        var ok bool
-        var seqid int64
 
-        allele_id := g_allele[prev_allele_name_id].Id
-        allele_path := g_allele_path_item[prev_allele_name_id]
-        cur_idx := len(allele_path)
+// Add the no-call intervals of seq[beg:end] as
+// no-calls on the given AllelePathItem.
+//
+func add_nocall_intervals(allele_name_id string, item AllelePathItem, seq string, beg, end int) {
+  for i:=beg; i<end; i++ {
+    if seq[i]!='n' && seq[i]!='N' { continue }
+    j:=i
+    for ; j<end && (seq[j]=='n' || seq[j]=='N'); j++ { }
+
+    g_allele_path_item_nocall[allele_name_id] = append(g_allele_path_item_nocall[allele_name_id],
+      AllelePathItemNoCall{ item.AlleleId, item.PathItemIndex, i-beg, j-i })
+    i=j
+  }
+}
 
+// Add the tag and body Sequence IDs for a single tile
+// to the AllelePathItem list of its allele.  The prefix
+// tag is only added for the first tile of the allele.
+//
+// If the tile was filled in by create_tile_graph, the
+// path goes through the filled in nodes and the no-calls
+// of the original tile are recorded per AllelePathItem.
+//
+// Alleles are keyed by haplotype ([name]:[variant]) here
+// and only get their IDs and names in call_alleles.  Tiles
+// without a sequence don't start a haplotype.
+//
+func add_tile(name string, tile *fastj.Tile) error {
+  allele_name_id := fmt.Sprintf("%s:%d", name, tile.TileID.Variant)
 
-        pfx_md5 := md5sum_str(pfx_tag)
-        if seqid,ok = g_md5_seqid_map[pfx_md5] ; !ok {
-          log.Fatal(fmt.Sprintf("ERROR: could not find tag '%s' (%s) in Sequence map", pfx_tag, pfx_md5))
-        }
+  if len(tile.Seq)==0 { return nil }
 
-        if cur_idx==0 {
-          allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, "'TRUE'"})
-          cur_idx++
-        }
+  if e:=tile.CheckMd5() ; e!=nil { return e }
 
-        body_md5 := md5sum_str(body_seq)
-        if seqid,ok = g_md5_seqid_map[body_md5] ; !ok {
-          log.Fatal(fmt.Sprintf("ERROR: could not find body (%s) in Sequence map", body_md5))
-        }
+  // Initialize everything if we haven't seen it before
+  //
+  if _,ok := g_allele[allele_name_id] ; !ok {
+    g_allele[allele_name_id] = Allele{ 0, g_START_VARIANTSET_ID, allele_name_id, 0 }
+    g_allele_path_item[allele_name_id] = make([]AllelePathItem, 0, 1024)
+    g_sample_haplotypes[name] = append(g_sample_haplotypes[name], allele_name_id)
+  }
 
-        allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, len(body_seq), "'TRUE'"})
-        cur_idx++
+  g_haplotype_tiles[allele_name_id] = append(g_haplotype_tiles[allele_name_id], allelecall.TileSig(tile))
+  if allelecall.IsUnphased(tile) { g_unphased[name] = true }
 
-        sfx_md5 := md5sum_str(sfx_tag)
-        if seqid,ok = g_md5_seqid_map[sfx_md5] ; !ok {
-          log.Fatal(fmt.Sprintf("ERROR: could not find tag '%s' (%s) in Sequence map", sfx_tag, sfx_md5))
-        }
+  orig_seq := tile.Seq
+  if filled_seq,ok := g_nocall_fill_map[tile.TileID.Pos()][tile.Md5Sum] ; ok {
+    if len(filled_seq)!=len(orig_seq) {
+      return fmt.Errorf("filled tile length %d != tile length %d for %s", len(filled_seq), len(orig_seq), tile.TileID)
+    }
+    z := *tile
+    z.Seq = filled_seq
+    z.Md5Sum = md5sum_str(filled_seq)
+    tile = &z
+  }
 
-        allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, "'TRUE'"})
-        cur_idx++
+  pfx_tag := tile.PrefixTag()
+  sfx_tag := tile.SuffixTag()
+  body_seq := tile.Body()
 
-        g_allele_path_item[prev_allele_name_id] = allele_path
+  allele_id := g_allele[allele_name_id].Id
+  allele_path := g_allele_path_item[allele_name_id]
+  cur_idx := len(allele_path)
 
-      } else {
-      }
+  seqid,e := tag_sequence_id(tile.TileID.Pos(), pfx_tag)
+  if e!=nil { return e }
 
-      curseq = curseq[0:0]
+  if cur_idx==0 {
+    allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, true})
+    add_nocall_intervals(allele_name_id, allele_path[cur_idx], orig_seq, 0, 24)
+    cur_idx++
+  }
 
-      prev_md5sum     = md5sum
-      prev_tile_path  = tile_path
-      prev_tileid     = tileid
-      prev_seedlen    = seedlen
-      prev_path_i     = path_i
-      prev_step_i     = step_i
-      prev_tile_allele = tile_allele
-      prev_allele_name_id = allele_name_id
+  seqid,seedlen,e := body_sequence_id(tile)
+  if e!=nil { return e }
+  if seedlen!=tile.SeedTileLength {
+    fmt.Fprintf(os.Stderr, "warning: %s: %s (seedTileLength %d) has seedTileLength %d in the graph, taking its suffix tag from there\n",
+      name, tile.TileID, tile.SeedTileLength, seedlen)
+  }
 
-      continue
+  allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, len(body_seq), true})
+  add_nocall_intervals(allele_name_id, allele_path[cur_idx], orig_seq, 24, len(orig_seq)-24)
+  cur_idx++
 
-    }
+  seqid,e = tag_sequence_id(tile.TileID.Pos().Next(seedlen), sfx_tag)
+  if e!=nil { return e }
 
-    curseq = append(curseq, l)
+  allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, true})
+  add_nocall_intervals(allele_name_id, allele_path[cur_idx], orig_seq, len(orig_seq)-24, len(orig_seq))
+  cur_idx++
 
-  }
+  g_allele_path_item[allele_name_id] = allele_path
 
-  if len(curseq)>0 {
-    tile_seq := strings.Join(curseq, "")
-    pfx_tag := tile_seq[0:24]
-    sfx_tag := tile_seq[len(tile_seq)-24:]
-    body_seq := tile_seq[24:len(tile_seq)-24]
+  return nil
+}
 
-    md5_tile_seq := md5sum_str(tile_seq)
-    if md5_tile_seq != prev_md5sum {
-      log.Fatal(fmt.Sprintf("previous md5sum %s != current md5sum %s (%s)\n", prev_md5sum, md5_tile_seq, prev_tileid))
+// Turn the haplotypes of each sample, in input order, into
+// Alleles and AlleleCalls (see the allelecall package).
+// Allele IDs are given out here, in order, and the path
+// items and no-calls of each Allele are those of its first
+// haplotype.
+//
+func call_alleles(names []string) error {
+  alleles := make(map[string]Allele)
+  paths := make(map[string][]AllelePathItem)
+  nocalls := make(map[string][]AllelePathItemNoCall)
+
+  for _,name := range names {
+    haps := make([]allelecall.Haplotype, 0, 2)
+    for _,k := range g_sample_haplotypes[name] {
+      variant,_ := strconv.Atoi(k[strings.LastIndex(k, ":")+1:])
+      haps = append(haps, allelecall.Haplotype{ Key:k, Variant:variant, Tiles:g_haplotype_tiles[k] })
     }
+    sort.Sort(HaplotypeOrder(haps))
 
-    var ok bool
-    var seqid int64
+    ploidy,ok := g_ploidy[name]
+    if !ok { ploidy = g_default_ploidy }
 
-    pfx_md5 := md5sum_str(pfx_tag)
-    if seqid,ok = g_md5_seqid_map[pfx_md5] ; !ok {
-      log.Fatal(fmt.Sprintf("ERROR: could not find tag '%s' (%s) in Sequence map", pfx_tag, pfx_md5))
-    }
+    sample := allelecall.Sample{ Name:name, Ploidy:ploidy, Phased:!g_unphased[name], Collapse:g_collapse_homozygous }
+    calls,e := allelecall.Calls(sample, haps)
+    if e!=nil { return e }
 
+    for _,call := range calls {
+      id := g_ALLELE_ID
+      g_ALLELE_ID++
 
-    // Only add the prefix tag if it's the first one in the AllelePathItem
-    //
-    allele_id := g_allele[prev_allele_name_id].Id
-    allele_path := g_allele_path_item[prev_allele_name_id]
-    cur_idx := len(allele_path)
+      k := call.Keys[0]
+      alleles[call.Name] = Allele{ id, g_START_VARIANTSET_ID, call.Name, 0 }
+      g_allele_call[call.Name] = AlleleCall{ id, g_callset[name].Id, call.Ploidy }
 
-    if cur_idx==0 {
-      allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, "'TRUE'"} )
-      cur_idx++
+      for _,item := range g_allele_path_item[k] {
+        item.AlleleId = id
+        paths[call.Name] = append(paths[call.Name], item)
+      }
+      for _,nc := range g_allele_path_item_nocall[k] {
+        nc.AlleleId = id
+        nocalls[call.Name] = append(nocalls[call.Name], nc)
+      }
     }
+  }
 
+  g_allele = alleles
+  g_allele_path_item = paths
+  g_allele_path_item_nocall = nocalls
+  return nil
+}
 
-    body_md5 := md5sum_str(body_seq)
-    if seqid,ok = g_md5_seqid_map[body_md5] ; !ok {
-      log.Fatal(fmt.Sprintf("ERROR: could not find body (%s) in Sequence map", body_md5))
-    }
+type HaplotypeOrder []allelecall.Haplotype
+func (h HaplotypeOrder) Len() int { return len(h) }
+func (h HaplotypeOrder) Swap(i,j int) { h[i],h[j] = h[j],h[i] }
+func (h HaplotypeOrder) Less(i,j int) bool { return h[i].Variant < h[j].Variant }
 
-    allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, len(body_seq), "'TRUE'"} )
-    cur_idx++
+// Open a stream and read the FastJ file.
+// Each tile is added to the AllelePathItem
+// list of the allele it belongs to.
+//
+func import_fastj(name, fn string) error {
+  fj,e := fjidx.OpenRange(fn, g_range_beg, g_range_end)
+  if e!=nil { return e }
+  defer fj.Close()
 
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { return e }
 
-    sfx_md5 := md5sum_str(sfx_tag)
-    if seqid,ok = g_md5_seqid_map[sfx_md5] ; !ok {
-      log.Fatal(fmt.Sprintf("ERROR: could not find tag '%s' (%s) in Sequence map", sfx_tag, sfx_md5))
+    if e:=add_tile(name, tile) ; e!=nil {
+      return fmt.Errorf("%s (line %d): %v", fn, fj.LineNo(), e)
     }
-
-    allele_path = append(allele_path, AllelePathItem{allele_id, cur_idx, int(seqid), 0, 24, "'TRUE'"} )
-    cur_idx++
-
-    g_allele_path_item[prev_allele_name_id] = allele_path
-
   }
 
-  _ = name
   return nil
 
 }
 
-// Parse Sequence CSV file.
-// Assumes no header.
-// Assumes Sequence element order is:
+// Parse Sequence CSV file.  If the first line is a header
+// (as written by create_tile_graph -csv-header) the columns
+// are found by name, otherwise they're in the order of the
+// schema:
 //
 //  0    1           2                3        4
 // ID,fastaID,sequenceRecordName,md5checksum,length
@@ -379,6 +558,9 @@ func import_sequence(fn string) error {
 
   line_no:=-1
 
+  var cols graphdb.ColumnMap
+  nfield := 0
+
   for h.ReadScan() {
     line_no++
     l := h.ReadText()
@@ -386,40 +568,120 @@ func import_sequence(fn string) error {
 
     line_parts := strings.Split(l, ",")
 
-    id,e := strconv.ParseInt(line_parts[0], 10, 64)
-    if e!=nil { return fmt.Errorf("ERROR: parsing ID in Sequence file (line %d): %s", line_no, line_parts[0]) }
+    if cols==nil {
+      var header bool
+      cols,header,e = graphdb.ParseColumns("Sequence", l)
+      if e!=nil { return e }
+      if e:=cols.Require("Sequence", "ID", "sequenceRecordName", "length") ; e!=nil { return e }
+      nfield = len(cols)
+      if header { continue }
+    }
+
+    if len(line_parts)!=nfield {
+      return fmt.Errorf("ERROR: expected %d fields in Sequence file (line %d)", nfield, line_no)
+    }
+
+    id,e := strconv.ParseInt(cols.Get(line_parts, "ID"), 10, 64)
+    if e!=nil { return fmt.Errorf("ERROR: parsing ID in Sequence file (line %d): %s", line_no, cols.Get(line_parts, "ID")) }
 
-    fastaid := line_parts[1] ; _ = fastaid
-    seqname := line_parts[2] ; _ = seqname
-    m5      := line_parts[3] ; _ = m5
+    seqname := cols.Get(line_parts, "sequenceRecordName")
 
-    seqlen,e := strconv.ParseInt(line_parts[4], 10, 64) ; _ = seqlen
-    if e!=nil { return fmt.Errorf("ERROR: parsing seqlen in Sequence file (line %d): %s", line_no, line_parts[4]) }
+    _,e = strconv.ParseInt(cols.Get(line_parts, "length"), 10, 64)
+    if e!=nil { return fmt.Errorf("ERROR: parsing seqlen in Sequence file (line %d): %s", line_no, cols.Get(line_parts, "length")) }
 
-    g_md5_seqid_map[m5] = id
+    if e:=add_sequence(id, seqname) ; e!=nil {
+      return fmt.Errorf("%v in Sequence file (line %d)", e, line_no)
+    }
   }
 
   return nil
 
 }
 
+// Read the Sequence rows from the database
+// written by create_tile_graph -db.
+//
+func import_sequence_db(db *sql.DB) error {
+  seqs,e := graphdb.ReadSequence(db)
+  if e!=nil { return e }
+
+  for i:=0; i<len(seqs); i++ {
+    if e:=add_sequence(int64(seqs[i].Id), seqs[i].SequenceRecordName) ; e!=nil {
+      return fmt.Errorf("%v in Sequence table", e)
+    }
+  }
+
+  return nil
+}
+
+func add_sequence(id int64, seqname string) error {
+  if prev_id,ok := g_seqname_seqid_map[seqname] ; ok && prev_id!=id {
+    return fmt.Errorf("ERROR: duplicate sequenceRecordName %s, IDs %d and %d", seqname, prev_id, id)
+  }
+  g_seqname_seqid_map[seqname] = id
+
+  // Body names are [md5sum].[path].[step].r[rank]+[seed-tile-length]
+  //
+  if r_pos := strings.LastIndex(seqname, ".r") ; r_pos>=0 {
+    if s_pos := strings.LastIndex(seqname, "+") ; s_pos>r_pos {
+      key := seqname[:r_pos] + seqname[s_pos:]
+      g_body_seqname_map[key] = append(g_body_seqname_map[key], seqname)
+    }
+  }
+
+  return nil
+}
+
 var g_allele_name_id_map map[string]int
 
+// Keys of g_allele, g_callset and g_variantset in ID order
+// so the output doesn't depend on map iteration order.
+// Allele and CallSet IDs follow the order of the inputs.
+//
+func keys_by_id(key_id map[string]int) []string {
+  ids := make([]int, 0, len(key_id))
+  id_key := make(map[int]string)
+  for k,id := range key_id {
+    ids = append(ids, id)
+    id_key[id] = k
+  }
+  sort.Ints(ids)
+
+  keys := make([]string, len(ids))
+  for i:=0; i<len(ids); i++ { keys[i] = id_key[ids[i]] }
+  return keys
+}
+
+func allele_keys() []string {
+  key_id := make(map[string]int)
+  for k := range g_allele { key_id[k] = g_allele[k].Id }
+  return keys_by_id(key_id)
+}
+
+func callset_keys() []string {
+  key_id := make(map[string]int)
+  for k := range g_callset { key_id[k] = g_callset[k].Id }
+  return keys_by_id(key_id)
+}
+
+func variantset_keys() []string {
+  key_id := make(map[string]int)
+  for k := range g_variantset { key_id[k] = g_variantset[k].Id }
+  return keys_by_id(key_id)
+}
+
 func emit_allele_call(ofp *bufio.Writer) {
-  for k := range g_allele_call {
-    s := fmt.Sprintf("%d,%d,%d\n",
-      g_allele_call[k].AlleleId,
-      g_allele_call[k].CallSetId,
-      g_allele_call[k].Ploidy)
-    ofp.Write([]byte(s))
+  for _,k := range allele_keys() {
+    a := g_allele_call[k]
+    emit_row(ofp, "AlleleCall", graphdb.Row{ "alleleID":a.AlleleId, "callSetID":a.CallSetId, "ploidy":a.Ploidy })
   }
 }
 
 func emit_allele(ofp *bufio.Writer) {
 
-  for allele_key := range g_allele {
-    s := fmt.Sprintf("%d,%d,%s\n", g_allele[allele_key].Id, g_allele[allele_key].VariantSetId, g_allele[allele_key].Name)
-    ofp.Write([]byte(s))
+  for _,allele_key := range allele_keys() {
+    a := g_allele[allele_key]
+    emit_row(ofp, "Allele", graphdb.Row{ "ID":a.Id, "variantSetID":a.VariantSetId, "name":a.Name })
   }
 
   return
@@ -440,17 +702,12 @@ func emit_allele(ofp *bufio.Writer) {
 
 func emit_allele_path_item(ofp *bufio.Writer) {
 
-  for k := range g_allele_path_item {
+  for _,k := range allele_keys() {
 
     for i:=0; i<len(g_allele_path_item[k]); i++ {
-      s := fmt.Sprintf("%d,%d,%d,%d,%d,%s\n",
-        g_allele_path_item[k][i].AlleleId,
-        g_allele_path_item[k][i].PathItemIndex,
-        g_allele_path_item[k][i].SequenceId,
-        g_allele_path_item[k][i].Start,
-        g_allele_path_item[k][i].Length,
-        g_allele_path_item[k][i].StrandIsForward)
-      ofp.Write([]byte(s))
+      item := g_allele_path_item[k][i]
+      emit_row(ofp, "AllelePathItem", graphdb.Row{ "alleleID":item.AlleleId, "pathItemIndex":item.PathItemIndex,
+        "sequenceID":item.SequenceId, "start":item.Start, "length":item.Length, "strandIsForward":item.StrandIsForward })
     }
   }
 
@@ -469,46 +726,444 @@ func emit_allele_path_item(ofp *bufio.Writer) {
   }
 }
 
+func emit_allele_path_item_nocall(ofp *bufio.Writer) {
+  for _,k := range allele_keys() {
+    for i:=0; i<len(g_allele_path_item_nocall[k]); i++ {
+      nc := g_allele_path_item_nocall[k][i]
+      emit_row(ofp, "AllelePathItemNoCall", graphdb.Row{ "alleleID":nc.AlleleId, "pathItemIndex":nc.PathItemIndex,
+        "start":nc.Start, "length":nc.Length })
+    }
+  }
+}
+
+// Parse the tile map written by create_tile_graph.
+// Each line is:
+//
+//   path.step,md5sum,body_sequence_record_name
+//
+func import_tile_map(fn string) error {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return e }
+  defer h.Close()
+
+  line_no:=0
+
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    line_parts := strings.Split(l, ",")
+    if len(line_parts)!=3 {
+      return fmt.Errorf("ERROR: expected 3 fields in tile map (line %d)", line_no)
+    }
+
+    path_step,e := fastj.ParseTilePos(line_parts[0])
+    if e!=nil { return fmt.Errorf("ERROR: parsing path.step in tile map (line %d): %v", line_no, e) }
+
+    if _,ok := g_tile_body_seqname[path_step] ; !ok {
+      g_tile_body_seqname[path_step] = make(map[string]string)
+    }
+
+    if prev,ok := g_tile_body_seqname[path_step][line_parts[1]] ; ok && prev!=line_parts[2] {
+      return fmt.Errorf("ERROR: tile %s %s maps to both %s and %s in tile map (line %d)", line_parts[0], line_parts[1], prev, line_parts[2], line_no)
+    }
+    g_tile_body_seqname[path_step][line_parts[1]] = line_parts[2]
+  }
+
+  return nil
+}
+
+// Parse the no-call fill map written by create_tile_graph.
+// Each line is:
+//
+//   path.step,md5sum,filled_md5sum,filled_sequence
+//
+func import_nocall_map(fn string) error {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return e }
+  defer h.Close()
+
+  line_no:=0
+
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    line_parts := strings.Split(l, ",")
+    if len(line_parts)!=4 {
+      return fmt.Errorf("ERROR: expected 4 fields in no-call map (line %d)", line_no)
+    }
+
+    path_step,e := fastj.ParseTilePos(line_parts[0])
+    if e!=nil { return fmt.Errorf("ERROR: parsing path.step in no-call map (line %d): %v", line_no, e) }
+
+    if md5sum_str(line_parts[3]) != line_parts[2] {
+      return fmt.Errorf("ERROR: filled sequence md5sum mismatch in no-call map (line %d)", line_no)
+    }
+
+    if _,ok := g_nocall_fill_map[path_step] ; !ok {
+      g_nocall_fill_map[path_step] = make(map[string]string)
+    }
+    g_nocall_fill_map[path_step][line_parts[1]] = line_parts[3]
+  }
+
+  return nil
+}
 
 func emit_callset(ofp *bufio.Writer) {
-  for cs_id := range g_callset {
-    s:=fmt.Sprintf("%d,%s,%s\n", g_callset[cs_id].Id, g_callset[cs_id].Name, g_callset[cs_id].SampleId)
-    ofp.Write([]byte(s))
+  for _,cs_id := range callset_keys() {
+    cs := g_callset[cs_id]
+    emit_row(ofp, "CallSet", graphdb.Row{ "ID":cs.Id, "name":cs.Name, "sampleID":cs.SampleId })
   }
 }
 
 func emit_variantset_callset_join(ofp *bufio.Writer) {
-  for cs_id := range g_callset {
-    s:=fmt.Sprintf("%d,%d\n", g_callset[cs_id].Id, g_START_VARIANTSET_ID)
-    ofp.Write([]byte(s))
+  for _,cs_id := range callset_keys() {
+    emit_row(ofp, "VariantSet_CallSet_Join", graphdb.Row{ "variantSetID":g_START_VARIANTSET_ID, "callSetID":g_callset[cs_id].Id })
   }
 }
 
 func add_variantset(id int, name string) int {
-  g_variantset[name] = VariantSet{id, name, -1}
+  g_variantset[name] = VariantSet{id, name, g_REFERENCESET_ID}
   return id+1
 }
 
 func emit_variantset(ofp *bufio.Writer) {
-  for v_id := range g_variantset {
-    s:=fmt.Sprintf("%d,%s,%d\n", g_variantset[v_id].Id, g_variantset[v_id].Name, g_variantset[v_id].ReferenceSetId)
-    ofp.Write([]byte(s))
+  for _,v_id := range variantset_keys() {
+    v := g_variantset[v_id]
+    emit_row(ofp, "VariantSet", graphdb.Row{ "ID":v.Id, "referenceSetID":v.ReferenceSetId, "name":v.Name })
+  }
+}
+
+// Write a GFA 1.0 P-line for each allele.  Segments are named by
+// their sequenceRecordName, as in create_tile_graph -gfa, so the
+// output can be appended to the graph GFA.
+//
+func emit_gfa_paths(ofp *bufio.Writer) {
+  id_name := make(map[int64]string)
+  for name,id := range g_seqname_seqid_map {
+    id_name[id] = name
+  }
+
+  for _,k := range allele_keys() {
+    path := g_allele_path_item[k]
+    if len(path)==0 { continue }
+
+    segs := make([]string, len(path))
+    fwd := make([]bool, len(path))
+    for i:=0; i<len(path); i++ {
+      segs[i] = id_name[int64(path[i].SequenceId)]
+      fwd[i] = path[i].StrandIsForward
+    }
+
+    gfa.WritePath(ofp, g_allele[k].Name, segs, fwd)
+  }
+}
+
+func create_csv(fn string, emit func(*bufio.Writer)) {
+  out,err := autoio.CreateWriter( fn )
+  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
+  emit(out.Writer)
+  out.Flush()
+  out.Close()
+}
+
+// CSV file of a table, with a header
+// line naming the columns if -csv-header is set.
+//
+func create_table_csv(fn, table string, emit func(*bufio.Writer)) {
+  create_csv(fn, func(ofp *bufio.Writer) {
+    if g_csv_header {
+      h,e := graphdb.CSVHeader(table)
+      if e!=nil { log.Fatal(e) }
+      ofp.WriteString(h)
+    }
+    emit(ofp)
+  })
+}
+
+func emit_row(ofp *bufio.Writer, table string, vals graphdb.Row) {
+  l,e := graphdb.CSVRow(table, vals)
+  if e!=nil { log.Fatal(e) }
+  ofp.WriteString(l)
+}
+
+// AllelePathItem rows of all the alleles, in allele then
+// path item order.
+//
+func path_items() []graphdb.AllelePathItem {
+  items := make([]graphdb.AllelePathItem, 0, 1024)
+  for _,k := range allele_keys() {
+    for _,p := range g_allele_path_item[k] {
+      items = append(items, graphdb.AllelePathItem{
+        AlleleId:p.AlleleId, PathItemIndex:p.PathItemIndex, SequenceId:p.SequenceId,
+        Start:p.Start, Length:p.Length, StrandIsForward:p.StrandIsForward })
+    }
+  }
+  return items
+}
+
+// Link the GraphJoins the alleles go through to the VariantSet.
+// With -db the joins are those in the database and the links
+// already there are left out, otherwise the joins are read from
+// the GraphJoin CSV file graphjoin_fn.
+//
+func link_graphjoins(db *sql.DB, graphjoin_fn string) error {
+  var e error
+  if db!=nil {
+    g_graphjoin_variantset,e = graphdb.VariantSetGraphJoinLinks(db, g_START_VARIANTSET_ID, nil, path_items())
+    return e
+  }
+
+  index,e := import_graphjoin(graphjoin_fn)
+  if e!=nil { return e }
+  g_graphjoin_variantset,e = graphdb.GraphJoinLinks(index, nil, g_START_VARIANTSET_ID, path_items())
+  if e!=nil { return fmt.Errorf("%s: %v", graphjoin_fn, e) }
+  return nil
+}
+
+func emit_graphjoin_variantset(ofp *bufio.Writer) {
+  for _,j := range g_graphjoin_variantset {
+    emit_row(ofp, "GraphJoin_VariantSet_Join", graphdb.Row{ "graphJoinID":j.GraphJoinId, "variantSetID":j.VariantSetId })
+  }
+}
+
+// Insert the VariantSet, CallSet, VariantSet_CallSet_Join, Allele,
+// AlleleCall, AllelePathItem, AllelePathItemNoCall and the
+// GraphJoin_VariantSet_Join rows inside a single transaction.
+//
+func write_db(db *sql.DB) error {
+  vs := make([]graphdb.VariantSet, 0, len(g_variantset))
+  for _,k := range variantset_keys() {
+    v := g_variantset[k]
+    vs = append(vs, graphdb.VariantSet{Id:v.Id, ReferenceSetId:v.ReferenceSetId, Name:v.Name})
+  }
+
+  cs := make([]graphdb.CallSet, 0, len(g_callset))
+  vs_cs := make([]graphdb.VariantSetCallSetJoin, 0, len(g_callset))
+  for _,k := range callset_keys() {
+    x := g_callset[k]
+    cs = append(cs, graphdb.CallSet{Id:x.Id, Name:x.Name, SampleId:x.SampleId})
+    vs_cs = append(vs_cs, graphdb.VariantSetCallSetJoin{VariantSetId:g_START_VARIANTSET_ID, CallSetId:x.Id})
+  }
+
+  alleles := make([]graphdb.Allele, 0, len(g_allele))
+  for _,k := range allele_keys() {
+    a := g_allele[k]
+    alleles = append(alleles, graphdb.Allele{Id:a.Id, VariantSetId:a.VariantSetId, Name:a.Name})
+  }
+
+  calls := make([]graphdb.AlleleCall, 0, len(g_allele_call))
+  for _,k := range allele_keys() {
+    a := g_allele_call[k]
+    calls = append(calls, graphdb.AlleleCall{AlleleId:a.AlleleId, CallSetId:a.CallSetId, Ploidy:a.Ploidy})
+  }
+
+  items := path_items()
+
+  nocalls := make([]graphdb.AllelePathItemNoCall, 0, 1024)
+  for _,k := range allele_keys() {
+    for _,p := range g_allele_path_item_nocall[k] {
+      nocalls = append(nocalls, graphdb.AllelePathItemNoCall{
+        AlleleId:p.AlleleId, PathItemIndex:p.PathItemIndex, Start:p.Start, Length:p.Length })
+    }
+  }
+
+  tx,e := db.Begin()
+  if e!=nil { return e }
+
+  if !g_variantset_in_db {
+    if e = graphdb.InsertVariantSet(tx, vs) ; e!=nil { tx.Rollback() ; return e }
+  }
+  if e = graphdb.InsertCallSet(tx, cs) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertVariantSetCallSetJoin(tx, vs_cs) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertGraphJoinVariantSetJoin(tx, g_graphjoin_variantset) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertAllele(tx, alleles) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertAlleleCall(tx, calls) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertAllelePathItem(tx, items) ; e!=nil { tx.Rollback() ; return e }
+  if e = graphdb.InsertAllelePathItemNoCall(tx, nocalls) ; e!=nil { tx.Rollback() ; return e }
+
+  return tx.Commit()
+}
+
+// The ReferenceSet the graph's reference path is in, -1 if
+// it has none.  It has to be just the one.
+//
+func graph_referenceset(ids []int) (int, error) {
+  if len(ids)==0 { return -1, nil }
+  if len(ids)>1 {
+    return -1, fmt.Errorf("the graph joins are in ReferenceSets %v, give the VariantSet's with -referenceset-id or -assembly", ids)
+  }
+  return ids[0], nil
+}
+
+// Read a GraphJoin CSV file (create_tile_graph -graphjoin)
+// into a map of JoinKey to GraphJoin ID.
+//
+func import_graphjoin(fn string) (map[[2]int]int, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  index := make(map[[2]int]int)
+
+  var cols graphdb.ColumnMap
+  line_no:=-1
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    if cols==nil {
+      var header bool
+      cols,header,e = graphdb.ParseColumns("GraphJoin", l)
+      if e!=nil { return nil, e }
+      if e:=cols.Require("GraphJoin", "ID", "side1SequenceID", "side2SequenceID") ; e!=nil { return nil, e }
+      if header { continue }
+    }
+
+    line_parts := strings.Split(l, ",")
+    var v [3]int
+    for i,col := range []string{ "ID", "side1SequenceID", "side2SequenceID" } {
+      v[i],e = strconv.Atoi(cols.Get(line_parts, col))
+      if e!=nil { return nil, fmt.Errorf("%s: parsing %s (line %d): %s", fn, col, line_no, cols.Get(line_parts, col)) }
+    }
+    index[graphdb.JoinKey(v[1], v[2])] = v[0]
   }
+
+  return index, nil
 }
 
+// Read the referenceSetIDs of a GraphJoin_ReferenceSet_Join
+// CSV file (create_tile_graph -graphjoin-referenceset).
+//
+func import_graphjoin_referenceset(fn string) ([]int, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  seen := make(map[int]bool)
+  ids := make([]int, 0, 1)
+
+  var cols graphdb.ColumnMap
+  line_no:=-1
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    if cols==nil {
+      var header bool
+      cols,header,e = graphdb.ParseColumns("GraphJoin_ReferenceSet_Join", l)
+      if e!=nil { return nil, e }
+      if e:=cols.Require("GraphJoin_ReferenceSet_Join", "referenceSetID") ; e!=nil { return nil, e }
+      if header { continue }
+    }
+
+    line_parts := strings.Split(l, ",")
+    id,e := strconv.Atoi(cols.Get(line_parts, "referenceSetID"))
+    if e!=nil { return nil, fmt.Errorf("%s: parsing referenceSetID (line %d): %s", fn, line_no, cols.Get(line_parts, "referenceSetID")) }
+
+    if !seen[id] {
+      seen[id] = true
+      ids = append(ids, id)
+    }
+  }
+
+  sort.Ints(ids)
+  return ids, nil
+}
+
+// With -db, the IDs not given on the command line follow the
+// largest ones already in the database.  The VariantSet is looked
+// up by -variantset-name and only added if it isn't there.
+//
+func auto_ids(c *cli.Context, db *sql.DB) error {
+  next_id := func(flag, table string, id *int) error {
+    if c.IsSet(flag) { return nil }
+    max_id,e := graphdb.MaxId(db, table)
+    if e!=nil { return e }
+    *id = max_id+1
+    return nil
+  }
+
+  if e:=next_id("start-allele-id", "Allele", &g_ALLELE_ID) ; e!=nil { return e }
+  if e:=next_id("start-callset-id", "CallSet", &g_START_CALLSET_ID) ; e!=nil { return e }
+
+  explicit_rs := c.IsSet("referenceset-id")
+  if assembly := c.String("assembly") ; len(assembly)>0 {
+    rs,ok,e := graphdb.ReferenceSetByAssembly(db, assembly)
+    if e!=nil { return e }
+    if !ok { return fmt.Errorf("no ReferenceSet for assembly %s", assembly) }
+    g_REFERENCESET_ID = rs.Id
+    explicit_rs = true
+  }
+
+  vs,ok,e := graphdb.VariantSetByName(db, g_VARIANTSET_NAME)
+  if e!=nil { return e }
+  if ok {
+    if explicit_rs && g_REFERENCESET_ID!=vs.ReferenceSetId {
+      return fmt.Errorf("VariantSet %s is in ReferenceSet %d, not %d", vs.Name, vs.ReferenceSetId, g_REFERENCESET_ID)
+    }
+    g_REFERENCESET_ID = vs.ReferenceSetId
+    g_START_VARIANTSET_ID = vs.Id
+    g_variantset_in_db = true
+    return nil
+  }
+
+  if !explicit_rs {
+    ids,e := graphdb.GraphJoinReferenceSetIds(db)
+    if e!=nil { return e }
+    if g_REFERENCESET_ID,e = graph_referenceset(ids) ; e!=nil { return e }
+  }
+
+  return next_id("start-variantset-id", "VariantSet", &g_START_VARIANTSET_ID)
+}
 
 func _main( c *cli.Context ) {
   sequence_ifn  := c.String("sequence")
-  if len(sequence_ifn)==0 { cli.ShowAppHelp(c) }
+  db_fn         := c.String("db")
+  if len(sequence_ifn)==0 && len(db_fn)==0 { cli.ShowAppHelp(c) }
 
   g_ALLELE_ID = c.Int("start-allele-id")
   g_START_CALLSET_ID = c.Int("start-callset-id")
   g_START_VARIANTSET_ID = c.Int("start-variantset-id")
   g_VARIANTSET_NAME = c.String("variantset-name")
+  g_REFERENCESET_ID = c.Int("referenceset-id")
+
+  g_csv_header = c.Bool("csv-header")
+
+  g_default_ploidy = c.Int("default-ploidy")
+  if g_default_ploidy<1 || g_default_ploidy>2 {
+    fmt.Fprintf(os.Stderr, "invalid -default-ploidy %d (must be 1 or 2)\n", g_default_ploidy)
+    os.Exit(1)
+  }
+  g_collapse_homozygous = c.Bool("collapse-homozygous")
+  if z,e := allelecall.ParsePloidy(c.StringSlice("ploidy")) ; e!=nil {
+    fmt.Fprintf(os.Stderr, "%v\n", e)
+    os.Exit(1)
+  } else {
+    g_ploidy = z
+  }
 
   ifns := c.StringSlice("input")
   if len(ifns)==0 { cli.ShowAppHelp(c) }
 
+  g_range_beg,g_range_end = fjidx.AllTiles(),fjidx.AllTiles()
+  if len(c.String("start"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("start"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid start: %v\n", e) ; os.Exit(1) }
+    g_range_beg = z
+  }
+  if len(c.String("end"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("end"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid end: %v\n", e) ; os.Exit(1) }
+    g_range_end = z
+  }
+
+
   if c.Bool( "pprof" ) {
     gProfileFlag = true
     gProfileFile = c.String("pprof-file")
@@ -538,46 +1193,61 @@ func _main( c *cli.Context ) {
 
   allele_ofn            := c.String("allele")
   allele_path_item_ofn  := c.String("allele-path")
+  allele_path_item_nocall_ofn := c.String("allele-path-nocall")
   callset_ofn           := c.String("callset")
   allele_call_ofn       := c.String("allele-call")
   variantset_ofn        := c.String("variantset")
   variantset_callset_ofn  := c.String("variantset-callset-join")
 
 
-  allele_out,err := autoio.CreateWriter( allele_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { allele_out.Flush(); allele_out.Close() }()
-
-  allele_path_item_out,err := autoio.CreateWriter( allele_path_item_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { allele_path_item_out.Flush(); allele_path_item_out.Close() }()
-
-  callset_out,err := autoio.CreateWriter( callset_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { callset_out.Flush(); callset_out.Close() }()
-
-  allele_call_out,err := autoio.CreateWriter( allele_call_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { allele_call_out.Flush(); allele_call_out.Close() }()
-
-  variantset_out,err := autoio.CreateWriter( variantset_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { variantset_out.Flush(); variantset_out.Close() }()
+  show_progress_flag := c.Bool("progress")
 
-  variantset_callset_out,err := autoio.CreateWriter( variantset_callset_ofn )
-  if err!=nil { fmt.Fprintf(os.Stderr, "%v", err); os.Exit(1) }
-  defer func() { variantset_callset_out.Flush(); variantset_callset_out.Close() }()
+  // With -db the Sequence rows written by create_tile_graph -db
+  // are read from the database, otherwise from the -sequence CSV file.
+  //
+  var db *sql.DB
+  if len(db_fn)>0 {
+    var e error
+    db,e = graphdb.Open(db_fn)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+    defer db.Close()
+
+    if e:=auto_ids(c, db) ; e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  }
 
-  show_progress_flag := c.Bool("progress")
+  if db==nil && !c.IsSet("referenceset-id") && len(c.String("graphjoin-referenceset"))>0 {
+    ids,e := import_graphjoin_referenceset(c.String("graphjoin-referenceset"))
+    if e!=nil { log.Fatal(e) }
+    if g_REFERENCESET_ID,e = graph_referenceset(ids) ; e!=nil { log.Fatal(e) }
+  }
 
   // We only use one variant set
   //
   add_variantset(g_START_VARIANTSET_ID, g_VARIANTSET_NAME)
 
+  if db!=nil {
+    e := import_sequence_db(db)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  } else {
+    e := import_sequence(sequence_ifn)
+    if e!=nil { log.Fatal(e) }
+  }
 
-  // Process Sequence CSV file
+  // Process the tile map so bodies are resolved
+  // by the whole tile md5sum.
   //
-  import_sequence(sequence_ifn)
+  if len(c.String("tile-map"))>0 {
+    e := import_tile_map(c.String("tile-map"))
+    if e!=nil { log.Fatal(e) }
+  }
+
+  // Process the no-call fill map, if the graph was
+  // built with filled in no-calls.
+  //
+  if len(c.String("nocall-map"))>0 {
+    e := import_nocall_map(c.String("nocall-map"))
+    if e!=nil { log.Fatal(e) }
+  }
 
 
   // First populate the callset maps
@@ -593,6 +1263,7 @@ func _main( c *cli.Context ) {
 
   // Process input FastJ files
   //
+  names := make([]string, 0, len(ifns))
   for i:=0; i<len(ifns); i++ {
 
 
@@ -603,6 +1274,7 @@ func _main( c *cli.Context ) {
       name = z[0]
       ifn = z[1]
     }
+    names = append(names, name)
 
     if show_progress_flag { fmt.Printf(">>>> %s %s\n", name, ifn) }
 
@@ -610,27 +1282,42 @@ func _main( c *cli.Context ) {
     if e!=nil { log.Fatal(e) }
   }
 
-  // Variant Sets
-  //
-  emit_variantset(variantset_out.Writer)
-
+  if e:=call_alleles(names) ; e!=nil { log.Fatal(e) }
 
-  // Output the CallSet
+  // Without -db the joins come from the -graphjoin CSV file,
+  // and GraphJoin_VariantSet_Join is only written if it's given.
   //
-  emit_callset(callset_out.Writer)
-
-  emit_variantset_callset_join(variantset_callset_out.Writer)
+  link_joins := db!=nil || len(c.String("graphjoin"))>0
+  if link_joins {
+    if e:=link_graphjoins(db, c.String("graphjoin")) ; e!=nil { log.Fatal(e) }
+  } else {
+    fmt.Fprintf(os.Stderr, "warning: no -graphjoin, so no GraphJoin_VariantSet_Join rows are written\n")
+  }
 
-  // Output Allele information
-  //
-  emit_allele(allele_out.Writer)
+  if db!=nil {
+    e := write_db(db)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  }
 
+  // The CSV files are always written when there is no -db,
+  // otherwise only if asked for with -csv.
   //
-  emit_allele_call(allele_call_out.Writer)
+  if len(c.String("gfa"))>0 {
+    create_csv(c.String("gfa"), emit_gfa_paths)
+  }
 
-  // Output actual path
-  //
-  emit_allele_path_item(allele_path_item_out.Writer)
+  if db==nil || c.Bool("csv") {
+    create_table_csv(variantset_ofn, "VariantSet", emit_variantset)
+    create_table_csv(callset_ofn, "CallSet", emit_callset)
+    create_table_csv(variantset_callset_ofn, "VariantSet_CallSet_Join", emit_variantset_callset_join)
+    create_table_csv(allele_ofn, "Allele", emit_allele)
+    create_table_csv(allele_call_ofn, "AlleleCall", emit_allele_call)
+    create_table_csv(allele_path_item_ofn, "AllelePathItem", emit_allele_path_item)
+    create_table_csv(allele_path_item_nocall_ofn, "AllelePathItemNoCall", emit_allele_path_item_nocall)
+    if link_joins {
+      create_table_csv(c.String("graphjoin-variantset"), "GraphJoin_VariantSet_Join", emit_graphjoin_variantset)
+    }
+  }
 
 }
 
@@ -659,7 +1346,32 @@ func main() {
     cli.StringFlag{
       Name: "sequence",
       Value: "out.sequence",
-      Usage: "Sequence OUTPUT",
+      Usage: "Sequence CSV INPUT (ignored with -db)",
+    },
+
+    cli.StringFlag{
+      Name: "graphjoin",
+      Usage: "GraphJoin CSV INPUT from create_tile_graph -graphjoin, to link the joins the alleles use to the VariantSet (ignored with -db)",
+    },
+
+    cli.StringFlag{
+      Name: "db",
+      Usage: "SQLite database written by create_tile_graph -db.  Sequence rows are read from it and the allele rows are inserted into it",
+    },
+
+    cli.BoolFlag{
+      Name: "csv",
+      Usage: "Also write the CSV OUTPUTs when -db is given",
+    },
+
+    cli.BoolFlag{
+      Name: "csv-header",
+      Usage: "Start each CSV OUTPUT with a line of column names",
+    },
+
+    cli.StringFlag{
+      Name: "gfa",
+      Usage: "GFA 1.0 P-line OUTPUT, one path per allele (append to create_tile_graph -gfa output)",
     },
 
     cli.StringFlag{
@@ -680,6 +1392,22 @@ func main() {
       Usage: "AllelePathItem CSV OUTPUT",
     },
 
+    cli.StringFlag{
+      Name: "allele-path-nocall",
+      Value: "out.allelepath-nocall",
+      Usage: "AllelePathItemNoCall CSV OUTPUT",
+    },
+
+    cli.StringFlag{
+      Name: "tile-map",
+      Usage: "Tile map INPUT from create_tile_graph -tile-map (path.step,md5sum,body_sequence_record_name)",
+    },
+
+    cli.StringFlag{
+      Name: "nocall-map",
+      Usage: "No-call fill map INPUT from create_tile_graph -nocall-map",
+    },
+
     cli.StringFlag{
       Name: "allele-call",
       Value: "out.allelecall",
@@ -698,28 +1426,77 @@ func main() {
       Usage: "VariantSet_CallSet_Join CSV OUTPUT",
     },
 
+    cli.StringFlag{
+      Name: "graphjoin-variantset",
+      Value: "out.graphjoin-variantset",
+      Usage: "GraphJoin_VariantSet_Join CSV OUTPUT (with -db, the links added)",
+    },
+
     cli.StringFlag{
       Name: "variantset-name",
       Value: "none",
       Usage: "VariantSet Name",
     },
 
+    cli.StringSliceFlag{
+      Name: "ploidy",
+      Value: &cli.StringSlice{},
+      Usage: "Ploidy of an input, NAME=PLOIDY (1 or 2, can be specified more than once)",
+    },
+
+    cli.IntFlag{
+      Name: "default-ploidy",
+      Value: 2,
+      Usage: "Ploidy of the inputs not given one with -ploidy",
+    },
+
+    cli.BoolFlag{
+      Name: "collapse-homozygous",
+      Usage: "Call identical haplotypes of a sample as one Allele with ploidy 2",
+    },
+
     cli.IntFlag{
       Name: "start-allele-id",
       Value: 1,
-      Usage: "Start Allele ID",
+      Usage: "Start Allele ID (with -db, defaults to after the largest in the database)",
     },
 
     cli.IntFlag{
       Name: "start-callset-id",
       Value: 1,
-      Usage: "Start CallSet ID",
+      Usage: "Start CallSet ID (with -db, defaults to after the largest in the database)",
     },
 
     cli.IntFlag{
       Name: "start-variantset-id",
       Value: 1,
-      Usage: "Start VariantSet ID",
+      Usage: "Start VariantSet ID (with -db, the ID of the -variantset-name VariantSet if there is one, otherwise defaults to after the largest)",
+    },
+
+    cli.IntFlag{
+      Name: "referenceset-id",
+      Value: -1,
+      Usage: "ReferenceSet ID of the VariantSet if it's added (default the one the graph's reference path is in, if any)",
+    },
+
+    cli.StringFlag{
+      Name: "graphjoin-referenceset",
+      Usage: "Without -db, GraphJoin_ReferenceSet_Join CSV file written by create_tile_graph, to take the VariantSet's ReferenceSet from",
+    },
+
+    cli.StringFlag{
+      Name: "assembly",
+      Usage: "With -db, use the ReferenceSet of this assembly (e.g. grch38) for the VariantSet if it's added",
+    },
+
+    cli.StringFlag{
+      Name: "start, s",
+      Usage: "Only use input tiles from START (path.step, path.ver.step or path.ver.step.variant, inclusive)",
+    },
+
+    cli.StringFlag{
+      Name: "end, e",
+      Usage: "Only use input tiles up to END (inclusive)",
     },
 
     cli.IntFlag{
@@ -0,0 +1,359 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Check FastJ files and report every problem found, one per
+// line, as:
+//
+//   [file]:[line]: [tileID]: [problem]
+//
+// Each tile is checked for:
+//
+//  - md5sum matching the sequence
+//  - n matching the sequence length
+//  - nocallCount matching the number of no-calls ('n') in the sequence
+//  - startSeq/endSeq matching the start and end of the sequence
+//  - startTag/endTag matching startSeq/endSeq, other than at
+//    no-calls and tag variants noted with 'tagVariant'
+//
+// and each tile against the one before it on the same haplotype
+// (tile variant) for:
+//
+//  - the end tag being the next tile's start tag
+//  - step + seedTileLength being the next tile's step
+//  - locus start and end increasing
+//
+// Finally, the .000 and .001 haplotypes have to cover the same
+// steps.  These problems are about a whole path, not a line, so
+// are reported as '[file]: [path]: [problem]'.  Start and end tiles (startTile/endTile) don't have the
+// start or end tag checked.  The exit status is 1 if there were
+// any problems.
+//
+// example usage:
+//
+//  ./fjcheck -i hu826751.fj.gz
+//
+
+package main
+
+import "fmt"
+import "os"
+import "io"
+import "bufio"
+import "sort"
+import "strings"
+import "strconv"
+
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+type Checker struct {
+  fn string
+  out io.Writer
+  max_report int
+  problems int
+
+  // Last tile seen on each haplotype.
+  //
+  prev map[int]*fastj.Tile
+
+  // Steps covered by each haplotype, per path.
+  //
+  cover map[int]map[int]map[int]bool
+}
+
+func NewChecker(fn string, out io.Writer, max_report int) *Checker {
+  return &Checker{ fn: fn, out: out, max_report: max_report,
+    prev: make(map[int]*fastj.Tile),
+    cover: make(map[int]map[int]map[int]bool) }
+}
+
+// Report a problem, without a line number if line_no is 0.
+//
+func (ck *Checker) report(line_no int, tileid string, format string, args ...interface{}) {
+  ck.problems++
+  if ck.max_report>0 && ck.problems>ck.max_report { return }
+  if ck.max_report>0 && ck.problems==ck.max_report {
+    fmt.Fprintf(ck.out, "%s: too many problems, only counting from here on\n", ck.fn)
+  }
+  if line_no<=0 {
+    fmt.Fprintf(ck.out, "%s: %s: %s\n", ck.fn, tileid, fmt.Sprintf(format, args...))
+    return
+  }
+  fmt.Fprintf(ck.out, "%s:%d: %s: %s\n", ck.fn, line_no, tileid, fmt.Sprintf(format, args...))
+}
+
+// Offsets of tagVariant notes for one end of the tile
+// ('start' or 'end').
+//
+func tag_variants(tile *fastj.Tile, which string) map[int]bool {
+  offs := make(map[int]bool)
+  for _,note := range tile.Notes {
+    f := strings.Fields(note)
+    if len(f)<3 || f[0]!="tagVariant" || f[1]!=which { continue }
+    if off,e := strconv.Atoi(f[2]) ; e==nil { offs[off] = true }
+  }
+  return offs
+}
+
+func (ck *Checker) check_tag(line_no int, tile *fastj.Tile, which, tag, seq string) {
+  id := tile.TileID.String()
+  if len(tag)!=fastj.TAGLEN {
+    ck.report(line_no, id, "%sTag is %d long, expected %d", which, len(tag), fastj.TAGLEN)
+    return
+  }
+  if len(seq)!=len(tag) { return }
+
+  tv := tag_variants(tile, which)
+  diff := make([]string, 0)
+  for i:=0; i<len(tag); i++ {
+    if tag[i]==seq[i] || seq[i]=='n' || seq[i]=='N' || tv[i] { continue }
+    diff = append(diff, fmt.Sprintf("%d %c/%c", i, tag[i], seq[i]))
+  }
+  if len(diff)>0 {
+    ck.report(line_no, id, "%sTag differs from %sSeq with no tagVariant note (%s)", which, which, strings.Join(diff, ", "))
+  }
+}
+
+func (ck *Checker) check_tile(line_no int, tile *fastj.Tile) {
+  id := tile.TileID.String()
+  seq := tile.Seq
+
+  if m5 := fastj.Md5Sum(seq) ; m5!=tile.Md5Sum {
+    ck.report(line_no, id, "md5sum %s doesn't match the sequence (%s)", tile.Md5Sum, m5)
+  }
+
+  if tile.N!=len(seq) {
+    ck.report(line_no, id, "n is %d, sequence is %d long", tile.N, len(seq))
+  }
+
+  if nc := strings.Count(seq, "n") + strings.Count(seq, "N") ; nc!=tile.NocallCount {
+    ck.report(line_no, id, "nocallCount is %d, sequence has %d no-calls", tile.NocallCount, nc)
+  }
+
+  if tile.SeedTileLength<1 {
+    ck.report(line_no, id, "seedTileLength is %d", tile.SeedTileLength)
+  }
+
+  if len(seq)<fastj.TAGLEN {
+    ck.report(line_no, id, "sequence is shorter than a tag (%d)", len(seq))
+    return
+  }
+
+  if !tile.StartTile {
+    if tile.StartSeq!=seq[:fastj.TAGLEN] {
+      ck.report(line_no, id, "startSeq doesn't match the start of the sequence")
+    }
+    ck.check_tag(line_no, tile, "start", tile.StartTag, tile.StartSeq)
+  }
+
+  if !tile.EndTile {
+    if tile.EndSeq!=seq[len(seq)-fastj.TAGLEN:] {
+      ck.report(line_no, id, "endSeq doesn't match the end of the sequence")
+    }
+    ck.check_tag(line_no, tile, "end", tile.EndTag, tile.EndSeq)
+  }
+}
+
+// Check tile against the previous tile on its haplotype.
+//
+func (ck *Checker) check_adjacent(line_no int, tile *fastj.Tile) {
+  id := tile.TileID.String()
+  variant := tile.TileID.Variant
+
+  prev,ok := ck.prev[variant]
+  ck.prev[variant] = tile
+  if !ok || prev.TileID.Path!=tile.TileID.Path { return }
+
+  if next := prev.TileID.Step + prev.SeedTileLength ; next!=tile.TileID.Step {
+    ck.report(line_no, id, "step %04x doesn't follow %s (seedTileLength %d, expected step %04x)",
+      tile.TileID.Step, prev.TileID, prev.SeedTileLength, next)
+  }
+
+  if !prev.EndTile && !tile.StartTile {
+    if prev.EndTag!=tile.StartTag {
+      ck.report(line_no, id, "startTag doesn't match the endTag of %s", prev.TileID)
+    }
+    if prev.EndSeq!=tile.StartSeq {
+      ck.report(line_no, id, "startSeq doesn't match the endSeq of %s", prev.TileID)
+    }
+  }
+
+  if len(prev.Locus)==0 || len(tile.Locus)==0 { return }
+  p,e0 := prev.Locus[0].Range()
+  q,e1 := tile.Locus[0].Range()
+  if e0!=nil || e1!=nil { return }
+
+  if p.Prefix!=q.Prefix || p.Chrom!=q.Chrom { return }
+  if q.Start<=p.Start || q.End<=p.End {
+    ck.report(line_no, id, "locus '%s' doesn't come after '%s' of %s", tile.Locus[0].Build, prev.Locus[0].Build, prev.TileID)
+  }
+}
+
+func (ck *Checker) add_cover(tile *fastj.Tile) {
+  v := tile.TileID.Variant
+  if _,ok := ck.cover[v] ; !ok { ck.cover[v] = make(map[int]map[int]bool) }
+  path := tile.TileID.Path
+  if _,ok := ck.cover[v][path] ; !ok { ck.cover[v][path] = make(map[int]bool) }
+
+  for k:=0; k<tile.SeedTileLength || k==0; k++ {
+    ck.cover[v][path][tile.TileID.Step+k] = true
+  }
+}
+
+// Steps of path covered by a and not b, as ranges.
+//
+func cover_diff(a, b map[int]bool) []string {
+  steps := make([]int, 0, len(a))
+  for s := range a {
+    if !b[s] { steps = append(steps, s) }
+  }
+  sort.Ints(steps)
+
+  ranges := make([]string, 0)
+  for i:=0; i<len(steps); {
+    j := i
+    for j+1<len(steps) && steps[j+1]==steps[j]+1 { j++ }
+    if i==j {
+      ranges = append(ranges, fmt.Sprintf("%04x", steps[i]))
+    } else {
+      ranges = append(ranges, fmt.Sprintf("%04x-%04x", steps[i], steps[j]))
+    }
+    i = j+1
+  }
+  return ranges
+}
+
+func (ck *Checker) check_cover() {
+  c0,ok0 := ck.cover[0]
+  c1,ok1 := ck.cover[1]
+  if !ok0 || !ok1 { return }
+
+  paths := make([]int, 0, len(c0)+len(c1))
+  for p := range c0 { paths = append(paths, p) }
+  for p := range c1 {
+    if _,ok := c0[p] ; !ok { paths = append(paths, p) }
+  }
+  sort.Ints(paths)
+
+  for _,p := range paths {
+    if d := cover_diff(c0[p], c1[p]) ; len(d)>0 {
+      ck.report(0, fmt.Sprintf("%03x", p), "steps covered by .000 but not .001: %s", strings.Join(d, ","))
+    }
+    if d := cover_diff(c1[p], c0[p]) ; len(d)>0 {
+      ck.report(0, fmt.Sprintf("%03x", p), "steps covered by .001 but not .000: %s", strings.Join(d, ","))
+    }
+  }
+}
+
+// Check a whole file.  Returns the number of problems.
+//
+func (ck *Checker) Check() (int, error) {
+  fj,e := fastj.Open(ck.fn)
+  if e!=nil { return 0, e }
+  defer fj.Close()
+
+  n:=0
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil {
+      ck.report(fj.LineNo(), "-", "%v", e)
+      continue
+    }
+    n++
+
+    ck.check_tile(fj.LineNo(), tile)
+    ck.check_adjacent(fj.LineNo(), tile)
+    ck.add_cover(tile)
+  }
+
+  ck.check_cover()
+
+  if gVerboseFlag {
+    fmt.Fprintf(os.Stderr, "# %s: %d tiles, %d problems\n", ck.fn, n, ck.problems)
+  }
+
+  return ck.problems, nil
+}
+
+func _main( c *cli.Context ) {
+  gVerboseFlag = c.Bool("Verbose")
+
+  ifns := c.StringSlice("input")
+  if len(ifns)==0 {
+    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  out := bufio.NewWriter(os.Stdout)
+
+  total := 0
+  for i:=0; i<len(ifns); i++ {
+    ck := NewChecker(ifns[i], out, c.Int("max-report"))
+    n,e := ck.Check()
+    if e!=nil {
+      out.Flush()
+      fmt.Fprintf(os.Stderr, "%v\n", e)
+      os.Exit(2)
+    }
+    total += n
+  }
+
+  out.Flush()
+  if total>0 { os.Exit(1) }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "fjcheck"
+  app.Usage = "Check FastJ files for consistency"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringSliceFlag{
+      Name: "input, i",
+      Value: &cli.StringSlice{},
+      Usage: "INPUT FastJ (can be specified more than once)",
+    },
+
+    cli.IntFlag{
+      Name: "max-report",
+      Value: 0,
+      Usage: "Only print the first MAX-REPORT problems of each file (0 for all)",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+  }
+
+  app.Run(os.Args)
+
+}
@@ -0,0 +1,354 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Compare two FastJ files tile by tile.
+//
+// Both files are walked a path at a time (they have to be in path
+// order, as lightning writes them).  Within a path, each haplotype
+// (tile variant) of A is compared with the same haplotype of B,
+// step by step.  With -haploid-a, A is a reference with only .000
+// tiles and every haplotype of B is compared with it.
+//
+// Tiles are grouped into blocks that start and end at the same
+// steps in both files.  Each block is one of:
+//
+//   match   the same tile (md5sum) in both
+//   differ  one tile in each, same steps, different sequence
+//   nocall  one tile in each, same steps, differing only at
+//           no-calls
+//   span    different tiles covering the same steps, e.g. a
+//           spanning tile in one and two tiles in the other
+//   only-a  tiles only in A
+//   only-b  tiles only in B
+//
+// For differ and span blocks the base differences of the block
+// sequences are listed as [offset] [a]/[b], offsets from the start
+// of the block, '-' for an empty side.  Equal length sequences are
+// compared base by base, otherwise the differing middle is given
+// as a single difference.  No-call differences (one side all 'n',
+// the same length as the other) are left out of differ and span
+// blocks unless -nocall is given.  An insertion or deletion is
+// never a no-call difference.
+//
+// Text output is one line per block:
+//
+//   [type] [path.ver.step.variant] a:[step+len,...] b:[step+len,...] [differences]
+//
+// and -json gives one JSON object per line instead.  A summary
+// follows the blocks.
+//
+// example usage:
+//
+//  ./fjdiff -a grch38_chr17.fj.gz -b hu826751.fj.gz -haploid-a --no-match
+//
+
+package main
+
+import "fmt"
+import "os"
+import "io"
+import "log"
+import "bufio"
+import "sort"
+import "strings"
+
+import "encoding/json"
+
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjdiff"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+var g_show_match bool
+var g_show_nocall bool
+var g_json bool
+
+// Reads a FastJ file a path at a time.
+//
+type path_reader struct {
+  fn string
+  fj *fastj.Reader
+  next *fastj.Tile
+  eof bool
+}
+
+func open_path_reader(fn string) (*path_reader, error) {
+  fj,e := fastj.Open(fn)
+  if e!=nil { return nil, e }
+  pr := &path_reader{ fn: fn, fj: fj }
+  if e:=pr.advance() ; e!=nil { return nil, e }
+  return pr, nil
+}
+
+func (pr *path_reader) advance() error {
+  tile,e := pr.fj.Read()
+  if e==io.EOF {
+    pr.next = nil
+    pr.eof = true
+    return nil
+  }
+  if e!=nil { return fmt.Errorf("%s: %v", pr.fn, e) }
+  pr.next = tile
+  return nil
+}
+
+// All tiles of path.  The file has to be in path order.
+//
+func (pr *path_reader) read_path(path int) ([]*fastj.Tile, error) {
+  tiles := make([]*fastj.Tile, 0, 1024)
+  for !pr.eof && pr.next.TileID.Path==path {
+    tiles = append(tiles, pr.next)
+    if e:=pr.advance() ; e!=nil { return nil, e }
+  }
+  if !pr.eof && pr.next.TileID.Path<path {
+    return nil, fmt.Errorf("%s (line %d): path %03x is after path %03x, the file must be in path order", pr.fn, pr.fj.LineNo(), pr.next.TileID.Path, path)
+  }
+  return tiles, nil
+}
+
+func spans(tiles []*fastj.Tile) string {
+  if len(tiles)==0 { return "-" }
+  s := make([]string, len(tiles))
+  for i:=0; i<len(tiles); i++ {
+    s[i] = fmt.Sprintf("%04x+%d", tiles[i].TileID.Step, tiles[i].SeedTileLength)
+  }
+  return strings.Join(s, ",")
+}
+
+func seedlen(t *fastj.Tile) int {
+  if t.SeedTileLength<1 { return 1 }
+  return t.SeedTileLength
+}
+
+type Summary struct {
+  Type string `json:"type"`
+  Match int `json:"match"`
+  Differ int `json:"differ"`
+  Nocall int `json:"nocall"`
+  Span int `json:"span"`
+  OnlyA int `json:"only-a"`
+  OnlyB int `json:"only-b"`
+}
+
+var g_summary Summary
+
+func emit_block(out io.Writer, id fastj.TileID, a, b []*fastj.Tile) {
+  blk := fjdiff.Compare(id, a, b, g_show_nocall)
+
+  switch blk.Type {
+  case "only-a": g_summary.OnlyA++
+  case "only-b": g_summary.OnlyB++
+  case "match": g_summary.Match++
+  case "nocall": g_summary.Nocall++
+  case "differ": g_summary.Differ++
+  case "span": g_summary.Span++
+  }
+
+  if blk.Type=="match" && !g_show_match { return }
+
+  if g_json {
+    j,_ := json.Marshal(blk)
+    fmt.Fprintf(out, "%s\n", j)
+    return
+  }
+
+  d := make([]string, len(blk.Diff))
+  for i:=0; i<len(blk.Diff); i++ { d[i] = blk.Diff[i].String() }
+  fmt.Fprintf(out, "%s\t%s\ta:%s\tb:%s\t%s\n", blk.Type, blk.TileID, spans(a), spans(b), strings.Join(d, ";"))
+}
+
+// Compare one haplotype of a path.  Blocks are grown until
+// both sides end at the same step or one side runs out.
+//
+func diff_haplotype(out io.Writer, path, variant int, a, b map[int]*fastj.Tile) {
+  steps := make([]int, 0, len(a)+len(b))
+  for s := range a { steps = append(steps, s) }
+  for s := range b {
+    if _,ok := a[s] ; !ok { steps = append(steps, s) }
+  }
+  sort.Ints(steps)
+
+  done := -1
+  for _,s := range steps {
+    if s<done { continue }
+
+    ta := make([]*fastj.Tile, 0, 1)
+    tb := make([]*fastj.Tile, 0, 1)
+    end_a,end_b := s,s
+
+    if t,ok := a[s] ; ok { ta = append(ta, t) ; end_a = s+seedlen(t) }
+    if t,ok := b[s] ; ok { tb = append(tb, t) ; end_b = s+seedlen(t) }
+
+    for len(ta)>0 && len(tb)>0 && end_a!=end_b {
+      if end_a<end_b {
+        t,ok := a[end_a]
+        if !ok { break }
+        ta = append(ta, t)
+        end_a += seedlen(t)
+      } else {
+        t,ok := b[end_b]
+        if !ok { break }
+        tb = append(tb, t)
+        end_b += seedlen(t)
+      }
+    }
+
+    done = end_a
+    if end_b>done { done = end_b }
+
+    emit_block(out, fastj.TileID{ Path: path, Ver: 0, Step: s, Variant: variant }, ta, tb)
+  }
+}
+
+func by_variant_step(tiles []*fastj.Tile) map[int]map[int]*fastj.Tile {
+  m := make(map[int]map[int]*fastj.Tile)
+  for _,t := range tiles {
+    v := t.TileID.Variant
+    if _,ok := m[v] ; !ok { m[v] = make(map[int]*fastj.Tile) }
+    m[v][t.TileID.Step] = t
+  }
+  return m
+}
+
+func diff_path(out io.Writer, path int, a, b []*fastj.Tile, haploid_a bool) {
+  va := by_variant_step(a)
+  vb := by_variant_step(b)
+
+  variants := make([]int, 0, 2)
+  for v := range vb { variants = append(variants, v) }
+  if !haploid_a {
+    for v := range va {
+      if _,ok := vb[v] ; !ok { variants = append(variants, v) }
+    }
+  }
+  sort.Ints(variants)
+
+  for _,v := range variants {
+    ref := va[v]
+    if haploid_a { ref = va[0] }
+    diff_haplotype(out, path, v, ref, vb[v])
+  }
+}
+
+func emit_summary(out io.Writer) {
+  g_summary.Type = "summary"
+  if g_json {
+    j,_ := json.Marshal(g_summary)
+    fmt.Fprintf(out, "%s\n", j)
+    return
+  }
+  fmt.Fprintf(out, "# match %d, differ %d, nocall %d, span %d, only-a %d, only-b %d\n",
+    g_summary.Match, g_summary.Differ, g_summary.Nocall, g_summary.Span, g_summary.OnlyA, g_summary.OnlyB)
+}
+
+func _main( c *cli.Context ) {
+  gVerboseFlag = c.Bool("Verbose")
+  g_show_match = !c.Bool("no-match")
+  g_show_nocall = c.Bool("nocall")
+  g_json = c.Bool("json")
+
+  if len(c.String("a"))==0 || len(c.String("b"))==0 {
+    fmt.Fprintf( os.Stderr, "Provide two FastJ files (-a and -b)\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  ra,e := open_path_reader(c.String("a"))
+  if e!=nil { log.Fatal(e) }
+  defer ra.fj.Close()
+
+  rb,e := open_path_reader(c.String("b"))
+  if e!=nil { log.Fatal(e) }
+  defer rb.fj.Close()
+
+  out := bufio.NewWriter(os.Stdout)
+  defer out.Flush()
+
+  for !ra.eof || !rb.eof {
+    path := -1
+    if !ra.eof { path = ra.next.TileID.Path }
+    if !rb.eof && (path<0 || rb.next.TileID.Path<path) { path = rb.next.TileID.Path }
+
+    a,e := ra.read_path(path)
+    if e!=nil { log.Fatal(e) }
+    b,e := rb.read_path(path)
+    if e!=nil { log.Fatal(e) }
+
+    if gVerboseFlag { fmt.Fprintf(os.Stderr, "# path %03x: %d, %d tiles\n", path, len(a), len(b)) }
+
+    diff_path(out, path, a, b, c.Bool("haploid-a"))
+  }
+
+  emit_summary(out)
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "fjdiff"
+  app.Usage = "Compare two FastJ files tile by tile"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringFlag{
+      Name: "a",
+      Usage: "First FastJ file",
+    },
+
+    cli.StringFlag{
+      Name: "b",
+      Usage: "Second FastJ file",
+    },
+
+    cli.BoolFlag{
+      Name: "haploid-a",
+      Usage: "Compare every haplotype of B with the .000 tiles of A (e.g. A is a reference)",
+    },
+
+    cli.BoolFlag{
+      Name: "no-match",
+      Usage: "Don't list matching tiles",
+    },
+
+    cli.BoolFlag{
+      Name: "nocall",
+      Usage: "List no-call differences too",
+    },
+
+    cli.BoolFlag{
+      Name: "json",
+      Usage: "Write one JSON object per line",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+  }
+
+  app.Run(os.Args)
+
+}
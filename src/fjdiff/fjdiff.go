@@ -0,0 +1,178 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+// Package fjdiff compares blocks of tiles, consecutive tiles of
+// one haplotype of two FastJ files that start and end at the same
+// steps, and says how they differ (see the fjdiff command).
+//
+package fjdiff
+
+import "fmt"
+import "strings"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+// A difference between the block sequences.
+//
+type Diff struct {
+  Offset int `json:"offset"`
+  A string `json:"a"`
+  B string `json:"b"`
+}
+
+func (d Diff) String() string {
+  a,b := d.A,d.B
+  if len(a)==0 { a = "-" }
+  if len(b)==0 { b = "-" }
+  return fmt.Sprintf("%d %s/%s", d.Offset, a, b)
+}
+
+// True if s is all no-calls ('n').  An empty side of an
+// insertion or deletion isn't.
+//
+func IsNocall(s string) bool {
+  return len(s)>0 && strings.Trim(s, "nN")==""
+}
+
+// True if the difference is only no-calls: one side all
+// no-calls, the same length as the other.
+//
+func IsNocallDiff(d Diff) bool {
+  return len(d.A)==len(d.B) && (IsNocall(d.A) || IsNocall(d.B))
+}
+
+// Differences between a and b.  Equal length sequences are
+// compared base by base, otherwise the differing middle is
+// given as a single difference.
+//
+func SeqDiff(a, b string) []Diff {
+  diffs := make([]Diff, 0)
+
+  if len(a)==len(b) {
+    for i:=0; i<len(a); {
+      if a[i]==b[i] { i++ ; continue }
+      j := i
+      for j<len(a) && a[j]!=b[j] { j++ }
+      diffs = append(diffs, Diff{ i, a[i:j], b[i:j] })
+      i = j
+    }
+  } else {
+    p := 0
+    for p<len(a) && p<len(b) && a[p]==b[p] { p++ }
+    s := 0
+    for s<len(a)-p && s<len(b)-p && a[len(a)-1-s]==b[len(b)-1-s] { s++ }
+    diffs = append(diffs, Diff{ p, a[p:len(a)-s], b[p:len(b)-s] })
+  }
+
+  return diffs
+}
+
+// Differences other than at no-calls.
+//
+func CalledDiff(diffs []Diff) []Diff {
+  called := make([]Diff, 0, len(diffs))
+  for _,d := range diffs {
+    if IsNocallDiff(d) { continue }
+    called = append(called, d)
+  }
+  return called
+}
+
+// Sequence of consecutive tiles, tags overlapping.
+//
+func BlockSeq(tiles []*fastj.Tile) string {
+  parts := make([]string, len(tiles))
+  for i:=0; i<len(tiles); i++ {
+    if i==0 || len(tiles[i].Seq)<fastj.TAGLEN {
+      parts[i] = tiles[i].Seq
+    } else {
+      parts[i] = tiles[i].Seq[fastj.TAGLEN:]
+    }
+  }
+  return strings.Join(parts, "")
+}
+
+type BlockTile struct {
+  TileID string `json:"tileID"`
+  Md5Sum string `json:"md5sum"`
+  SeedTileLength int `json:"seedTileLength"`
+}
+
+// A block is one of:
+//
+//   match   the same tile (md5sum) in both
+//   differ  one tile in each, same steps, different sequence
+//   nocall  one tile in each, same steps, differing only at
+//           no-calls
+//   span    different tiles covering the same steps
+//   only-a  tiles only in A
+//   only-b  tiles only in B
+//
+type Block struct {
+  Type string `json:"type"`
+  TileID string `json:"tileID"`
+  A []BlockTile `json:"a"`
+  B []BlockTile `json:"b"`
+  Diff []Diff `json:"diff,omitempty"`
+}
+
+func block_tiles(tiles []*fastj.Tile) []BlockTile {
+  bt := make([]BlockTile, len(tiles))
+  for i:=0; i<len(tiles); i++ {
+    bt[i] = BlockTile{ tiles[i].TileID.String(), tiles[i].Md5Sum, tiles[i].SeedTileLength }
+  }
+  return bt
+}
+
+// Compare the tiles a and b of a block starting at id.
+// No-call differences are left out of the block's Diff
+// unless with_nocall is set.
+//
+func Compare(id fastj.TileID, a, b []*fastj.Tile, with_nocall bool) Block {
+  blk := Block{ TileID: id.String(), A: block_tiles(a), B: block_tiles(b) }
+
+  switch {
+  case len(b)==0:
+    blk.Type = "only-a"
+  case len(a)==0:
+    blk.Type = "only-b"
+  case len(a)==1 && len(b)==1 && a[0].SeedTileLength==b[0].SeedTileLength:
+    if a[0].Md5Sum==b[0].Md5Sum {
+      blk.Type = "match"
+      break
+    }
+
+    all := SeqDiff(a[0].Seq, b[0].Seq)
+    called := CalledDiff(all)
+    if len(all)>0 && len(called)==0 {
+      blk.Type = "nocall"
+    } else {
+      blk.Type = "differ"
+    }
+    blk.Diff = called
+    if with_nocall { blk.Diff = all }
+  default:
+    blk.Type = "span"
+    blk.Diff = SeqDiff(BlockSeq(a), BlockSeq(b))
+    if !with_nocall { blk.Diff = CalledDiff(blk.Diff) }
+  }
+
+  return blk
+}
@@ -0,0 +1,89 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package fjdiff
+
+import "testing"
+import "strings"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+const base_seq = "acgtacgtacgtacgtacgtacgtggccttaaggccttaaccggttacgtacgtacgtacgtacgtacgt"
+
+func tile_seq(seq string) *fastj.Tile {
+  id := fastj.TileID{ Path:0x2c5, Ver:0, Step:6, Variant:0 }
+  return &fastj.Tile{ TileID:id, SeedTileLength:1, Seq:seq, Md5Sum:fastj.Md5Sum(seq) }
+}
+
+func diff_str(diffs []Diff) string {
+  s := make([]string, len(diffs))
+  for i,d := range diffs { s[i] = d.String() }
+  return strings.Join(s, ";")
+}
+
+// An SNP and an insertion or deletion are differences, a
+// stretch of no-calls isn't unless asked for.
+//
+func TestCompare(t *testing.T) {
+  snp := base_seq[:30] + "g" + base_seq[31:]
+  del := base_seq[:26] + base_seq[36:]
+  ins := base_seq[:26] + "tttttttttt" + base_seq[26:]
+  ndel := base_seq[:26] + base_seq[28:]
+  nocall := base_seq[:26] + "nnnnnnnnnn" + base_seq[36:]
+
+  tests := []struct {
+    name, b string
+    with_nocall bool
+    typ, diff string
+  }{
+    { "match", base_seq, false, "match", "" },
+    { "snp", snp, false, "differ", "30 a/g" },
+    { "deletion", del, false, "differ", "26 ccttaaggcc/-" },
+    { "insertion", ins, false, "differ", "26 -/tttttttttt" },
+    { "2 bp deletion", ndel, false, "differ", "26 cc/-" },
+    { "nocall", nocall, false, "nocall", "" },
+    { "nocall shown", nocall, true, "nocall", "26 ccttaaggcc/nnnnnnnnnn" },
+  }
+
+  id := fastj.TileID{ Path:0x2c5, Ver:0, Step:6, Variant:0 }
+  for _,x := range tests {
+    blk := Compare(id, []*fastj.Tile{ tile_seq(base_seq) }, []*fastj.Tile{ tile_seq(x.b) }, x.with_nocall)
+    if blk.Type!=x.typ || diff_str(blk.Diff)!=x.diff {
+      t.Errorf("%s: got %s '%s', expected %s '%s'", x.name, blk.Type, diff_str(blk.Diff), x.typ, x.diff)
+    }
+  }
+}
+
+func TestIsNocall(t *testing.T) {
+  tests := []struct {
+    s string
+    want bool
+  }{
+    { "", false },
+    { "n", true },
+    { "nNn", true },
+    { "nna", false },
+  }
+  for _,x := range tests {
+    if got := IsNocall(x.s) ; got!=x.want {
+      t.Errorf("IsNocall(%q) got %v, expected %v", x.s, got, x.want)
+    }
+  }
+}
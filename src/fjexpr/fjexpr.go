@@ -0,0 +1,562 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package fjexpr is a small predicate language over FastJ
+// tile header fields, e.g.:
+//
+//   variant == 1 and nocallCount <= 10
+//   seedTileLength > 1 or not md5sum in @known_md5.txt
+//   tileID in (2c5.00.03cd..2c5.00.03e0, 2c5.00.0400..2c5.00.0410)
+//
+// Grammar:
+//
+//   expr    := and_expr { ('or' | '||') and_expr }
+//   and_expr:= not_expr { ('and' | '&&') not_expr }
+//   not_expr:= ('not' | '!') not_expr | '(' expr ')' | test
+//   test    := field op value
+//            | field 'in' '(' item { ',' item } ')'
+//            | field 'in' item
+//            | field 'in' '@' file
+//            | bool_field
+//   op      := '==' | '=' | '!=' | '<' | '<=' | '>' | '>=' | '~'
+//   item    := value | value '..' value
+//
+// Fields:
+//
+//   path, ver, step, variant        hexadecimal
+//   n, seedTileLength, nocallCount  decimal
+//   tileID                          partial tile ID (path.step,
+//                                   path.ver.step or path.ver.step.variant)
+//   md5sum, startTag, endTag,
+//   startSeq, endSeq, build         strings ('build' is the first locus)
+//   notes                           strings, true if any note matches
+//   startTile, endTile              booleans
+//
+// Ranges ('a..b') are inclusive.  For tileID the fields that
+// aren't given aren't compared, and a spanning tile is in a
+// step range if any of the steps it covers are (the same as
+// fjfilter -s and -e).  '~' is substring match on strings.
+// '@file' reads the list of values from a file, one per line.
+// Values can be quoted with double quotes.
+//
+package fjexpr
+
+import "fmt"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/autoio"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+type Expr interface {
+  Eval(t *fastj.Tile) bool
+}
+
+const (
+  kind_hex = iota
+  kind_dec
+  kind_str
+  kind_strs
+  kind_bool
+  kind_tileid
+)
+
+var field_kind = map[string]int{
+  "path": kind_hex, "ver": kind_hex, "step": kind_hex, "variant": kind_hex,
+  "n": kind_dec, "seedTileLength": kind_dec, "nocallCount": kind_dec,
+  "md5sum": kind_str, "startTag": kind_str, "endTag": kind_str,
+  "startSeq": kind_str, "endSeq": kind_str, "build": kind_str,
+  "notes": kind_strs,
+  "startTile": kind_bool, "endTile": kind_bool,
+  "tileID": kind_tileid,
+}
+
+func int_field(t *fastj.Tile, field string) int {
+  switch field {
+  case "path": return t.TileID.Path
+  case "ver": return t.TileID.Ver
+  case "step": return t.TileID.Step
+  case "variant": return t.TileID.Variant
+  case "n": return t.N
+  case "seedTileLength": return t.SeedTileLength
+  case "nocallCount": return t.NocallCount
+  }
+  return 0
+}
+
+func str_field(t *fastj.Tile, field string) []string {
+  switch field {
+  case "md5sum": return []string{ t.Md5Sum }
+  case "startTag": return []string{ t.StartTag }
+  case "endTag": return []string{ t.EndTag }
+  case "startSeq": return []string{ t.StartSeq }
+  case "endSeq": return []string{ t.EndSeq }
+  case "build":
+    if len(t.Locus)==0 { return []string{ "" } }
+    return []string{ t.Locus[0].Build }
+  case "notes": return t.Notes
+  }
+  return nil
+}
+
+// Inclusive tile ID range.  Fields that are -1 are not
+// compared.  A tile passes the step bounds if any of the
+// steps it covers (step to step+seed_tile_len-1) are
+// within them.
+//
+func InRange(tileid fastj.TileID, seed_tile_len int, beg, end fastj.TileID) bool {
+  if seed_tile_len<1 { seed_tile_len = 1 }
+
+  if (beg.Path>=0) && (tileid.Path<beg.Path) { return false }
+  if (end.Path>=0) && (tileid.Path>end.Path) { return false }
+
+  if (beg.Ver>=0) && (tileid.Ver<beg.Ver) { return false }
+  if (end.Ver>=0) && (tileid.Ver>end.Ver) { return false }
+
+  if (beg.Step>=0) && ((tileid.Step+seed_tile_len-1)<beg.Step) { return false }
+  if (end.Step>=0) && (tileid.Step>end.Step) { return false }
+
+  if (beg.Variant>=0) && (tileid.Variant<beg.Variant) { return false }
+  if (end.Variant>=0) && (tileid.Variant>end.Variant) { return false }
+
+  return true
+}
+
+//--
+// Expression nodes
+//
+
+type or_expr struct { a,b Expr }
+type and_expr struct { a,b Expr }
+type not_expr struct { a Expr }
+type true_expr struct {}
+
+func (e *or_expr) Eval(t *fastj.Tile) bool { return e.a.Eval(t) || e.b.Eval(t) }
+func (e *and_expr) Eval(t *fastj.Tile) bool { return e.a.Eval(t) && e.b.Eval(t) }
+func (e *not_expr) Eval(t *fastj.Tile) bool { return !e.a.Eval(t) }
+func (e *true_expr) Eval(t *fastj.Tile) bool { return true }
+
+type int_cmp struct {
+  field string
+  op string
+  val int
+}
+
+func (e *int_cmp) Eval(t *fastj.Tile) bool {
+  v := int_field(t, e.field)
+  switch e.op {
+  case "==": return v==e.val
+  case "!=": return v!=e.val
+  case "<": return v<e.val
+  case "<=": return v<=e.val
+  case ">": return v>e.val
+  case ">=": return v>=e.val
+  }
+  return false
+}
+
+type str_cmp struct {
+  field string
+  op string
+  val string
+}
+
+func (e *str_cmp) Eval(t *fastj.Tile) bool {
+  for _,v := range str_field(t, e.field) {
+    var r bool
+    switch e.op {
+    case "==": r = v==e.val
+    case "!=": r = v!=e.val
+    case "<": r = v<e.val
+    case "<=": r = v<=e.val
+    case ">": r = v>e.val
+    case ">=": r = v>=e.val
+    case "~": r = strings.Contains(v, e.val)
+    }
+    if r { return true }
+  }
+  return false
+}
+
+type bool_cmp struct {
+  field string
+  val bool
+}
+
+func (e *bool_cmp) Eval(t *fastj.Tile) bool {
+  v := t.StartTile
+  if e.field=="endTile" { v = t.EndTile }
+  return v==e.val
+}
+
+type int_range struct { beg,end int }
+
+type int_in struct {
+  field string
+  ranges []int_range
+}
+
+func (e *int_in) Eval(t *fastj.Tile) bool {
+  v := int_field(t, e.field)
+  for _,r := range e.ranges {
+    if v>=r.beg && v<=r.end { return true }
+  }
+  return false
+}
+
+type str_in struct {
+  field string
+  set map[string]bool
+}
+
+func (e *str_in) Eval(t *fastj.Tile) bool {
+  for _,v := range str_field(t, e.field) {
+    if e.set[v] { return true }
+  }
+  return false
+}
+
+type tileid_range struct { beg,end fastj.TileID }
+
+type tileid_in struct {
+  ranges []tileid_range
+}
+
+func (e *tileid_in) Eval(t *fastj.Tile) bool {
+  for _,r := range e.ranges {
+    if InRange(t.TileID, t.SeedTileLength, r.beg, r.end) { return true }
+  }
+  return false
+}
+
+//--
+// Tokenizer
+//
+
+type token struct {
+  s string
+  quoted bool
+}
+
+func is_word_byte(c byte) bool {
+  switch {
+  case c>='a' && c<='z', c>='A' && c<='Z', c>='0' && c<='9': return true
+  case c=='.' || c=='_' || c=='-' || c=='+' || c=='*' || c=='@' || c=='/' || c==':': return true
+  }
+  return false
+}
+
+func tokenize(s string) ([]token, error) {
+  toks := make([]token, 0, 16)
+
+  for i:=0; i<len(s); {
+    c := s[i]
+
+    switch {
+    case c==' ' || c=='\t' || c=='\n' || c=='\r':
+      i++
+
+    case c=='#':
+      for i<len(s) && s[i]!='\n' { i++ }
+
+    case c=='"':
+      j := i+1
+      for j<len(s) && s[j]!='"' { j++ }
+      if j>=len(s) { return nil, fmt.Errorf("unterminated string at %d", i) }
+      toks = append(toks, token{ s[i+1:j], true })
+      i = j+1
+
+    case c=='(' || c==')' || c==',' || c=='~':
+      toks = append(toks, token{ s[i:i+1], false })
+      i++
+
+    case c=='=' || c=='!' || c=='<' || c=='>' || c=='&' || c=='|':
+      if i+1<len(s) && (s[i+1]=='=' || (c=='&' && s[i+1]=='&') || (c=='|' && s[i+1]=='|')) {
+        toks = append(toks, token{ s[i:i+2], false })
+        i+=2
+      } else if c=='&' || c=='|' {
+        return nil, fmt.Errorf("unexpected '%c' at %d", c, i)
+      } else {
+        toks = append(toks, token{ s[i:i+1], false })
+        i++
+      }
+
+    case is_word_byte(c):
+      j := i
+      for j<len(s) && is_word_byte(s[j]) { j++ }
+      toks = append(toks, token{ s[i:j], false })
+      i = j
+
+    default:
+      return nil, fmt.Errorf("unexpected '%c' at %d", c, i)
+    }
+  }
+
+  return toks, nil
+}
+
+//--
+// Parser
+//
+
+type parser struct {
+  toks []token
+  p int
+}
+
+func (p *parser) peek() string {
+  if p.p>=len(p.toks) || p.toks[p.p].quoted { return "" }
+  return p.toks[p.p].s
+}
+
+func (p *parser) next() (token, error) {
+  if p.p>=len(p.toks) { return token{}, fmt.Errorf("unexpected end of expression") }
+  p.p++
+  return p.toks[p.p-1], nil
+}
+
+func (p *parser) expect(s string) error {
+  t,e := p.next()
+  if e!=nil { return e }
+  if t.quoted || t.s!=s { return fmt.Errorf("expected '%s', got '%s'", s, t.s) }
+  return nil
+}
+
+func (p *parser) parse_or() (Expr, error) {
+  a,e := p.parse_and()
+  if e!=nil { return nil, e }
+  for p.peek()=="or" || p.peek()=="||" {
+    p.p++
+    b,e := p.parse_and()
+    if e!=nil { return nil, e }
+    a = &or_expr{a,b}
+  }
+  return a, nil
+}
+
+func (p *parser) parse_and() (Expr, error) {
+  a,e := p.parse_not()
+  if e!=nil { return nil, e }
+  for p.peek()=="and" || p.peek()=="&&" {
+    p.p++
+    b,e := p.parse_not()
+    if e!=nil { return nil, e }
+    a = &and_expr{a,b}
+  }
+  return a, nil
+}
+
+func (p *parser) parse_not() (Expr, error) {
+  switch p.peek() {
+  case "not", "!":
+    p.p++
+    a,e := p.parse_not()
+    if e!=nil { return nil, e }
+    return &not_expr{a}, nil
+  case "(":
+    p.p++
+    a,e := p.parse_or()
+    if e!=nil { return nil, e }
+    if e:=p.expect(")") ; e!=nil { return nil, e }
+    return a, nil
+  }
+  return p.parse_test()
+}
+
+var cmp_ops = map[string]bool{ "==": true, "=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "~": true }
+
+func (p *parser) parse_test() (Expr, error) {
+  t,e := p.next()
+  if e!=nil { return nil, e }
+  field := t.s
+  kind,ok := field_kind[field]
+  if t.quoted || !ok { return nil, fmt.Errorf("unknown field '%s'", field) }
+
+  op := p.peek()
+
+  if kind==kind_bool && op!="==" && op!="=" && op!="!=" {
+    return &bool_cmp{ field, true }, nil
+  }
+
+  if op=="in" {
+    p.p++
+    return p.parse_in(field, kind)
+  }
+
+  if !cmp_ops[op] { return nil, fmt.Errorf("expected comparison after '%s'", field) }
+  p.p++
+  if op=="=" { op = "==" }
+
+  v,e := p.next()
+  if e!=nil { return nil, e }
+
+  switch kind {
+  case kind_hex, kind_dec:
+    if op=="~" { return nil, fmt.Errorf("'~' only applies to strings") }
+    x,e := parse_int(v.s, kind)
+    if e!=nil { return nil, fmt.Errorf("%s: %v", field, e) }
+    return &int_cmp{ field, op, x }, nil
+
+  case kind_str, kind_strs:
+    return &str_cmp{ field, op, v.s }, nil
+
+  case kind_bool:
+    if v.s!="true" && v.s!="false" { return nil, fmt.Errorf("%s: expected true or false, got '%s'", field, v.s) }
+    b := v.s=="true"
+    if op=="!=" { b = !b }
+    return &bool_cmp{ field, b }, nil
+
+  case kind_tileid:
+    if op!="==" && op!="!=" { return nil, fmt.Errorf("tileID only supports '==', '!=' and 'in'") }
+    id,e := fastj.ParseTileIDPartial(v.s)
+    if e!=nil { return nil, e }
+    var x Expr = &tileid_in{ []tileid_range{ tileid_range{ id, id } } }
+    if op=="!=" { x = &not_expr{x} }
+    return x, nil
+  }
+
+  return nil, fmt.Errorf("can't compare '%s'", field)
+}
+
+func parse_int(s string, kind int) (int, error) {
+  base := 10
+  if kind==kind_hex { base = 16 }
+  v,e := strconv.ParseInt(s, base, 64)
+  if e!=nil { return 0, fmt.Errorf("invalid number '%s'", s) }
+  return int(v), nil
+}
+
+// Items of an 'in' list.  A list is either in parentheses,
+// a single item or '@file'.
+//
+func (p *parser) parse_in(field string, kind int) (Expr, error) {
+  items := make([]string, 0, 8)
+
+  if p.peek()=="(" {
+    p.p++
+    for {
+      t,e := p.next()
+      if e!=nil { return nil, e }
+      items = append(items, t.s)
+      if p.peek()==")" { p.p++ ; break }
+      if e:=p.expect(",") ; e!=nil { return nil, e }
+    }
+  } else {
+    t,e := p.next()
+    if e!=nil { return nil, e }
+    if !t.quoted && strings.HasPrefix(t.s, "@") {
+      items,e = read_list(t.s[1:])
+      if e!=nil { return nil, e }
+    } else {
+      items = append(items, t.s)
+    }
+  }
+
+  switch kind {
+  case kind_hex, kind_dec:
+    x := &int_in{ field: field }
+    for _,it := range items {
+      lo,hi := split_range(it)
+      a,e := parse_int(lo, kind)
+      if e!=nil { return nil, fmt.Errorf("%s: %v", field, e) }
+      b,e := parse_int(hi, kind)
+      if e!=nil { return nil, fmt.Errorf("%s: %v", field, e) }
+      x.ranges = append(x.ranges, int_range{a,b})
+    }
+    return x, nil
+
+  case kind_str, kind_strs:
+    x := &str_in{ field: field, set: make(map[string]bool) }
+    for _,it := range items { x.set[it] = true }
+    return x, nil
+
+  case kind_tileid:
+    x := &tileid_in{}
+    for _,it := range items {
+      lo,hi := split_range(it)
+      a,e := fastj.ParseTileIDPartial(lo)
+      if e!=nil { return nil, e }
+      b,e := fastj.ParseTileIDPartial(hi)
+      if e!=nil { return nil, e }
+      x.ranges = append(x.ranges, tileid_range{a,b})
+    }
+    return x, nil
+  }
+
+  return nil, fmt.Errorf("'in' doesn't apply to '%s'", field)
+}
+
+func split_range(s string) (string, string) {
+  if k := strings.Index(s, "..") ; k>=0 { return s[:k], s[k+2:] }
+  return s, s
+}
+
+// Values from a file, one per line, ignoring blank
+// lines and '#' comments.
+//
+func read_list(fn string) ([]string, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  items := make([]string, 0, 16)
+  for h.ReadScan() {
+    l := strings.TrimSpace(h.ReadText())
+    if len(l)==0 || l[0]=='#' { continue }
+    items = append(items, l)
+  }
+  return items, nil
+}
+
+// Parse an expression.  An empty expression is
+// always true.
+//
+func Parse(s string) (Expr, error) {
+  toks,e := tokenize(s)
+  if e!=nil { return nil, e }
+  if len(toks)==0 { return &true_expr{}, nil }
+
+  p := &parser{ toks: toks }
+  x,e := p.parse_or()
+  if e!=nil { return nil, e }
+  if p.p<len(toks) { return nil, fmt.Errorf("unexpected '%s'", toks[p.p].s) }
+  return x, nil
+}
+
+// Parse an expression from a file.  '#' starts a comment.
+//
+func ParseFile(fn string) (Expr, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  lines := make([]string, 0, 8)
+  for h.ReadScan() { lines = append(lines, h.ReadText()) }
+
+  x,e := Parse(strings.Join(lines, "\n"))
+  if e!=nil { return nil, fmt.Errorf("%s: %v", fn, e) }
+  return x, nil
+}
+
+// Both a and b.
+//
+func And(a, b Expr) Expr {
+  return &and_expr{a,b}
+}
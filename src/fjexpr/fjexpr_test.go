@@ -0,0 +1,61 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package fjexpr
+
+import "testing"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+func tile_at(step, seedlen int) *fastj.Tile {
+  return &fastj.Tile{ TileID:fastj.TileID{ Path:0x2c5, Ver:0, Step:step, Variant:0 }, SeedTileLength:seedlen }
+}
+
+// A tile matches a tileID step range if any of the steps it
+// covers are in it, so a seedTileLength 1 tile just before the
+// range doesn't, but a spanning one that reaches into it does.
+//
+func TestTileIDSpanning(t *testing.T) {
+  tests := []struct {
+    expr string
+    step, seedlen int
+    want bool
+  }{
+    { "tileID == 2c5.00.03cd.000", 0x3cc, 1, false },
+    { "tileID == 2c5.00.03cd.000", 0x3cc, 2, true },
+    { "tileID == 2c5.00.03cd.000", 0x3cd, 1, true },
+    { "tileID == 2c5.00.03cd.000", 0x3cb, 2, false },
+    { "tileID == 2c5.00.03cd.000", 0x3cd, 0, true },
+    { "tileID != 2c5.00.03cd.000", 0x3cc, 1, true },
+    { "tileID in (2c5.00.03cd..2c5.00.03e0)", 0x3cc, 1, false },
+    { "tileID in (2c5.00.03cd..2c5.00.03e0)", 0x3cc, 2, true },
+    { "tileID in (2c5.00.03cd..2c5.00.03e0)", 0x3e0, 1, true },
+    { "tileID in (2c5.00.03cd..2c5.00.03e0)", 0x3e1, 1, false },
+    { "tileID in 2c5.03cd..2c5.03e0", 0x3ca, 3, false },
+    { "tileID in 2c5.03cd..2c5.03e0", 0x3ca, 4, true },
+  }
+
+  for _,x := range tests {
+    expr,e := Parse(x.expr)
+    if e!=nil { t.Fatalf("%s: %v", x.expr, e) }
+    if got := expr.Eval(tile_at(x.step, x.seedlen)) ; got!=x.want {
+      t.Errorf("%s: step %x seedTileLength %d got %v, expected %v", x.expr, x.step, x.seedlen, got, x.want)
+    }
+  }
+}
@@ -17,143 +17,284 @@
 
 */
 
+// Filter a FastJ file, keeping only the tiles that pass.
+//
+// Tiles can be selected by tile ID range (-s/-e, inclusive), by an
+// expression over the header fields (-where, -where-file, see the
+// fjexpr package), and by genomic region (-region
+// chr17:43044294-43125482 or grch38:chr17:43044294-43125482,
+// 1-based and inclusive as in samtools, or -bed with 0-based
+// half-open BED regions).  -region and -bed can be given more than
+// once and a tile is kept if it overlaps any of the regions, so
+// tiles that cross a region boundary are kept too.
+//
+// If the input has an index (see fjindex), reading starts at -s
+// and stops after -e instead of going through the whole file.
+//
+// Regions are matched against the locus build field of each tile
+// ('hg19 chr17 41175997 41176246').  Sample tiles have the loci of
+// the assembly they were called against, which needn't be the one
+// the regions are in, so with -ref-fastj the extent of each tile is
+// instead taken from the reference tiles at the same path.step
+// positions.  A region's assembly (from the region or -assembly,
+// which also applies to -bed) has to match the first word of the
+// locus, hg19/grch37 and hg38/grch38 being taken as the same.
+// Without one only the chromosome is compared, and if nothing is
+// kept the assemblies of the tile loci are reported.
+//
+// All of the filters given have to pass.
+//
+// example usage:
+//
+//  ./fjfilter -i hu826751.fj.gz -ref-fastj grch38_chr17.fj.gz -region chr17:43044294-43125482
+//  ./fjfilter -i hu826751.fj.gz -where 'variant == 1 and nocallCount <= 10'
+//
+
 package main
 
 import "fmt"
 import "os"
-
-import "log"
-
+import "io"
+import "bufio"
+import "sort"
 import "strings"
 import "strconv"
 
 import "github.com/abeconnelly/autoio"
-import "github.com/abeconnelly/sloppyjson"
+
+import "log"
+
 import "github.com/codegangsta/cli"
 
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjexpr"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjidx"
+
 
 var VERSION_STR string = "0.1.0, AGPLv3.0"
 
 var g_verboseFlag bool
 
-var g_beg_path int64
-var g_beg_ver int64
-var g_beg_step int64
-var g_beg_variant int64
+// Inclusive filter bounds.  Fields that
+// are -1 are not filtered on.
+//
+var g_beg fastj.TileID
+var g_end fastj.TileID
 
-var g_end_path int64
-var g_end_ver int64
-var g_end_step int64
-var g_end_variant int64
+// -where and -where-file, ANDed together.
+//
+var g_where fjexpr.Expr
 
 func init() {
+  g_beg = fastj.TileID{ Path: -1, Ver: -1, Step: -1, Variant: -1 }
+  g_end = fastj.TileID{ Path: -1, Ver: -1, Step: -1, Variant: -1 }
+}
 
-  g_beg_path    = -1
-  g_beg_ver     = -1
-  g_beg_step    = -1
-  g_beg_variant = -1
-
-  g_end_path    = -1
-  g_end_ver     = -1
-  g_end_step    = -1
-  g_end_variant = -1
+// Parse a filter bound of the form path.step,
+// path.ver.step or path.ver.step.variant.
+//
+func parse_filter(s string) (fastj.TileID, error) {
+  return fastj.ParseTileIDPartial(s)
+}
 
+// A region to keep, 0-based and inclusive.  Assembly
+// is empty if it wasn't given.
+//
+type Region struct {
+  Assembly string
+  Chrom string
+  Start int
+  End int
 }
 
-func parse_filter(s string, path, ver, step, variant *int64) {
-  parts := strings.Split(s, ".")
-  if (len(parts)<2) || (len(parts)>4) {
-    return
+var g_regions []Region
+
+// Assemblies of the tile extents seen, those that
+// didn't match the assembly of a region on the same
+// chromosome and the number of tiles kept, to say why
+// if no tiles are kept.
+//
+var g_seen_assembly map[string]bool
+var g_mismatch_assembly map[string]bool
+var g_kept int
+
+// Reference extent of each path.step, from -ref-fastj.
+//
+var g_ref_extent map[fastj.TilePos]fastj.LocusRange
+
+// Assembly names compared case insensitively, with the
+// UCSC names of GRCh37 and GRCh38 taken as the same.
+//
+func assembly_name(s string) string {
+  s = strings.ToLower(s)
+  switch s {
+  case "hg19": return "grch37"
+  case "hg38": return "grch38"
   }
+  return s
+}
 
-  if len(parts)==2 {
-    path_str := parts[0]
-    step_str := parts[1]
-
-    p,e := strconv.ParseInt(path_str, 16, 32)
-    if e!=nil { return }
-    s,e := strconv.ParseInt(step_str, 16, 32)
-    if e!=nil { return }
-
-    *path = p
-    *step = s
-    return
+// Parse [assembly:]chrom:start-end (1-based, inclusive).
+// A bare [assembly:]chrom is the whole chromosome.
+//
+func parse_region(s string) (Region, error) {
+  fields := strings.Split(s, ":")
+  last := fields[len(fields)-1]
+  is_range := len(fields)>1 && len(last)>0 && last[0]>='0' && last[0]<='9'
+
+  if !is_range { fields = append(fields, "") }
+  if len(fields)>3 { return Region{}, fmt.Errorf("invalid region '%s' (expected [assembly:]chrom:start-end)", s) }
+
+  r := Region{ Chrom: fields[len(fields)-2] }
+  if len(fields)==3 { r.Assembly = fields[0] }
+  if !is_range {
+    r.Start = 0
+    r.End = int(^uint(0)>>1)
+    return r, nil
   }
 
-  if len(parts)==3 {
-    path_str := parts[0]
-    ver_str := parts[1]
-    step_str := parts[2]
-
-    p,e := strconv.ParseInt(path_str, 16, 32)
-    if e!=nil { return }
-    v,e := strconv.ParseInt(ver_str, 16, 32)
-    if e!=nil { return }
-    s,e := strconv.ParseInt(step_str, 16, 32)
-    if e!=nil { return }
+  rng := strings.Replace(last, ",", "", -1)
+  parts := strings.Split(rng, "-")
+  if len(parts)!=2 { return Region{}, fmt.Errorf("invalid region '%s' (expected [assembly:]chrom:start-end)", s) }
 
-    *path = p
-    *ver = v
-    *step = s
+  beg,e := strconv.Atoi(parts[0])
+  if e!=nil || beg<1 { return Region{}, fmt.Errorf("invalid region start in '%s'", s) }
+  end,e := strconv.Atoi(parts[1])
+  if e!=nil || end<beg { return Region{}, fmt.Errorf("invalid region end in '%s'", s) }
 
-    return
-  }
+  r.Start = beg-1
+  r.End = end-1
+  return r, nil
+}
 
-  if len(parts)==4 {
-    path_str := parts[0]
-    ver_str := parts[1]
-    step_str := parts[2]
-    variant_str := parts[3]
+// Read the regions of a BED file (0-based, half-open).
+//
+func load_bed(fn string) ([]Region, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
 
+  regions := make([]Region, 0, 8)
+  line_no:=0
+  for h.ReadScan() {
+    line_no++
+    l := strings.TrimSpace(h.ReadText())
+    if len(l)==0 || l[0]=='#' || strings.HasPrefix(l, "track") || strings.HasPrefix(l, "browser") { continue }
 
-    p,e := strconv.ParseInt(path_str, 16, 32)
-    if e!=nil { return }
-    v,e := strconv.ParseInt(ver_str, 16, 32)
-    if e!=nil { return }
-    s,e := strconv.ParseInt(step_str, 16, 32)
-    if e!=nil { return }
-    va,e := strconv.ParseInt(variant_str, 16, 32)
-    if e!=nil { return }
+    fields := strings.Fields(l)
+    if len(fields)<3 { return nil, fmt.Errorf("%s: bad read on line %d", fn, line_no) }
 
-    *path = p
-    *ver = v
-    *step = s
-    *variant = va
+    beg,e := strconv.Atoi(fields[1])
+    if e!=nil { return nil, fmt.Errorf("%s: bad start on line %d", fn, line_no) }
+    end,e := strconv.Atoi(fields[2])
+    if e!=nil || end<=beg { return nil, fmt.Errorf("%s: bad end on line %d", fn, line_no) }
 
-    return
+    regions = append(regions, Region{ Chrom: fields[0], Start: beg, End: end-1 })
   }
+
+  return regions, nil
 }
 
-func pass_filter(tileid string, seed_tile_len int) bool {
-  parts := strings.Split(tileid, ".")
-  if len(parts)!=4 { return false }
+// Read the extent of every path.step from a reference FastJ.  A
+// spanning tile gives its extent to all of the steps it covers.
+//
+func load_ref_extent(fn string) (map[fastj.TilePos]fastj.LocusRange, error) {
+  fj,e := fastj.Open(fn)
+  if e!=nil { return nil, e }
+  defer fj.Close()
+
+  extent := make(map[fastj.TilePos]fastj.LocusRange)
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { return nil, e }
+    if len(tile.Locus)==0 { continue }
+
+    r,e := tile.Locus[0].Range()
+    if e!=nil { return nil, fmt.Errorf("%s: %s: %v", fn, tile.TileID, e) }
+
+    for k:=0; k<tile.SeedTileLength || k==0; k++ {
+      pos := fastj.TilePos{ Path: tile.TileID.Path, Step: tile.TileID.Step+k }
+      if x,ok := extent[pos] ; ok {
+        if x.Start<r.Start { r.Start = x.Start }
+        if x.End>r.End { r.End = x.End }
+      }
+      extent[pos] = r
+    }
+  }
 
-  path,e := strconv.ParseInt(parts[0], 16, 32)
-  if e!=nil { return false }
+  return extent, nil
+}
 
-  ver,e := strconv.ParseInt(parts[1], 16, 32)
-  if e!=nil { return false }
+// Extent of a tile, from the reference if there is one,
+// otherwise from its own locus.
+//
+func tile_extent(tile *fastj.Tile) (fastj.LocusRange, bool) {
+  if g_ref_extent==nil {
+    if len(tile.Locus)==0 { return fastj.LocusRange{}, false }
+    r,e := tile.Locus[0].Range()
+    if e!=nil { return fastj.LocusRange{}, false }
+    return r, true
+  }
 
-  step,e := strconv.ParseInt(parts[2], 16, 32)
-  if e!=nil { return false }
+  var r fastj.LocusRange
+  found := false
+  for k:=0; k<tile.SeedTileLength || k==0; k++ {
+    x,ok := g_ref_extent[fastj.TilePos{ Path: tile.TileID.Path, Step: tile.TileID.Step+k }]
+    if !ok { continue }
+    if !found {
+      r = x
+      found = true
+      continue
+    }
+    if x.Start<r.Start { r.Start = x.Start }
+    if x.End>r.End { r.End = x.End }
+  }
 
-  variant,e := strconv.ParseInt(parts[3], 16, 32)
-  if e!=nil { return false }
+  return r, found
+}
 
-  if (g_beg_path>=0) && (path<g_beg_path) { return false }
-  if (g_end_path>=0) && (path>g_end_path) { return false }
+func pass_region(tile *fastj.Tile) bool {
+  if len(g_regions)==0 { return true }
 
-  if (g_beg_ver>=0) && (ver<g_beg_ver) { return false }
-  if (g_end_ver>=0) && (ver>g_end_ver) { return false }
+  r,ok := tile_extent(tile)
+  if !ok {
+    if g_verboseFlag { fmt.Fprintf(os.Stderr, "# %s: no locus, skipping\n", tile.TileID) }
+    return false
+  }
 
-  if (g_beg_step>=0) && ((step+int64(seed_tile_len))<g_beg_step) { return false }
-  if (g_end_step>=0) && (step>g_end_step) { return false }
+  g_seen_assembly[r.Prefix] = true
 
-  if (g_beg_variant>=0) && (variant<g_beg_variant) { return false }
-  if (g_end_variant>=0) && (variant>g_end_variant) { return false }
+  for i:=0; i<len(g_regions); i++ {
+    if r.Chrom!=g_regions[i].Chrom { continue }
+    if len(g_regions[i].Assembly)>0 && assembly_name(r.Prefix)!=assembly_name(g_regions[i].Assembly) {
+      if !g_mismatch_assembly[r.Prefix] {
+        fmt.Fprintf(os.Stderr, "warning: %s has a %s locus, not %s, so isn't matched against %s:%s (see -ref-fastj)\n",
+          tile.TileID, r.Prefix, g_regions[i].Assembly, g_regions[i].Assembly, g_regions[i].Chrom)
+        g_mismatch_assembly[r.Prefix] = true
+      }
+      continue
+    }
+    if r.Start<=g_regions[i].End && r.End>=g_regions[i].Start { return true }
+  }
+  return false
+}
 
-  return true
+// If regions were given but no tiles kept, say which
+// assemblies the tile loci were in.
+//
+func report_regions() {
+  if len(g_regions)==0 || g_kept>0 || len(g_seen_assembly)==0 { return }
 
+  names := make([]string, 0, len(g_seen_assembly))
+  for a := range g_seen_assembly {
+    if len(a)==0 { a = "(none)" }
+    names = append(names, a)
+  }
+  sort.Strings(names)
+
+  fmt.Fprintf(os.Stderr, "warning: no tiles overlap the regions, the tile loci are in %s (give the assembly of the regions, or the reference with -ref-fastj)\n",
+    strings.Join(names, ", "))
 }
 
 func _main( c *cli.Context ) {
@@ -163,11 +304,52 @@ func _main( c *cli.Context ) {
   end_str := c.String("end")
 
   if len(beg_str)>0 {
-    parse_filter(beg_str, &g_beg_path, &g_beg_ver, &g_beg_step, &g_beg_variant)
+    z,e := parse_filter(beg_str)
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid start filter: %v\n", e) ; os.Exit(1) }
+    g_beg = z
   }
 
   if len(end_str)>0 {
-    parse_filter(end_str, &g_end_path, &g_end_ver, &g_end_step, &g_end_variant)
+    z,e := parse_filter(end_str)
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid end filter: %v\n", e) ; os.Exit(1) }
+    g_end = z
+  }
+
+  g_where,_ = fjexpr.Parse("")
+  if len(c.String("where"))>0 {
+    x,e := fjexpr.Parse(c.String("where"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid -where: %v\n", e) ; os.Exit(1) }
+    g_where = fjexpr.And(g_where, x)
+  }
+
+  if len(c.String("where-file"))>0 {
+    x,e := fjexpr.ParseFile(c.String("where-file"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid -where-file: %v\n", e) ; os.Exit(1) }
+    g_where = fjexpr.And(g_where, x)
+  }
+
+  for _,rs := range c.StringSlice("region") {
+    r,e := parse_region(rs)
+    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; os.Exit(1) }
+    g_regions = append(g_regions, r)
+  }
+
+  for _,fn := range c.StringSlice("bed") {
+    r,e := load_bed(fn)
+    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; os.Exit(1) }
+    g_regions = append(g_regions, r...)
+  }
+
+  for i:=0; i<len(g_regions); i++ {
+    if len(g_regions[i].Assembly)==0 { g_regions[i].Assembly = c.String("assembly") }
+  }
+  g_seen_assembly = make(map[string]bool)
+  g_mismatch_assembly = make(map[string]bool)
+
+  if len(c.String("ref-fastj"))>0 {
+    x,e := load_ref_extent(c.String("ref-fastj"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; os.Exit(1) }
+    g_ref_extent = x
   }
 
   if len( c.String("input-fastj")) == 0 {
@@ -176,73 +358,33 @@ func _main( c *cli.Context ) {
     os.Exit(1)
   }
 
-  scanner,err := autoio.OpenReadScannerSimple( c.String("input-fastj") )
+  // The tile ID range is applied by the reader, which uses
+  // the index (see fjindex) to skip to it if there is one.
+  //
+  fj,err := fjidx.OpenRange( c.String("input-fastj"), g_beg, g_end )
   if err != nil {
     fmt.Fprintf( os.Stderr, "%v", err )
     os.Exit(1)
   }
-  defer scanner.Close()
+  defer fj.Close()
 
-  h_line := ""
-  fold_width := 50
+  out := bufio.NewWriter(os.Stdout)
+  defer out.Flush()
 
-  first_pass := true
+  fj_out := fastj.NewWriter(out)
 
-  line_no:=0
-  seq := make( []byte, 300 )
-
-  var prev_tileid string
-  var prev_seed_tile_len int
-
-  for scanner.ReadScan() {
-    line_no++
-
-    l := scanner.ReadText()
-    if len(l)==0 { continue }
-    if l[0]=='>' {
-
-      sj,e := sloppyjson.Loads(l[1:])
-      if e!=nil { log.Fatal(e) }
-
-      tileid := sj.O["tileID"].S
-      seed_tile_len := int(sj.O["seedTileLength"].P)
-
-      if !first_pass {
-        if pass_filter(prev_tileid, prev_seed_tile_len) {
-          fmt.Printf("%s\n", h_line)
-          p:=0
-          for ; p<(len(seq)-fold_width); p+=fold_width {
-            fmt.Printf("%s\n", seq[p:p+fold_width])
-          }
-          if p<len(seq) { fmt.Printf("%s\n", seq[p:]) }
-          fmt.Printf("\n")
-        }
-      }
-
-      first_pass = false
-
-      h_line = l
-      seq = seq[0:0]
-      prev_tileid=tileid
-      prev_seed_tile_len = seed_tile_len
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { log.Fatal(e) }
 
-      continue
+    if g_where.Eval(tile) && pass_region(tile) {
+      fj_out.Write(tile)
+      g_kept++
     }
-
-    seq = append(seq, []byte(l)... )
   }
 
-  if !first_pass {
-    if pass_filter(prev_tileid, prev_seed_tile_len) {
-      fmt.Printf("%s\n", h_line)
-      p:=0
-      for ; p<(len(seq)-fold_width); p+=fold_width {
-        fmt.Printf("%s\n", seq[p:p+fold_width])
-      }
-      if p<len(seq) { fmt.Printf("%s\n", seq[p:]) }
-      fmt.Printf("\n")
-    }
-  }
+  report_regions()
 
 }
 
@@ -273,6 +415,38 @@ func main() {
       Usage: "end filter (inclusive)",
     },
 
+    cli.StringFlag{
+      Name: "where, w",
+      Usage: "keep tiles matching the expression (e.g. 'variant == 1 and nocallCount <= 10')",
+    },
+
+    cli.StringFlag{
+      Name: "where-file",
+      Usage: "keep tiles matching the expression in a file",
+    },
+
+    cli.StringSliceFlag{
+      Name: "region, r",
+      Value: &cli.StringSlice{},
+      Usage: "keep tiles overlapping [assembly:]chrom:start-end (1-based, inclusive, can be specified more than once)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "bed",
+      Value: &cli.StringSlice{},
+      Usage: "keep tiles overlapping the regions in a BED file (can be specified more than once)",
+    },
+
+    cli.StringFlag{
+      Name: "assembly",
+      Usage: "ASSEMBLY of the -region and -bed regions that don't give one (e.g. grch38), compared to the first word of the tile loci",
+    },
+
+    cli.StringFlag{
+      Name: "ref-fastj",
+      Usage: "reference FastJ to take tile positions from (for samples without a reference locus)",
+    },
+
     cli.BoolFlag{
       Name: "Verbose, V",
       Usage: "Verbose flag",
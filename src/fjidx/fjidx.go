@@ -0,0 +1,471 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package fjidx is a random access index for FastJ files in
+// path.step order, kept next to the file as <file>.fji:
+//
+//   #fji  1  [kind]  [max seedTileLength]  [stride]  [max disorder]
+//   [path.step]  [offset]  [line number]
+//   ...
+//
+// (tab separated).  Steps are grouped stride at a time and there is
+// an entry for each group, giving the earliest tile in the file at
+// or after the group's first step.  Reading from the offset of the
+// entry finds every tile at or after the entry's path.step.
+//
+// Tiles don't have to be strictly in step order: lightning writes
+// a haplotype's spanning tile after the other haplotype's next
+// step, for example.  Paths have to be in order and the most a
+// step comes after a later step in the same path is the max
+// disorder.
+//
+// kind says what offset is:
+//
+//   plain   byte offset in an uncompressed file
+//   bgzf    BGZF virtual offset, compressed block offset << 16 |
+//           offset in the block, as in .tbi/.bai (files made
+//           with bgzip)
+//   gzip    offset in the uncompressed stream.  Ordinary gzip
+//           can't be seeked so everything before is decompressed
+//           and thrown away, which still skips the parsing.
+//
+// To read a range, start at the last entry at or before the first
+// step less the longest seedTileLength (so tiles spanning into the
+// range are found) and stop at the first tile more than the max
+// disorder past the end.
+//
+package fjidx
+
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "bufio"
+import "sort"
+import "strings"
+import "strconv"
+import "compress/gzip"
+import "encoding/binary"
+
+import "github.com/abeconnelly/autoio"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjexpr"
+
+const VERSION = 1
+
+const (
+  KIND_PLAIN = "plain"
+  KIND_BGZF = "bgzf"
+  KIND_GZIP = "gzip"
+)
+
+type Entry struct {
+  Pos fastj.TilePos
+  Offset int64
+  LineNo int
+}
+
+type Index struct {
+  Kind string
+  MaxSeedTileLength int
+  Stride int
+  MaxDisorder int
+  Entries []Entry
+}
+
+// File name of the index for fn.
+//
+func IndexName(fn string) string {
+  return fn + ".fji"
+}
+
+//--
+// BGZF
+//
+
+type bgzf_block struct {
+  coff int64
+  ustart int64
+  usize int64
+}
+
+// Size of the BGZF block whose header is hdr, or -1 if it
+// isn't a BGZF block.
+//
+func bgzf_block_size(hdr []byte, f io.Reader) (int64, error) {
+  if len(hdr)<12 || hdr[0]!=31 || hdr[1]!=139 || hdr[2]!=8 || (hdr[3]&4)==0 { return -1, nil }
+
+  xlen := int(binary.LittleEndian.Uint16(hdr[10:12]))
+  extra := make([]byte, xlen)
+  if _,e := io.ReadFull(f, extra) ; e!=nil { return -1, e }
+
+  for p:=0; p+4<=xlen; {
+    slen := int(binary.LittleEndian.Uint16(extra[p+2:p+4]))
+    if extra[p]==66 && extra[p+1]==67 && slen==2 && p+6<=xlen {
+      return int64(binary.LittleEndian.Uint16(extra[p+4:p+6]))+1, nil
+    }
+    p += 4+slen
+  }
+
+  return -1, nil
+}
+
+func file_kind(fn string) (string, error) {
+  f,e := os.Open(fn)
+  if e!=nil { return "", e }
+  defer f.Close()
+
+  hdr := make([]byte, 12)
+  n,_ := io.ReadFull(f, hdr)
+  if n<2 || hdr[0]!=31 || hdr[1]!=139 { return KIND_PLAIN, nil }
+
+  bsize,e := bgzf_block_size(hdr[:n], f)
+  if e!=nil { return "", e }
+  if bsize>0 { return KIND_BGZF, nil }
+  return KIND_GZIP, nil
+}
+
+// Offsets and uncompressed sizes of every block.  Only the
+// headers and trailers are read.
+//
+func bgzf_blocks(fn string) ([]bgzf_block, error) {
+  f,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  defer f.Close()
+
+  blocks := make([]bgzf_block, 0, 1024)
+  hdr := make([]byte, 12)
+  isize := make([]byte, 4)
+  coff,ustart := int64(0),int64(0)
+
+  for {
+    if _,e := f.Seek(coff, 0) ; e!=nil { return nil, e }
+    n,e := io.ReadFull(f, hdr)
+    if n==0 && e==io.EOF { break }
+    if e!=nil { return nil, fmt.Errorf("%s: truncated BGZF block at %d", fn, coff) }
+
+    bsize,e := bgzf_block_size(hdr, f)
+    if e!=nil { return nil, e }
+    if bsize<0 { return nil, fmt.Errorf("%s: not a BGZF block at %d", fn, coff) }
+
+    if _,e := f.Seek(coff+bsize-4, 0) ; e!=nil { return nil, e }
+    if _,e := io.ReadFull(f, isize) ; e!=nil { return nil, fmt.Errorf("%s: truncated BGZF block at %d", fn, coff) }
+    usize := int64(binary.LittleEndian.Uint32(isize))
+
+    blocks = append(blocks, bgzf_block{ coff, ustart, usize })
+    coff += bsize
+    ustart += usize
+  }
+
+  return blocks, nil
+}
+
+func bgzf_voffset(blocks []bgzf_block, u int64) int64 {
+  k := sort.Search(len(blocks), func(i int) bool { return blocks[i].ustart+blocks[i].usize > u })
+  if k==len(blocks) { return 0 }
+  return (blocks[k].coff<<16) | (u-blocks[k].ustart)
+}
+
+//--
+// Building
+//
+
+// Build the index of a FastJ file in path.step order, with an
+// entry every stride steps.  Returns an error if the paths are
+// out of order.
+//
+func Build(fn string, stride int) (*Index, error) {
+  if stride<1 { stride = 1 }
+
+  kind,e := file_kind(fn)
+  if e!=nil { return nil, e }
+
+  var blocks []bgzf_block
+  if kind==KIND_BGZF {
+    blocks,e = bgzf_blocks(fn)
+    if e!=nil { return nil, e }
+  }
+
+  f,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  defer f.Close()
+
+  var r io.Reader = f
+  if kind!=KIND_PLAIN {
+    gz,e := gzip.NewReader(f)
+    if e!=nil { return nil, e }
+    defer gz.Close()
+    r = gz
+  }
+  br := bufio.NewReaderSize(r, 1024*1024)
+
+  idx := &Index{ Kind: kind, Stride: stride }
+
+  // Earliest tile of each group of steps.
+  //
+  group_first := make(map[fastj.TilePos]Entry)
+  groups := make([]fastj.TilePos, 0, 1024)
+
+  cur_path,max_step := -1,-1
+
+  u := int64(0)
+  line_no := 0
+  for {
+    l,e := br.ReadSlice('\n')
+    if e==bufio.ErrBufferFull { return nil, fmt.Errorf("%s: line %d is too long", fn, line_no+1) }
+    if len(l)==0 && e==io.EOF { break }
+    if e!=nil && e!=io.EOF { return nil, e }
+
+    line_no++
+    line_start := u
+    u += int64(len(l))
+
+    if l[0]=='>' {
+      tile,e := fastj.ParseHeader(strings.TrimSpace(string(l)))
+      if e!=nil { return nil, fmt.Errorf("%s: line %d: %v", fn, line_no, e) }
+
+      pos := tile.TileID.Pos()
+      if pos.Path<cur_path {
+        return nil, fmt.Errorf("%s: line %d: path %03x is after path %03x, the file must be in path.step order", fn, line_no, cur_path, pos.Path)
+      }
+      if pos.Path>cur_path { cur_path,max_step = pos.Path,-1 }
+
+      if pos.Step>max_step { max_step = pos.Step }
+      if max_step-pos.Step > idx.MaxDisorder { idx.MaxDisorder = max_step-pos.Step }
+
+      if tile.SeedTileLength > idx.MaxSeedTileLength { idx.MaxSeedTileLength = tile.SeedTileLength }
+
+      group := fastj.TilePos{ Path: pos.Path, Step: (pos.Step/stride)*stride }
+      if _,ok := group_first[group] ; !ok {
+        off := line_start
+        if kind==KIND_BGZF { off = bgzf_voffset(blocks, line_start) }
+        group_first[group] = Entry{ group, off, line_no }
+        groups = append(groups, group)
+      }
+    }
+
+    if e==io.EOF { break }
+  }
+
+  // Tiles of a later group can come before a group's first
+  // tile, so each entry is the earliest of its group and all
+  // of the groups after it.
+  //
+  sort.Sort(fastj.TilePosOrder(groups))
+  idx.Entries = make([]Entry, len(groups))
+  for i:=len(groups)-1; i>=0; i-- {
+    ent := group_first[groups[i]]
+    if i+1<len(groups) && idx.Entries[i+1].LineNo < ent.LineNo {
+      ent.Offset,ent.LineNo = idx.Entries[i+1].Offset,idx.Entries[i+1].LineNo
+    }
+    idx.Entries[i] = ent
+  }
+
+  return idx, nil
+}
+
+func (idx *Index) Write(w io.Writer) error {
+  if _,e := fmt.Fprintf(w, "#fji\t%d\t%s\t%d\t%d\t%d\n", VERSION, idx.Kind, idx.MaxSeedTileLength, idx.Stride, idx.MaxDisorder) ; e!=nil { return e }
+  for i:=0; i<len(idx.Entries); i++ {
+    ent := idx.Entries[i]
+    if _,e := fmt.Fprintf(w, "%s\t%d\t%d\n", ent.Pos, ent.Offset, ent.LineNo) ; e!=nil { return e }
+  }
+  return nil
+}
+
+// Load the index of fn.  Returns nil with no error if there
+// is no index.  An index older than the file is an error.
+//
+func Load(fn string) (*Index, error) {
+  if fn=="-" { return nil, nil }
+
+  ifn := IndexName(fn)
+  ist,e := os.Stat(ifn)
+  if e!=nil { return nil, nil }
+  fst,e := os.Stat(fn)
+  if e!=nil { return nil, e }
+  if ist.ModTime().Before(fst.ModTime()) { return nil, fmt.Errorf("%s is older than %s, rebuild it with fjindex", ifn, fn) }
+
+  h,e := autoio.OpenReadScannerSimple(ifn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  idx := &Index{ Entries: make([]Entry, 0, 1024) }
+
+  line_no:=0
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+    fields := strings.Split(l, "\t")
+
+    if line_no==1 {
+      if len(fields)!=6 || fields[0]!="#fji" { return nil, fmt.Errorf("%s: not a FastJ index", ifn) }
+      if fields[1]!=fmt.Sprintf("%d", VERSION) { return nil, fmt.Errorf("%s: unsupported index version %s", ifn, fields[1]) }
+      idx.Kind = fields[2]
+      if idx.MaxSeedTileLength,e = strconv.Atoi(fields[3]) ; e!=nil { return nil, fmt.Errorf("%s: bad header", ifn) }
+      if idx.Stride,e = strconv.Atoi(fields[4]) ; e!=nil { return nil, fmt.Errorf("%s: bad header", ifn) }
+      if idx.MaxDisorder,e = strconv.Atoi(fields[5]) ; e!=nil { return nil, fmt.Errorf("%s: bad header", ifn) }
+      continue
+    }
+
+    if len(fields)!=3 { return nil, fmt.Errorf("%s: bad read on line %d", ifn, line_no) }
+
+    var ent Entry
+    if ent.Pos,e = fastj.ParseTilePos(fields[0]) ; e!=nil { return nil, fmt.Errorf("%s: line %d: %v", ifn, line_no, e) }
+    if ent.Offset,e = strconv.ParseInt(fields[1], 10, 64) ; e!=nil { return nil, fmt.Errorf("%s: bad offset on line %d", ifn, line_no) }
+    if ent.LineNo,e = strconv.Atoi(fields[2]) ; e!=nil { return nil, fmt.Errorf("%s: bad line number on line %d", ifn, line_no) }
+    idx.Entries = append(idx.Entries, ent)
+  }
+
+  if line_no==0 { return nil, fmt.Errorf("%s: empty index", ifn) }
+
+  return idx, nil
+}
+
+// Where to start reading to get every tile in range of beg,
+// the last entry at or before beg less the longest seed tile
+// length, or the start of the file.
+//
+func (idx *Index) Find(beg fastj.TileID) Entry {
+  start := Entry{ Offset: 0, LineNo: 1 }
+  if beg.Path<0 { return start }
+
+  step := beg.Step
+  if step<0 { step = 0 }
+  target := fastj.TilePos{ Path: beg.Path, Step: step - idx.MaxSeedTileLength }
+
+  k := sort.Search(len(idx.Entries), func(i int) bool { return target.Less(idx.Entries[i].Pos) })
+  if k==0 { return start }
+  return idx.Entries[k-1]
+}
+
+//--
+// Reading
+//
+
+type read_closer struct {
+  f *os.File
+  gz *gzip.Reader
+}
+
+func (rc *read_closer) Close() error {
+  if rc.gz!=nil { rc.gz.Close() }
+  return rc.f.Close()
+}
+
+// Open fn for reading from an index entry.
+//
+func (idx *Index) OpenAt(fn string, ent Entry) (*fastj.Reader, error) {
+  f,e := os.Open(fn)
+  if e!=nil { return nil, e }
+  rc := &read_closer{ f: f }
+
+  var r io.Reader = f
+
+  switch idx.Kind {
+  case KIND_PLAIN:
+    _,e = f.Seek(ent.Offset, 0)
+
+  case KIND_BGZF:
+    if _,e = f.Seek(ent.Offset>>16, 0) ; e!=nil { break }
+    if rc.gz,e = gzip.NewReader(f) ; e!=nil { break }
+    r = rc.gz
+    _,e = io.CopyN(ioutil.Discard, r, ent.Offset&0xffff)
+
+  case KIND_GZIP:
+    if rc.gz,e = gzip.NewReader(f) ; e!=nil { break }
+    r = rc.gz
+    _,e = io.CopyN(ioutil.Discard, r, ent.Offset)
+
+  default:
+    e = fmt.Errorf("%s: unknown index kind '%s'", IndexName(fn), idx.Kind)
+  }
+
+  if e!=nil {
+    rc.Close()
+    return nil, fmt.Errorf("%s: seeking to %s: %v", fn, ent.Pos, e)
+  }
+
+  return fastj.NewReaderAt(r, rc, ent.LineNo-1), nil
+}
+
+// Tiles of a FastJ file in an inclusive tile ID range (see
+// fjexpr.InRange).  If the file has an index, reading starts
+// from the index and stops once past the end of the range.
+//
+type RangeReader struct {
+  fj *fastj.Reader
+  beg fastj.TileID
+  end fastj.TileID
+  idx *Index
+}
+
+func OpenRange(fn string, beg, end fastj.TileID) (*RangeReader, error) {
+  rr := &RangeReader{ beg: beg, end: end }
+
+  idx,e := Load(fn)
+  if e!=nil { return nil, e }
+
+  if idx==nil {
+    rr.fj,e = fastj.Open(fn)
+  } else {
+    rr.fj,e = idx.OpenAt(fn, idx.Find(beg))
+    rr.idx = idx
+  }
+  if e!=nil { return nil, e }
+
+  return rr, nil
+}
+
+// Nothing in range can come after id.
+//
+func (rr *RangeReader) past_end(id fastj.TileID) bool {
+  if rr.idx==nil || rr.end.Path<0 { return false }
+  if id.Path!=rr.end.Path { return id.Path>rr.end.Path }
+  return rr.end.Step>=0 && id.Step > rr.end.Step+rr.idx.MaxDisorder
+}
+
+// Read the next tile in range.  Returns io.EOF when
+// there are no more.
+//
+func (rr *RangeReader) Read() (*fastj.Tile, error) {
+  for {
+    tile,e := rr.fj.Read()
+    if e!=nil { return nil, e }
+
+    if rr.past_end(tile.TileID) { return nil, io.EOF }
+    if fjexpr.InRange(tile.TileID, tile.SeedTileLength, rr.beg, rr.end) { return tile, nil }
+  }
+}
+
+func (rr *RangeReader) LineNo() int {
+  return rr.fj.LineNo()
+}
+
+func (rr *RangeReader) Close() error {
+  return rr.fj.Close()
+}
+
+// The whole range, for readers that take everything.
+//
+func AllTiles() fastj.TileID {
+  return fastj.TileID{ Path: -1, Ver: -1, Step: -1, Variant: -1 }
+}
@@ -0,0 +1,232 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package fjidx
+
+import "testing"
+import "bytes"
+import "fmt"
+import "io"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+import "sort"
+import "math/rand"
+import "hash/crc32"
+import "compress/flate"
+import "compress/gzip"
+import "encoding/binary"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjexpr"
+
+// Tiles of two haplotypes on two paths, with some spanning
+// tiles, in the order lightning writes them: by the last step
+// each covers, so a spanning tile comes after the other
+// haplotype's next step.
+//
+func test_tiles() []*fastj.Tile {
+  r := rand.New(rand.NewSource(1))
+  tiles := make([]*fastj.Tile, 0, 256)
+
+  for _,path := range []int{ 0x2c5, 0x2c6 } {
+    for variant,span_every := range []int{ 7, 5 } {
+      for step:=0; step<60; {
+        seedlen := 1
+        if step%span_every==span_every-1 && step+2<=60 { seedlen = 2 }
+
+        seq := make([]byte, 200+r.Intn(100))
+        for i:=0; i<len(seq); i++ { seq[i] = "acgt"[r.Intn(4)] }
+
+        tile := &fastj.Tile{ TileID: fastj.TileID{ Path: path, Ver: 0, Step: step, Variant: variant },
+          N: len(seq), SeedTileLength: seedlen, Seq: string(seq), Md5Sum: fastj.Md5Sum(string(seq)) }
+        tiles = append(tiles, tile)
+        step += seedlen
+      }
+    }
+  }
+
+  last := func(t *fastj.Tile) fastj.TilePos { return t.TileID.Pos().Next(t.SeedTileLength-1) }
+  sort.SliceStable(tiles, func(i,j int) bool {
+    if c := last(tiles[i]).Cmp(last(tiles[j])) ; c!=0 { return c<0 }
+    return tiles[i].TileID.Variant < tiles[j].TileID.Variant
+  })
+
+  return tiles
+}
+
+// Compress b as BGZF, in blocks of at most block_size bytes,
+// with the empty end of file block.
+//
+func bgzf(b []byte, block_size int) []byte {
+  var out bytes.Buffer
+  for p:=0; ; {
+    q := p+block_size
+    if q>len(b) { q = len(b) }
+
+    var z bytes.Buffer
+    fw,_ := flate.NewWriter(&z, flate.DefaultCompression)
+    fw.Write(b[p:q])
+    fw.Close()
+
+    hdr := []byte{ 31, 139, 8, 4, 0, 0, 0, 0, 0, 255, 6, 0, 'B', 'C', 2, 0, 0, 0 }
+    binary.LittleEndian.PutUint16(hdr[16:], uint16(len(hdr)+z.Len()+8-1))
+    out.Write(hdr)
+    out.Write(z.Bytes())
+
+    tail := make([]byte, 8)
+    binary.LittleEndian.PutUint32(tail[0:], crc32.ChecksumIEEE(b[p:q]))
+    binary.LittleEndian.PutUint32(tail[4:], uint32(q-p))
+    out.Write(tail)
+
+    if p==len(b) { break }
+    p = q
+  }
+  return out.Bytes()
+}
+
+func gzipped(b []byte) []byte {
+  var out bytes.Buffer
+  gz := gzip.NewWriter(&out)
+  gz.Write(b)
+  gz.Close()
+  return out.Bytes()
+}
+
+type read_tile struct {
+  id fastj.TileID
+  md5 string
+  line_no int
+}
+
+func read_all(t *testing.T, read func() (*fastj.Tile, error), line_no func() int) []read_tile {
+  tiles := make([]read_tile, 0)
+  for {
+    tile,e := read()
+    if e==io.EOF { break }
+    if e!=nil { t.Fatal(e) }
+    tiles = append(tiles, read_tile{ tile.TileID, tile.Md5Sum, line_no() })
+  }
+  return tiles
+}
+
+// Tiles in range by reading the whole file without the index.
+//
+func scan_range(t *testing.T, fn string, beg, end fastj.TileID) []read_tile {
+  fj,e := fastj.Open(fn)
+  if e!=nil { t.Fatal(e) }
+  defer fj.Close()
+
+  read := func() (*fastj.Tile, error) {
+    for {
+      tile,e := fj.Read()
+      if e!=nil { return nil, e }
+      if fjexpr.InRange(tile.TileID, tile.SeedTileLength, beg, end) { return tile, nil }
+    }
+  }
+  return read_all(t, read, fj.LineNo)
+}
+
+func partial(t *testing.T, s string) fastj.TileID {
+  id,e := fastj.ParseTileIDPartial(s)
+  if e!=nil { t.Fatal(e) }
+  return id
+}
+
+// Reading a range through the index of a plain, gzip or BGZF
+// file gives the same tiles, with the same line numbers, as
+// scanning the whole file.
+//
+func TestOpenRange(t *testing.T) {
+  dir,e := ioutil.TempDir("", "fjidx")
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll(dir)
+
+  var buf bytes.Buffer
+  w := fastj.NewWriter(&buf)
+  for _,tile := range test_tiles() {
+    if e := w.Write(tile) ; e!=nil { t.Fatal(e) }
+  }
+
+  files := []struct {
+    kind string
+    data []byte
+  }{
+    { KIND_PLAIN, buf.Bytes() },
+    { KIND_GZIP, gzipped(buf.Bytes()) },
+    { KIND_BGZF, bgzf(buf.Bytes(), 4000) },
+  }
+
+  whole_path := func(path int) fastj.TileID { return fastj.TileID{ Path: path, Ver: -1, Step: -1, Variant: -1 } }
+  ranges := [][2]fastj.TileID{
+    { AllTiles(), AllTiles() },
+    { partial(t, "2c5.0000"), partial(t, "2c5.0000") },
+    { partial(t, "2c5.0010"), partial(t, "2c5.0020") },
+    { partial(t, "2c5.0012"), partial(t, "2c5.0012") },
+    { partial(t, "2c5.0031"), partial(t, "2c5.003b") },
+    { partial(t, "2c6.0000"), partial(t, "2c6.0005") },
+    { partial(t, "2c6.0036"), partial(t, "2c6.00ff") },
+    { partial(t, "2c5.00.0010.001"), partial(t, "2c5.00.0020.001") },
+    { whole_path(0x2c6), whole_path(0x2c6) },
+    { whole_path(0x2c5), whole_path(0x2c5) },
+  }
+
+  for _,f := range files {
+    fn := filepath.Join(dir, "test.fj." + f.kind)
+    if e := ioutil.WriteFile(fn, f.data, 0644) ; e!=nil { t.Fatal(e) }
+
+    for _,stride := range []int{ 1, 4, 16 } {
+      idx,e := Build(fn, stride)
+      if e!=nil { t.Fatalf("%s: %v", fn, e) }
+      if idx.Kind!=f.kind { t.Errorf("%s: kind got %s, expected %s", fn, idx.Kind, f.kind) }
+      if idx.MaxSeedTileLength!=2 || idx.MaxDisorder!=1 {
+        t.Errorf("%s: max seedTileLength %d, max disorder %d, expected 2 and 1", fn, idx.MaxSeedTileLength, idx.MaxDisorder)
+      }
+
+      var ibuf bytes.Buffer
+      idx.Write(&ibuf)
+      if e := ioutil.WriteFile(IndexName(fn), ibuf.Bytes(), 0644) ; e!=nil { t.Fatal(e) }
+
+      for _,rng := range ranges {
+        name := fmt.Sprintf("%s stride %d %s..%s", f.kind, stride, rng[0], rng[1])
+        want := scan_range(t, fn, rng[0], rng[1])
+
+        rr,e := OpenRange(fn, rng[0], rng[1])
+        if e!=nil { t.Fatalf("%s: %v", name, e) }
+        if rr.idx==nil { t.Errorf("%s: index not used", name) }
+        got := read_all(t, rr.Read, rr.LineNo)
+        rr.Close()
+
+        if len(got)!=len(want) {
+          t.Errorf("%s: got %d tiles, expected %d", name, len(got), len(want))
+          continue
+        }
+        for i:=0; i<len(got); i++ {
+          if got[i]!=want[i] {
+            t.Errorf("%s: tile %d got %+v, expected %+v", name, i, got[i], want[i])
+            break
+          }
+        }
+      }
+
+      os.Remove(IndexName(fn))
+    }
+  }
+}
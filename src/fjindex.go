@@ -0,0 +1,111 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Build the random access index (<file>.fji) of a FastJ file
+// in path.step order.  See the fjidx package for the format.
+//
+// fjfilter, create_tile_graph and fj2allele use the index, when
+// there is one, to seek straight to the -s/-e range.  Compress
+// FastJ with bgzip (from htslib) rather than gzip so the index
+// can seek into the compressed file.
+//
+// example usage:
+//
+//  bgzip hu826751.fj
+//  ./fjindex -i hu826751.fj.gz
+//  ./fjfilter -i hu826751.fj.gz -s 2c5.00.3cd -e 2c5.00.52c
+//
+
+package main
+
+import "fmt"
+import "os"
+import "log"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjidx"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+func _main( c *cli.Context ) {
+  gVerboseFlag = c.Bool("Verbose")
+
+  ifns := c.StringSlice("input")
+  if len(ifns)==0 {
+    fmt.Fprintf( os.Stderr, "Provide input FastJ file\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  for i:=0; i<len(ifns); i++ {
+    if ifns[i]=="-" { log.Fatal("can't index stdin") }
+
+    idx,e := fjidx.Build(ifns[i], c.Int("stride"))
+    if e!=nil { log.Fatal(e) }
+
+    ofn := fjidx.IndexName(ifns[i])
+
+    out,e := autoio.CreateWriter(ofn)
+    if e!=nil { log.Fatal(e) }
+    if e:=idx.Write(out.Writer) ; e!=nil { log.Fatal(e) }
+    out.Flush()
+    out.Close()
+
+    if gVerboseFlag {
+      fmt.Fprintf(os.Stderr, "# %s: %s, %d entries, max seedTileLength %d\n", ofn, idx.Kind, len(idx.Entries), idx.MaxSeedTileLength)
+    }
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "fjindex"
+  app.Usage = "Index FastJ files in path.step order for random access"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringSliceFlag{
+      Name: "input, i",
+      Value: &cli.StringSlice{},
+      Usage: "INPUT FastJ (can be specified more than once)",
+    },
+
+    cli.IntFlag{
+      Name: "stride",
+      Value: 16,
+      Usage: "Steps between index entries",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+  }
+
+  app.Run(os.Args)
+
+}
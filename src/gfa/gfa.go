@@ -0,0 +1,85 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package gfa writes the tile graph as GFA 1.0.
+//
+// Tag and body sequences are segments (S-lines) named by their
+// sequenceRecordName, GraphJoins are links (L-lines) and each
+// allele is a path (P-line).  Tags and bodies abut, so every
+// overlap is 0M.
+//
+// See:
+//  https://github.com/GFA-spec/GFA-spec/blob/master/GFA1.md
+//
+package gfa
+
+import "io"
+import "fmt"
+import "strings"
+
+func orient(fwd bool) string {
+  if fwd { return "+" }
+  return "-"
+}
+
+// Write the H-line.
+//
+func WriteHeader(w io.Writer) error {
+  _,e := fmt.Fprintf(w, "H\tVN:Z:1.0\n")
+  return e
+}
+
+// Write an S-line.  An empty sequence is written as '*'
+// with an explicit LN:i:0.
+//
+func WriteSegment(w io.Writer, name, seq string) error {
+  if len(seq)==0 {
+    _,e := fmt.Fprintf(w, "S\t%s\t*\tLN:i:0\n", name)
+    return e
+  }
+  _,e := fmt.Fprintf(w, "S\t%s\t%s\n", name, seq)
+  return e
+}
+
+// Write an L-line for a GraphJoin.
+//
+// A GraphJoin side with the strand forward attaches to the start
+// (5' end) of its sequence, otherwise to the end (3' end).  The link
+// leaves side 1 and enters side 2, so side 1 is traversed forward
+// when it attaches at the end and side 2 is traversed forward when
+// it attaches at the start.
+//
+func WriteLink(w io.Writer, side1 string, side1_forward bool, side2 string, side2_forward bool) error {
+  _,e := fmt.Fprintf(w, "L\t%s\t%s\t%s\t%s\t0M\n",
+    side1, orient(!side1_forward),
+    side2, orient(side2_forward))
+  return e
+}
+
+// Write a P-line.  segs and forward are the segment names
+// and their orientation along the path.
+//
+func WritePath(w io.Writer, name string, segs []string, forward []bool) error {
+  steps := make([]string, len(segs))
+  for i:=0; i<len(segs); i++ {
+    steps[i] = segs[i] + orient(forward[i])
+  }
+  _,e := fmt.Fprintf(w, "P\t%s\t%s\t*\n", name, strings.Join(steps, ","))
+  return e
+}
@@ -0,0 +1,137 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package graphdb
+
+import "fmt"
+import "strings"
+
+// Columns of each table of graphSQL_v023.sql (plus
+// AllelePathItemNoCall), in order.
+//
+var columns_v023 = map[string][]string{
+  "FASTA": { "ID", "fastaURI" },
+  "Sequence": { "ID", "fastaID", "sequenceRecordName", "md5checksum", "length" },
+  "GraphJoin": { "ID", "side1SequenceID", "side1Position", "side1StrandIsForward", "side2SequenceID", "side2Position", "side2StrandIsForward" },
+  "Reference": { "ID", "name", "updateTime", "sequenceID", "start", "length", "md5checksum", "isDerived", "sourceDivergence", "ncbiTaxonID", "isPrimary" },
+  "ReferenceAccession": { "ID", "referenceID", "accessionID" },
+  "ReferenceSet": { "ID", "ncbiTaxonID", "description", "assemblyID", "isDerived" },
+  "ReferenceSetAccession": { "ID", "referenceSetID", "accessionID" },
+  "Reference_ReferenceSet_Join": { "referenceID", "referenceSetID" },
+  "GraphJoin_ReferenceSet_Join": { "graphJoinID", "referenceSetID" },
+  "VariantSet": { "ID", "referenceSetID", "name" },
+  "CallSet": { "ID", "name", "sampleID" },
+  "VariantSet_CallSet_Join": { "variantSetID", "callSetID" },
+  "GraphJoin_VariantSet_Join": { "graphJoinID", "variantSetID" },
+  "Allele": { "ID", "variantSetID", "name" },
+  "AllelePathItem": { "alleleID", "pathItemIndex", "sequenceID", "start", "length", "strandIsForward" },
+  "AlleleCall": { "alleleID", "callSetID", "ploidy" },
+  "AllelePathItemNoCall": { "alleleID", "pathItemIndex", "start", "length" },
+}
+
+// Columns of table, in order.
+//
+func Columns(table string) ([]string, error) {
+  if cols,ok := columns_v023[table] ; ok { return cols, nil }
+  return nil, fmt.Errorf("no table %s in the schema", table)
+}
+
+// Values of a row by column name.
+//
+type Row map[string]interface{}
+
+// Write a CSV row of table in the column order of the
+// schema.  Columns not in vals are left empty, values for
+// columns the table doesn't have are dropped, and
+// booleans are written as 1 and 0, which the sqlite3
+// .import in db/ stores as integers, the same as -db does
+// (TRUE and FALSE, quoted or not, would be stored as
+// strings).
+//
+func CSVRow(table string, vals Row) (string, error) {
+  cols,e := Columns(table)
+  if e!=nil { return "", e }
+
+  fields := make([]string, len(cols))
+  for i,col := range cols {
+    v,ok := vals[col]
+    if !ok { continue }
+    switch x := v.(type) {
+    case bool:
+      fields[i] = "0"
+      if x { fields[i] = "1" }
+    default:
+      fields[i] = fmt.Sprint(x)
+    }
+  }
+  return strings.Join(fields, ",") + "\n", nil
+}
+
+// Header line of the CSV file of table.
+//
+func CSVHeader(table string) (string, error) {
+  cols,e := Columns(table)
+  if e!=nil { return "", e }
+  return strings.Join(cols, ",") + "\n", nil
+}
+
+// Position of each column in a CSV file of table.  If the
+// first line is a header (its first field is a column
+// name of the table) the columns are found by name,
+// otherwise they're taken to be in the order of the
+// schema.  header is true if line is a header.
+//
+type ColumnMap map[string]int
+
+func ParseColumns(table, line string) (m ColumnMap, header bool, e error) {
+  cols,e := Columns(table)
+  if e!=nil { return nil, false, e }
+
+  fields := strings.Split(line, ",")
+  m = make(ColumnMap)
+
+  is_col := make(map[string]bool)
+  for _,col := range cols { is_col[col] = true }
+
+  if !is_col[fields[0]] {
+    for i,col := range cols { m[col] = i }
+    return m, false, nil
+  }
+
+  for i,f := range fields { m[f] = i }
+  return m, true, nil
+}
+
+// Value of col in the CSV fields, "" if the column
+// isn't there.
+//
+func (m ColumnMap) Get(fields []string, col string) string {
+  i,ok := m[col]
+  if !ok || i>=len(fields) { return "" }
+  return fields[i]
+}
+
+// Check the columns are all there.
+//
+func (m ColumnMap) Require(table string, cols ...string) error {
+  for _,col := range cols {
+    if _,ok := m[col] ; !ok { return fmt.Errorf("%s: no column %s", table, col) }
+  }
+  return nil
+}
@@ -0,0 +1,614 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package graphdb writes the tile graph into a SQLite database
+// using the GA4GH reference graph schema (graphSQL_v023.sql).
+//
+// Rows are inserted with their proper types (booleans are stored
+// as booleans, not the string 'TRUE') inside a single transaction
+// per tool run.
+//
+package graphdb
+
+import "os"
+import "fmt"
+import "sort"
+import "strings"
+import "database/sql"
+
+import _ "github.com/mattn/go-sqlite3"
+
+type FASTA struct {
+  Id int
+  FastaURI string
+}
+
+type Sequence struct {
+  Id int
+  FastaId int
+  SequenceRecordName string
+  Md5Checksum string
+  Length int
+}
+
+type GraphJoin struct {
+  Id int
+  Side1SequenceId int
+  Side1Position int
+  Side1StrandIsForward bool
+  Side2SequenceId int
+  Side2Position int
+  Side2StrandIsForward bool
+}
+
+type Reference struct {
+  Id int
+  Name string
+  UpdateTime string
+  SequenceId int
+  Start int
+  Length int
+  Md5Checksum string
+  IsDerived bool
+  NcbiTaxonId int
+  IsPrimary bool
+}
+
+type ReferenceAccession struct {
+  Id int
+  ReferenceId int
+  AccessionId string
+}
+
+type ReferenceSet struct {
+  Id int
+  NcbiTaxonId int
+  Description string
+  AssemblyId string
+  IsDerived bool
+}
+
+type ReferenceSetAccession struct {
+  Id int
+  ReferenceSetId int
+  AccessionId string
+}
+
+type ReferenceReferenceSetJoin struct {
+  ReferenceId int
+  ReferenceSetId int
+}
+
+type GraphJoinReferenceSetJoin struct {
+  GraphJoinId int
+  ReferenceSetId int
+}
+
+type GraphJoinVariantSetJoin struct {
+  GraphJoinId int
+  VariantSetId int
+}
+
+type VariantSet struct {
+  Id int
+  ReferenceSetId int
+  Name string
+}
+
+type CallSet struct {
+  Id int
+  Name string
+  SampleId string
+}
+
+type VariantSetCallSetJoin struct {
+  VariantSetId int
+  CallSetId int
+}
+
+type Allele struct {
+  Id int
+  VariantSetId int
+  Name string
+}
+
+type AlleleCall struct {
+  AlleleId int
+  CallSetId int
+  Ploidy int
+}
+
+type AllelePathItem struct {
+  AlleleId int
+  PathItemIndex int
+  SequenceId int
+  Start int
+  Length int
+  StrandIsForward bool
+}
+
+type AllelePathItemNoCall struct {
+  AlleleId int
+  PathItemIndex int
+  Start int
+  Length int
+}
+
+// Open the SQLite database fn, creating the schema
+// if the database doesn't have it yet.
+//
+func Open(fn string) (*sql.DB, error) {
+  db,e := sql.Open("sqlite3", fn)
+  if e!=nil { return nil, e }
+
+  has,e := HasTable(db, "Sequence")
+  if e!=nil { db.Close() ; return nil, e }
+
+  if !has {
+    if _,e = db.Exec(SCHEMA_V023) ; e!=nil {
+      db.Close()
+      return nil, fmt.Errorf("creating schema in %s: %v", fn, e)
+    }
+  }
+
+  return db, nil
+}
+
+// Open an existing database read only, for serving it.
+// The schema isn't created if it's missing.
+//
+func OpenReadOnly(fn string) (*sql.DB, error) {
+  if _,e := os.Stat(fn) ; e!=nil { return nil, e }
+
+  db,e := sql.Open("sqlite3", "file:" + fn + "?mode=ro")
+  if e!=nil { return nil, e }
+
+  has,e := HasTable(db, "Sequence")
+  if e!=nil { db.Close() ; return nil, e }
+  if !has { db.Close() ; return nil, fmt.Errorf("%s has no tile graph schema", fn) }
+
+  return db, nil
+}
+
+// Boolean column as written by this package (0 or 1) or by
+// the sqlite3 .import of older CSV files (the string 'TRUE',
+// quotes included).
+//
+type Bool bool
+
+func (b *Bool) Scan(v interface{}) error {
+  switch x := v.(type) {
+  case nil: *b = false
+  case bool: *b = Bool(x)
+  case int64: *b = x!=0
+  case []byte: return b.Scan(string(x))
+  case string:
+    switch strings.ToUpper(strings.Trim(x, "'")) {
+    case "TRUE", "T", "1": *b = true
+    case "FALSE", "F", "0", "": *b = false
+    default: return fmt.Errorf("invalid boolean '%s'", x)
+    }
+  default:
+    return fmt.Errorf("invalid boolean %v", v)
+  }
+  return nil
+}
+
+func HasTable(db *sql.DB, name string) (bool, error) {
+  var n int
+  e := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type='table' AND name=?`, name).Scan(&n)
+  if e!=nil { return false, e }
+  return n>0, nil
+}
+
+// Prepare stmt and execute it once per row, using
+// args to get the values of row i.
+//
+func insert_rows(tx *sql.Tx, table, stmt string, n int, args func(i int) []interface{}) error {
+  st,e := tx.Prepare(stmt)
+  if e!=nil { return fmt.Errorf("%s: %v", table, e) }
+  defer st.Close()
+
+  for i:=0; i<n; i++ {
+    if _,e := st.Exec(args(i)...) ; e!=nil {
+      return fmt.Errorf("%s: inserting row %d: %v", table, i, e)
+    }
+  }
+
+  return nil
+}
+
+func InsertFASTA(tx *sql.Tx, rows []FASTA) error {
+  return insert_rows(tx, "FASTA",
+    `INSERT INTO FASTA (ID, fastaURI) VALUES (?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].FastaURI }
+    })
+}
+
+func InsertSequence(tx *sql.Tx, rows []Sequence) error {
+  return insert_rows(tx, "Sequence",
+    `INSERT INTO Sequence (ID, fastaID, sequenceRecordName, md5checksum, length) VALUES (?,?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].FastaId, rows[i].SequenceRecordName, rows[i].Md5Checksum, rows[i].Length }
+    })
+}
+
+func InsertGraphJoin(tx *sql.Tx, rows []GraphJoin) error {
+  return insert_rows(tx, "GraphJoin",
+    `INSERT INTO GraphJoin (ID, side1SequenceID, side1Position, side1StrandIsForward, side2SequenceID, side2Position, side2StrandIsForward) VALUES (?,?,?,?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      r := rows[i]
+      return []interface{}{ r.Id, r.Side1SequenceId, r.Side1Position, r.Side1StrandIsForward, r.Side2SequenceId, r.Side2Position, r.Side2StrandIsForward }
+    })
+}
+
+func InsertReference(tx *sql.Tx, rows []Reference) error {
+  return insert_rows(tx, "Reference",
+    `INSERT INTO Reference (ID, name, updateTime, sequenceID, start, length, md5checksum, isDerived, ncbiTaxonID, isPrimary) VALUES (?,?,?,?,?,?,?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      r := rows[i]
+      return []interface{}{ r.Id, r.Name, r.UpdateTime, r.SequenceId, r.Start, r.Length, r.Md5Checksum, r.IsDerived, r.NcbiTaxonId, r.IsPrimary }
+    })
+}
+
+func InsertReferenceAccession(tx *sql.Tx, rows []ReferenceAccession) error {
+  return insert_rows(tx, "ReferenceAccession",
+    `INSERT INTO ReferenceAccession (ID, referenceID, accessionID) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].ReferenceId, rows[i].AccessionId }
+    })
+}
+
+func InsertReferenceSet(tx *sql.Tx, rows []ReferenceSet) error {
+  return insert_rows(tx, "ReferenceSet",
+    `INSERT INTO ReferenceSet (ID, ncbiTaxonID, description, assemblyID, isDerived) VALUES (?,?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      r := rows[i]
+      return []interface{}{ r.Id, r.NcbiTaxonId, r.Description, r.AssemblyId, r.IsDerived }
+    })
+}
+
+func InsertReferenceSetAccession(tx *sql.Tx, rows []ReferenceSetAccession) error {
+  return insert_rows(tx, "ReferenceSetAccession",
+    `INSERT INTO ReferenceSetAccession (ID, referenceSetID, accessionID) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].ReferenceSetId, rows[i].AccessionId }
+    })
+}
+
+func InsertReferenceReferenceSetJoin(tx *sql.Tx, rows []ReferenceReferenceSetJoin) error {
+  return insert_rows(tx, "Reference_ReferenceSet_Join",
+    `INSERT INTO Reference_ReferenceSet_Join (referenceID, referenceSetID) VALUES (?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].ReferenceId, rows[i].ReferenceSetId }
+    })
+}
+
+func InsertGraphJoinReferenceSetJoin(tx *sql.Tx, rows []GraphJoinReferenceSetJoin) error {
+  return insert_rows(tx, "GraphJoin_ReferenceSet_Join",
+    `INSERT INTO GraphJoin_ReferenceSet_Join (graphJoinID, referenceSetID) VALUES (?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].GraphJoinId, rows[i].ReferenceSetId }
+    })
+}
+
+func InsertGraphJoinVariantSetJoin(tx *sql.Tx, rows []GraphJoinVariantSetJoin) error {
+  return insert_rows(tx, "GraphJoin_VariantSet_Join",
+    `INSERT INTO GraphJoin_VariantSet_Join (graphJoinID, variantSetID) VALUES (?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].GraphJoinId, rows[i].VariantSetId }
+    })
+}
+
+func InsertVariantSet(tx *sql.Tx, rows []VariantSet) error {
+  return insert_rows(tx, "VariantSet",
+    `INSERT INTO VariantSet (ID, referenceSetID, name) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].ReferenceSetId, rows[i].Name }
+    })
+}
+
+func InsertCallSet(tx *sql.Tx, rows []CallSet) error {
+  return insert_rows(tx, "CallSet",
+    `INSERT INTO CallSet (ID, name, sampleID) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].Name, rows[i].SampleId }
+    })
+}
+
+func InsertVariantSetCallSetJoin(tx *sql.Tx, rows []VariantSetCallSetJoin) error {
+  return insert_rows(tx, "VariantSet_CallSet_Join",
+    `INSERT INTO VariantSet_CallSet_Join (variantSetID, callSetID) VALUES (?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].VariantSetId, rows[i].CallSetId }
+    })
+}
+
+func InsertAllele(tx *sql.Tx, rows []Allele) error {
+  return insert_rows(tx, "Allele",
+    `INSERT INTO Allele (ID, variantSetID, name) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].Id, rows[i].VariantSetId, rows[i].Name }
+    })
+}
+
+func InsertAlleleCall(tx *sql.Tx, rows []AlleleCall) error {
+  return insert_rows(tx, "AlleleCall",
+    `INSERT INTO AlleleCall (alleleID, callSetID, ploidy) VALUES (?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].AlleleId, rows[i].CallSetId, rows[i].Ploidy }
+    })
+}
+
+func InsertAllelePathItem(tx *sql.Tx, rows []AllelePathItem) error {
+  return insert_rows(tx, "AllelePathItem",
+    `INSERT INTO AllelePathItem (alleleID, pathItemIndex, sequenceID, start, length, strandIsForward) VALUES (?,?,?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      r := rows[i]
+      return []interface{}{ r.AlleleId, r.PathItemIndex, r.SequenceId, r.Start, r.Length, r.StrandIsForward }
+    })
+}
+
+func InsertAllelePathItemNoCall(tx *sql.Tx, rows []AllelePathItemNoCall) error {
+  return insert_rows(tx, "AllelePathItemNoCall",
+    `INSERT INTO AllelePathItemNoCall (alleleID, pathItemIndex, start, length) VALUES (?,?,?,?)`,
+    len(rows), func(i int) []interface{} {
+      return []interface{}{ rows[i].AlleleId, rows[i].PathItemIndex, rows[i].Start, rows[i].Length }
+    })
+}
+
+// Read all Sequence rows.
+//
+func ReadSequence(db *sql.DB) ([]Sequence, error) {
+  rows,e := db.Query(`SELECT ID, fastaID, sequenceRecordName, md5checksum, length FROM Sequence`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  seqs := make([]Sequence, 0, 1024)
+  for rows.Next() {
+    var s Sequence
+    if e := rows.Scan(&s.Id, &s.FastaId, &s.SequenceRecordName, &s.Md5Checksum, &s.Length) ; e!=nil {
+      return nil, e
+    }
+    seqs = append(seqs, s)
+  }
+
+  return seqs, rows.Err()
+}
+
+// Largest ID in table, 0 if the table is empty.
+//
+func MaxId(db *sql.DB, table string) (int, error) {
+  var id int
+  e := db.QueryRow(fmt.Sprintf(`SELECT COALESCE(MAX(ID),0) FROM %s`, table)).Scan(&id)
+  if e!=nil { return 0, fmt.Errorf("%s: %v", table, e) }
+  return id, nil
+}
+
+// Read all FASTA rows.
+//
+func ReadFASTA(db *sql.DB) ([]FASTA, error) {
+  rows,e := db.Query(`SELECT ID, fastaURI FROM FASTA`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  fas := make([]FASTA, 0, 4)
+  for rows.Next() {
+    var f FASTA
+    if e := rows.Scan(&f.Id, &f.FastaURI) ; e!=nil { return nil, e }
+    fas = append(fas, f)
+  }
+
+  return fas, rows.Err()
+}
+
+// Read all VariantSet rows.
+//
+func ReadVariantSet(db *sql.DB) ([]VariantSet, error) {
+  rows,e := db.Query(`SELECT ID, referenceSetID, COALESCE(name,'') FROM VariantSet`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  vss := make([]VariantSet, 0, 4)
+  for rows.Next() {
+    var v VariantSet
+    if e := rows.Scan(&v.Id, &v.ReferenceSetId, &v.Name) ; e!=nil { return nil, e }
+    vss = append(vss, v)
+  }
+
+  return vss, rows.Err()
+}
+
+// Read all CallSet rows.
+//
+func ReadCallSet(db *sql.DB) ([]CallSet, error) {
+  rows,e := db.Query(`SELECT ID, COALESCE(name,''), COALESCE(sampleID,'') FROM CallSet`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  css := make([]CallSet, 0, 256)
+  for rows.Next() {
+    var c CallSet
+    if e := rows.Scan(&c.Id, &c.Name, &c.SampleId) ; e!=nil { return nil, e }
+    css = append(css, c)
+  }
+
+  return css, rows.Err()
+}
+
+// IDs of the Sequences Reference rows point to.
+//
+func ReferenceSequenceIds(db *sql.DB) (map[int]bool, error) {
+  rows,e := db.Query(`SELECT sequenceID FROM Reference`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  ids := make(map[int]bool)
+  for rows.Next() {
+    var id int
+    if e := rows.Scan(&id) ; e!=nil { return nil, e }
+    ids[id] = true
+  }
+
+  return ids, rows.Err()
+}
+
+// The ReferenceSets the GraphJoins are linked to with
+// GraphJoin_ReferenceSet_Join, in ID order.
+//
+func GraphJoinReferenceSetIds(db *sql.DB) ([]int, error) {
+  rows,e := db.Query(`SELECT DISTINCT referenceSetID FROM GraphJoin_ReferenceSet_Join ORDER BY referenceSetID`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  ids := make([]int, 0, 1)
+  for rows.Next() {
+    var id int
+    if e := rows.Scan(&id) ; e!=nil { return nil, e }
+    ids = append(ids, id)
+  }
+
+  return ids, rows.Err()
+}
+
+// The VariantSet called name, ok is false if there is none.
+//
+func VariantSetByName(db *sql.DB, name string) (vs VariantSet, ok bool, e error) {
+  vss,e := ReadVariantSet(db)
+  if e!=nil { return vs, false, e }
+  for _,v := range vss {
+    if v.Name==name { return v, true, nil }
+  }
+  return vs, false, nil
+}
+
+// The ReferenceSet of assembly, ok is false if there is none.
+//
+func ReferenceSetByAssembly(db *sql.DB, assembly string) (rs ReferenceSet, ok bool, e error) {
+  e = db.QueryRow(`SELECT ID, COALESCE(ncbiTaxonID,0), COALESCE(description,''), assemblyID, isDerived FROM ReferenceSet WHERE assemblyID=? ORDER BY ID LIMIT 1`, assembly).Scan(
+    &rs.Id, &rs.NcbiTaxonId, &rs.Description, &rs.AssemblyId, &rs.IsDerived)
+  if e==sql.ErrNoRows { return rs, false, nil }
+  if e!=nil { return rs, false, e }
+  return rs, true, nil
+}
+
+// Key of the GraphJoin between two Sequences, whichever
+// side each is on.
+//
+func JoinKey(seq1, seq2 int) [2]int {
+  if seq1 > seq2 { seq1,seq2 = seq2,seq1 }
+  return [2]int{seq1, seq2}
+}
+
+// Map of JoinKey to GraphJoin ID for all GraphJoin rows.
+//
+func ReadGraphJoinIndex(db *sql.DB) (map[[2]int]int, error) {
+  rows,e := db.Query(`SELECT ID, side1SequenceID, side2SequenceID FROM GraphJoin`)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  index := make(map[[2]int]int)
+  for rows.Next() {
+    var id, seq1, seq2 int
+    if e := rows.Scan(&id, &seq1, &seq2) ; e!=nil { return nil, e }
+    index[JoinKey(seq1, seq2)] = id
+  }
+
+  return index, rows.Err()
+}
+
+// IDs of the GraphJoins linked to the VariantSet.
+//
+func ReadVariantSetGraphJoins(db *sql.DB, variantset_id int) (map[int]bool, error) {
+  rows,e := db.Query(`SELECT graphJoinID FROM GraphJoin_VariantSet_Join WHERE variantSetID=?`, variantset_id)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  ids := make(map[int]bool)
+  for rows.Next() {
+    var id int
+    if e := rows.Scan(&id) ; e!=nil { return nil, e }
+    ids[id] = true
+  }
+
+  return ids, rows.Err()
+}
+
+// IDs of the GraphJoins the allele paths go through, in
+// order of first use.  items are in allele then path item
+// order, each Sequence joined to the one before it in the
+// same allele.
+//
+func PathGraphJoins(index map[[2]int]int, items []AllelePathItem) ([]int, error) {
+  ids := make([]int, 0, 1024)
+  seen := make(map[int]bool)
+
+  for i:=1; i<len(items); i++ {
+    prev,cur := items[i-1], items[i]
+    if prev.AlleleId!=cur.AlleleId { continue }
+
+    id,ok := index[JoinKey(prev.SequenceId, cur.SequenceId)]
+    if !ok {
+      return nil, fmt.Errorf("allele %d: no GraphJoin between Sequences %d and %d", cur.AlleleId, prev.SequenceId, cur.SequenceId)
+    }
+    if seen[id] { continue }
+    seen[id] = true
+    ids = append(ids, id)
+  }
+
+  return ids, nil
+}
+
+// GraphJoin_VariantSet_Join rows linking the GraphJoins the
+// allele paths go through to the VariantSet, leaving out the
+// ones already linked in the database.  new_joins are
+// GraphJoins not inserted yet.  Rows are in GraphJoin ID
+// order.
+//
+func VariantSetGraphJoinLinks(db *sql.DB, variantset_id int, new_joins []GraphJoin, items []AllelePathItem) ([]GraphJoinVariantSetJoin, error) {
+  index,e := ReadGraphJoinIndex(db)
+  if e!=nil { return nil, e }
+  for _,gj := range new_joins {
+    index[JoinKey(gj.Side1SequenceId, gj.Side2SequenceId)] = gj.Id
+  }
+
+  linked,e := ReadVariantSetGraphJoins(db, variantset_id)
+  if e!=nil { return nil, e }
+
+  return GraphJoinLinks(index, linked, variantset_id, items)
+}
+
+// GraphJoin_VariantSet_Join rows linking the GraphJoins in
+// index the allele paths go through to the VariantSet,
+// leaving out the ones in linked.  Rows are in GraphJoin ID
+// order.
+//
+func GraphJoinLinks(index map[[2]int]int, linked map[int]bool, variantset_id int, items []AllelePathItem) ([]GraphJoinVariantSetJoin, error) {
+  ids,e := PathGraphJoins(index, items)
+  if e!=nil { return nil, e }
+  sort.Ints(ids)
+
+  links := make([]GraphJoinVariantSetJoin, 0, len(ids))
+  for _,id := range ids {
+    if linked[id] { continue }
+    links = append(links, GraphJoinVariantSetJoin{GraphJoinId:id, VariantSetId:variantset_id})
+  }
+  return links, nil
+}
@@ -0,0 +1,119 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package graphdb
+
+// Schema for the GA4GH reference graph server, version 0.2.3.
+// This is db/graphSQL_v023.sql followed by db/allelepathitem_nocall.sql.
+//
+// See:
+//  https://github.com/ga4gh/server/blob/graph/tests/data/graphs/graphSQL_v023.sql
+//
+const SCHEMA_V023 = `
+CREATE TABLE FASTA (ID INTEGER PRIMARY KEY,
+	fastaURI TEXT NOT NULL);
+
+CREATE TABLE Sequence (ID INTEGER PRIMARY KEY,
+	fastaID INTEGER NOT NULL REFERENCES FASTA(ID),
+	sequenceRecordName TEXT NOT NULL,
+	md5checksum TEXT NOT NULL,
+	length INTEGER NOT NULL);
+
+CREATE TABLE GraphJoin (ID INTEGER PRIMARY KEY,
+	side1SequenceID INTEGER NOT NULL REFERENCES Sequence(ID),
+	side1Position INTEGER NOT NULL,
+	side1StrandIsForward BOOLEAN NOT NULL,
+	side2SequenceID INTEGER NOT NULL REFERENCES Sequence(ID),
+	side2Position INTEGER NOT NULL,
+	side2StrandIsForward BOOLEAN NOT NULL);
+
+CREATE TABLE Reference (ID INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	updateTime DATE NOT NULL,
+	sequenceID INTEGER NOT NULL REFERENCES Sequence(ID),
+	start INTEGER,
+	length INTEGER,
+	md5checksum TEXT,
+	isDerived BOOLEAN,
+	sourceDivergence REAL,
+	ncbiTaxonID INTEGER,
+	isPrimary BOOLEAN);
+
+CREATE TABLE ReferenceAccession (ID INTEGER PRIMARY KEY,
+	referenceID INTEGER NOT NULL REFERENCES Reference(ID),
+	accessionID TEXT NOT NULL);
+
+CREATE TABLE ReferenceSet (ID INTEGER PRIMARY KEY,
+	ncbiTaxonID INT,
+	description TEXT,
+	assemblyID TEXT,
+	isDerived BOOLEAN NOT NULL);
+
+CREATE TABLE ReferenceSetAccession (ID INTEGER PRIMARY KEY,
+	referenceSetID INTEGER NOT NULL REFERENCES ReferenceSet(ID),
+	accessionID TEXT NOT NULL);
+
+CREATE TABLE Reference_ReferenceSet_Join (referenceID INTEGER NOT NULL REFERENCES Reference(ID),
+	referenceSetID INTEGER NOT NULL REFERENCES ReferenceSet(ID),
+	PRIMARY KEY(referenceID, referenceSetID));
+
+CREATE TABLE GraphJoin_ReferenceSet_Join (graphJoinID INTEGER NOT NULL REFERENCES GraphJoin(ID),
+	referenceSetID INTEGER NOT NULL REFERENCES ReferenceSet(ID),
+	PRIMARY KEY(graphJoinID, referenceSetID));
+
+CREATE TABLE VariantSet (ID INTEGER PRIMARY KEY,
+	referenceSetID INTEGER NOT NULL REFERENCES ReferenceSet(ID),
+	name TEXT);
+
+CREATE TABLE CallSet (ID INTEGER PRIMARY KEY,
+	name TEXT,
+	sampleID TEXT);
+
+CREATE TABLE VariantSet_CallSet_Join (variantSetID INTEGER NOT NULL REFERENCES VariantSet(ID),
+	callSetID INTEGER NOT NULL REFERENCES CallSet(ID),
+	PRIMARY KEY(variantSetID, callSetID));
+
+CREATE TABLE GraphJoin_VariantSet_Join (graphJoinID INTEGER NOT NULL REFERENCES GraphJoin(ID),
+	variantSetID INTEGER NOT NULL REFERENCES VariantSet(ID),
+	PRIMARY KEY(graphJoinID, variantSetID));
+
+CREATE TABLE Allele (ID INTEGER PRIMARY KEY,
+	variantSetID INTEGER REFERENCES VariantSet(ID),
+	name TEXT);
+
+CREATE TABLE AllelePathItem (alleleID INTEGER REFERENCES allele(ID),
+	pathItemIndex INTEGER NOT NULL,
+	sequenceID INTEGER NOT NULL REFERENCES Sequence(ID),
+	start INTEGER NOT NULL,
+	length INTEGER NOT NULL,
+	strandIsForward BOOLEAN NOT NULL,
+	PRIMARY KEY(alleleID, pathItemIndex));
+
+CREATE TABLE AlleleCall (alleleID INTEGER NOT NULL REFERENCES allele(ID),
+	callSetID INTEGER NOT NULL REFERENCES CallSet(ID),
+	ploidy INTEGER NOT NULL,
+	PRIMARY KEY(alleleID, callSetID));
+
+CREATE TABLE AllelePathItemNoCall (alleleID INTEGER NOT NULL,
+	pathItemIndex INTEGER NOT NULL,
+	start INTEGER NOT NULL,
+	length INTEGER NOT NULL,
+	PRIMARY KEY(alleleID, pathItemIndex, start),
+	FOREIGN KEY(alleleID, pathItemIndex) REFERENCES AllelePathItem(alleleID, pathItemIndex));
+`
@@ -0,0 +1,793 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package graphserver serves a tile graph database, as written
+// by create_tile_graph -db and fj2allele -db, over HTTP using the
+// JSON messages of the GA4GH reference graph API:
+//
+//   POST /sequences/search      Sequences, of a ReferenceSet or VariantSet
+//   GET  /sequences/{id}        a Sequence
+//   GET  /sequences/{id}/bases  bases start to end (query or POST body)
+//   POST /joins/search          GraphJoins, optionally those on a Sequence
+//   POST /alleles/search        Alleles of a VariantSet, with their paths
+//   POST /callsets/search       CallSets, by VariantSet or name
+//   POST /subgraph/extract      Segments and joins within a radius
+//
+// IDs are the decimal table IDs as strings, positions are
+// 0-based.  Bases are read from the FASTA file the Sequence's
+// FASTA row points to, by sequenceRecordName.  Searches are paged
+// with pageSize and the nextPageToken of the previous response.
+//
+// Errors are returned as {"errorCode":..., "message":...} with
+// the HTTP status in errorCode.
+//
+// The /tiles endpoints for lightning_graph_viz are in tiles.go.
+//
+package graphserver
+
+import "io"
+import "fmt"
+import "sort"
+import "sync"
+import "strconv"
+import "strings"
+import "net/http"
+import "path/filepath"
+import "database/sql"
+import "encoding/json"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fasta"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+
+const POS_STRAND = "POS_STRAND"
+const NEG_STRAND = "NEG_STRAND"
+
+const DEFAULT_PAGE_SIZE = 100
+
+type Position struct {
+  SequenceId string `json:"sequenceId"`
+  Position int64 `json:"position"`
+}
+
+type Side struct {
+  Base Position `json:"base"`
+  Strand string `json:"strand"`
+}
+
+type Join struct {
+  Id string `json:"id"`
+  Side1 Side `json:"side1"`
+  Side2 Side `json:"side2"`
+}
+
+type Sequence struct {
+  Id string `json:"id"`
+  Length int64 `json:"length"`
+  SequenceRecordName string `json:"sequenceRecordName"`
+  Md5checksum string `json:"md5checksum"`
+  FastaId string `json:"fastaId"`
+  Bases *string `json:"bases,omitempty"`
+}
+
+type Segment struct {
+  Start Side `json:"start"`
+  Length int64 `json:"length"`
+}
+
+type Path struct {
+  Segments []Segment `json:"segments"`
+}
+
+type Allele struct {
+  Id string `json:"id"`
+  VariantSetId string `json:"variantSetId"`
+  Name string `json:"name"`
+  Path Path `json:"path"`
+}
+
+type CallSet struct {
+  Id string `json:"id"`
+  Name string `json:"name"`
+  SampleId string `json:"sampleId"`
+  VariantSetIds []string `json:"variantSetIds"`
+}
+
+type SearchSequencesRequest struct {
+  ReferenceSetId string `json:"referenceSetId"`
+  VariantSetId string `json:"variantSetId"`
+  ListBases bool `json:"listBases"`
+  PageSize int `json:"pageSize"`
+  PageToken string `json:"pageToken"`
+}
+
+type SearchSequencesResponse struct {
+  Sequences []Sequence `json:"sequences"`
+  NextPageToken *string `json:"nextPageToken"`
+}
+
+type GetSequenceBasesRequest struct {
+  Start *int64 `json:"start"`
+  End *int64 `json:"end"`
+}
+
+type GetSequenceBasesResponse struct {
+  Offset int64 `json:"offset"`
+  Sequence string `json:"sequence"`
+  NextPageToken *string `json:"nextPageToken"`
+}
+
+type SearchJoinsRequest struct {
+  ReferenceSetId string `json:"referenceSetId"`
+  VariantSetId string `json:"variantSetId"`
+  SequenceId string `json:"sequenceId"`
+  Start *int64 `json:"start"`
+  End *int64 `json:"end"`
+  Strand string `json:"strand"`
+  PageSize int `json:"pageSize"`
+  PageToken string `json:"pageToken"`
+}
+
+type SearchJoinsResponse struct {
+  Joins []Join `json:"joins"`
+  NextPageToken *string `json:"nextPageToken"`
+}
+
+type SearchAllelesRequest struct {
+  VariantSetId string `json:"variantSetId"`
+  SequenceId string `json:"sequenceId"`
+  Start *int64 `json:"start"`
+  End *int64 `json:"end"`
+  PageSize int `json:"pageSize"`
+  PageToken string `json:"pageToken"`
+}
+
+type SearchAllelesResponse struct {
+  Alleles []Allele `json:"alleles"`
+  NextPageToken *string `json:"nextPageToken"`
+}
+
+type SearchCallSetsRequest struct {
+  VariantSetIds []string `json:"variantSetIds"`
+  Name string `json:"name"`
+  PageSize int `json:"pageSize"`
+  PageToken string `json:"pageToken"`
+}
+
+type SearchCallSetsResponse struct {
+  CallSets []CallSet `json:"callSets"`
+  NextPageToken *string `json:"nextPageToken"`
+}
+
+// Radius is the number of joins away from the Sequence at
+// Position a Sequence can be to be in the subgraph.
+//
+type ExtractSubgraphRequest struct {
+  Position Position `json:"position"`
+  Radius int `json:"radius"`
+  ReferenceSetId string `json:"referenceSetId"`
+  VariantSetId string `json:"variantSetId"`
+}
+
+type ExtractSubgraphResponse struct {
+  Segments []Segment `json:"segments"`
+  Joins []Join `json:"joins"`
+}
+
+type ErrorResponse struct {
+  ErrorCode int `json:"errorCode"`
+  Message string `json:"message"`
+}
+
+type Server struct {
+  DB *sql.DB
+
+  // Directory relative fastaURIs are taken to be in.  If
+  // empty they are relative to the current directory, as
+  // create_tile_graph -fasta writes them.
+  //
+  FastaDir string
+
+  // Records of each FASTA file read so far, by
+  // file name then sequenceRecordName.
+  //
+  fasta_mu sync.Mutex
+  fasta_seq map[string]map[string]string
+
+  // Index for the /tiles endpoints (see tiles.go).
+  //
+  tile_mu sync.Mutex
+  tile_idx *tile_index
+}
+
+func New(db *sql.DB, fasta_dir string) *Server {
+  return &Server{ DB:db, FastaDir:fasta_dir, fasta_seq:make(map[string]map[string]string) }
+}
+
+// Register the GA4GH graph endpoints on mux.
+//
+func (s *Server) Register(mux *http.ServeMux) {
+  mux.HandleFunc("/sequences/search", s.handle(s.search_sequences))
+  mux.HandleFunc("/sequences/", s.handle(s.get_sequence))
+  mux.HandleFunc("/joins/search", s.handle(s.search_joins))
+  mux.HandleFunc("/alleles/search", s.handle(s.search_alleles))
+  mux.HandleFunc("/callsets/search", s.handle(s.search_callsets))
+  mux.HandleFunc("/subgraph/extract", s.handle(s.extract_subgraph))
+}
+
+// Error with the HTTP status to return it with.
+//
+type http_error struct {
+  status int
+  msg string
+}
+
+func (e *http_error) Error() string { return e.msg }
+
+func bad_request(format string, args ...interface{}) error {
+  return &http_error{ http.StatusBadRequest, fmt.Sprintf(format, args...) }
+}
+
+func not_found(format string, args ...interface{}) error {
+  return &http_error{ http.StatusNotFound, fmt.Sprintf(format, args...) }
+}
+
+// Wrap an endpoint, writing what it returns as JSON, or
+// the error as an ErrorResponse.
+//
+func (s *Server) handle(f func(*http.Request) (interface{}, error)) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "application/json")
+
+    resp,e := f(r)
+    if e!=nil {
+      status := http.StatusInternalServerError
+      if he,ok := e.(*http_error) ; ok { status = he.status }
+      w.WriteHeader(status)
+      json.NewEncoder(w).Encode(ErrorResponse{ ErrorCode:status, Message:e.Error() })
+      return
+    }
+
+    json.NewEncoder(w).Encode(resp)
+  }
+}
+
+// Decode the JSON body of a POST into req.  An empty body
+// leaves req as it is.
+//
+func decode(r *http.Request, req interface{}) error {
+  if r.Method!="POST" {
+    return &http_error{ http.StatusMethodNotAllowed, fmt.Sprintf("%s %s: use POST", r.Method, r.URL.Path) }
+  }
+  e := json.NewDecoder(r.Body).Decode(req)
+  if e!=nil && e!=io.EOF { return bad_request("invalid request: %v", e) }
+  return nil
+}
+
+// Parse an ID, "" giving ok false.
+//
+func parse_id(field, s string) (id int, ok bool, e error) {
+  if len(s)==0 { return 0, false, nil }
+  id,e = strconv.Atoi(s)
+  if e!=nil { return 0, false, bad_request("invalid %s '%s'", field, s) }
+  return id, true, nil
+}
+
+func id_str(id int) string { return strconv.Itoa(id) }
+
+func strand(fwd graphdb.Bool) string {
+  if fwd { return POS_STRAND }
+  return NEG_STRAND
+}
+
+// Rows [start,end) of n for the page, and the token of the
+// next page (nil on the last one).
+//
+func page(n, page_size int, token string) (start, end int, next *string, e error) {
+  if page_size<=0 { page_size = DEFAULT_PAGE_SIZE }
+  if len(token)>0 {
+    start,e = strconv.Atoi(token)
+    if e!=nil || start<0 { return 0, 0, nil, bad_request("invalid pageToken '%s'", token) }
+  }
+  if start>n { start = n }
+
+  end = start+page_size
+  if end<n {
+    t := strconv.Itoa(end)
+    next = &t
+  } else {
+    end = n
+  }
+  return start, end, next, nil
+}
+
+func read_ids(db *sql.DB, query string, args ...interface{}) ([]int, error) {
+  rows,e := db.Query(query, args...)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  ids := make([]int, 0, 1024)
+  for rows.Next() {
+    var id int
+    if e := rows.Scan(&id) ; e!=nil { return nil, e }
+    ids = append(ids, id)
+  }
+  return ids, rows.Err()
+}
+
+// Sequences
+//
+
+const sequence_cols = `Sequence.ID, Sequence.fastaID, Sequence.sequenceRecordName, Sequence.md5checksum, Sequence.length`
+
+func scan_sequence(sc interface{ Scan(...interface{}) error }) (graphdb.Sequence, error) {
+  var seq graphdb.Sequence
+  e := sc.Scan(&seq.Id, &seq.FastaId, &seq.SequenceRecordName, &seq.Md5Checksum, &seq.Length)
+  return seq, e
+}
+
+func sequence_json(seq graphdb.Sequence) Sequence {
+  return Sequence{ Id:id_str(seq.Id), Length:int64(seq.Length), SequenceRecordName:seq.SequenceRecordName,
+    Md5checksum:seq.Md5Checksum, FastaId:id_str(seq.FastaId) }
+}
+
+// Sequences of the ReferenceSet (those of its References)
+// or of the VariantSet (those on its joins), all of them
+// if neither is given.
+//
+func (s *Server) search_sequences(r *http.Request) (interface{}, error) {
+  var req SearchSequencesRequest
+  if e:=decode(r, &req) ; e!=nil { return nil, e }
+
+  rs_id,has_rs,e := parse_id("referenceSetId", req.ReferenceSetId)
+  if e!=nil { return nil, e }
+  vs_id,has_vs,e := parse_id("variantSetId", req.VariantSetId)
+  if e!=nil { return nil, e }
+
+  query := `SELECT ` + sequence_cols + ` FROM Sequence`
+  args := []interface{}{}
+  if has_rs {
+    query += ` WHERE Sequence.ID IN (SELECT Reference.sequenceID FROM Reference JOIN Reference_ReferenceSet_Join j ON j.referenceID=Reference.ID WHERE j.referenceSetID=?)`
+    args = append(args, rs_id)
+  }
+  if has_vs {
+    if has_rs { query += ` AND` } else { query += ` WHERE` }
+    query += ` Sequence.ID IN (SELECT side1SequenceID FROM GraphJoin g JOIN GraphJoin_VariantSet_Join j ON j.graphJoinID=g.ID WHERE j.variantSetID=?
+      UNION SELECT side2SequenceID FROM GraphJoin g JOIN GraphJoin_VariantSet_Join j ON j.graphJoinID=g.ID WHERE j.variantSetID=?)`
+    args = append(args, vs_id, vs_id)
+  }
+  query += ` ORDER BY Sequence.ID`
+
+  rows,e := s.DB.Query(query, args...)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  seqs := make([]graphdb.Sequence, 0, 1024)
+  for rows.Next() {
+    seq,e := scan_sequence(rows)
+    if e!=nil { return nil, e }
+    seqs = append(seqs, seq)
+  }
+  if e:=rows.Err() ; e!=nil { return nil, e }
+
+  start,end,next,e := page(len(seqs), req.PageSize, req.PageToken)
+  if e!=nil { return nil, e }
+
+  resp := SearchSequencesResponse{ Sequences:make([]Sequence, 0, end-start), NextPageToken:next }
+  for _,seq := range seqs[start:end] {
+    js := sequence_json(seq)
+    if req.ListBases {
+      bases,e := s.bases(seq)
+      if e!=nil { return nil, e }
+      js.Bases = &bases
+    }
+    resp.Sequences = append(resp.Sequences, js)
+  }
+  return resp, nil
+}
+
+func (s *Server) sequence(id int) (graphdb.Sequence, error) {
+  seq,e := scan_sequence(s.DB.QueryRow(`SELECT ` + sequence_cols + ` FROM Sequence WHERE ID=?`, id))
+  if e==sql.ErrNoRows { return seq, not_found("no Sequence %d", id) }
+  return seq, e
+}
+
+// /sequences/{id} and /sequences/{id}/bases.
+//
+func (s *Server) get_sequence(r *http.Request) (interface{}, error) {
+  parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/sequences/"), "/"), "/")
+  if len(parts)>2 || (len(parts)==2 && parts[1]!="bases") { return nil, not_found("no endpoint %s", r.URL.Path) }
+
+  id,e := strconv.Atoi(parts[0])
+  if e!=nil { return nil, bad_request("invalid sequence ID '%s'", parts[0]) }
+
+  seq,e := s.sequence(id)
+  if e!=nil { return nil, e }
+
+  if len(parts)==1 { return sequence_json(seq), nil }
+
+  var req GetSequenceBasesRequest
+  if r.Method=="POST" {
+    if e:=decode(r, &req) ; e!=nil { return nil, e }
+  }
+  for _,p := range []struct{ name string ; v **int64 }{ {"start", &req.Start}, {"end", &req.End} } {
+    q := r.URL.Query().Get(p.name)
+    if len(q)==0 { continue }
+    x,e := strconv.ParseInt(q, 10, 64)
+    if e!=nil { return nil, bad_request("invalid %s '%s'", p.name, q) }
+    *p.v = &x
+  }
+
+  start,end := int64(0), int64(seq.Length)
+  if req.Start!=nil { start = *req.Start }
+  if req.End!=nil { end = *req.End }
+  if start<0 || end>int64(seq.Length) || start>end {
+    return nil, bad_request("invalid range [%d,%d) of Sequence %d (length %d)", start, end, id, seq.Length)
+  }
+
+  bases,e := s.bases(seq)
+  if e!=nil { return nil, e }
+  return GetSequenceBasesResponse{ Offset:start, Sequence:bases[start:end] }, nil
+}
+
+// Bases of the Sequence, from its FASTA file.  Files are
+// read whole the first time one of their records is asked
+// for, unless they have a .fai index.
+//
+func (s *Server) bases(seq graphdb.Sequence) (string, error) {
+  var uri string
+  e := s.DB.QueryRow(`SELECT fastaURI FROM FASTA WHERE ID=?`, seq.FastaId).Scan(&uri)
+  if e==sql.ErrNoRows { return "", not_found("Sequence %d: no FASTA %d", seq.Id, seq.FastaId) }
+  if e!=nil { return "", e }
+
+  fn := strings.TrimPrefix(uri, "file://")
+  if !filepath.IsAbs(fn) && len(s.FastaDir)>0 { fn = filepath.Join(s.FastaDir, fn) }
+
+  if idx,e := fasta.ReadIndex(fn) ; e==nil && idx!=nil {
+    rec,e := fasta.ReadRecord(fn, seq.SequenceRecordName)
+    if e!=nil { return "", not_found("%v", e) }
+    return rec.Seq, nil
+  }
+
+  s.fasta_mu.Lock()
+  defer s.fasta_mu.Unlock()
+
+  recs,ok := s.fasta_seq[fn]
+  if !ok {
+    recs = make(map[string]string)
+    e := fasta.Each(fn, func(rec *fasta.Record) error {
+      recs[rec.Name] = rec.Seq
+      return nil
+    })
+    if e!=nil { return "", e }
+    s.fasta_seq[fn] = recs
+  }
+
+  bases,ok := recs[seq.SequenceRecordName]
+  if !ok { return "", not_found("%s: no FASTA record named '%s'", fn, seq.SequenceRecordName) }
+  return bases, nil
+}
+
+// Joins
+//
+
+// GraphJoin rows, only those of the ReferenceSet or VariantSet
+// if given (rs_id, vs_id >= 0), in ID order.
+//
+func (s *Server) graph_joins(rs_id, vs_id int, where string, args ...interface{}) ([]graphdb.GraphJoin, error) {
+  query := `SELECT ID, side1SequenceID, side1Position, side1StrandIsForward, side2SequenceID, side2Position, side2StrandIsForward FROM GraphJoin WHERE 1`
+  qargs := []interface{}{}
+  if rs_id>=0 {
+    query += ` AND ID IN (SELECT graphJoinID FROM GraphJoin_ReferenceSet_Join WHERE referenceSetID=?)`
+    qargs = append(qargs, rs_id)
+  }
+  if vs_id>=0 {
+    query += ` AND ID IN (SELECT graphJoinID FROM GraphJoin_VariantSet_Join WHERE variantSetID=?)`
+    qargs = append(qargs, vs_id)
+  }
+  if len(where)>0 {
+    query += ` AND ` + where
+    qargs = append(qargs, args...)
+  }
+  query += ` ORDER BY ID`
+
+  rows,e := s.DB.Query(query, qargs...)
+  if e!=nil { return nil, e }
+  defer rows.Close()
+
+  joins := make([]graphdb.GraphJoin, 0, 1024)
+  for rows.Next() {
+    var gj graphdb.GraphJoin
+    var fwd1, fwd2 graphdb.Bool
+    if e := rows.Scan(&gj.Id, &gj.Side1SequenceId, &gj.Side1Position, &fwd1, &gj.Side2SequenceId, &gj.Side2Position, &fwd2) ; e!=nil {
+      return nil, e
+    }
+    gj.Side1StrandIsForward, gj.Side2StrandIsForward = bool(fwd1), bool(fwd2)
+    joins = append(joins, gj)
+  }
+  return joins, rows.Err()
+}
+
+func join_json(gj graphdb.GraphJoin) Join {
+  return Join{ Id:id_str(gj.Id),
+    Side1:Side{ Base:Position{ SequenceId:id_str(gj.Side1SequenceId), Position:int64(gj.Side1Position) }, Strand:strand(graphdb.Bool(gj.Side1StrandIsForward)) },
+    Side2:Side{ Base:Position{ SequenceId:id_str(gj.Side2SequenceId), Position:int64(gj.Side2Position) }, Strand:strand(graphdb.Bool(gj.Side2StrandIsForward)) } }
+}
+
+// Both sets are optional, -1 if not given.
+//
+func set_ids(rs, vs string) (rs_id, vs_id int, e error) {
+  rs_id,vs_id = -1,-1
+  if id,ok,e := parse_id("referenceSetId", rs) ; e!=nil {
+    return 0, 0, e
+  } else if ok {
+    rs_id = id
+  }
+  if id,ok,e := parse_id("variantSetId", vs) ; e!=nil {
+    return 0, 0, e
+  } else if ok {
+    vs_id = id
+  }
+  return rs_id, vs_id, nil
+}
+
+// Joins with a side on the Sequence, at a position in
+// [start,end) and on strand if given.
+//
+func (s *Server) search_joins(r *http.Request) (interface{}, error) {
+  var req SearchJoinsRequest
+  if e:=decode(r, &req) ; e!=nil { return nil, e }
+
+  rs_id,vs_id,e := set_ids(req.ReferenceSetId, req.VariantSetId)
+  if e!=nil { return nil, e }
+  if req.Strand!="" && req.Strand!=POS_STRAND && req.Strand!=NEG_STRAND {
+    return nil, bad_request("invalid strand '%s'", req.Strand)
+  }
+
+  seq_id,has_seq,e := parse_id("sequenceId", req.SequenceId)
+  if e!=nil { return nil, e }
+
+  var joins []graphdb.GraphJoin
+  if has_seq {
+    joins,e = s.graph_joins(rs_id, vs_id, `(side1SequenceID=? OR side2SequenceID=?)`, seq_id, seq_id)
+  } else {
+    joins,e = s.graph_joins(rs_id, vs_id, "")
+  }
+  if e!=nil { return nil, e }
+
+  on_side := func(seq, pos int, fwd bool) bool {
+    if has_seq && seq!=seq_id { return false }
+    if req.Start!=nil && int64(pos) < *req.Start { return false }
+    if req.End!=nil && int64(pos) >= *req.End { return false }
+    if req.Strand!="" && strand(graphdb.Bool(fwd))!=req.Strand { return false }
+    return true
+  }
+
+  matched := make([]graphdb.GraphJoin, 0, len(joins))
+  for _,gj := range joins {
+    if on_side(gj.Side1SequenceId, gj.Side1Position, gj.Side1StrandIsForward) ||
+       on_side(gj.Side2SequenceId, gj.Side2Position, gj.Side2StrandIsForward) {
+      matched = append(matched, gj)
+    }
+  }
+
+  start,end,next,e := page(len(matched), req.PageSize, req.PageToken)
+  if e!=nil { return nil, e }
+
+  resp := SearchJoinsResponse{ Joins:make([]Join, 0, end-start), NextPageToken:next }
+  for _,gj := range matched[start:end] { resp.Joins = append(resp.Joins, join_json(gj)) }
+  return resp, nil
+}
+
+// Alleles
+//
+
+// Alleles of the VariantSet (all of them if not given) whose
+// path goes through the Sequence, overlapping [start,end) if
+// given, in ID order.
+//
+func (s *Server) search_alleles(r *http.Request) (interface{}, error) {
+  var req SearchAllelesRequest
+  if e:=decode(r, &req) ; e!=nil { return nil, e }
+
+  vs_id,has_vs,e := parse_id("variantSetId", req.VariantSetId)
+  if e!=nil { return nil, e }
+  seq_id,has_seq,e := parse_id("sequenceId", req.SequenceId)
+  if e!=nil { return nil, e }
+
+  query := `SELECT ID FROM Allele WHERE 1`
+  args := []interface{}{}
+  if has_vs {
+    query += ` AND variantSetID=?`
+    args = append(args, vs_id)
+  }
+  if has_seq {
+    query += ` AND ID IN (SELECT alleleID FROM AllelePathItem WHERE sequenceID=?`
+    args = append(args, seq_id)
+    if req.End!=nil {
+      query += ` AND start<?`
+      args = append(args, *req.End)
+    }
+    if req.Start!=nil {
+      query += ` AND start+length>?`
+      args = append(args, *req.Start)
+    }
+    query += `)`
+  }
+  query += ` ORDER BY ID`
+
+  ids,e := read_ids(s.DB, query, args...)
+  if e!=nil { return nil, e }
+
+  start,end,next,e := page(len(ids), req.PageSize, req.PageToken)
+  if e!=nil { return nil, e }
+
+  resp := SearchAllelesResponse{ Alleles:make([]Allele, 0, end-start), NextPageToken:next }
+  for _,id := range ids[start:end] {
+    a,e := s.allele(id)
+    if e!=nil { return nil, e }
+    resp.Alleles = append(resp.Alleles, a)
+  }
+  return resp, nil
+}
+
+func (s *Server) allele(id int) (Allele, error) {
+  var vs_id int
+  var name string
+  e := s.DB.QueryRow(`SELECT variantSetID, COALESCE(name,'') FROM Allele WHERE ID=?`, id).Scan(&vs_id, &name)
+  if e!=nil { return Allele{}, e }
+
+  a := Allele{ Id:id_str(id), VariantSetId:id_str(vs_id), Name:name, Path:Path{ Segments:make([]Segment, 0, 64) } }
+
+  rows,e := s.DB.Query(`SELECT sequenceID, start, length, strandIsForward FROM AllelePathItem WHERE alleleID=? ORDER BY pathItemIndex`, id)
+  if e!=nil { return a, e }
+  defer rows.Close()
+
+  for rows.Next() {
+    var seq_id int
+    var pos, length int64
+    var fwd graphdb.Bool
+    if e := rows.Scan(&seq_id, &pos, &length, &fwd) ; e!=nil { return a, e }
+    a.Path.Segments = append(a.Path.Segments, Segment{
+      Start:Side{ Base:Position{ SequenceId:id_str(seq_id), Position:pos }, Strand:strand(fwd) },
+      Length:length })
+  }
+  return a, rows.Err()
+}
+
+// CallSets
+//
+
+// CallSets in any of the VariantSets (all of them if none are
+// given), only the one called name if given, in ID order.
+//
+func (s *Server) search_callsets(r *http.Request) (interface{}, error) {
+  var req SearchCallSetsRequest
+  if e:=decode(r, &req) ; e!=nil { return nil, e }
+
+  want_vs := make(map[int]bool)
+  for _,v := range req.VariantSetIds {
+    id,_,e := parse_id("variantSetId", v)
+    if e!=nil { return nil, e }
+    want_vs[id] = true
+  }
+
+  cs_vs := make(map[int][]int)
+  rows,e := s.DB.Query(`SELECT variantSetID, callSetID FROM VariantSet_CallSet_Join ORDER BY variantSetID`)
+  if e!=nil { return nil, e }
+  for rows.Next() {
+    var vs_id, cs_id int
+    if e := rows.Scan(&vs_id, &cs_id) ; e!=nil { rows.Close() ; return nil, e }
+    cs_vs[cs_id] = append(cs_vs[cs_id], vs_id)
+  }
+  rows.Close()
+  if e:=rows.Err() ; e!=nil { return nil, e }
+
+  css,e := graphdb.ReadCallSet(s.DB)
+  if e!=nil { return nil, e }
+  sort.Sort(CallSetOrder(css))
+
+  matched := make([]CallSet, 0, len(css))
+  for _,c := range css {
+    if len(req.Name)>0 && c.Name!=req.Name { continue }
+
+    in_vs := len(want_vs)==0
+    vs_ids := make([]string, 0, len(cs_vs[c.Id]))
+    for _,vs_id := range cs_vs[c.Id] {
+      if want_vs[vs_id] { in_vs = true }
+      vs_ids = append(vs_ids, id_str(vs_id))
+    }
+    if !in_vs { continue }
+
+    matched = append(matched, CallSet{ Id:id_str(c.Id), Name:c.Name, SampleId:c.SampleId, VariantSetIds:vs_ids })
+  }
+
+  start,end,next,e := page(len(matched), req.PageSize, req.PageToken)
+  if e!=nil { return nil, e }
+  return SearchCallSetsResponse{ CallSets:matched[start:end], NextPageToken:next }, nil
+}
+
+type CallSetOrder []graphdb.CallSet
+
+func (o CallSetOrder) Len() int { return len(o) }
+func (o CallSetOrder) Swap(i, j int) { o[i],o[j] = o[j],o[i] }
+func (o CallSetOrder) Less(i, j int) bool { return o[i].Id < o[j].Id }
+
+// Subgraph
+//
+
+// The Sequences within Radius joins of the one at Position,
+// as whole segments, and the joins between them.  Only the
+// joins of the ReferenceSet or VariantSet are followed if
+// one is given.
+//
+func (s *Server) extract_subgraph(r *http.Request) (interface{}, error) {
+  var req ExtractSubgraphRequest
+  if e:=decode(r, &req) ; e!=nil { return nil, e }
+
+  rs_id,vs_id,e := set_ids(req.ReferenceSetId, req.VariantSetId)
+  if e!=nil { return nil, e }
+
+  seq_id,ok,e := parse_id("sequenceId", req.Position.SequenceId)
+  if e!=nil { return nil, e }
+  if !ok { return nil, bad_request("no position.sequenceId") }
+  if req.Radius<0 { return nil, bad_request("invalid radius %d", req.Radius) }
+
+  if _,e := s.sequence(seq_id) ; e!=nil { return nil, e }
+
+  joins,e := s.graph_joins(rs_id, vs_id, "")
+  if e!=nil { return nil, e }
+
+  adj := make(map[int][]int)
+  for _,gj := range joins {
+    adj[gj.Side1SequenceId] = append(adj[gj.Side1SequenceId], gj.Side2SequenceId)
+    adj[gj.Side2SequenceId] = append(adj[gj.Side2SequenceId], gj.Side1SequenceId)
+  }
+
+  dist := map[int]int{ seq_id:0 }
+  queue := []int{ seq_id }
+  for len(queue)>0 {
+    cur := queue[0]
+    queue = queue[1:]
+    if dist[cur]==req.Radius { continue }
+    for _,nxt := range adj[cur] {
+      if _,seen := dist[nxt] ; seen { continue }
+      dist[nxt] = dist[cur]+1
+      queue = append(queue, nxt)
+    }
+  }
+
+  ids := make([]int, 0, len(dist))
+  for id := range dist { ids = append(ids, id) }
+  sort.Ints(ids)
+
+  resp := ExtractSubgraphResponse{ Segments:make([]Segment, 0, len(ids)), Joins:make([]Join, 0, len(ids)) }
+  for _,id := range ids {
+    seq,e := s.sequence(id)
+    if e!=nil { return nil, e }
+    resp.Segments = append(resp.Segments, Segment{
+      Start:Side{ Base:Position{ SequenceId:id_str(id), Position:0 }, Strand:POS_STRAND },
+      Length:int64(seq.Length) })
+  }
+  for _,gj := range joins {
+    _,ok1 := dist[gj.Side1SequenceId]
+    _,ok2 := dist[gj.Side2SequenceId]
+    if ok1 && ok2 { resp.Joins = append(resp.Joins, join_json(gj)) }
+  }
+  return resp, nil
+}
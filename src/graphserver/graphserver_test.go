@@ -0,0 +1,100 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package graphserver
+
+import "os"
+import "testing"
+import "io/ioutil"
+import "path/filepath"
+import "encoding/json"
+import "net/http"
+import "net/http/httptest"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+
+// Build a database in out-data/ of a scratch directory the way the
+// repo's scripts do (create_tile_graph -db out-data/x.sqlite3 -fasta
+// out-data/x.fa), so the fastaURI is relative to the directory it
+// was built in, not to the database.
+//
+func make_subdir_db(t *testing.T) (dir, db_fn string) {
+  dir,e := ioutil.TempDir("", "graphserver")
+  if e!=nil { t.Fatal(e) }
+
+  if e:=os.Mkdir(filepath.Join(dir, "out-data"), 0755) ; e!=nil { t.Fatal(e) }
+  fa := ">seq1\nacgtacgtac\n"
+  if e:=ioutil.WriteFile(filepath.Join(dir, "out-data", "x.fa"), []byte(fa), 0644) ; e!=nil { t.Fatal(e) }
+
+  db_fn = filepath.Join(dir, "out-data", "x.sqlite3")
+  db,e := graphdb.Open(db_fn)
+  if e!=nil { t.Fatal(e) }
+  defer db.Close()
+
+  tx,e := db.Begin()
+  if e!=nil { t.Fatal(e) }
+  if e:=graphdb.InsertFASTA(tx, []graphdb.FASTA{{Id:1, FastaURI:"out-data/x.fa"}}) ; e!=nil { t.Fatal(e) }
+  seq := []graphdb.Sequence{{Id:1, FastaId:1, SequenceRecordName:"seq1", Md5Checksum:"-", Length:10}}
+  if e:=graphdb.InsertSequence(tx, seq) ; e!=nil { t.Fatal(e) }
+  if e:=tx.Commit() ; e!=nil { t.Fatal(e) }
+
+  return dir, db_fn
+}
+
+func get_bases(t *testing.T, srv *Server, url string) (int, GetSequenceBasesResponse) {
+  mux := http.NewServeMux()
+  srv.Register(mux)
+
+  w := httptest.NewRecorder()
+  mux.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+
+  var resp GetSequenceBasesResponse
+  if w.Code==http.StatusOK {
+    if e:=json.Unmarshal(w.Body.Bytes(), &resp) ; e!=nil { t.Fatal(e) }
+  }
+  return w.Code, resp
+}
+
+func TestBasesSubdirDB(t *testing.T) {
+  dir,db_fn := make_subdir_db(t)
+  defer os.RemoveAll(dir)
+
+  cwd,e := os.Getwd()
+  if e!=nil { t.Fatal(e) }
+  defer os.Chdir(cwd)
+
+  db,e := graphdb.OpenReadOnly(db_fn)
+  if e!=nil { t.Fatal(e) }
+  defer db.Close()
+
+  // Run from the directory the database was built in, as
+  // tilegraph_server is without -fasta-dir.
+  //
+  if e:=os.Chdir(dir) ; e!=nil { t.Fatal(e) }
+  code,resp := get_bases(t, New(db, ""), "/sequences/1/bases?start=2&end=6")
+  if code!=http.StatusOK { t.Fatalf("got status %d", code) }
+  if resp.Sequence!="gtac" { t.Errorf("got bases '%s', expected 'gtac'", resp.Sequence) }
+
+  // And from somewhere else, with -fasta-dir.
+  //
+  if e:=os.Chdir(os.TempDir()) ; e!=nil { t.Fatal(e) }
+  code,resp = get_bases(t, New(db, dir), "/sequences/1/bases?start=0&end=4")
+  if code!=http.StatusOK { t.Fatalf("got status %d with FastaDir", code) }
+  if resp.Sequence!="acgt" { t.Errorf("got bases '%s' with FastaDir, expected 'acgt'", resp.Sequence) }
+}
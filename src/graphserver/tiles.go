@@ -0,0 +1,297 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package graphserver
+
+// Tile range endpoints for lightning_graph_viz, so the viewer can
+// fetch the part of the graph on screen instead of the whole
+// database:
+//
+//   GET /tiles/paths   tile paths in the database and their step ranges
+//   GET /tiles/range   Sequences, joins and sample paths of a step range
+//
+// /tiles/range takes path, start and end (hex steps, inclusive,
+// as in the -s and -e of the other tools), bases=1 to include the
+// bases of each Sequence, callset=NAME (can be given more than
+// once) for the path items of those samples in the range, and
+// paths_only=1 to leave out the Sequences and joins.  Only the
+// joins with both sides in the range are returned, so a viewer
+// fetching blocks of steps should overlap them by a step.
+//
+// The step of a Sequence comes from its sequenceRecordName
+// ([md5sum].[path].[step]...).  The Sequences Reference rows
+// point to aren't tiles so are left out.  The index of
+// Sequences and joins by step is rebuilt when rows are added
+// (tile_graph_add) while the server is running.
+
+import "fmt"
+import "sort"
+import "strconv"
+import "strings"
+import "net/http"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+
+// Most steps a /tiles/range request can ask for.
+//
+const MAX_TILE_STEPS = 4096
+
+type TilePath struct {
+  Path string `json:"path"`
+  MinStep int `json:"minStep"`
+  MaxStep int `json:"maxStep"`
+  Sequences int `json:"sequences"`
+}
+
+type TilePathsResponse struct {
+  Paths []TilePath `json:"paths"`
+}
+
+type TileSequence struct {
+  Id int `json:"id"`
+  Name string `json:"name"`
+  Md5checksum string `json:"md5checksum"`
+  Length int `json:"length"`
+  Step int `json:"step"`
+  Bases *string `json:"bases,omitempty"`
+}
+
+type TileJoin struct {
+  Id int `json:"id"`
+  Side1SequenceId int `json:"side1SequenceId"`
+  Side1Position int `json:"side1Position"`
+  Side1StrandIsForward bool `json:"side1StrandIsForward"`
+  Side2SequenceId int `json:"side2SequenceId"`
+  Side2Position int `json:"side2Position"`
+  Side2StrandIsForward bool `json:"side2StrandIsForward"`
+}
+
+// Paths maps sample (CallSet) name to Allele name to the IDs of
+// the Sequences of its path in the range, in path order.
+//
+type TileRangeResponse struct {
+  Path string `json:"path"`
+  Start int `json:"start"`
+  End int `json:"end"`
+  Sequences []TileSequence `json:"sequences"`
+  Joins []TileJoin `json:"joins"`
+  Paths map[string]map[string][]int `json:"paths"`
+}
+
+type tile_seq struct {
+  seq graphdb.Sequence
+  path string
+  step int
+}
+
+type TileSeqOrder []tile_seq
+
+func (o TileSeqOrder) Len() int { return len(o) }
+func (o TileSeqOrder) Swap(i, j int) { o[i],o[j] = o[j],o[i] }
+func (o TileSeqOrder) Less(i, j int) bool {
+  if o[i].step!=o[j].step { return o[i].step < o[j].step }
+  return o[i].seq.Id < o[j].seq.Id
+}
+
+// Sequences of each tile path in step order, and the joins
+// of each Sequence.  max_seq and max_gj are the largest IDs
+// when it was built.
+//
+type tile_index struct {
+  max_seq, max_gj int
+  paths map[string][]tile_seq
+  seq_joins map[int][]graphdb.GraphJoin
+}
+
+// Register the /tiles endpoints on mux.
+//
+func (s *Server) RegisterTiles(mux *http.ServeMux) {
+  mux.HandleFunc("/tiles/paths", s.handle(s.tile_paths))
+  mux.HandleFunc("/tiles/range", s.handle(s.tile_range))
+}
+
+// Path and step of a tile Sequence, ok false for names that
+// don't have them.
+//
+func tile_step(name string) (path string, step int, ok bool) {
+  parts := strings.SplitN(name, ".", 4)
+  if len(parts)<4 { return "", 0, false }
+  x,e := strconv.ParseInt(parts[2], 16, 64)
+  if e!=nil { return "", 0, false }
+  return parts[1], int(x), true
+}
+
+// The tile index, rebuilt if rows have been added since it
+// was last built.
+//
+func (s *Server) tiles() (*tile_index, error) {
+  max_seq,e := graphdb.MaxId(s.DB, "Sequence")
+  if e!=nil { return nil, e }
+  max_gj,e := graphdb.MaxId(s.DB, "GraphJoin")
+  if e!=nil { return nil, e }
+
+  s.tile_mu.Lock()
+  defer s.tile_mu.Unlock()
+
+  if s.tile_idx!=nil && s.tile_idx.max_seq==max_seq && s.tile_idx.max_gj==max_gj {
+    return s.tile_idx, nil
+  }
+
+  idx := &tile_index{ max_seq:max_seq, max_gj:max_gj,
+    paths:make(map[string][]tile_seq), seq_joins:make(map[int][]graphdb.GraphJoin) }
+
+  seqs,e := graphdb.ReadSequence(s.DB)
+  if e!=nil { return nil, e }
+  ref_seq,e := graphdb.ReferenceSequenceIds(s.DB)
+  if e!=nil { return nil, e }
+  for _,seq := range seqs {
+    if ref_seq[seq.Id] { continue }
+    path,step,ok := tile_step(seq.SequenceRecordName)
+    if !ok { continue }
+    idx.paths[path] = append(idx.paths[path], tile_seq{ seq:seq, path:path, step:step })
+  }
+  for path := range idx.paths { sort.Sort(TileSeqOrder(idx.paths[path])) }
+
+  joins,e := s.graph_joins(-1, -1, "")
+  if e!=nil { return nil, e }
+  for _,gj := range joins {
+    idx.seq_joins[gj.Side1SequenceId] = append(idx.seq_joins[gj.Side1SequenceId], gj)
+    if gj.Side2SequenceId!=gj.Side1SequenceId {
+      idx.seq_joins[gj.Side2SequenceId] = append(idx.seq_joins[gj.Side2SequenceId], gj)
+    }
+  }
+
+  s.tile_idx = idx
+  return idx, nil
+}
+
+func (s *Server) tile_paths(r *http.Request) (interface{}, error) {
+  idx,e := s.tiles()
+  if e!=nil { return nil, e }
+
+  names := make([]string, 0, len(idx.paths))
+  for path := range idx.paths { names = append(names, path) }
+  sort.Strings(names)
+
+  resp := TilePathsResponse{ Paths:make([]TilePath, 0, len(names)) }
+  for _,path := range names {
+    seqs := idx.paths[path]
+    resp.Paths = append(resp.Paths, TilePath{ Path:path, MinStep:seqs[0].step, MaxStep:seqs[len(seqs)-1].step, Sequences:len(seqs) })
+  }
+  return resp, nil
+}
+
+func (s *Server) tile_range(r *http.Request) (interface{}, error) {
+  q := r.URL.Query()
+
+  path := q.Get("path")
+  if len(path)==0 { return nil, bad_request("no path") }
+
+  parse_step := func(field string) (int, error) {
+    x,e := strconv.ParseInt(q.Get(field), 16, 64)
+    if e!=nil { return 0, bad_request("invalid %s '%s' (expected a hex step)", field, q.Get(field)) }
+    return int(x), nil
+  }
+  start,e := parse_step("start")
+  if e!=nil { return nil, e }
+  end,e := parse_step("end")
+  if e!=nil { return nil, e }
+  if end<start { return nil, bad_request("end %x before start %x", end, start) }
+  if end-start+1 > MAX_TILE_STEPS { return nil, bad_request("range of %d steps (at most %d)", end-start+1, MAX_TILE_STEPS) }
+
+  idx,e := s.tiles()
+  if e!=nil { return nil, e }
+  seqs,ok := idx.paths[path]
+  if !ok { return nil, not_found("no tile path %s", path) }
+
+  lo := sort.Search(len(seqs), func(i int) bool { return seqs[i].step>=start })
+  hi := sort.Search(len(seqs), func(i int) bool { return seqs[i].step>end })
+  in_range := seqs[lo:hi]
+
+  in_seq := make(map[int]bool)
+  for _,ts := range in_range { in_seq[ts.seq.Id] = true }
+
+  resp := TileRangeResponse{ Path:path, Start:start, End:end,
+    Sequences:[]TileSequence{}, Joins:[]TileJoin{}, Paths:make(map[string]map[string][]int) }
+
+  if q.Get("paths_only")!="1" {
+    seen := make(map[int]bool)
+    for _,ts := range in_range {
+      t := TileSequence{ Id:ts.seq.Id, Name:ts.seq.SequenceRecordName, Md5checksum:ts.seq.Md5Checksum, Length:ts.seq.Length, Step:ts.step }
+      if q.Get("bases")=="1" {
+        bases,e := s.bases(ts.seq)
+        if e!=nil { return nil, e }
+        t.Bases = &bases
+      }
+      resp.Sequences = append(resp.Sequences, t)
+
+      for _,gj := range idx.seq_joins[ts.seq.Id] {
+        if seen[gj.Id] || !in_seq[gj.Side1SequenceId] || !in_seq[gj.Side2SequenceId] { continue }
+        seen[gj.Id] = true
+        resp.Joins = append(resp.Joins, TileJoin{ Id:gj.Id,
+          Side1SequenceId:gj.Side1SequenceId, Side1Position:gj.Side1Position, Side1StrandIsForward:gj.Side1StrandIsForward,
+          Side2SequenceId:gj.Side2SequenceId, Side2Position:gj.Side2Position, Side2StrandIsForward:gj.Side2StrandIsForward })
+      }
+    }
+    sort.Sort(TileJoinOrder(resp.Joins))
+  }
+
+  for _,name := range q["callset"] {
+    paths,e := s.sample_paths(name, in_seq)
+    if e!=nil { return nil, e }
+    resp.Paths[name] = paths
+  }
+
+  return resp, nil
+}
+
+type TileJoinOrder []TileJoin
+
+func (o TileJoinOrder) Len() int { return len(o) }
+func (o TileJoinOrder) Swap(i, j int) { o[i],o[j] = o[j],o[i] }
+func (o TileJoinOrder) Less(i, j int) bool { return o[i].Id < o[j].Id }
+
+// Sequence IDs of the paths of each Allele of the sample,
+// only those in in_seq.
+//
+func (s *Server) sample_paths(name string, in_seq map[int]bool) (map[string][]int, error) {
+  var n int
+  if e := s.DB.QueryRow(`SELECT count(*) FROM CallSet WHERE name=?`, name).Scan(&n) ; e!=nil { return nil, e }
+  if n==0 { return nil, not_found("no CallSet named %s", name) }
+
+  rows,e := s.DB.Query(`SELECT Allele.name, AllelePathItem.sequenceID
+    FROM CallSet
+    JOIN AlleleCall ON AlleleCall.callSetID=CallSet.ID
+    JOIN Allele ON Allele.ID=AlleleCall.alleleID
+    JOIN AllelePathItem ON AllelePathItem.alleleID=Allele.ID
+    WHERE CallSet.name=?
+    ORDER BY Allele.ID, AllelePathItem.pathItemIndex`, name)
+  if e!=nil { return nil, fmt.Errorf("CallSet %s: %v", name, e) }
+  defer rows.Close()
+
+  paths := make(map[string][]int)
+  for rows.Next() {
+    var allele string
+    var seq_id int
+    if e := rows.Scan(&allele, &seq_id) ; e!=nil { return nil, e }
+    if _,ok := paths[allele] ; !ok { paths[allele] = []int{} }
+    if in_seq[seq_id] { paths[allele] = append(paths[allele], seq_id) }
+  }
+  return paths, rows.Err()
+}
@@ -0,0 +1,347 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Create a tag set for a reference region, the CSV of:
+//
+//   path.step,tag_sequence
+//
+// that tileset2fj and vcf2fj read.
+//
+// A 24mer can be a tag if it:
+//
+//  - has only a, c, g and t (no no-calls or other codes)
+//  - has at least --min-complexity distinct 3mers (out of 22),
+//    which rules out homopolymers and short repeats
+//  - occurs once in the region, counting both strands
+//  - occurs at most once in the --wide reference, if given,
+//    counting both strands.  The region is usually part of the
+//    wider reference so one occurrence is allowed.
+//
+// Tags are picked in order along the region.  The first tag is the
+// first candidate and each following tag is the first candidate
+// at least --spacing bases past the end of the previous one, so
+// tile bodies are about --spacing long (longer where there are no
+// candidates).  Tags are numbered from --step-start on --path, both
+// in hex.
+//
+// example usage:
+//
+//  ./tagset-gen -i chr17_region.fa --path 2c5 --wide hg38.fa.gz -o 2c5.tagset
+//
+
+package main
+
+import "fmt"
+import "os"
+import "log"
+import "runtime"
+import "runtime/pprof"
+
+import "io"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fasta"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+var gProfileFlag bool
+var gProfileFile string = "tagset-gen.pprof"
+
+var gMemProfileFlag bool
+var gMemProfileFile string = "tagset-gen.mprof"
+
+func base_code(b byte) uint64 {
+  switch b {
+  case 'a','A': return 0
+  case 'c','C': return 1
+  case 'g','G': return 2
+  case 't','T': return 3
+  }
+  return 4
+}
+
+// Call f with the start and 2 bit encoding of every 24mer of
+// seq made up of only a, c, g and t.  The encoding is of the
+// lesser of the 24mer and its reverse complement so both strands
+// count as the same 24mer.
+//
+func each_kmer(seq string, f func(pos int, code uint64)) {
+  K := uint(fastj.TAGLEN)
+  mask := (uint64(1)<<(2*K)) - 1
+
+  var fwd,rev uint64
+  n := uint(0)
+
+  for i:=0; i<len(seq); i++ {
+    c := base_code(seq[i])
+    if c>3 {
+      n=0
+      continue
+    }
+
+    fwd = ((fwd<<2) | c) & mask
+    rev = (rev>>2) | ((3-c)<<(2*(K-1)))
+    if n<K { n++ }
+    if n<K { continue }
+
+    code := fwd
+    if rev<code { code = rev }
+    f(i+1-int(K), code)
+  }
+}
+
+// Number of distinct 3mers in s.
+//
+func complexity(s string) int {
+  seen := make(map[string]bool)
+  for i:=0; i+3<=len(s); i++ {
+    seen[s[i:i+3]] = true
+  }
+  return len(seen)
+}
+
+// Tag candidates in the region, by start position.
+//
+func find_candidates(seq string, min_complexity int, wide_fns []string) ([]bool, error) {
+  count := make(map[uint64]int)
+  each_kmer(seq, func(pos int, code uint64) { count[code]++ })
+
+  cand := make([]bool, len(seq))
+  code_at := make(map[int]uint64)
+  each_kmer(seq, func(pos int, code uint64) {
+    if count[code]!=1 { return }
+    if complexity(seq[pos:pos+fastj.TAGLEN]) < min_complexity { return }
+    cand[pos] = true
+    code_at[pos] = code
+  })
+
+  if len(wide_fns)==0 { return cand, nil }
+
+  wide_count := make(map[uint64]int)
+  for _,code := range code_at { wide_count[code] = 0 }
+
+  for i:=0; i<len(wide_fns); i++ {
+    e := fasta.Each(wide_fns[i], func(rec *fasta.Record) error {
+      if gVerboseFlag { fmt.Fprintf(os.Stderr, "# scanning %s %s\n", wide_fns[i], rec.Name) }
+      each_kmer(rec.Seq, func(pos int, code uint64) {
+        if n,ok := wide_count[code] ; ok { wide_count[code] = n+1 }
+      })
+      return nil
+    })
+    if e!=nil { return nil, e }
+  }
+
+  for pos,code := range code_at {
+    if wide_count[code]>1 { cand[pos] = false }
+  }
+
+  return cand, nil
+}
+
+func gen_tagset(ofp io.Writer, seq string, cand []bool, path, step_start, spacing int) int {
+  n:=0
+  next := 0
+
+  for p:=0; p+fastj.TAGLEN<=len(seq); p++ {
+    if p<next || !cand[p] { continue }
+
+    pos := fastj.TilePos{ Path: path, Step: step_start+n }
+    fmt.Fprintf(ofp, "%s,%s\n", pos, seq[p:p+fastj.TAGLEN])
+
+    n++
+    next = p + fastj.TAGLEN + spacing
+  }
+
+  return n
+}
+
+func parse_hex_flag(c *cli.Context, name string) int {
+  v,e := strconv.ParseInt(c.String(name), 16, 32)
+  if e!=nil || v<0 {
+    fmt.Fprintf(os.Stderr, "invalid --%s '%s' (expected hex)\n", name, c.String(name))
+    os.Exit(1)
+  }
+  return int(v)
+}
+
+func _main( c *cli.Context ) {
+
+  if c.String("input") == "" {
+    fmt.Fprintf( os.Stderr, "Input required, exiting\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  path := parse_hex_flag(c, "path")
+  step_start := parse_hex_flag(c, "step-start")
+
+  if c.Bool( "pprof" ) {
+    gProfileFlag = true
+    gProfileFile = c.String("pprof-file")
+  }
+
+  if c.Bool( "mprof" ) {
+    gMemProfileFlag = true
+    gMemProfileFile = c.String("mprof-file")
+  }
+
+  gVerboseFlag = c.Bool("Verbose")
+
+  if c.Int("max-procs") > 0 {
+    runtime.GOMAXPROCS( c.Int("max-procs") )
+  }
+
+  if gProfileFlag {
+    prof_f,err := os.Create( gProfileFile )
+    if err != nil {
+      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
+      os.Exit(2)
+    }
+
+    pprof.StartCPUProfile( prof_f )
+    defer pprof.StopCPUProfile()
+  }
+
+  rec,err := fasta.ReadRecord( c.String("input"), c.String("ref-name") )
+  if err!=nil { log.Fatal(err) }
+  seq := strings.ToLower(rec.Seq)
+
+  cand,err := find_candidates(seq, c.Int("min-complexity"), c.StringSlice("wide"))
+  if err!=nil { log.Fatal(err) }
+
+  out,err := autoio.CreateWriter( c.String("output") )
+  if err!=nil { log.Fatal(err) }
+  defer func() { out.Flush() ; out.Close() }()
+
+  n := gen_tagset(out.Writer, seq, cand, path, step_start, c.Int("spacing"))
+
+  if gVerboseFlag {
+    fmt.Fprintf(os.Stderr, "# %d tags over %d bases\n", n, len(seq))
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "tagset-gen"
+  app.Usage = "Pick unique 24mer tags from a reference region to make a tag set"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringFlag{
+      Name: "input, i",
+      Usage: "INPUT reference FASTA of the region",
+    },
+
+    cli.StringFlag{
+      Name: "ref-name",
+      Usage: "Name of the FASTA record to use (default first)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "wide, w",
+      Value: &cli.StringSlice{},
+      Usage: "Wider reference FASTA tags must also be unique in (can be specified more than once)",
+    },
+
+    cli.StringFlag{
+      Name: "path, p",
+      Value: "0",
+      Usage: "Tile path (hex)",
+    },
+
+    cli.StringFlag{
+      Name: "step-start",
+      Value: "0",
+      Usage: "Step of the first tag (hex)",
+    },
+
+    cli.IntFlag{
+      Name: "spacing",
+      Value: 200,
+      Usage: "Minimum number of bases between tags",
+    },
+
+    cli.IntFlag{
+      Name: "min-complexity",
+      Value: 10,
+      Usage: "Minimum number of distinct 3mers in a tag",
+    },
+
+    cli.StringFlag{
+      Name: "output, o",
+      Value: "-",
+      Usage: "OUTPUT tag set CSV (default stdout)",
+    },
+
+    cli.IntFlag{
+      Name: "max-procs, N",
+      Value: -1,
+      Usage: "MAXPROCS",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+
+    cli.BoolFlag{
+      Name: "pprof",
+      Usage: "Profile usage",
+    },
+
+    cli.StringFlag{
+      Name: "pprof-file",
+      Value: gProfileFile,
+      Usage: "Profile File",
+    },
+
+    cli.BoolFlag{
+      Name: "mprof",
+      Usage: "Profile memory usage",
+    },
+
+    cli.StringFlag{
+      Name: "mprof-file",
+      Value: gMemProfileFile,
+      Usage: "Profile Memory File",
+    },
+
+  }
+
+  app.Run( os.Args )
+
+  if gMemProfileFlag {
+    fmem,err := os.Create( gMemProfileFile )
+    if err!=nil { panic(fmem) }
+    pprof.WriteHeapProfile(fmem)
+    fmem.Close()
+  }
+
+}
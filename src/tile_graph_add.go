@@ -0,0 +1,894 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Add samples to an existing tile graph database, as written by
+// create_tile_graph -db and fj2allele -db, without rebuilding it.
+//
+// Tiles of the new samples that aren't in the graph yet (not in the
+// -tile-map) get new tag and body Sequence rows, with their records
+// appended to the -fasta file, and new GraphJoin rows.  New tiles
+// are ranked after the tiles already at their path.step, by
+// frequency, so existing body Sequence record names don't change.
+// Each new sample then gets its CallSet, Allele, AlleleCall,
+// AllelePathItem and AllelePathItemNoCall rows, as in fj2allele
+// (including -ploidy and -collapse-homozygous),
+// and the GraphJoins it goes through are linked to its VariantSet.
+//
+// New rows are numbered from the largest ID in each table, so
+// existing IDs are left as they are.  The -tile-map is appended to
+// and the -tile-lib (see create_tile_graph), if given, is updated.
+//
+// No-calls aren't filled in (see create_tile_graph -nocall-fill), so
+// a tile with no-calls that isn't in the tile map gets nodes of its
+// own.
+//
+// example usage (c.fj is the FastJ of the new sample hu000001):
+//
+//  ./tile_graph_add -db tilegraph.sqlite3 -fasta out.fa -tile-map out.tilemap -i hu000001,c.fj
+//
+
+package main
+
+import "os"
+import "io"
+import "fmt"
+import "log"
+import "strings"
+import "strconv"
+import "bufio"
+import "sort"
+
+import "crypto/md5"
+import "database/sql"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fjidx"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/tilelib"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/allelecall"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+// Map of sequenceRecordName to its Sequence ID, existing
+// and new.
+//
+var g_seqname_seqid map[string]int
+
+// Key is [path].[step]
+// Second key is the md5sum of the whole tile, value is the
+// body sequenceRecordName.  Read from the tile map and
+// extended with the new tiles.
+//
+var g_tile_body_seqname map[fastj.TilePos]map[string]string
+
+// Highest body rank in the graph at each path.step, from
+// the body sequenceRecordNames.
+//
+var g_max_rank map[fastj.TilePos]int
+
+// A tile of the new samples that isn't in the graph yet.
+//
+type NewTile struct {
+  Md5Sum string
+  PathStep fastj.TilePos
+  SeedLen int
+  Freq int
+  Rank int
+  Seq string
+}
+
+// Key is [path].[step]
+// Second key is md5sum of full tile sequence.
+//
+var g_new_tiles map[fastj.TilePos]map[string]*NewTile
+
+// Number of times each tile (path.step and md5sum) was
+// seen in the new samples, for the tile library.
+//
+var g_tile_freq map[fastj.TilePos]map[string]int
+
+// A tag or tile body Sequence to add, see create_tile_graph.
+//
+type SequenceInfo struct {
+  Name string
+  Pos fastj.TilePos
+  IsBody bool
+  Rank int
+  Seq string
+}
+
+// Tiles of each allele of the new samples, keyed by
+// [name]:[variant], with the keys in order of first
+// appearance.
+//
+var g_allele_tiles map[string][]*fastj.Tile
+var g_allele_order []string
+
+// Samples with unphased tiles.
+//
+var g_unphased map[string]bool
+
+// Tile ID range of the inputs to use, -s and -e (inclusive,
+// see fjfilter).
+//
+var g_range_beg fastj.TileID
+var g_range_end fastj.TileID
+
+func md5sum_str(seq string) string {
+  ta := make([]string, 0, 32)
+  s := md5.Sum([]byte(seq))
+  for i:=0; i<len(s); i++ {
+    ta = append(ta, fmt.Sprintf("%02x", s[i]))
+  }
+  return strings.Join(ta, "")
+}
+
+func init() {
+  g_seqname_seqid = make(map[string]int)
+  g_tile_body_seqname = make(map[fastj.TilePos]map[string]string)
+  g_max_rank = make(map[fastj.TilePos]int)
+  g_new_tiles = make(map[fastj.TilePos]map[string]*NewTile)
+  g_tile_freq = make(map[fastj.TilePos]map[string]int)
+  g_allele_tiles = make(map[string][]*fastj.Tile)
+  g_unphased = make(map[string]bool)
+}
+
+// Parse a body sequenceRecordName:
+//
+//   [md5sum].[path].[step].r[rank]+[seed-tile-length]
+//
+// ok is false for tags and reference sequences
+// ([md5sum].[path].[step].s+[steps]).
+//
+func parse_body_name(name string) (pos fastj.TilePos, rank int, ok bool) {
+  f := strings.Split(name, ".")
+  if len(f)!=4 || !strings.HasPrefix(f[3], "r") { return pos, 0, false }
+
+  p := strings.Index(f[3], "+")
+  if p<0 { return pos, 0, false }
+
+  pos,e := fastj.ParseTilePos(f[1] + "." + f[2])
+  if e!=nil { return pos, 0, false }
+
+  r,e := strconv.ParseInt(f[3][1:p], 16, 64)
+  if e!=nil { return pos, 0, false }
+
+  return pos, int(r), true
+}
+
+func import_sequence_db(db *sql.DB) error {
+  seqs,e := graphdb.ReadSequence(db)
+  if e!=nil { return e }
+
+  // Older databases name the reference sequences
+  // ...ref+[steps], which parses as a body, so the
+  // Reference rows are used to skip them.
+  //
+  ref_seq,e := graphdb.ReferenceSequenceIds(db)
+  if e!=nil { return e }
+
+  for _,s := range seqs {
+    if prev_id,ok := g_seqname_seqid[s.SequenceRecordName] ; ok && prev_id!=s.Id {
+      return fmt.Errorf("ERROR: duplicate sequenceRecordName %s, IDs %d and %d", s.SequenceRecordName, prev_id, s.Id)
+    }
+    g_seqname_seqid[s.SequenceRecordName] = s.Id
+
+    if ref_seq[s.Id] { continue }
+    if pos,rank,ok := parse_body_name(s.SequenceRecordName) ; ok {
+      if r,ok := g_max_rank[pos] ; !ok || rank>r { g_max_rank[pos] = rank }
+    }
+  }
+
+  return nil
+}
+
+// Parse the tile map written by create_tile_graph.
+// Each line is:
+//
+//   path.step,md5sum,body_sequence_record_name
+//
+func import_tile_map(fn string) error {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return e }
+  defer h.Close()
+
+  line_no:=0
+
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    line_parts := strings.Split(l, ",")
+    if len(line_parts)!=3 {
+      return fmt.Errorf("ERROR: expected 3 fields in tile map (line %d)", line_no)
+    }
+
+    path_step,e := fastj.ParseTilePos(line_parts[0])
+    if e!=nil { return fmt.Errorf("ERROR: parsing path.step in tile map (line %d): %v", line_no, e) }
+
+    if _,ok := g_seqname_seqid[line_parts[2]] ; !ok {
+      return fmt.Errorf("ERROR: body %s is not in the Sequence table (tile map line %d)", line_parts[2], line_no)
+    }
+
+    if _,ok := g_tile_body_seqname[path_step] ; !ok {
+      g_tile_body_seqname[path_step] = make(map[string]string)
+    }
+    g_tile_body_seqname[path_step][line_parts[1]] = line_parts[2]
+  }
+
+  return nil
+}
+
+// Record a tile of a new sample.  Tiles that aren't in
+// the tile map are added to g_new_tiles.
+//
+func add_tile(name string, tile *fastj.Tile) error {
+  allele_key := fmt.Sprintf("%s:%d", name, tile.TileID.Variant)
+
+  if len(tile.Seq)==0 { return nil }
+
+  if e:=tile.CheckMd5() ; e!=nil { return e }
+  if len(tile.Seq)<2*fastj.TAGLEN {
+    return fmt.Errorf("tile %s is shorter than two tags (%d)", tile.TileID, len(tile.Seq))
+  }
+
+  if _,ok := g_allele_tiles[allele_key] ; !ok {
+    g_allele_tiles[allele_key] = make([]*fastj.Tile, 0, 1024)
+    g_allele_order = append(g_allele_order, allele_key)
+  }
+  if allelecall.IsUnphased(tile) { g_unphased[name] = true }
+
+  g_allele_tiles[allele_key] = append(g_allele_tiles[allele_key], tile)
+
+  pos := tile.TileID.Pos()
+  if _,ok := g_tile_freq[pos] ; !ok { g_tile_freq[pos] = make(map[string]int) }
+  g_tile_freq[pos][tile.Md5Sum]++
+
+  if _,ok := g_tile_body_seqname[pos][tile.Md5Sum] ; ok { return nil }
+
+  if _,ok := g_new_tiles[pos] ; !ok { g_new_tiles[pos] = make(map[string]*NewTile) }
+  if nt,ok := g_new_tiles[pos][tile.Md5Sum] ; ok {
+    nt.Freq++
+    return nil
+  }
+  g_new_tiles[pos][tile.Md5Sum] = &NewTile{ tile.Md5Sum, pos, tile.SeedTileLength, 1, -1, tile.Seq }
+
+  return nil
+}
+
+// Seed tile lengths are corrected as in create_tile_graph
+// (see fastj.BridgedSeedTileLength).
+//
+func import_fastj(name, fn string) error {
+  fj,e := fjidx.OpenRange(fn, g_range_beg, g_range_end)
+  if e!=nil { return e }
+  defer fj.Close()
+
+  prev := make(map[int]*fastj.Tile)
+
+  for {
+    tile,e := fj.Read()
+    if e==io.EOF { break }
+    if e!=nil { return e }
+
+    if p,ok := prev[tile.TileID.Variant] ; ok && len(tile.Seq)>0 {
+      if seedlen := fastj.BridgedSeedTileLength(p, tile) ; seedlen>0 {
+        fmt.Fprintf(os.Stderr, "warning: %s: %s (seedTileLength %d) is followed by %s, taking its seedTileLength to be %d\n",
+          name, p.TileID, p.SeedTileLength, tile.TileID, seedlen)
+        p.SeedTileLength = seedlen
+        if nt,ok := g_new_tiles[p.TileID.Pos()][p.Md5Sum] ; ok { nt.SeedLen = seedlen }
+      }
+    }
+    if len(tile.Seq)>0 { prev[tile.TileID.Variant] = tile }
+
+    if e:=add_tile(name, tile) ; e!=nil {
+      return fmt.Errorf("%s (line %d): %v", fn, fj.LineNo(), e)
+    }
+  }
+
+  return nil
+}
+
+type HaplotypeOrder []allelecall.Haplotype
+func (h HaplotypeOrder) Len() int { return len(h) }
+func (h HaplotypeOrder) Swap(i,j int) { h[i],h[j] = h[j],h[i] }
+func (h HaplotypeOrder) Less(i,j int) bool { return h[i].Variant < h[j].Variant }
+
+type NewTileOrder []*NewTile
+func (t NewTileOrder) Len() int { return len(t) }
+func (t NewTileOrder) Swap(i,j int) { t[i],t[j] = t[j],t[i] }
+func (t NewTileOrder) Less(i,j int) bool {
+  if c := t[i].PathStep.Cmp(t[j].PathStep) ; c!=0 { return c<0 }
+  if t[i].Freq != t[j].Freq { return t[i].Freq > t[j].Freq }
+  return t[i].Md5Sum < t[j].Md5Sum
+}
+
+// Rank the new tiles after the highest rank at their
+// path.step (in the graph or the tile library), by
+// frequency then md5sum.  Returns the new tiles in
+// path.step then rank order.
+//
+func rank_new_tiles(lib tilelib.Library) []*NewTile {
+  tiles := make([]*NewTile, 0, 1024)
+  for pos := range g_new_tiles {
+    for _,nt := range g_new_tiles[pos] { tiles = append(tiles, nt) }
+  }
+  sort.Sort(NewTileOrder(tiles))
+
+  next_rank := make(map[fastj.TilePos]int)
+  for _,nt := range tiles {
+    pos := nt.PathStep
+    if _,ok := next_rank[pos] ; !ok {
+      next_rank[pos] = 0
+      if r,ok := g_max_rank[pos] ; ok { next_rank[pos] = r+1 }
+      if lib!=nil && lib.NextRank(pos)>next_rank[pos] { next_rank[pos] = lib.NextRank(pos) }
+    }
+    nt.Rank = next_rank[pos]
+    next_rank[pos]++
+  }
+
+  return tiles
+}
+
+func new_body_name(nt *NewTile) string {
+  body := nt.Seq[fastj.TAGLEN:len(nt.Seq)-fastj.TAGLEN]
+  return fastj.BodySequenceName(md5sum_str(body), nt.PathStep, nt.Rank, nt.SeedLen)
+}
+
+type SequenceOrder []SequenceInfo
+func (s SequenceOrder) Len() int { return len(s) }
+func (s SequenceOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s SequenceOrder) Less(i,j int) bool {
+  if c := s[i].Pos.Cmp(s[j].Pos) ; c!=0 { return c<0 }
+  if s[i].IsBody != s[j].IsBody { return !s[i].IsBody }
+  if s[i].Rank != s[j].Rank { return s[i].Rank < s[j].Rank }
+  return s[i].Name < s[j].Name
+}
+
+// The tag and body Sequences of the new tiles that aren't
+// in the graph yet, in the create_tile_graph order.  Each
+// new tile is added to g_tile_body_seqname.
+//
+func new_sequences(tiles []*NewTile) []SequenceInfo {
+  seen := make(map[string]bool)
+  seqs := make([]SequenceInfo, 0, 3*len(tiles))
+
+  add := func(si SequenceInfo) {
+    if seen[si.Name] { return }
+    if _,ok := g_seqname_seqid[si.Name] ; ok { return }
+    seen[si.Name] = true
+    seqs = append(seqs, si)
+  }
+
+  for _,nt := range tiles {
+    pfx_tag := nt.Seq[:fastj.TAGLEN]
+    sfx_tag := nt.Seq[len(nt.Seq)-fastj.TAGLEN:]
+    body := nt.Seq[fastj.TAGLEN:len(nt.Seq)-fastj.TAGLEN]
+
+    add(SequenceInfo{fastj.TagSequenceName(nt.PathStep, pfx_tag), nt.PathStep, false, 0, pfx_tag})
+
+    sfx_pos := nt.PathStep.Next(nt.SeedLen)
+    add(SequenceInfo{fastj.TagSequenceName(sfx_pos, sfx_tag), sfx_pos, false, 0, sfx_tag})
+
+    body_name := new_body_name(nt)
+    add(SequenceInfo{body_name, nt.PathStep, true, nt.Rank, body})
+
+    if _,ok := g_tile_body_seqname[nt.PathStep] ; !ok {
+      g_tile_body_seqname[nt.PathStep] = make(map[string]string)
+    }
+    g_tile_body_seqname[nt.PathStep][nt.Md5Sum] = body_name
+  }
+
+  sort.Sort(SequenceOrder(seqs))
+  return seqs
+}
+
+func new_graphjoin(id, seq1, pos1 int, fwd1 bool, seq2, pos2 int, fwd2 bool) graphdb.GraphJoin {
+  return graphdb.GraphJoin{
+    Id:id,
+    Side1SequenceId:seq1, Side1Position:pos1, Side1StrandIsForward:fwd1,
+    Side2SequenceId:seq2, Side2Position:pos2, Side2StrandIsForward:fwd2 }
+}
+
+// GraphJoins between each new body and its tags, numbered
+// from gj_id, as in create_tile_graph.
+//
+func new_graphjoins(tiles []*NewTile, gj_id int) []graphdb.GraphJoin {
+  rows := make([]graphdb.GraphJoin, 0, 2*len(tiles))
+
+  for _,nt := range tiles {
+    pfx_tag_id := fastj.TagSequenceName(nt.PathStep, nt.Seq[:fastj.TAGLEN])
+    sfx_tag_id := fastj.TagSequenceName(nt.PathStep.Next(nt.SeedLen), nt.Seq[len(nt.Seq)-fastj.TAGLEN:])
+    body_id := new_body_name(nt)
+
+    pfx_seq_id := g_seqname_seqid[pfx_tag_id]
+    sfx_seq_id := g_seqname_seqid[sfx_tag_id]
+    body_seq_id := g_seqname_seqid[body_id]
+
+    if pfx_tag_id < body_id {
+      rows = append(rows, new_graphjoin(gj_id, pfx_seq_id, 23, false, body_seq_id, 0, true))
+    } else {
+      rows = append(rows, new_graphjoin(gj_id, body_seq_id, 0, true, pfx_seq_id, 23, false))
+    }
+    gj_id++
+
+    if body_id < sfx_tag_id {
+      rows = append(rows, new_graphjoin(gj_id, body_seq_id, len(nt.Seq)-49, false, sfx_seq_id, 0, true))
+    } else {
+      rows = append(rows, new_graphjoin(gj_id, sfx_seq_id, 0, true, body_seq_id, len(nt.Seq)-49, false))
+    }
+    gj_id++
+  }
+
+  return rows
+}
+
+func tag_sequence_id(pos fastj.TilePos, tag string) (int, error) {
+  tag_name := fastj.TagSequenceName(pos, tag)
+  seqid,ok := g_seqname_seqid[tag_name]
+  if !ok {
+    return 0, fmt.Errorf("could not find tag '%s' (%s) in Sequence map", tag, tag_name)
+  }
+  return seqid, nil
+}
+
+// Sequence ID of the body of tile and the seed tile
+// length it has in the graph, as in fj2allele.
+//
+func body_sequence_id(tile *fastj.Tile) (int, int, error) {
+  body_name,ok := g_tile_body_seqname[tile.TileID.Pos()][tile.Md5Sum]
+  if !ok {
+    return 0, 0, fmt.Errorf("could not find tile %s (%s) in tile map", tile.TileID, tile.Md5Sum)
+  }
+  seqid,ok := g_seqname_seqid[body_name]
+  if !ok {
+    return 0, 0, fmt.Errorf("could not find body %s in Sequence map", body_name)
+  }
+  seedlen,ok := fastj.BodySeedTileLength(body_name)
+  if !ok { seedlen = tile.SeedTileLength }
+  return seqid, seedlen, nil
+}
+
+// No-call intervals of seq[beg:end] for the path item.
+//
+func nocall_intervals(item graphdb.AllelePathItem, seq string, beg, end int) []graphdb.AllelePathItemNoCall {
+  nocalls := make([]graphdb.AllelePathItemNoCall, 0)
+  for i:=beg; i<end; i++ {
+    if seq[i]!='n' && seq[i]!='N' { continue }
+    j:=i
+    for ; j<end && (seq[j]=='n' || seq[j]=='N'); j++ { }
+
+    nocalls = append(nocalls, graphdb.AllelePathItemNoCall{ AlleleId:item.AlleleId, PathItemIndex:item.PathItemIndex, Start:i-beg, Length:j-i })
+    i=j
+  }
+  return nocalls
+}
+
+// The AllelePathItem and AllelePathItemNoCall rows of an
+// allele, as in fj2allele.
+//
+func allele_path(allele_id int, tiles []*fastj.Tile) ([]graphdb.AllelePathItem, []graphdb.AllelePathItemNoCall, error) {
+  items := make([]graphdb.AllelePathItem, 0, 3*len(tiles))
+  nocalls := make([]graphdb.AllelePathItemNoCall, 0)
+
+  add := func(seqid int, length int, seq string, beg, end int) {
+    item := graphdb.AllelePathItem{ AlleleId:allele_id, PathItemIndex:len(items), SequenceId:seqid, Start:0, Length:length, StrandIsForward:true }
+    items = append(items, item)
+    nocalls = append(nocalls, nocall_intervals(item, seq, beg, end)...)
+  }
+
+  for _,tile := range tiles {
+    seq := tile.Seq
+
+    if len(items)==0 {
+      seqid,e := tag_sequence_id(tile.TileID.Pos(), tile.PrefixTag())
+      if e!=nil { return nil, nil, e }
+      add(seqid, fastj.TAGLEN, seq, 0, fastj.TAGLEN)
+    }
+
+    seqid,seedlen,e := body_sequence_id(tile)
+    if e!=nil { return nil, nil, e }
+    add(seqid, len(tile.Body()), seq, fastj.TAGLEN, len(seq)-fastj.TAGLEN)
+
+    seqid,e = tag_sequence_id(tile.TileID.Pos().Next(seedlen), tile.SuffixTag())
+    if e!=nil { return nil, nil, e }
+    add(seqid, fastj.TAGLEN, seq, len(seq)-fastj.TAGLEN, len(seq))
+  }
+
+  return items, nocalls, nil
+}
+
+func emit_fasta(ofp *bufio.Writer, seqs []SequenceInfo) {
+  for _,si := range seqs {
+    ofp.Write([]byte(fmt.Sprintf(">%s\n", si.Name)))
+
+    if !si.IsBody {
+      ofp.Write([]byte(si.Seq))
+      ofp.Write([]byte("\n\n"))
+      continue
+    }
+
+    if len(si.Seq)==0 { ofp.Write([]byte("\n")) }
+    fastj.WriteFold(ofp, si.Seq, fastj.FOLD)
+    ofp.Write([]byte("\n"))
+  }
+}
+
+// Append to the file fn, creating it if needed.
+//
+func append_file(fn string, emit func(*bufio.Writer)) error {
+  f,e := os.OpenFile(fn, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+  if e!=nil { return e }
+  w := bufio.NewWriter(f)
+  emit(w)
+  if e:=w.Flush() ; e!=nil { f.Close() ; return e }
+  return f.Close()
+}
+
+// Pick the VariantSet to add to, by ID or, if there is
+// only one, the one in the database.
+//
+func pick_variantset(db *sql.DB, id int) (int, error) {
+  vss,e := graphdb.ReadVariantSet(db)
+  if e!=nil { return 0, e }
+
+  if id>0 {
+    for _,vs := range vss {
+      if vs.Id==id { return id, nil }
+    }
+    return 0, fmt.Errorf("no VariantSet with ID %d", id)
+  }
+
+  if len(vss)!=1 {
+    return 0, fmt.Errorf("%d VariantSets, pick one with -variantset-id", len(vss))
+  }
+  return vss[0].Id, nil
+}
+
+func _main( c *cli.Context ) {
+  gVerboseFlag = c.Bool("Verbose")
+
+  db_fn := c.String("db")
+  fasta_fn := c.String("fasta")
+  tile_map_fn := c.String("tile-map")
+  tile_lib_fn := c.String("tile-lib")
+  ifns := c.StringSlice("input")
+
+  if len(db_fn)==0 || len(fasta_fn)==0 || len(tile_map_fn)==0 || len(ifns)==0 {
+    fmt.Fprintf( os.Stderr, "Provide the database (-db), FASTA (-fasta), tile map (-tile-map) and input FastJ (-i)\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  if _,e := os.Stat(db_fn) ; e!=nil { log.Fatal(e) }
+
+  default_ploidy := c.Int("default-ploidy")
+  if default_ploidy<1 || default_ploidy>2 {
+    fmt.Fprintf(os.Stderr, "invalid -default-ploidy %d (must be 1 or 2)\n", default_ploidy)
+    os.Exit(1)
+  }
+  ploidy_map,e := allelecall.ParsePloidy(c.StringSlice("ploidy"))
+  if e!=nil { fmt.Fprintf(os.Stderr, "%v\n", e) ; os.Exit(1) }
+
+  g_range_beg,g_range_end = fjidx.AllTiles(),fjidx.AllTiles()
+  if len(c.String("start"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("start"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid start: %v\n", e) ; os.Exit(1) }
+    g_range_beg = z
+  }
+  if len(c.String("end"))>0 {
+    z,e := fastj.ParseTileIDPartial(c.String("end"))
+    if e!=nil { fmt.Fprintf(os.Stderr, "invalid end: %v\n", e) ; os.Exit(1) }
+    g_range_end = z
+  }
+
+  db,e := graphdb.Open(db_fn)
+  if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  defer db.Close()
+
+  fatal := func(e error) { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+
+  variantset_id,e := pick_variantset(db, c.Int("variantset-id"))
+  if e!=nil { fatal(e) }
+
+  if e:=import_sequence_db(db) ; e!=nil { fatal(e) }
+  if e:=import_tile_map(tile_map_fn) ; e!=nil { log.Fatal(e) }
+
+  var lib tilelib.Library
+  if len(tile_lib_fn)>0 {
+    lib,e = tilelib.Load(tile_lib_fn)
+    if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", tile_lib_fn, e)) }
+  }
+
+  // Existing IDs, new rows are numbered after them.
+  //
+  max_id := make(map[string]int)
+  for _,table := range []string{"FASTA", "Sequence", "GraphJoin", "CallSet", "Allele"} {
+    id,e := graphdb.MaxId(db, table)
+    if e!=nil { fatal(e) }
+    max_id[table] = id
+  }
+
+  callsets,e := graphdb.ReadCallSet(db)
+  if e!=nil { fatal(e) }
+  callset_names := make(map[string]bool)
+  for _,cs := range callsets { callset_names[cs.Name] = true }
+
+  // Read the new samples.
+  //
+  names := make([]string, 0, len(ifns))
+  for i:=0; i<len(ifns); i++ {
+    name := ifns[i]
+    ifn := ifns[i]
+    if strings.Contains(ifns[i], ",") {
+      z := strings.SplitN(ifns[i], ",", 2)
+      name = z[0]
+      ifn = z[1]
+    }
+
+    if callset_names[name] {
+      log.Fatal(fmt.Sprintf("%s: CallSet %s is already in the database", db_fn, name))
+    }
+    callset_names[name] = true
+    names = append(names, name)
+
+    if gVerboseFlag { fmt.Fprintf(os.Stderr, ">>> %s %s\n", name, ifn) }
+
+    if e:=import_fastj(name, ifn) ; e!=nil { log.Fatal(e) }
+  }
+
+  // New graph nodes and edges.
+  //
+  new_tiles := rank_new_tiles(lib)
+  seqs := new_sequences(new_tiles)
+
+  fasta_id := max_id["FASTA"]+1
+  new_fasta := true
+  fas,e := graphdb.ReadFASTA(db)
+  if e!=nil { fatal(e) }
+  for _,fa := range fas {
+    if fa.FastaURI==fasta_fn { fasta_id = fa.Id ; new_fasta = false }
+  }
+
+  seq_rows := make([]graphdb.Sequence, len(seqs))
+  for i,si := range seqs {
+    id := max_id["Sequence"]+1+i
+    seq_rows[i] = graphdb.Sequence{ Id:id, FastaId:fasta_id, SequenceRecordName:si.Name, Md5Checksum:md5sum_str(si.Seq), Length:len(si.Seq) }
+    g_seqname_seqid[si.Name] = id
+  }
+
+  gj_rows := new_graphjoins(new_tiles, max_id["GraphJoin"]+1)
+
+  // The new samples.
+  //
+  cs_rows := make([]graphdb.CallSet, len(names))
+  vs_cs := make([]graphdb.VariantSetCallSetJoin, len(names))
+  callset_id := make(map[string]int)
+  for i,name := range names {
+    id := max_id["CallSet"]+1+i
+    cs_rows[i] = graphdb.CallSet{ Id:id, Name:name, SampleId:name }
+    vs_cs[i] = graphdb.VariantSetCallSetJoin{ VariantSetId:variantset_id, CallSetId:id }
+    callset_id[name] = id
+  }
+
+  allele_rows := make([]graphdb.Allele, 0, len(g_allele_order))
+  call_rows := make([]graphdb.AlleleCall, 0, len(g_allele_order))
+  item_rows := make([]graphdb.AllelePathItem, 0, 1024)
+  nocall_rows := make([]graphdb.AllelePathItemNoCall, 0, 1024)
+
+  sample_haps := make(map[string][]allelecall.Haplotype)
+  for _,k := range g_allele_order {
+    name := k[:strings.LastIndex(k, ":")]
+    variant,_ := strconv.Atoi(k[strings.LastIndex(k, ":")+1:])
+
+    sigs := make([]string, len(g_allele_tiles[k]))
+    for i,tile := range g_allele_tiles[k] { sigs[i] = allelecall.TileSig(tile) }
+    sample_haps[name] = append(sample_haps[name], allelecall.Haplotype{ Key:k, Variant:variant, Tiles:sigs })
+  }
+
+  allele_id := max_id["Allele"]+1
+  for _,name := range names {
+    haps := sample_haps[name]
+    sort.Sort(HaplotypeOrder(haps))
+
+    ploidy,ok := ploidy_map[name]
+    if !ok { ploidy = default_ploidy }
+
+    sample := allelecall.Sample{ Name:name, Ploidy:ploidy, Phased:!g_unphased[name], Collapse:c.Bool("collapse-homozygous") }
+    calls,e := allelecall.Calls(sample, haps)
+    if e!=nil { log.Fatal(e) }
+
+    for _,call := range calls {
+      id := allele_id
+      allele_id++
+
+      allele_rows = append(allele_rows, graphdb.Allele{ Id:id, VariantSetId:variantset_id, Name:call.Name })
+      call_rows = append(call_rows, graphdb.AlleleCall{ AlleleId:id, CallSetId:callset_id[name], Ploidy:call.Ploidy })
+
+      items,nocalls,e := allele_path(id, g_allele_tiles[call.Keys[0]])
+      if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", call.Name, e)) }
+      item_rows = append(item_rows, items...)
+      nocall_rows = append(nocall_rows, nocalls...)
+    }
+  }
+
+  // Link every GraphJoin the new samples go through, new or
+  // already in the graph, to the VariantSet.
+  //
+  gj_vs,e := graphdb.VariantSetGraphJoinLinks(db, variantset_id, gj_rows, item_rows)
+  if e!=nil { fatal(e) }
+
+  // Insert everything in one transaction, only committed once
+  // the FASTA and tile map have been appended to.
+  //
+  tx,e := db.Begin()
+  if e!=nil { fatal(e) }
+
+  insert := func(e error) {
+    if e==nil { return }
+    tx.Rollback()
+    fatal(e)
+  }
+
+  if new_fasta {
+    insert(graphdb.InsertFASTA(tx, []graphdb.FASTA{{Id:fasta_id, FastaURI:fasta_fn}}))
+  }
+  insert(graphdb.InsertSequence(tx, seq_rows))
+  insert(graphdb.InsertGraphJoin(tx, gj_rows))
+  insert(graphdb.InsertGraphJoinVariantSetJoin(tx, gj_vs))
+  insert(graphdb.InsertCallSet(tx, cs_rows))
+  insert(graphdb.InsertVariantSetCallSetJoin(tx, vs_cs))
+  insert(graphdb.InsertAllele(tx, allele_rows))
+  insert(graphdb.InsertAlleleCall(tx, call_rows))
+  insert(graphdb.InsertAllelePathItem(tx, item_rows))
+  insert(graphdb.InsertAllelePathItemNoCall(tx, nocall_rows))
+
+  e = append_file(fasta_fn, func(ofp *bufio.Writer) { emit_fasta(ofp, seqs) })
+  if e!=nil { tx.Rollback() ; log.Fatal(e) }
+
+  e = append_file(tile_map_fn, func(ofp *bufio.Writer) {
+    for _,nt := range new_tiles {
+      ofp.Write([]byte(fmt.Sprintf("%s,%s,%s\n", nt.PathStep, nt.Md5Sum, new_body_name(nt))))
+    }
+  })
+  if e!=nil { tx.Rollback() ; log.Fatal(e) }
+
+  if e:=tx.Commit() ; e!=nil { fatal(e) }
+
+  // The tile library gets the new tiles, and the
+  // frequencies of the new samples are added on.
+  //
+  if lib!=nil {
+    for pos := range g_tile_freq {
+      for m5,n := range g_tile_freq[pos] {
+        if t,ok := lib[pos][m5] ; ok {
+          t.Freq += n
+          lib[pos][m5] = t
+        }
+      }
+    }
+    for _,nt := range new_tiles {
+      if _,ok := lib[nt.PathStep][nt.Md5Sum] ; ok { continue }
+      t := tilelib.Tile{ Md5Sum:nt.Md5Sum, PathStep:nt.PathStep, SeedLen:nt.SeedLen, Freq:nt.Freq, Rank:nt.Rank, Build:c.String("build") }
+      if e:=lib.Add(t) ; e!=nil { log.Fatal(fmt.Sprintf("%s: %v", tile_lib_fn, e)) }
+    }
+
+    out,e := autoio.CreateWriter(tile_lib_fn)
+    if e!=nil { log.Fatal(e) }
+    lib.Write(out.Writer)
+    out.Flush()
+    out.Close()
+  }
+
+  if gVerboseFlag {
+    fmt.Fprintf(os.Stderr, "# %d new tiles, %d Sequence, %d GraphJoin, %d CallSet, %d Allele rows\n",
+      len(new_tiles), len(seq_rows), len(gj_rows), len(cs_rows), len(allele_rows))
+  }
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "tile_graph_add"
+  app.Usage = "Add samples to an existing tile graph database"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringSliceFlag{
+      Name: "input, i",
+      Value: &cli.StringSlice{},
+      Usage: "INPUT FastJ of a new sample, as name,file (can be specified more than once)",
+    },
+
+    cli.StringFlag{
+      Name: "db",
+      Usage: "SQLite database written by create_tile_graph -db and fj2allele -db",
+    },
+
+    cli.StringFlag{
+      Name: "fasta",
+      Usage: "FASTA the new Sequence records are appended to",
+    },
+
+    cli.StringFlag{
+      Name: "tile-map",
+      Usage: "Tile map written by create_tile_graph, the new tiles are appended to it",
+    },
+
+    cli.StringFlag{
+      Name: "tile-lib",
+      Usage: "Tile library (see create_tile_graph -tile-lib) to update",
+    },
+
+    cli.StringFlag{
+      Name: "build",
+      Usage: "Build name recorded in the tile library for the new tiles",
+    },
+
+    cli.IntFlag{
+      Name: "variantset-id",
+      Value: 0,
+      Usage: "ID of the VariantSet to add to (needed if there is more than one)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "ploidy",
+      Value: &cli.StringSlice{},
+      Usage: "Ploidy of an input, NAME=PLOIDY (1 or 2, can be specified more than once)",
+    },
+
+    cli.IntFlag{
+      Name: "default-ploidy",
+      Value: 2,
+      Usage: "Ploidy of the inputs not given one with -ploidy",
+    },
+
+    cli.BoolFlag{
+      Name: "collapse-homozygous",
+      Usage: "Call identical haplotypes of a sample as one Allele with ploidy 2",
+    },
+
+    cli.StringFlag{
+      Name: "start, s",
+      Usage: "Only use input tiles from START (path.step, path.ver.step or path.ver.step.variant, inclusive)",
+    },
+
+    cli.StringFlag{
+      Name: "end, e",
+      Usage: "Only use input tiles up to END (inclusive)",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+  }
+
+  app.Run(os.Args)
+
+}
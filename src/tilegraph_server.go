@@ -0,0 +1,129 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Serve a tile graph database over HTTP with the GA4GH reference
+// graph API (see the graphserver package for the endpoints), so
+// clients can be tried against it without copying it to a GA4GH
+// server.  The database is opened read only.
+//
+// The /tiles endpoints answer step range queries for
+// lightning_graph_viz, and with -viz the viewer itself is served
+// from /, so it fetches only the part of the graph on screen.
+//
+// example usage:
+//
+//  ./tilegraph_server -db tilegraph.sqlite3 -listen localhost:8081
+//  ./tilegraph_server -db tilegraph.sqlite3 -viz lightning_graph_viz
+//  curl -s -X POST -d '{"sequenceId":"12"}' localhost:8081/joins/search
+//  curl -s 'localhost:8081/sequences/12/bases?start=0&end=24'
+//
+
+package main
+
+import "os"
+import "fmt"
+import "log"
+import "net/http"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphdb"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/graphserver"
+
+import "github.com/codegangsta/cli"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+// Log each request with -V.
+//
+func log_requests(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if gVerboseFlag { log.Printf("%s %s", r.Method, r.URL) }
+    h.ServeHTTP(w, r)
+  })
+}
+
+func _main( c *cli.Context ) {
+  db_fn := c.String("db")
+  gVerboseFlag = c.Bool("Verbose")
+
+  if len(db_fn)==0 { cli.ShowAppHelp(c) ; os.Exit(1) }
+
+  db,e := graphdb.OpenReadOnly(db_fn)
+  if e!=nil { log.Fatal(fmt.Sprintf("%s: %v", db_fn, e)) }
+  defer db.Close()
+
+  // The fastaURIs create_tile_graph writes are the -fasta
+  // given, relative to the directory it was run in, so
+  // by default they're taken as relative to this one.
+  //
+  mux := http.NewServeMux()
+  srv := graphserver.New(db, c.String("fasta-dir"))
+  srv.Register(mux)
+  srv.RegisterTiles(mux)
+
+  if viz_dir := c.String("viz") ; len(viz_dir)>0 {
+    if _,e := os.Stat(viz_dir) ; e!=nil { log.Fatal(e) }
+    mux.Handle("/", http.FileServer(http.Dir(viz_dir)))
+  }
+
+  if gVerboseFlag { log.Printf("serving %s on %s", db_fn, c.String("listen")) }
+  log.Fatal(http.ListenAndServe(c.String("listen"), log_requests(mux)))
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "tilegraph_server"
+  app.Usage = "Serve a tile graph database with the GA4GH reference graph API"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringFlag{
+      Name: "db",
+      Usage: "SQLite database to serve, as written by create_tile_graph -db",
+    },
+
+    cli.StringFlag{
+      Name: "listen, l",
+      Value: "localhost:8081",
+      Usage: "ADDRESS to listen on",
+    },
+
+    cli.StringFlag{
+      Name: "fasta-dir",
+      Usage: "DIRectory relative FASTA files named in the FASTA table are in (default the current directory)",
+    },
+
+    cli.StringFlag{
+      Name: "viz",
+      Usage: "Serve the lightning_graph_viz DIRectory from / (using the /tiles endpoints)",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag (log requests)",
+    },
+  }
+
+  app.Run(os.Args)
+
+}
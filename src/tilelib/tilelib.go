@@ -0,0 +1,164 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package tilelib reads and writes the persistent tile library
+// that keeps tile ranks (and so body Sequence record names)
+// stable from one graph build to the next.
+//
+// The library is a CSV file with no header, one tile per line, in
+// path.step then rank order:
+//
+//   path.step,md5sum,seedlen,freq,rank,build
+//
+// where md5sum is of the whole tile and build is the name of the
+// build the tile was first seen in.
+//
+package tilelib
+
+import "fmt"
+import "io"
+import "os"
+import "sort"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/autoio"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+type Tile struct {
+  Md5Sum string
+  PathStep fastj.TilePos
+  SeedLen int
+  Freq int
+  Rank int
+  Build string
+}
+
+// Key is [path].[step]
+// Second key is md5sum of full tile sequence.
+//
+type Library map[fastj.TilePos]map[string]Tile
+
+func New() Library {
+  return make(Library)
+}
+
+// Read the library in fn.  A missing file is an empty library.
+//
+func Load(fn string) (Library, error) {
+  lib := New()
+  if _,e := os.Stat(fn) ; os.IsNotExist(e) { return lib, nil }
+
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  line_no:=0
+
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    line_parts := strings.Split(l, ",")
+    if len(line_parts)!=6 {
+      return nil, fmt.Errorf("ERROR: expected 6 fields in tile library (line %d)", line_no)
+    }
+
+    path_step,e := fastj.ParseTilePos(line_parts[0])
+    if e!=nil { return nil, fmt.Errorf("ERROR: parsing path.step in tile library (line %d): %v", line_no, e) }
+
+    seedlen,e0 := strconv.Atoi(line_parts[2])
+    freq,e1 := strconv.Atoi(line_parts[3])
+    rank,e2 := strconv.Atoi(line_parts[4])
+    if e0!=nil || e1!=nil || e2!=nil || rank<0 {
+      return nil, fmt.Errorf("ERROR: invalid seedlen, freq or rank in tile library (line %d)", line_no)
+    }
+
+    if e:=lib.Add(Tile{ line_parts[1], path_step, seedlen, freq, rank, line_parts[5] }) ; e!=nil {
+      return nil, fmt.Errorf("ERROR: %v in tile library (line %d)", e, line_no)
+    }
+  }
+
+  return lib, nil
+}
+
+// Add a tile.  Each tile, and each rank, can only be in
+// the library once per path.step.
+//
+func (lib Library) Add(t Tile) error {
+  if strings.Contains(t.Build, ",") {
+    return fmt.Errorf("build '%s' of tile %s %s can't contain ','", t.Build, t.PathStep, t.Md5Sum)
+  }
+
+  if _,ok := lib[t.PathStep] ; !ok {
+    lib[t.PathStep] = make(map[string]Tile)
+  }
+  tiles := lib[t.PathStep]
+
+  if _,ok := tiles[t.Md5Sum] ; ok {
+    return fmt.Errorf("tile %s %s listed twice", t.PathStep, t.Md5Sum)
+  }
+  for _,u := range tiles {
+    if u.Rank==t.Rank {
+      return fmt.Errorf("tiles %s and %s at %s both have rank %d", u.Md5Sum, t.Md5Sum, t.PathStep, t.Rank)
+    }
+  }
+
+  tiles[t.Md5Sum] = t
+  return nil
+}
+
+// Rank for the next new tile at pos, one past the
+// highest rank there.
+//
+func (lib Library) NextRank(pos fastj.TilePos) int {
+  next := 0
+  for _,t := range lib[pos] {
+    if t.Rank >= next { next = t.Rank+1 }
+  }
+  return next
+}
+
+type TileOrder []Tile
+func (t TileOrder) Len() int { return len(t) }
+func (t TileOrder) Swap(i,j int) { t[i],t[j] = t[j],t[i] }
+func (t TileOrder) Less(i,j int) bool {
+  if c := t[i].PathStep.Cmp(t[j].PathStep) ; c!=0 { return c<0 }
+  return t[i].Rank < t[j].Rank
+}
+
+// Write the library in path.step then rank order.
+//
+func (lib Library) Write(w io.Writer) error {
+  tiles := make([]Tile, 0, len(lib))
+  for path_step := range lib {
+    for _,t := range lib[path_step] {
+      tiles = append(tiles, t)
+    }
+  }
+  sort.Sort(TileOrder(tiles))
+
+  for _,t := range tiles {
+    _,e := fmt.Fprintf(w, "%s,%s,%d,%d,%d,%s\n", t.PathStep, t.Md5Sum, t.SeedLen, t.Freq, t.Rank, t.Build)
+    if e!=nil { return e }
+  }
+  return nil
+}
@@ -0,0 +1,158 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package tilelib
+
+import "testing"
+import "bytes"
+import "io/ioutil"
+import "os"
+import "path/filepath"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+var test_lib = `2c5.03cc,aaaa,1,10,0,b1
+2c5.03cc,bbbb,1,4,1,b1
+2c5.03cc,cccc,2,1,3,b2
+2c5.03cd,dddd,1,12,0,b1
+247.0ae5,eeee,1,7,0,b2
+`
+
+func load_string(t *testing.T, dir, s string) Library {
+  fn := filepath.Join(dir, "tilelib.csv")
+  if e := ioutil.WriteFile(fn, []byte(s), 0644) ; e!=nil { t.Fatal(e) }
+  lib,e := Load(fn)
+  if e!=nil { t.Fatal(e) }
+  return lib
+}
+
+func pos(t *testing.T, s string) fastj.TilePos {
+  p,e := fastj.ParseTilePos(s)
+  if e!=nil { t.Fatal(e) }
+  return p
+}
+
+// Tiles already in the library keep their rank from one build
+// to the next, new tiles are ranked after the highest rank at
+// their path.step and the library is written back in path.step
+// then rank order.
+//
+func TestRanksKept(t *testing.T) {
+  dir,e := ioutil.TempDir("", "tilelib")
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll(dir)
+
+  lib := load_string(t, dir, test_lib)
+
+  var buf bytes.Buffer
+  if e := lib.Write(&buf) ; e!=nil { t.Fatal(e) }
+  want := `247.0ae5,eeee,1,7,0,b2
+2c5.03cc,aaaa,1,10,0,b1
+2c5.03cc,bbbb,1,4,1,b1
+2c5.03cc,cccc,2,1,3,b2
+2c5.03cd,dddd,1,12,0,b1
+`
+  if buf.String()!=want {
+    t.Errorf("Write: got\n%s\nexpected\n%s", buf.String(), want)
+  }
+
+  next := []struct {
+    pos string
+    rank int
+  }{
+    { "2c5.03cc", 4 },
+    { "2c5.03cd", 1 },
+    { "247.0ae5", 1 },
+    { "2c5.03ce", 0 },
+  }
+  for _,x := range next {
+    if got := lib.NextRank(pos(t, x.pos)) ; got!=x.rank {
+      t.Errorf("NextRank(%s): got %d, expected %d", x.pos, got, x.rank)
+    }
+  }
+
+  added := []Tile{
+    { "ffff", pos(t, "2c5.03cc"), 1, 2, lib.NextRank(pos(t, "2c5.03cc")), "b3" },
+    { "0000", pos(t, "2c5.03ce"), 1, 9, lib.NextRank(pos(t, "2c5.03ce")), "b3" },
+  }
+  for _,nt := range added {
+    if e := lib.Add(nt) ; e!=nil { t.Fatal(e) }
+  }
+
+  buf.Reset()
+  if e := lib.Write(&buf) ; e!=nil { t.Fatal(e) }
+  lib = load_string(t, dir, buf.String())
+
+  ranks := []struct {
+    pos, md5 string
+    rank int
+  }{
+    { "2c5.03cc", "aaaa", 0 },
+    { "2c5.03cc", "bbbb", 1 },
+    { "2c5.03cc", "cccc", 3 },
+    { "2c5.03cc", "ffff", 4 },
+    { "2c5.03cd", "dddd", 0 },
+    { "2c5.03ce", "0000", 0 },
+    { "247.0ae5", "eeee", 0 },
+  }
+  for _,x := range ranks {
+    tile,ok := lib[pos(t, x.pos)][x.md5]
+    if !ok { t.Errorf("%s %s: missing after reload", x.pos, x.md5) ; continue }
+    if tile.Rank!=x.rank {
+      t.Errorf("%s %s: rank got %d, expected %d", x.pos, x.md5, tile.Rank, x.rank)
+    }
+  }
+  if tile := lib[pos(t, "2c5.03cc")]["cccc"] ; tile.SeedLen!=2 || tile.Freq!=1 || tile.Build!="b2" {
+    t.Errorf("2c5.03cc cccc: got %+v, expected seedlen 2, freq 1, build b2", tile)
+  }
+}
+
+func TestLoadMissing(t *testing.T) {
+  lib,e := Load(filepath.Join(os.TempDir(), "tilelib-does-not-exist.csv"))
+  if e!=nil { t.Fatal(e) }
+  if len(lib)!=0 { t.Errorf("got %d path.steps, expected an empty library", len(lib)) }
+}
+
+func TestLoadInvalid(t *testing.T) {
+  dir,e := ioutil.TempDir("", "tilelib")
+  if e!=nil { t.Fatal(e) }
+  defer os.RemoveAll(dir)
+
+  bad := []string{
+    "2c5.03cc,aaaa,1,10,0\n",
+    "2c5.03cc,aaaa,1,10,x,b1\n",
+    "2c5.03cc,aaaa,1,10,-1,b1\n",
+    "2c5,aaaa,1,10,0,b1\n",
+    "2c5.03cc,aaaa,1,10,0,b1\n2c5.03cc,aaaa,1,3,1,b1\n",
+    "2c5.03cc,aaaa,1,10,0,b1\n2c5.03cc,bbbb,1,3,0,b1\n",
+  }
+  for _,s := range bad {
+    fn := filepath.Join(dir, "bad.csv")
+    if e := ioutil.WriteFile(fn, []byte(s), 0644) ; e!=nil { t.Fatal(e) }
+    if _,e := Load(fn) ; e==nil {
+      t.Errorf("Load(%q): expected an error", s)
+    }
+  }
+
+  lib := New()
+  if e := lib.Add(Tile{ "aaaa", fastj.TilePos{ Path:0x2c5, Step:0x3cc }, 1, 1, 0, "a,b" }) ; e==nil {
+    t.Errorf("Add with ',' in the build: expected an error")
+  }
+}
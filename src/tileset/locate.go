@@ -0,0 +1,259 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+package tileset
+
+import "fmt"
+import "sort"
+import "strings"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+// Status of a tag after locating the tag set on a sequence.
+//
+const (
+  TAG_OK = iota
+  TAG_MISSING
+  TAG_DUPLICATE
+  TAG_MISMATCH
+  TAG_OUT_OF_ORDER
+)
+
+var tag_status_name = []string{ "ok", "missing", "duplicate", "mismatch", "out-of-order" }
+
+// A place a tag matches the sequence with Mismatch
+// mismatches.
+//
+type Hit struct {
+  SeqPos int
+  Mismatch int
+}
+
+// What happened to a single tag.  Hits has every place the
+// tag matched, Chosen is the index of the hit used as the
+// anchor or -1 if the tag wasn't used.
+//
+type TagReport struct {
+  Pos fastj.TilePos
+  Status int
+  Hits []Hit
+  Chosen int
+}
+
+// One line per report:
+//
+//   path.step,status,seq_pos[;seq_pos...],mismatch[;mismatch...],chosen_seq_pos
+//
+// chosen_seq_pos is empty if the tag wasn't used.
+//
+func (r TagReport) String() string {
+  pos := make([]string, len(r.Hits))
+  mm := make([]string, len(r.Hits))
+  for i:=0; i<len(r.Hits); i++ {
+    pos[i] = fmt.Sprintf("%d", r.Hits[i].SeqPos)
+    mm[i] = fmt.Sprintf("%d", r.Hits[i].Mismatch)
+  }
+
+  chosen := ""
+  if r.Chosen>=0 { chosen = fmt.Sprintf("%d", r.Hits[r.Chosen].SeqPos) }
+
+  return fmt.Sprintf("%s,%s,%s,%s,%s", r.Pos, tag_status_name[r.Status],
+    strings.Join(pos, ";"), strings.Join(mm, ";"), chosen)
+}
+
+func mismatches(a, b string, max int) int {
+  n:=0
+  for i:=0; i<len(a); i++ {
+    if a[i]!=b[i] {
+      n++
+      if n>max { return n }
+    }
+  }
+  return n
+}
+
+// Every place in seq tag matches with at most max_mismatch
+// mismatches, in sequence order.
+//
+// With mismatches allowed, the tag is split into max_mismatch+1
+// seeds.  Any match has at least one seed that matches exactly,
+// so only the places the seeds are found need to be checked.
+//
+func find_hits(seq, tag string, max_mismatch int, seed_index map[string][]int, seed_len int) []Hit {
+  hits := make([]Hit, 0, 1)
+
+  if max_mismatch==0 {
+    for off:=0; ; {
+      p := strings.Index(seq[off:], tag)
+      if p<0 { break }
+      hits = append(hits, Hit{ SeqPos: off+p })
+      off += p+1
+    }
+    return hits
+  }
+
+  seen := make(map[int]bool)
+  for k:=0; k<=max_mismatch; k++ {
+    seed_off := k*seed_len
+    for _,p := range seed_index[tag[seed_off:seed_off+seed_len]] {
+      start := p-seed_off
+      if start<0 || start+len(tag)>len(seq) || seen[start] { continue }
+      seen[start] = true
+
+      if m := mismatches(seq[start:start+len(tag)], tag, max_mismatch) ; m<=max_mismatch {
+        hits = append(hits, Hit{ SeqPos: start, Mismatch: m })
+      }
+    }
+  }
+
+  sort.Sort(HitOrder(hits))
+  return hits
+}
+
+type HitOrder []Hit
+func (h HitOrder) Len() int { return len(h) }
+func (h HitOrder) Swap(i,j int) { h[i],h[j] = h[j],h[i] }
+func (h HitOrder) Less(i,j int) bool { return h[i].SeqPos < h[j].SeqPos }
+
+// Prefix maximum over positions (a Fenwick tree) used to
+// find the best chain of tags ending before a position.
+//
+type chain_tree struct {
+  score []int
+  cand []int
+}
+
+func new_chain_tree(n int) *chain_tree {
+  t := &chain_tree{ make([]int, n+1), make([]int, n+1) }
+  for i:=0; i<=n; i++ { t.cand[i] = -1 }
+  return t
+}
+
+// Best score and candidate over positions [0,i).
+//
+func (t *chain_tree) query(i int) (int, int) {
+  score,cand := 0,-1
+  for ; i>0; i -= i&(-i) {
+    if t.cand[i]>=0 && t.score[i]>score { score,cand = t.score[i],t.cand[i] }
+  }
+  return score, cand
+}
+
+func (t *chain_tree) update(i, score, cand int) {
+  for i++ ; i<len(t.score); i += i&(-i) {
+    if t.cand[i]<0 || score>t.score[i] { t.score[i],t.cand[i] = score,cand }
+  }
+}
+
+// Locate the tags on seq, allowing up to max_mismatch mismatches.
+//
+// All matches of every tag are found.  Of those, at most one per tag
+// is used so that the tag positions increase along the sequence in
+// path.step order, placing as many tags as possible and then using
+// as few mismatches as possible.  Returns the anchors and a report
+// for every tag that is missing, duplicated, mismatched or dropped
+// to keep the order.
+//
+func (ts *Tagset) Locate(seq string, max_mismatch int) ([]Anchor, []TagReport) {
+  if max_mismatch<0 { max_mismatch = 0 }
+  if max_mismatch>=fastj.TAGLEN { max_mismatch = fastj.TAGLEN-1 }
+
+  var seed_index map[string][]int
+  seed_len := fastj.TAGLEN/(max_mismatch+1)
+  if max_mismatch>0 {
+    seed_index = make(map[string][]int)
+    for i:=0; i+seed_len<=len(seq); i++ {
+      seed_index[seq[i:i+seed_len]] = append(seed_index[seq[i:i+seed_len]], i)
+    }
+  }
+
+  type candidate struct {
+    index int
+    hit int
+    prev int
+  }
+
+  reports := make([]TagReport, len(ts.Pos))
+  cands := make([]candidate, 0, len(ts.Pos))
+  first_cand := make([]int, len(ts.Pos)+1)
+
+  for i:=0; i<len(ts.Pos); i++ {
+    first_cand[i] = len(cands)
+    hits := find_hits(seq, ts.Tag[ts.Pos[i]], max_mismatch, seed_index, seed_len)
+    reports[i] = TagReport{ Pos: ts.Pos[i], Hits: hits, Chosen: -1 }
+    for h:=0; h<len(hits); h++ {
+      cands = append(cands, candidate{ i, h, -1 })
+    }
+  }
+  first_cand[len(ts.Pos)] = len(cands)
+
+  // Longest chain of candidates, increasing in both tag order
+  // and sequence position.  Each placed tag is worth more than
+  // any number of mismatches.
+  //
+  weight := fastj.TAGLEN+1
+  score := make([]int, len(cands))
+  tree := new_chain_tree(len(seq))
+  best,best_cand := 0,-1
+
+  for i:=0; i<len(ts.Pos); i++ {
+    for c:=first_cand[i]; c<first_cand[i+1]; c++ {
+      h := reports[i].Hits[cands[c].hit]
+      prev_score,prev := tree.query(h.SeqPos)
+      score[c] = prev_score + weight - h.Mismatch
+      cands[c].prev = prev
+      if score[c]>best { best,best_cand = score[c],c }
+    }
+
+    // Only update once all of the tag's candidates are
+    // scored so a tag can't follow itself.
+    //
+    for c:=first_cand[i]; c<first_cand[i+1]; c++ {
+      tree.update(reports[i].Hits[cands[c].hit].SeqPos, score[c], c)
+    }
+  }
+
+  chain := make([]int, 0, len(ts.Pos))
+  for c:=best_cand; c>=0; c = cands[c].prev {
+    chain = append(chain, c)
+  }
+
+  anchors := make([]Anchor, len(chain))
+  for k:=0; k<len(chain); k++ {
+    c := cands[chain[len(chain)-1-k]]
+    h := reports[c.index].Hits[c.hit]
+    reports[c.index].Chosen = c.hit
+    anchors[k] = Anchor{ Index: c.index, SeqPos: h.SeqPos, RefPos: h.SeqPos }
+  }
+
+  problems := make([]TagReport, 0)
+  for i:=0; i<len(reports); i++ {
+    r := &reports[i]
+    switch {
+    case len(r.Hits)==0: r.Status = TAG_MISSING
+    case r.Chosen<0: r.Status = TAG_OUT_OF_ORDER
+    case len(r.Hits)>1: r.Status = TAG_DUPLICATE
+    case r.Hits[r.Chosen].Mismatch>0: r.Status = TAG_MISMATCH
+    default: continue
+    }
+    problems = append(problems, *r)
+  }
+
+  return anchors, problems
+}
@@ -0,0 +1,160 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+
+package tileset
+
+import "testing"
+import "math/rand"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+// A random sequence of n bases with a tag set of the 24mers at
+// each of tag_pos, at steps 0, 1, ... of path 2c5.
+//
+func random_tagset(n int, tag_pos []int) ([]byte, *Tagset) {
+  r := rand.New(rand.NewSource(1))
+  seq := make([]byte, n)
+  for i:=0; i<n; i++ { seq[i] = "acgt"[r.Intn(4)] }
+
+  ts := &Tagset{ Tag: make(map[fastj.TilePos]string) }
+  for i,p := range tag_pos {
+    pos := fastj.TilePos{ Path:0x2c5, Step:i }
+    ts.Pos = append(ts.Pos, pos)
+    ts.Tag[pos] = string(seq[p:p+fastj.TAGLEN])
+  }
+  return seq, ts
+}
+
+// Change base i of seq to another base.
+//
+func mutate(seq []byte, i int) {
+  seq[i] = map[byte]byte{ 'a':'c', 'c':'g', 'g':'t', 't':'a' }[seq[i]]
+}
+
+func anchor_pos(anchors []Anchor) []int {
+  p := make([]int, len(anchors))
+  for i:=0; i<len(anchors); i++ { p[i] = anchors[i].SeqPos }
+  return p
+}
+
+func report_status(reports []TagReport) map[int]int {
+  m := make(map[int]int)
+  for _,r := range reports { m[r.Pos.Step] = r.Status }
+  return m
+}
+
+func equal_ints(a, b []int) bool {
+  if len(a)!=len(b) { return false }
+  for i:=0; i<len(a); i++ {
+    if a[i]!=b[i] { return false }
+  }
+  return true
+}
+
+func TestLocate(t *testing.T) {
+  tag_pos := []int{ 0, 100, 200, 300, 400 }
+
+  tests := []struct {
+    name string
+    edit func(seq []byte)
+    max_mismatch int
+    anchors []int
+    status map[int]int
+  }{
+    { "exact", func(seq []byte) {}, 0,
+      []int{ 0, 100, 200, 300, 400 }, map[int]int{} },
+
+    { "mismatch not allowed", func(seq []byte) { mutate(seq, 205) }, 0,
+      []int{ 0, 100, 300, 400 }, map[int]int{ 2:TAG_MISSING } },
+
+    { "mismatch", func(seq []byte) { mutate(seq, 205) }, 1,
+      []int{ 0, 100, 200, 300, 400 }, map[int]int{ 2:TAG_MISMATCH } },
+
+    { "too many mismatches", func(seq []byte) { mutate(seq, 205) ; mutate(seq, 215) }, 1,
+      []int{ 0, 100, 300, 400 }, map[int]int{ 2:TAG_MISSING } },
+
+    // The copy of tag 1 after tag 2 is out of order, so the
+    // one at 100 is used.
+    //
+    { "duplicate", func(seq []byte) { copy(seq[250:], seq[100:124]) }, 0,
+      []int{ 0, 100, 200, 300, 400 }, map[int]int{ 1:TAG_DUPLICATE } },
+
+    // The copy of tag 3 before tag 1 is out of order, so the
+    // one at 300 is used.
+    //
+    { "duplicate out of order first", func(seq []byte) { copy(seq[50:], seq[300:324]) }, 0,
+      []int{ 0, 100, 200, 300, 400 }, map[int]int{ 3:TAG_DUPLICATE } },
+
+    // With only a copy of tag 3 before tag 1, dropping tag 3
+    // keeps more tags than dropping tags 1 and 2.
+    //
+    { "out of order", func(seq []byte) { copy(seq[50:], seq[300:324]) ; mutate(seq, 310) }, 0,
+      []int{ 0, 100, 200, 400 }, map[int]int{ 3:TAG_OUT_OF_ORDER } },
+
+    // An exact duplicate is used over a mismatched one when
+    // both keep the order.
+    //
+    { "fewest mismatches", func(seq []byte) { copy(seq[150:], seq[100:124]) ; mutate(seq, 110) }, 1,
+      []int{ 0, 150, 200, 300, 400 }, map[int]int{ 1:TAG_DUPLICATE } },
+  }
+
+  for _,x := range tests {
+    seq,ts := random_tagset(500, tag_pos)
+    x.edit(seq)
+
+    anchors,reports := ts.Locate(string(seq), x.max_mismatch)
+    if got := anchor_pos(anchors) ; !equal_ints(got, x.anchors) {
+      t.Errorf("%s: anchors got %v, expected %v", x.name, got, x.anchors)
+    }
+
+    status := report_status(reports)
+    if len(status)!=len(x.status) {
+      t.Errorf("%s: reports got %v, expected %v", x.name, reports, x.status)
+      continue
+    }
+    for step,s := range x.status {
+      if status[step]!=s {
+        t.Errorf("%s: tag %d status got %s, expected %s", x.name, step, tag_status_name[status[step]], tag_status_name[s])
+      }
+    }
+  }
+}
+
+// Every anchor chosen must have its tag increase along the
+// sequence.
+//
+func TestLocateMonotone(t *testing.T) {
+  seq,ts := random_tagset(1000, []int{ 0, 100, 200, 300, 400, 500, 600, 700, 800 })
+  copy(seq[650:], seq[100:124])
+  copy(seq[150:], seq[800:824])
+  copy(seq[450:], seq[300:324])
+  mutate(seq, 310)
+
+  anchors,_ := ts.Locate(string(seq), 1)
+  for k:=1; k<len(anchors); k++ {
+    if anchors[k].Index<=anchors[k-1].Index || anchors[k].SeqPos<=anchors[k-1].SeqPos {
+      t.Errorf("anchor %d (tag %d at %d) does not follow anchor %d (tag %d at %d)",
+        k, anchors[k].Index, anchors[k].SeqPos, k-1, anchors[k-1].Index, anchors[k-1].SeqPos)
+    }
+  }
+  if len(anchors)!=len(ts.Pos) {
+    t.Errorf("got %d anchors (%v), expected %d", len(anchors), anchor_pos(anchors), len(ts.Pos))
+  }
+}
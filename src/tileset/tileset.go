@@ -0,0 +1,189 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Package tileset cuts sequences into FastJ tiles using
+// an ordered tag set, a CSV of:
+//
+//   path.step,tag_sequence
+//
+// Tags are first located on a sequence, giving Anchors (see
+// Locate).  A tile runs from the start of one anchored tag to
+// the end of the next one.  Tags that are not anchored are
+// skipped over, making tiles that span more than one step.
+//
+package tileset
+
+import "fmt"
+import "sort"
+import "strings"
+
+import "github.com/abeconnelly/autoio"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+
+type Tagset struct {
+
+  // Tag positions in path.step order.
+  //
+  Pos []fastj.TilePos
+
+  // Tag sequence (lower case) at each position.
+  //
+  Tag map[fastj.TilePos]string
+}
+
+// Load a tag set CSV.
+//
+func Load(fn string) (*Tagset, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  ts := &Tagset{}
+  ts.Tag = make(map[fastj.TilePos]string)
+
+  line_no:=0
+
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+    fields := strings.Split(l, ",")
+    if len(fields) != 2 { return nil, fmt.Errorf("bad read on line %d", line_no) }
+
+    tilepos,e := fastj.ParseTilePos(fields[0])
+    if e!=nil { return nil, fmt.Errorf("bad tile position on line %d: %v", line_no, e) }
+    if len(fields[1])!=fastj.TAGLEN {
+      return nil, fmt.Errorf("tag on line %d is %d long, expected %d", line_no, len(fields[1]), fastj.TAGLEN)
+    }
+
+    if _,dup := ts.Tag[tilepos] ; dup { return nil, fmt.Errorf("duplicate tile position %s on line %d", tilepos, line_no) }
+
+    ts.Tag[tilepos] = strings.ToLower(fields[1])
+    ts.Pos = append(ts.Pos, tilepos)
+  }
+
+  sort.Sort(fastj.TilePosOrder(ts.Pos))
+
+  return ts, nil
+}
+
+// A tag located on a sequence.
+//
+type Anchor struct {
+
+  // Index of the tag in Tagset.Pos.
+  //
+  Index int
+
+  // Start of the tag in the sequence being tiled.
+  //
+  SeqPos int
+
+  // Start of the tag in reference coordinates, used
+  // for the locus.  The same as SeqPos when tiling
+  // the reference itself.
+  //
+  RefPos int
+}
+
+// Locate the tags in seq by exact match.  See Locate.
+//
+func (ts *Tagset) FindAnchors(seq string) []Anchor {
+  anchors,_ := ts.Locate(seq, 0)
+  return anchors
+}
+
+// Information for the locus and notes of a tile.
+//
+type Locus struct {
+
+  // e.g. 'grch38 chr17'
+  //
+  BuildPrefix string
+
+  // Reference coordinate of the first base of
+  // the reference sequence.
+  //
+  SeqStart int
+}
+
+// Cut seq into tiles between consecutive anchors.  variant
+// is the tile ID variant (the haplotype allele, 0 or 1).
+//
+func (ts *Tagset) Tiles(seq string, anchors []Anchor, variant int, locus Locus) []*fastj.Tile {
+  tiles := make([]*fastj.Tile, 0, len(anchors))
+
+  for k:=0; k+1<len(anchors); k++ {
+    a := anchors[k]
+    b := anchors[k+1]
+
+    pos := ts.Pos[a.Index]
+    tile_seq := seq[a.SeqPos:b.SeqPos+fastj.TAGLEN]
+
+    s := a.RefPos + locus.SeqStart
+    e := b.RefPos + fastj.TAGLEN + locus.SeqStart - 1
+
+    tile := NewTile(fastj.TileID{ Path: pos.Path, Ver: 0, Step: pos.Step, Variant: variant }, tile_seq, b.Index-a.Index)
+    tile.StartTag = ts.Tag[pos]
+    tile.EndTag = ts.Tag[ts.Pos[b.Index]]
+    tile.Locus = []fastj.Locus{ fastj.Locus{ Build: fmt.Sprintf("%s %d %d", locus.BuildPrefix, s, e) } }
+    tile.Notes = append(tile.Notes, tag_variant_notes("start", tile.StartTag, tile.StartSeq)...)
+    tile.Notes = append(tile.Notes, tag_variant_notes("end", tile.EndTag, tile.EndSeq)...)
+
+    tiles = append(tiles, tile)
+  }
+
+  return tiles
+}
+
+// Notes for the bases where the sequence differs from the tag
+// it was matched to:
+//
+//   tagVariant [start|end] [offset] [tag base]/[sequence base]
+//
+// No-calls in the sequence are not tag variation.
+//
+func tag_variant_notes(which, tag, seq string) []string {
+  notes := []string{}
+  for i:=0; i<len(tag) && i<len(seq); i++ {
+    if tag[i]==seq[i] || seq[i]=='n' || seq[i]=='N' { continue }
+    notes = append(notes, fmt.Sprintf("tagVariant %s %d %c/%c", which, i, tag[i], seq[i]))
+  }
+  return notes
+}
+
+// Make a tile for seq, filling in the md5sum, length, start
+// and end sequences and tags and the no-call count.
+//
+func NewTile(tileid fastj.TileID, seq string, seedlen int) *fastj.Tile {
+  tile := fastj.Tile{}
+  tile.TileID = tileid
+  tile.Md5Sum = fastj.Md5Sum(seq)
+  tile.N = len(seq)
+  tile.SeedTileLength = seedlen
+  tile.StartSeq = seq[0:fastj.TAGLEN]
+  tile.EndSeq = seq[len(seq)-fastj.TAGLEN:]
+  tile.StartTag = tile.StartSeq
+  tile.EndTag = tile.EndSeq
+  tile.NocallCount = strings.Count(seq, "n") + strings.Count(seq, "N")
+  tile.Notes = []string{}
+  tile.Seq = seq
+  return &tile
+}
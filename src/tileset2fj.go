@@ -21,10 +21,29 @@
 //
 //   path.step,tag_sequence
 //
-// along with a sequence and create a FastJ file.
+// along with a FASTA file and create a FastJ file.  Each
+// record is tiled on its own.  The locus of each tile is:
+//
+//   [build-prefix] [record name] [start] [end]
+//
+// with the start and end offset by --seq-start.  Line wrapped,
+// gzipped and multi-record FASTA are all fine.  --record picks
+// out records by name (and can be given more than once), using
+// the .fai index if there is one.  A plain sequence with no
+// header line is taken as a single record with no name, in
+// which case the locus is just the build prefix and position.
+//
+// Tags are located allowing up to --max-mismatch mismatches
+// (default 0).  Tags that are missing, duplicated, mismatched
+// or out of order are reported, one line each, as:
+//
+//   record,path.step,status,seq_pos[;seq_pos...],mismatch[;mismatch...],chosen_seq_pos
+//
+// to --report (default stderr).  Mismatched tags are kept and
+// recorded in the tile notes as 'tagVariant' entries.
 //
 // example usage:
-//  ./tileset2fj -i 'actcat....gcat' -t mytileset.csv --build-prefix 'hg19 chr17' -o out.fj
+//  ./tileset2fj -i chr17.fa -t mytileset.csv --build-prefix 'hg19' -o out.fj
 //
 
 package main
@@ -35,14 +54,15 @@ import "os"
 import "runtime"
 import "runtime/pprof"
 
+import "io"
 import "strings"
-import _ "bufio"
 
 import "github.com/abeconnelly/autoio"
 import "github.com/codegangsta/cli"
 
-import "sort"
-import "crypto/md5"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fasta"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/tileset"
 
 var VERSION_STR string = "0.1.0"
 var gVerboseFlag bool
@@ -53,136 +73,89 @@ var gProfileFile string = "tileset2fj.pprof"
 var gMemProfileFlag bool
 var gMemProfileFile string = "tileset2fj.mprof"
 
-var g_tagset map[string]string
-var g_tagseq map[string]string
-
-var g_seq string
+var g_tagset *tileset.Tagset
 
 var g_build_prefix string
 var g_seq_start int
 
+var g_max_mismatch int
+
 func init() {
   g_build_prefix = "unknown"
-  g_tagset = make(map[string]string)
-  g_tagseq = make(map[string]string)
 }
 
-func md5sum_str(seq string) string {
-  ta := make([]string, 0, 32)
-  s := md5.Sum([]byte(seq))
-  for i:=0; i<len(s); i++ {
-    ta = append(ta, fmt.Sprintf("%02x", s[i]))
-  }
-  return strings.Join(ta, "")
-}
-
-
-func load_tagset(h autoio.AutoioHandle) error {
-  line_no:=0
+func gen_tiling(fj_out *fastj.Writer, report_fp io.Writer, rec *fasta.Record) {
+  seq := strings.ToLower(rec.Seq)
 
-  for h.ReadScan() {
-    line_no++
-    l := h.ReadText()
-    if len(l)==0 { continue }
-    fields := strings.Split(l, ",")
-    if len(fields) != 2 { return fmt.Errorf("bad read on line %s", line_no) }
-
-    g_tagset[fields[0]] = fields[1]
-    g_tagseq[fields[1]] = fields[0]
+  anchors,reports := g_tagset.Locate(seq, g_max_mismatch)
+  for i:=0; i<len(reports); i++ {
+    fmt.Fprintf(report_fp, "%s,%s\n", rec.Name, reports[i])
   }
 
-  return nil
-
-}
-
-func load_seq(h autoio.AutoioHandle) {
-  //fold := 50
-
-  for h.ReadScan() {
-    l := h.ReadText()
-    if len(l) == 0 { continue }
-    g_seq = l
+  locus := tileset.Locus{ BuildPrefix: g_build_prefix, SeqStart: g_seq_start }
+  if len(rec.Name)>0 {
+    locus.BuildPrefix = strings.TrimSpace(g_build_prefix + " " + rec.Name)
   }
 
-  g_seq = strings.ToLower(g_seq)
-
-}
-
-func find_tag_positions() {
-  //for tilepos := range tagpos_ind { fmt.Printf("%s >> %d\n", tilepos, tagpos_ind[tilepos]) }
-}
-
-func print_fold(seq string, fold int) {
-  if len(seq)==0 { return }
-
-  p:=0
-  for ; p<(len(seq)-fold); p+=fold {
-    fmt.Printf("%s\n", seq[p:p+fold])
+  tiles := g_tagset.Tiles(seq, anchors, 0, locus)
+  for i:=0; i<len(tiles); i++ {
+    fj_out.Write(tiles[i])
   }
-  fmt.Printf("%s\n", seq[p:])
 
 }
 
-type LexOrder []string
-func (s LexOrder) Len() int { return len(s) }
-func (s LexOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
-func (s LexOrder) Less(i,j int) bool { return s[i] < s[j] }
-
-
-func gen_tiling() {
-  tagpos_ind := make(map[string]int)
-
-  for tilepos := range g_tagset {
-    seq := g_tagset[tilepos]
-    tagpos_ind[tilepos] = strings.Index(g_seq, seq)
-  }
-
-  tagpos := make([]string, 0, len(g_tagset))
-  for tilepos := range g_tagset {
-    tagpos = append(tagpos, tilepos)
+// Tile the named records, in file order, or every record
+// if names is empty.
+//
+func tile_fasta(fn string, names []string, fj_out *fastj.Writer, report_fp io.Writer) error {
+  want := make(map[string]bool)
+  for i:=0; i<len(names); i++ { want[names[i]] = true }
+
+  found := make(map[string]bool)
+  check_missing := func() error {
+    for i:=0; i<len(names); i++ {
+      if !found[names[i]] { return fmt.Errorf("%s: no FASTA record named '%s'", fn, names[i]) }
+    }
+    return nil
   }
-  sort.Sort(LexOrder(tagpos))
 
-  for i:=0; i<len(tagpos); i++ {
-    if (tagpos_ind[tagpos[i]]<0) { continue }
-    n:=1
-    for ; (i+n)<len(tagpos); n++ {
-      if tagpos_ind[tagpos[i+n]]>=0 { break }
+  if len(names)>0 {
+    idx,e := fasta.ReadIndex(fn)
+    if e!=nil { return e }
+
+    if idx!=nil {
+      for i:=0; i<len(idx); i++ {
+        if !want[idx[i].Name] { continue }
+        found[idx[i].Name] = true
+      }
+      if e:=check_missing() ; e!=nil { return e }
+
+      for i:=0; i<len(idx); i++ {
+        if !want[idx[i].Name] { continue }
+        rec,e := fasta.ReadIndexed(fn, idx[i])
+        if e!=nil { return e }
+        gen_tiling(fj_out, report_fp, rec)
+      }
+      return nil
     }
-
-    if (i+n)==len(tagpos) { continue }
-
-    sp := tagpos_ind[tagpos[i]]
-    ep := tagpos_ind[tagpos[i+n]] + 24
-
-    seq := g_seq[sp:ep]
-
-    tagpos_parts := strings.Split(tagpos[i], ".")
-    tileid := fmt.Sprintf("%03s.00.%04s.000", tagpos_parts[0], tagpos_parts[1])
-    m5 := md5sum_str(seq)
-
-    s := sp + g_seq_start
-    e := ep + g_seq_start - 1
-
-    json_str := fmt.Sprintf(`{ "tileID" : "%s", "md5sum":"%s", "locus":[{"build":"%s %d %d"}], "n":%d, "seedTileLength":%d, ` +
-      `"startTile":false, "endTile":false, "startSeq":"%s", "endSeq":"%s", ` +
-      `"startTag":"%s", "endTag":"%s", "nocallCount":%d, "notes":[] }`,
-      tileid, m5, g_build_prefix, s, e, len(seq), n,
-      seq[0:24], seq[len(seq)-24:], seq[0:24], seq[len(seq)-24:], 0)
-
-
-    fmt.Printf("> %s\n", json_str)
-    print_fold(seq, 50)
-    fmt.Printf("\n")
-
   }
 
+  e := fasta.Each(fn, func(rec *fasta.Record) error {
+    if len(names)>0 && !want[rec.Name] { return nil }
+    found[rec.Name] = true
+    gen_tiling(fj_out, report_fp, rec)
+    return nil
+  })
+  if e!=nil { return e }
+
+  return check_missing()
 }
 
 func _main( c *cli.Context ) {
 
   g_build_prefix = c.String("build-prefix")
   g_seq_start = c.Int("seq-start")
+  g_max_mismatch = c.Int("max-mismatch")
 
   if c.String("input") == "" {
     fmt.Fprintf( os.Stderr, "Input required, exiting\n" )
@@ -190,26 +163,35 @@ func _main( c *cli.Context ) {
     os.Exit(1)
   }
 
-  seq_fp,err := autoio.OpenReadScannerSimple( c.String("input") ) ; _ = seq_fp
+  var err error
+  g_tagset,err = tileset.Load( c.String("tileset") )
   if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
+    fmt.Fprintf(os.Stderr, "%v\n", err)
     os.Exit(1)
   }
-  defer seq_fp.Close()
 
-  tileset_fp,err := autoio.OpenReadScannerSimple( c.String("tileset") ) ; _ = tileset_fp
+  report_fp := os.Stderr
+  if c.String("report")!="" && c.String("report")!="-" {
+    report_fp,err = os.Create(c.String("report"))
+    if err!=nil {
+      fmt.Fprintf(os.Stderr, "%v\n", err)
+      os.Exit(1)
+    }
+    defer report_fp.Close()
+  }
+
+  out,err := autoio.CreateWriter( c.String("output") )
   if err!=nil {
-    fmt.Fprintf(os.Stderr, "%v", err)
+    fmt.Fprintf(os.Stderr, "%v\n", err)
     os.Exit(1)
   }
-  defer tileset_fp.Close()
-
-  load_tagset(tileset_fp)
-  load_seq(seq_fp)
-
-  find_tag_positions()
-  gen_tiling()
+  defer func() { out.Flush() ; out.Close() }()
 
+  err = tile_fasta( c.String("input"), c.StringSlice("record"), fastj.NewWriter(out.Writer), report_fp )
+  if err!=nil {
+    fmt.Fprintf(os.Stderr, "%v\n", err)
+    os.Exit(1)
+  }
 
   if c.Bool( "pprof" ) {
     gProfileFlag = true
@@ -253,7 +235,13 @@ func main() {
   app.Flags = []cli.Flag{
     cli.StringFlag{
       Name: "input, i",
-      Usage: "INPUT sequence",
+      Usage: "INPUT FASTA (or plain sequence)",
+    },
+
+    cli.StringSliceFlag{
+      Name: "record, r",
+      Value: &cli.StringSlice{},
+      Usage: "Only tile the FASTA record named RECORD (can be specified more than once)",
     },
 
     cli.StringFlag{
@@ -263,7 +251,7 @@ func main() {
 
     cli.StringFlag{
       Name: "build-prefix",
-      Usage: "Prefix to put in build note (e.g. 'grch38 chr17')",
+      Usage: "Prefix to put in build note, before the record name (e.g. 'grch38')",
     },
 
     cli.IntFlag{
@@ -271,10 +259,20 @@ func main() {
       Usage: "Offset of sequence, used for build-prefix calculations. 0 reference.",
     },
 
+    cli.IntFlag{
+      Name: "max-mismatch",
+      Usage: "Maximum number of mismatches allowed when locating a tag (default 0)",
+    },
+
+    cli.StringFlag{
+      Name: "report",
+      Usage: "Write missing, duplicated, mismatched and out of order tags to REPORT (default stderr)",
+    },
+
     cli.StringFlag{
       Name: "output, o",
       Value: "-",
-      Usage: "OUTPUT",
+      Usage: "OUTPUT FastJ (default stdout)",
     },
 
     cli.IntFlag{
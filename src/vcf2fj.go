@@ -0,0 +1,618 @@
+/*
+
+    Copyright (C) 2015 Curoverse, Inc.
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as
+    published by the Free Software Foundation, either version 3 of the
+    License, or (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+*/
+
+// Create a FastJ file for a sample from a VCF, a reference
+// FASTA region and a tag set (as used by tileset2fj).
+//
+// The variants of the sample are applied to the reference to get
+// each haplotype, which is then tiled with the tag set.  Tiles of
+// the first haplotype are written with variant .000 and tiles of
+// the second with .001, interleaved in path.step order, so the
+// output can go straight into create_tile_graph and fj2allele.
+// A haploid sample only has .000 tiles (load it with fj2allele
+// -ploidy [sample]=1).
+//
+// Tags are located on the reference.  A haplotype keeps a tag
+// unless a called variant on that haplotype changes it, in which
+// case the tag is skipped and the tile spans more than one step.
+// Missing genotypes ('.') are no-calls: the reference bases are
+// replaced by 'n'.  No-calls don't break tags.
+//
+// Genotypes can be phased ('|') or unphased ('/').  Unphased
+// genotypes are assigned to haplotypes in the order listed and the
+// tiles they fall in are noted as 'Phase (UNPHASED)'.  The sample
+// is haploid if all its genotypes on the chromosome are (e.g. chrX
+// of a male sample), unless --ploidy says otherwise.  A haploid
+// genotype of a diploid sample is applied to both haplotypes.  The
+// FILTER column is ignored.  Variants that overlap one already applied on the same
+// haplotype are skipped with a warning.
+//
+// example usage:
+//
+//  ./vcf2fj -r chr17_region.fa --seq-start 43000000 -t tagset.csv -i sample.vcf.gz \
+//    --chrom chr17 --sample hu826751 --build-prefix 'grch38 chr17' -o hu826751_2c5.fj
+//
+// Here --seq-start is the 0-based reference coordinate of the first
+// base of the FASTA record, as in tileset2fj.
+//
+
+package main
+
+import "fmt"
+import "os"
+import "log"
+import "runtime"
+import "runtime/pprof"
+
+import "io"
+import "sort"
+import "strings"
+import "strconv"
+
+import "github.com/abeconnelly/autoio"
+import "github.com/codegangsta/cli"
+
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fasta"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/fastj"
+import "github.com/abeconnelly/hgvm-lighting-graph/src/tileset"
+
+var VERSION_STR string = "0.1.0"
+var gVerboseFlag bool
+
+var gProfileFlag bool
+var gProfileFile string = "vcf2fj.pprof"
+
+var gMemProfileFlag bool
+var gMemProfileFile string = "vcf2fj.mprof"
+
+var g_build_prefix string
+var g_seq_start int
+
+// Ploidy of the sample, 0 to take it from the genotypes.
+//
+var g_ploidy int
+
+// A single change to the reference on one haplotype.
+// Start and End are 0-based indexes into the reference
+// sequence, End exclusive.  An insertion has Start==End
+// and goes before the reference base at Start.
+//
+type Edit struct {
+  Start int
+  End int
+  Alt string
+  Nocall bool
+  Phased bool
+
+  // Start of Alt in the haplotype sequence, set
+  // when the edit is applied.
+  //
+  HapStart int
+
+  // VCF line the edit came from, for messages.
+  //
+  LineNo int
+}
+
+// A reference sequence with a set of edits applied.
+// HapPos maps each reference index to its index in
+// Seq, or -1 if the base was deleted.
+//
+type Haplotype struct {
+  Seq string
+  HapPos []int
+  Edits []Edit
+}
+
+// Remove the bases shared at the end and then at the start of
+// ref and alt, returning the number of bases removed from the
+// start.
+//
+func trim_allele(ref, alt string) (string, string, int) {
+  for len(ref)>0 && len(alt)>0 && ref[len(ref)-1]==alt[len(alt)-1] {
+    ref = ref[:len(ref)-1]
+    alt = alt[:len(alt)-1]
+  }
+
+  n:=0
+  for n<len(ref) && n<len(alt) && ref[n]==alt[n] { n++ }
+
+  return ref[n:], alt[n:], n
+}
+
+// Parse the GT field.  Returns the allele index per haplotype
+// (-1 for a missing allele), one for a haploid genotype, and
+// whether the genotype is phased.
+//
+func parse_gt(gt string) ([]int, bool, error) {
+  phased := !strings.Contains(gt, "/")
+  parts := strings.FieldsFunc(gt, func(r rune) bool { return r=='|' || r=='/' })
+  if len(parts)==0 || len(parts)>2 { return nil, false, fmt.Errorf("unsupported genotype '%s'", gt) }
+
+  idx := make([]int, len(parts))
+  for i:=0; i<len(parts); i++ {
+    if parts[i]=="." { idx[i] = -1 ; continue }
+    v,e := strconv.Atoi(parts[i])
+    if e!=nil || v<0 { return nil, false, fmt.Errorf("invalid genotype '%s'", gt) }
+    idx[i] = v
+  }
+
+  if len(idx)==1 { phased = true }
+
+  return idx, phased, nil
+}
+
+// Read the VCF and return the edits for each haplotype of the
+// sample, one for a haploid sample and two otherwise.  Only
+// records on chrom that fall within the reference sequence are
+// used.
+//
+func load_vcf(fn, chrom, sample, ref string) ([][]Edit, error) {
+  h,e := autoio.OpenReadScannerSimple(fn)
+  if e!=nil { return nil, e }
+  defer h.Close()
+
+  edits := make([][]Edit, 2)
+  sample_col := -1
+
+  // Edits of haploid genotypes, applied to the second
+  // haplotype too if the sample turns out to be diploid.
+  //
+  haploid_edits := make([]Edit, 0)
+  ploidy := 0
+
+  line_no:=0
+  for h.ReadScan() {
+    line_no++
+    l := h.ReadText()
+    if len(l)==0 { continue }
+
+    if strings.HasPrefix(l, "##") { continue }
+
+    fields := strings.Split(l, "\t")
+
+    if strings.HasPrefix(l, "#") {
+      if len(fields)<10 { return nil, fmt.Errorf("%s: no sample columns (line %d)", fn, line_no) }
+      for i:=9; i<len(fields); i++ {
+        if len(sample)==0 || fields[i]==sample { sample_col = i ; break }
+      }
+      if sample_col<0 { return nil, fmt.Errorf("%s: sample '%s' not found", fn, sample) }
+      continue
+    }
+
+    if sample_col<0 { return nil, fmt.Errorf("%s: missing #CHROM header line (line %d)", fn, line_no) }
+    if len(fields)<=sample_col { return nil, fmt.Errorf("%s: expected %d columns (line %d)", fn, sample_col+1, line_no) }
+    if fields[0]!=chrom { continue }
+
+    pos,e := strconv.Atoi(fields[1])
+    if e!=nil { return nil, fmt.Errorf("%s: invalid POS '%s' (line %d)", fn, fields[1], line_no) }
+
+    ref_allele := strings.ToLower(fields[3])
+    alt_alleles := strings.Split(strings.ToLower(fields[4]), ",")
+
+    start := pos - 1 - g_seq_start
+    end := start + len(ref_allele)
+    if end<=0 || start>=len(ref) { continue }
+    if start<0 || end>len(ref) {
+      fmt.Fprintf(os.Stderr, "WARNING: %s: variant at %s:%d crosses the end of the reference region, skipping (line %d)\n", fn, chrom, pos, line_no)
+      continue
+    }
+
+    if ref[start:end]!=ref_allele {
+      return nil, fmt.Errorf("%s: REF '%s' does not match the reference '%s' at %s:%d (line %d)", fn, fields[3], ref[start:end], chrom, pos, line_no)
+    }
+
+    format := strings.Split(fields[8], ":")
+    gt_idx := -1
+    for i:=0; i<len(format); i++ {
+      if format[i]=="GT" { gt_idx = i ; break }
+    }
+    if gt_idx<0 { return nil, fmt.Errorf("%s: no GT field (line %d)", fn, line_no) }
+
+    sample_fields := strings.Split(fields[sample_col], ":")
+    gt := "."
+    if gt_idx<len(sample_fields) { gt = sample_fields[gt_idx] }
+
+    alleles,phased,e := parse_gt(gt)
+    if e!=nil { return nil, fmt.Errorf("%s: %v (line %d)", fn, e, line_no) }
+    if len(alleles)>ploidy { ploidy = len(alleles) }
+
+    add_edit := func(hap int, ed Edit) {
+      edits[hap] = append(edits[hap], ed)
+      if len(alleles)==1 { haploid_edits = append(haploid_edits, ed) }
+    }
+
+    for hap:=0; hap<len(alleles); hap++ {
+      a := alleles[hap]
+      if a==0 { continue }
+
+      if a<0 {
+        add_edit(hap, Edit{ Start: start, End: end, Alt: strings.Repeat("n", end-start), Nocall: true, Phased: true, LineNo: line_no })
+        continue
+      }
+
+      if a>len(alt_alleles) { return nil, fmt.Errorf("%s: genotype '%s' refers to a missing ALT allele (line %d)", fn, gt, line_no) }
+      alt := alt_alleles[a-1]
+
+      // Overlapping deletion placeholder, the deletion itself
+      // comes from its own record.
+      //
+      if alt=="*" { continue }
+
+      // Symbolic alleles can't be spelled out, treat
+      // them as no-calls.
+      //
+      if strings.HasPrefix(alt, "<") || strings.ContainsAny(alt, "[]") {
+        add_edit(hap, Edit{ Start: start, End: end, Alt: strings.Repeat("n", end-start), Nocall: true, Phased: true, LineNo: line_no })
+        continue
+      }
+
+      r,alt,n := trim_allele(ref_allele, alt)
+      if len(r)==0 && len(alt)==0 { continue }
+
+      add_edit(hap, Edit{ Start: start+n, End: start+n+len(r), Alt: alt, Phased: phased, LineNo: line_no })
+    }
+  }
+
+  if g_ploidy>0 {
+    if ploidy>g_ploidy { return nil, fmt.Errorf("%s: sample has diploid genotypes on %s but --ploidy is %d", fn, chrom, g_ploidy) }
+    ploidy = g_ploidy
+  }
+
+  if ploidy==1 { return edits[:1], nil }
+
+  edits[1] = append(edits[1], haploid_edits...)
+  return edits, nil
+}
+
+type EditOrder []Edit
+func (s EditOrder) Len() int { return len(s) }
+func (s EditOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s EditOrder) Less(i,j int) bool {
+  if s[i].Start != s[j].Start { return s[i].Start < s[j].Start }
+  return s[i].End < s[j].End
+}
+
+// Apply edits to ref.
+//
+func make_haplotype(ref string, edits []Edit, hap int) Haplotype {
+  sort.Stable(EditOrder(edits))
+
+  h := Haplotype{}
+  h.HapPos = make([]int, len(ref)+1)
+  h.Edits = make([]Edit, 0, len(edits))
+
+  seq := make([]byte, 0, len(ref)+1024)
+  prev := 0
+
+  for _,ed := range edits {
+    if ed.Start < prev {
+      fmt.Fprintf(os.Stderr, "WARNING: variant on VCF line %d overlaps a previous variant on haplotype %d, skipping\n", ed.LineNo, hap)
+      continue
+    }
+
+    for i:=prev; i<ed.Start; i++ {
+      h.HapPos[i] = len(seq)
+      seq = append(seq, ref[i])
+    }
+
+    ed.HapStart = len(seq)
+    for i:=ed.Start; i<ed.End; i++ {
+      h.HapPos[i] = -1
+      if ed.Nocall { h.HapPos[i] = len(seq)+i-ed.Start }
+    }
+    seq = append(seq, ed.Alt...)
+
+    h.Edits = append(h.Edits, ed)
+    prev = ed.End
+  }
+
+  for i:=prev; i<len(ref); i++ {
+    h.HapPos[i] = len(seq)
+    seq = append(seq, ref[i])
+  }
+  h.HapPos[len(ref)] = len(seq)
+
+  h.Seq = string(seq)
+  return h
+}
+
+// Whether a called (not no-call) edit changes the
+// reference bases [s,e).  An insertion changes the
+// span only if it falls strictly inside it.
+//
+func (h *Haplotype) changes(s, e int) bool {
+  for _,ed := range h.Edits {
+    if ed.Nocall { continue }
+    if ed.Start==ed.End {
+      if s<ed.Start && ed.Start<e { return true }
+      continue
+    }
+    if ed.Start<e && ed.End>s { return true }
+  }
+  return false
+}
+
+// Anchors on the haplotype for the tags found on the reference.
+//
+func (h *Haplotype) anchors(ref_anchors []tileset.Anchor) []tileset.Anchor {
+  anchors := make([]tileset.Anchor, 0, len(ref_anchors))
+  for _,a := range ref_anchors {
+    if h.changes(a.RefPos, a.RefPos+fastj.TAGLEN) { continue }
+    anchors = append(anchors, tileset.Anchor{ Index: a.Index, SeqPos: h.HapPos[a.RefPos], RefPos: a.RefPos })
+  }
+  return anchors
+}
+
+func edit_type(ed Edit) string {
+  if ed.Nocall { return "GAP" }
+  ref_len := ed.End-ed.Start
+  switch {
+  case ref_len==1 && len(ed.Alt)==1: return "SNP"
+  case ref_len==len(ed.Alt): return "SUB"
+  case ref_len==0: return "INS"
+  case len(ed.Alt)==0: return "DEL"
+  }
+  return "INDEL"
+}
+
+// Add the variant, phase and no-call notes to a tile
+// cut from h between the anchors a and b.
+//
+func add_notes(tile *fastj.Tile, h *Haplotype, a, b tileset.Anchor, hap int) {
+  phase := "REPORTED"
+
+  for _,ed := range h.Edits {
+    if ed.Start < a.RefPos || ed.End > b.RefPos+fastj.TAGLEN { continue }
+    if ed.Start==ed.End && (ed.Start==a.RefPos || ed.Start==b.RefPos+fastj.TAGLEN) { continue }
+
+    s := ed.Start + g_seq_start
+    e := ed.End - 1 + g_seq_start
+    if e<s { e = s }
+
+    alt := ed.Alt
+    if ed.Nocall || len(alt)==0 { alt = "-" }
+
+    tile.Notes = append(tile.Notes, fmt.Sprintf("%s %d %d %s %s %d %d",
+      g_build_prefix, s, e, edit_type(ed), alt, ed.HapStart-a.SeqPos, len(ed.Alt)))
+
+    if !ed.Phased { phase = "UNPHASED" }
+  }
+
+  tile.Notes = append(tile.Notes, fmt.Sprintf("Phase (%s) %c", phase, 'A'+hap))
+
+  for i:=0; i<len(tile.Seq); {
+    if tile.Seq[i]!='n' { i++ ; continue }
+    j := i
+    for j<len(tile.Seq) && tile.Seq[j]=='n' { j++ }
+    tile.Notes = append(tile.Notes, fmt.Sprintf("nocall %d %d", i, j-i))
+    i = j
+  }
+}
+
+type TileOrder []*fastj.Tile
+func (s TileOrder) Len() int { return len(s) }
+func (s TileOrder) Swap(i,j int) { s[i],s[j] = s[j],s[i] }
+func (s TileOrder) Less(i,j int) bool { return s[i].TileID.Cmp(s[j].TileID) < 0 }
+
+func gen_tiling(ofp io.Writer, ts *tileset.Tagset, ref string, edits [][]Edit) {
+  fj_out := fastj.NewWriter(ofp)
+
+  // Tags on the reference, in order.  Tags missing from the
+  // region are expected, anything else is worth a warning.
+  //
+  ref_anchors,reports := ts.Locate(ref, 0)
+  for _,r := range reports {
+    if r.Status==tileset.TAG_MISSING { continue }
+    fmt.Fprintf(os.Stderr, "WARNING: tag %s\n", r)
+  }
+
+  locus := tileset.Locus{ BuildPrefix: g_build_prefix, SeqStart: g_seq_start }
+
+  tiles := make([]*fastj.Tile, 0, len(edits)*len(ref_anchors))
+  for hap:=0; hap<len(edits); hap++ {
+    h := make_haplotype(ref, edits[hap], hap)
+    anchors := h.anchors(ref_anchors)
+
+    hap_tiles := ts.Tiles(h.Seq, anchors, hap, locus)
+    for k:=0; k<len(hap_tiles); k++ {
+      add_notes(hap_tiles[k], &h, anchors[k], anchors[k+1], hap)
+    }
+    tiles = append(tiles, hap_tiles...)
+  }
+
+  sort.Stable(TileOrder(tiles))
+
+  for i:=0; i<len(tiles); i++ {
+    fj_out.Write(tiles[i])
+  }
+}
+
+func _main( c *cli.Context ) {
+
+  g_build_prefix = c.String("build-prefix")
+  g_seq_start = c.Int("seq-start")
+  g_ploidy = c.Int("ploidy")
+  if g_ploidy<0 || g_ploidy>2 {
+    fmt.Fprintf( os.Stderr, "invalid --ploidy %d (must be 1 or 2)\n", g_ploidy )
+    os.Exit(1)
+  }
+
+  if c.String("input")=="" || c.String("ref")=="" || c.String("tileset")=="" {
+    fmt.Fprintf( os.Stderr, "VCF input, reference and tileset required, exiting\n" )
+    cli.ShowAppHelp( c )
+    os.Exit(1)
+  }
+
+  if c.Bool( "pprof" ) {
+    gProfileFlag = true
+    gProfileFile = c.String("pprof-file")
+  }
+
+  if c.Bool( "mprof" ) {
+    gMemProfileFlag = true
+    gMemProfileFile = c.String("mprof-file")
+  }
+
+  gVerboseFlag = c.Bool("Verbose")
+
+  if c.Int("max-procs") > 0 {
+    runtime.GOMAXPROCS( c.Int("max-procs") )
+  }
+
+  if gProfileFlag {
+    prof_f,err := os.Create( gProfileFile )
+    if err != nil {
+      fmt.Fprintf( os.Stderr, "Could not open profile file %s: %v\n", gProfileFile, err )
+      os.Exit(2)
+    }
+
+    pprof.StartCPUProfile( prof_f )
+    defer pprof.StopCPUProfile()
+  }
+
+  ts,err := tileset.Load( c.String("tileset") )
+  if err!=nil { log.Fatal(err) }
+
+  ref_rec,err := fasta.ReadRecord( c.String("ref"), c.String("ref-name") )
+  if err!=nil { log.Fatal(err) }
+  ref := strings.ToLower(ref_rec.Seq)
+
+  chrom := c.String("chrom")
+  if len(chrom)==0 { chrom = ref_rec.Name }
+
+  edits,err := load_vcf( c.String("input"), chrom, c.String("sample"), ref )
+  if err!=nil { log.Fatal(err) }
+
+  out,err := autoio.CreateWriter( c.String("output") )
+  if err!=nil { log.Fatal(err) }
+  defer func() { out.Flush() ; out.Close() }()
+
+  gen_tiling(out.Writer, ts, ref, edits)
+
+}
+
+func main() {
+
+  app := cli.NewApp()
+  app.Name  = "vcf2fj"
+  app.Usage = "Convert a sample in a VCF to FastJ using a reference region and a tileset"
+  app.Version = VERSION_STR
+  app.Author = "Curoverse, Inc."
+  app.Email = "info@curoverse.com"
+  app.Action = func( c *cli.Context ) { _main(c) }
+
+  app.Flags = []cli.Flag{
+    cli.StringFlag{
+      Name: "input, i",
+      Usage: "INPUT VCF (phased or unphased)",
+    },
+
+    cli.StringFlag{
+      Name: "ref, r",
+      Usage: "Reference FASTA of the region",
+    },
+
+    cli.StringFlag{
+      Name: "ref-name",
+      Usage: "Name of the record in the reference FASTA to use (default first record)",
+    },
+
+    cli.StringFlag{
+      Name: "tileset, t",
+      Usage: "TileSet as a CSV path.step,tag_sequence",
+    },
+
+    cli.StringFlag{
+      Name: "chrom",
+      Usage: "VCF CHROM of the region (default the reference record name)",
+    },
+
+    cli.StringFlag{
+      Name: "sample",
+      Usage: "VCF sample column to use (default first sample)",
+    },
+
+    cli.IntFlag{
+      Name: "ploidy",
+      Usage: "Ploidy of the sample, 1 or 2 (default from the genotypes, 2 if there are none)",
+    },
+
+    cli.StringFlag{
+      Name: "build-prefix",
+      Value: "unknown",
+      Usage: "Prefix to put in build note (e.g. 'grch38 chr17')",
+    },
+
+    cli.IntFlag{
+      Name: "seq-start",
+      Usage: "Reference coordinate of the first base of the reference region. 0 reference.",
+    },
+
+    cli.StringFlag{
+      Name: "output, o",
+      Value: "-",
+      Usage: "OUTPUT",
+    },
+
+    cli.IntFlag{
+      Name: "max-procs, N",
+      Value: -1,
+      Usage: "MAXPROCS",
+    },
+
+    cli.BoolFlag{
+      Name: "Verbose, V",
+      Usage: "Verbose flag",
+    },
+
+    cli.BoolFlag{
+      Name: "pprof",
+      Usage: "Profile usage",
+    },
+
+    cli.StringFlag{
+      Name: "pprof-file",
+      Value: gProfileFile,
+      Usage: "Profile File",
+    },
+
+    cli.BoolFlag{
+      Name: "mprof",
+      Usage: "Profile memory usage",
+    },
+
+    cli.StringFlag{
+      Name: "mprof-file",
+      Value: gMemProfileFile,
+      Usage: "Profile Memory File",
+    },
+
+  }
+
+  app.Run( os.Args )
+
+  if gMemProfileFlag {
+    fmem,err := os.Create( gMemProfileFile )
+    if err!=nil { panic(fmem) }
+    pprof.WriteHeapProfile(fmem)
+    fmem.Close()
+  }
+
+}